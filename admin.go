@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dagit/repofs"
+)
+
+var repoNameRegex = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// The repos a server watches by name, served under /api/repos/{name}/. The repo the server
+// was started with is registered too, under its directory name, and can't be removed.
+type repoRegistry struct {
+	mu    sync.RWMutex
+	repos map[string]*Repo
+	// the name of the repo the server was started with
+	primary string
+	// the poller refreshing each repo
+	pollers map[*Repo]*repoPoller
+}
+
+var watched = &repoRegistry{repos: map[string]*Repo{}, pollers: map[*Repo]*repoPoller{}}
+
+// A watched repo as listed by the API.
+type WatchedRepo struct {
+	Name     string    `json:"name"`
+	Path     string    `json:"path"`
+	Objects  int       `json:"objects"`
+	LoadedAt time.Time `json:"loadedAt"`
+	Primary  bool      `json:"primary"`
+}
+
+func (reg *repoRegistry) get(name string) (*Repo, bool) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	r, ok := reg.repos[name]
+	return r, ok
+}
+
+// Returns the poller refreshing r, or false when r is no longer watched.
+func (reg *repoRegistry) poller(r *Repo) (*repoPoller, bool) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	p, ok := reg.pollers[r]
+	return p, ok
+}
+
+// Registers the repo the server was started with and polls it until ctx is done.
+func (reg *repoRegistry) setPrimary(ctx context.Context, r *Repo) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.primary = defaultRepoName(r.location)
+	reg.repos[reg.primary] = r
+	reg.pollers[r] = startPoller(ctx, r)
+}
+
+func (reg *repoRegistry) list() []WatchedRepo {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	repos := []WatchedRepo{}
+	for name, r := range reg.repos {
+		unlock := readLock(r)
+		repos = append(repos, WatchedRepo{Name: name, Path: r.location, Objects: len(r.objects), LoadedAt: r.loadedAt, Primary: name == reg.primary})
+		unlock()
+	}
+	slices.SortFunc(repos, func(a, b WatchedRepo) int { return strings.Compare(a.Name, b.Name) })
+	return repos
+}
+
+func defaultRepoName(location string) string {
+	if abs, err := filepath.Abs(location); err == nil {
+		location = abs
+	}
+	return filepath.Base(location)
+}
+
+// Returns the repo a request is for: the watched repo named by its {name} path segment, or
+// by its repo query parameter on /ws and /api/export, otherwise the server's repo. The repo
+// is nil when the server serves an imported graph.
+func requestRepo(r *http.Request) (*Repo, error) {
+	name := r.PathValue("name")
+	if name == "" && (r.URL.Path == "/ws" || r.URL.Path == "/api/export") {
+		name = r.URL.Query().Get("repo")
+	}
+	if name == "" {
+		return repo, nil
+	}
+	target, ok := watched.get(name)
+	if !ok {
+		return nil, fmt.Errorf("no watched repo named %q", name)
+	}
+	return target, nil
+}
+
+// Serves the watched repos.
+func serveRepos(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(watched.list()); err != nil {
+		loggerFrom(r.Context()).Error("writing repos", "error", err)
+	}
+}
+
+// Wraps an admin handler so it needs an Authorization: Bearer header with token. Without a
+// token the admin API is off.
+func requireAdmin(token string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			http.Error(w, "the admin API is off, start the server with --admin-token to turn it on", http.StatusNotFound)
+			return
+		}
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="dagit admin"`)
+			http.Error(w, "missing or wrong admin token", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// The body of POST /api/admin/repos. Name defaults to the repo's directory name.
+type addRepoRequest struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// Loads a repo and starts watching it. Websocket clients connect to it with /ws?repo=name.
+func serveAddRepo(ctx context.Context, opts RepoOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req addRepoRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("invalid body: %s", err), http.StatusBadRequest)
+			return
+		}
+		if req.Path == "" {
+			http.Error(w, "path is required", http.StatusBadRequest)
+			return
+		}
+		if req.Name == "" {
+			req.Name = defaultRepoName(req.Path)
+		}
+		if !repoNameRegex.MatchString(req.Name) {
+			http.Error(w, fmt.Sprintf("invalid name %q, use letters, digits, '.', '_' and '-'", req.Name), http.StatusBadRequest)
+			return
+		}
+		if info, err := repofs.Stat(gitDir(req.Path)); err != nil || !info.IsDir() {
+			http.Error(w, fmt.Sprintf("%s is not a git repo", req.Path), http.StatusBadRequest)
+			return
+		}
+		if _, ok := watched.get(req.Name); ok {
+			http.Error(w, fmt.Sprintf("a repo named %q is already watched", req.Name), http.StatusConflict)
+			return
+		}
+		// loading can take a while, so it happens outside the registry's lock
+		added, err := newRepo(ctx, req.Path, opts)
+		if err != nil {
+			status := http.StatusInternalServerError
+			var notFound *RepoNotFoundError
+			var corrupt *CorruptObjectError
+			if errors.As(err, &notFound) || errors.As(err, &corrupt) {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		added.trackHistory(false)
+		// read before the poller starts refreshing the repo
+		info := WatchedRepo{Name: req.Name, Path: added.location, Objects: len(added.objects), LoadedAt: added.loadedAt}
+		watched.mu.Lock()
+		if _, ok := watched.repos[req.Name]; ok {
+			watched.mu.Unlock()
+			http.Error(w, fmt.Sprintf("a repo named %q is already watched", req.Name), http.StatusConflict)
+			return
+		}
+		watched.repos[req.Name] = added
+		watched.pollers[added] = startPoller(ctx, added)
+		watched.mu.Unlock()
+		loggerFrom(r.Context()).Info("watching repo", "name", req.Name, "path", req.Path, "objects", info.Objects)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(info)
+	}
+}
+
+// Stops watching a repo. Its poller stops, which disconnects its websocket clients.
+func serveRemoveRepo(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	watched.mu.Lock()
+	removed, ok := watched.repos[name]
+	primary := name == watched.primary
+	if ok && !primary {
+		delete(watched.repos, name)
+		watched.pollers[removed].stop()
+		delete(watched.pollers, removed)
+	}
+	watched.mu.Unlock()
+	switch {
+	case !ok:
+		http.Error(w, fmt.Sprintf("no watched repo named %q", name), http.StatusNotFound)
+	case primary:
+		http.Error(w, "the repo the server was started with can't be removed", http.StatusConflict)
+	default:
+		loggerFrom(r.Context()).Info("stopped watching repo", "name", name)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// How the from revision of an ancestry path relates to the to revision.
+const (
+	PATH_SAME       = "same"
+	PATH_ANCESTOR   = "ancestor"
+	PATH_DESCENDANT = "descendant"
+	PATH_DIVERGED   = "diverged"
+)
+
+// The chain of commits connecting two revisions, or where they diverged when neither
+// contains the other.
+type AncestryPath struct {
+	// the commits the revisions resolve to
+	From string `json:"from"`
+	To   string `json:"to"`
+	// same, ancestor when from is an ancestor of to, descendant when to is an ancestor of
+	// from, or diverged
+	Relation string `json:"relation"`
+	// the shortest chain of commits from the older revision to the newer one, both included,
+	// oldest first. Each commit is a parent of the next, first parents preferred. Empty when
+	// the revisions diverged.
+	Commits []PathCommit `json:"commits"`
+	// when the revisions diverged, their best common ancestors. Empty for unrelated histories.
+	MergeBases []string `json:"mergeBases,omitempty"`
+	// when the revisions diverged, the number of commits only from or only to reaches
+	OnlyFrom int `json:"onlyFrom,omitempty"`
+	OnlyTo   int `json:"onlyTo,omitempty"`
+}
+
+type PathCommit struct {
+	Hash string `json:"hash"`
+	Commit
+}
+
+// Finds how the commit from got into the history of to, e.g. through which merge a commit
+// reached main, or where they diverged when neither contains the other.
+func (r *Repo) AncestryPath(from string, to string) (*AncestryPath, error) {
+	fromCommit, err := r.commitOf(from)
+	if err != nil {
+		return nil, err
+	}
+	toCommit, err := r.commitOf(to)
+	if err != nil {
+		return nil, err
+	}
+	path := &AncestryPath{From: fromCommit.Hash, To: toCommit.Hash, Commits: []PathCommit{}}
+	if path.From == path.To {
+		path.Relation = PATH_SAME
+		path.Commits = append(path.Commits, PathCommit{Hash: path.From, Commit: r.mailmap.commit(fromCommit)})
+		return path, nil
+	}
+	if chain := r.parentChain(path.To, path.From); chain != nil {
+		path.Relation = PATH_ANCESTOR
+		path.Commits = chain
+		return path, nil
+	}
+	if chain := r.parentChain(path.From, path.To); chain != nil {
+		path.Relation = PATH_DESCENDANT
+		path.Commits = chain
+		return path, nil
+	}
+	path.Relation = PATH_DIVERGED
+	path.MergeBases = mergeBases(r, path.From, r, path.To)
+	fromAncestors, toAncestors := r.ancestors([]string{path.From}), r.ancestors([]string{path.To})
+	for name := range fromAncestors {
+		if !toAncestors[name] {
+			path.OnlyFrom++
+		}
+	}
+	for name := range toAncestors {
+		if !fromAncestors[name] {
+			path.OnlyTo++
+		}
+	}
+	return path, nil
+}
+
+// Returns the shortest chain of parents from the commit newer down to older, oldest first,
+// or nil when older isn't an ancestor of newer. The walk is breadth first with first parents
+// visited first, so of equally short chains the one along first parents wins.
+func (r *Repo) parentChain(newer string, older string) []PathCommit {
+	// the child each visited commit was reached from
+	child := map[string]string{newer: ""}
+	queue := []string{newer}
+	for len(queue) > 0 && child[older] == "" {
+		name := queue[0]
+		queue = queue[1:]
+		obj := r.getObject(name)
+		if obj == nil || obj.Type != "commit" {
+			// missing parents, e.g. in shallow clones
+			continue
+		}
+		for _, parent := range parseCommit(obj).Parents {
+			if _, seen := child[parent]; !seen {
+				child[parent] = name
+				queue = append(queue, parent)
+			}
+		}
+	}
+	if _, found := child[older]; !found {
+		return nil
+	}
+	var chain []PathCommit
+	for name := older; name != ""; name = child[name] {
+		chain = append(chain, PathCommit{Hash: name, Commit: r.mailmap.commit(parseCommit(r.getObject(name)))})
+	}
+	return chain
+}
+
+func writeAncestryPath(w io.Writer, path *AncestryPath, from string, to string) {
+	switch path.Relation {
+	case PATH_SAME:
+		fmt.Fprintf(w, "%s and %s are the same commit, %s\n", from, to, path.From[:7])
+		return
+	case PATH_ANCESTOR:
+		fmt.Fprintf(w, "%s is an ancestor of %s, %s away:\n", from, to, count(len(path.Commits)-1, "commit"))
+	case PATH_DESCENDANT:
+		fmt.Fprintf(w, "%s is an ancestor of %s, %s away:\n", to, from, count(len(path.Commits)-1, "commit"))
+	case PATH_DIVERGED:
+		if len(path.MergeBases) == 0 {
+			fmt.Fprintf(w, "%s and %s have unrelated histories\n", from, to)
+			return
+		}
+		fmt.Fprintf(w, "%s and %s diverged, %s only in %s and %s only in %s, at:\n",
+			from, to, count(path.OnlyFrom, "commit"), from, count(path.OnlyTo, "commit"), to)
+		for _, base := range path.MergeBases {
+			fmt.Fprintf(w, "  %s\n", base)
+		}
+		return
+	}
+	for _, commit := range path.Commits {
+		fmt.Fprintf(w, "  %s\n", oneline(commit.Commit))
+	}
+}
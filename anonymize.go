@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+)
+
+// The domain of pseudonymous emails.
+const ANONYMOUS_DOMAIN = "anonymous.invalid"
+
+// Returns a pseudonym for a person, derived from their email or, without one, their name.
+// It's the same in every output and every repo, so contributions can still be told apart and
+// followed across repos, but anyone who knows an email can check whether it's behind one.
+// Pseudonyms are returned as they are, as people can be resolved more than once.
+func pseudonym(user User) User {
+	if strings.HasSuffix(user.Email, "@"+ANONYMOUS_DOMAIN) {
+		return user
+	}
+	key := strings.ToLower(strings.Trim(strings.TrimSpace(user.Email), "<>"))
+	if key == "" {
+		key = strings.TrimSpace(user.Name)
+	}
+	if key == "" {
+		return user
+	}
+	sum := sha256.Sum256([]byte(key))
+	id := hex.EncodeToString(sum[:5])
+	return User{Name: "author-" + id, Email: id + "@" + ANONYMOUS_DOMAIN}
+}
+
+// Returns the repo's mailmap, also replacing people with pseudonyms when the repo anonymizes
+// authors. Nil when there's neither.
+func (r *Repo) identities() *Mailmap {
+	m := r.loadMailmap()
+	if r.opts.AnonymizeAuthors {
+		if m == nil {
+			m = &Mailmap{}
+		}
+		m.anonymize = true
+	}
+	return m
+}
+
+// Returns the JSON show prints for an object, with the author, committer or tagger replaced
+// by their pseudonym when the repo anonymizes authors.
+func (r *Repo) showJson(obj *Object) ([]byte, error) {
+	if !r.opts.AnonymizeAuthors || obj.Type != "commit" && obj.Type != "tag" {
+		return obj.toJson(), nil
+	}
+	node, err := (&selection{mailmap: r.mailmap}).node(obj)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(node["object"])
+}
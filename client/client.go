@@ -0,0 +1,319 @@
+// Package client follows the graph of a repo served by dagit serve over its /ws websocket,
+// answering the server's pings and reconnecting when the connection drops:
+//
+//	c, err := client.Dial(ctx, "http://localhost:8080", client.Options{})
+//	for msg := range c.Messages() {
+//		switch msg := msg.(type) {
+//		case *client.GraphSnapshot:
+//			fmt.Println(len(msg.Nodes), "nodes")
+//		case *client.GraphDelta:
+//			fmt.Println(msg.RepoEvent)
+//		}
+//	}
+//
+// A snapshot of the graph is requested on every connection, so after a reconnect the next
+// snapshot replaces whatever changes were missed.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/dagit/graphjson"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// the message asking the server for the current graph
+	needObjects = "need-objects"
+	// the server pings every 9 seconds
+	DefaultPingTimeout = 30 * time.Second
+	DefaultMinBackoff  = time.Second
+	DefaultMaxBackoff  = 30 * time.Second
+	writeWait          = 10 * time.Second
+)
+
+// Returned by RequestSnapshot while the client is reconnecting.
+var ErrNotConnected = errors.New("client: not connected to the server")
+
+// A message from the server: a *GraphSnapshot, *GraphDelta or *Progress.
+type Message interface {
+	message()
+}
+
+// The whole graph, sent when the repo changed or when requested.
+type GraphSnapshot struct {
+	*graphjson.Graph
+	Received time.Time
+}
+
+// A change to the repo's history, sent ahead of the snapshot of the graph it led to.
+type GraphDelta struct {
+	graphjson.RepoEvent
+}
+
+// The progress of a job the server runs on the repo, e.g. an export.
+type Progress struct {
+	graphjson.Job
+}
+
+func (*GraphSnapshot) message() {}
+func (*GraphDelta) message()    {}
+func (*Progress) message()      {}
+
+type Options struct {
+	// the repo to follow on a server serving several, by its name in the admin API
+	Repo string
+	// sent with every handshake, e.g. for authentication by a proxy in front of the server
+	Header http.Header
+	// how long to wait for a message or ping before reconnecting. Defaults to
+	// DefaultPingTimeout.
+	PingTimeout time.Duration
+	// the delay before the first reconnection attempt, doubled after each failed one up to
+	// MaxBackoff. Defaults to DefaultMinBackoff and DefaultMaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// called with the errors that made the client reconnect and messages it couldn't decode
+	OnError func(error)
+}
+
+// A connection to a dagit server that reconnects until closed.
+type Client struct {
+	url      string
+	opts     Options
+	ctx      context.Context
+	cancel   context.CancelFunc
+	messages chan Message
+	done     chan struct{}
+	// nil while reconnecting
+	conn *websocket.Conn
+	mu   sync.Mutex
+}
+
+// Connects to a dagit server, given by its http(s) or ws(s) URL, and requests a snapshot of
+// the graph. The client reconnects until ctx is done or it's closed, so only the first
+// connection's error is returned.
+func Dial(ctx context.Context, server string, opts Options) (*Client, error) {
+	u, err := websocketURL(server, opts.Repo)
+	if err != nil {
+		return nil, err
+	}
+	if opts.PingTimeout <= 0 {
+		opts.PingTimeout = DefaultPingTimeout
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = DefaultMinBackoff
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = max(DefaultMaxBackoff, opts.MinBackoff)
+	}
+	if opts.OnError == nil {
+		opts.OnError = func(error) {}
+	}
+	c := &Client{url: u, opts: opts, messages: make(chan Message, 16), done: make(chan struct{})}
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	conn, err := c.connect()
+	if err != nil {
+		c.cancel()
+		return nil, err
+	}
+	// unblocks the read of the current connection once the client is closed
+	context.AfterFunc(c.ctx, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.conn != nil {
+			c.conn.Close()
+		}
+	})
+	go c.run(conn)
+	return c, nil
+}
+
+// Returns the websocket URL of a server's /ws endpoint, following repo if set.
+func websocketURL(server string, repo string) (string, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	case "ws", "wss":
+	default:
+		return "", fmt.Errorf("client: unsupported scheme %q, expected http(s) or ws(s)", u.Scheme)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/ws"
+	}
+	if repo != "" {
+		query := u.Query()
+		query.Set("repo", repo)
+		u.RawQuery = query.Encode()
+	}
+	return u.String(), nil
+}
+
+// The messages from the server, in the order they were sent across reconnections. Closed
+// once the client is. Messages must be read promptly: pings aren't answered while a message
+// waits, and the server drops clients that don't answer.
+func (c *Client) Messages() <-chan Message {
+	return c.messages
+}
+
+// Asks the server for a snapshot of the graph. Servers in demo mode ignore requests over
+// their rate limit.
+func (c *Client) RequestSnapshot() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return ErrNotConnected
+	}
+	return c.send(c.conn, needObjects)
+}
+
+// Closes the connection and stops reconnecting. Messages is closed once the client stops.
+func (c *Client) Close() error {
+	c.cancel()
+	<-c.done
+	return nil
+}
+
+// Sends a message. Callers hold mu, since a connection supports one writer at a time.
+func (c *Client) send(conn *websocket.Conn, msg string) error {
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return conn.WriteMessage(websocket.TextMessage, []byte(msg))
+}
+
+// Opens a connection and requests a snapshot on it.
+func (c *Client) connect() (*websocket.Conn, error) {
+	conn, _, err := websocket.DefaultDialer.DialContext(c.ctx, c.url, c.opts.Header)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(c.opts.PingTimeout))
+	conn.SetPingHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(c.opts.PingTimeout))
+		err := conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(writeWait))
+		if errors.Is(err, websocket.ErrCloseSent) {
+			return nil
+		}
+		return err
+	})
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// closed while dialing
+	if c.ctx.Err() != nil {
+		conn.Close()
+		return nil, c.ctx.Err()
+	}
+	if err := c.send(conn, needObjects); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c.conn = conn
+	return conn, nil
+}
+
+// Reads conn, then reconnects with backoff whenever the connection drops, until closed.
+func (c *Client) run(conn *websocket.Conn) {
+	defer close(c.done)
+	defer close(c.messages)
+	for {
+		err := c.read(conn)
+		c.mu.Lock()
+		c.conn = nil
+		c.mu.Unlock()
+		conn.Close()
+		if c.ctx.Err() != nil {
+			return
+		}
+		c.opts.OnError(err)
+		for backoff := c.opts.MinBackoff; ; backoff = min(2*backoff, c.opts.MaxBackoff) {
+			// jittered so clients of a restarted server don't reconnect all at once
+			timer := time.NewTimer(backoff/2 + rand.N(backoff/2+1))
+			select {
+			case <-c.ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			if conn, err = c.connect(); err == nil {
+				break
+			}
+			if c.ctx.Err() != nil {
+				return
+			}
+			c.opts.OnError(err)
+		}
+	}
+}
+
+// Delivers the messages read from conn until it fails.
+func (c *Client) read(conn *websocket.Conn) error {
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(c.opts.PingTimeout))
+		msg, err := decode(data)
+		if err != nil {
+			c.opts.OnError(err)
+			continue
+		}
+		if msg == nil {
+			continue
+		}
+		select {
+		case c.messages <- msg:
+		case <-c.ctx.Done():
+			return c.ctx.Err()
+		}
+	}
+}
+
+// Decodes a message from the server. Graphs are told apart from other messages by their
+// nodes, and the others by their type. Returns nil for messages the client doesn't deliver.
+func decode(data []byte) (Message, error) {
+	var head struct {
+		Type  string          `json:"type"`
+		Nodes json.RawMessage `json:"nodes"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return nil, fmt.Errorf("client: decoding message: %w", err)
+	}
+	switch {
+	case head.Nodes != nil:
+		graph, err := graphjson.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("client: decoding graph: %w", err)
+		}
+		return &GraphSnapshot{Graph: graph, Received: time.Now()}, nil
+	case head.Type == "progress":
+		var progress struct {
+			Job graphjson.Job `json:"job"`
+		}
+		if err := json.Unmarshal(data, &progress); err != nil {
+			return nil, fmt.Errorf("client: decoding progress: %w", err)
+		}
+		return &Progress{Job: progress.Job}, nil
+	case head.Type == "object":
+		// replies to object lookups, which the client doesn't make
+		return nil, nil
+	}
+	var delta GraphDelta
+	if err := json.Unmarshal(data, &delta.RepoEvent); err != nil {
+		return nil, fmt.Errorf("client: decoding event: %w", err)
+	}
+	return &delta, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"path"
+	"slices"
+)
+
+// The group a tree or blob collapses into in the UI: the commit that introduced it and the
+// directory it was introduced in.
+type Cluster struct {
+	// commit and path joined by a colon, equal for every object of the group
+	ID     string `json:"id"`
+	Commit string `json:"commit"`
+	// the tree's own path or the blob's directory, "" for the root
+	Path string `json:"path"`
+}
+
+// Assigns the trees and blobs of the selected commits to the commit that introduced them,
+// the oldest one in topological order whose tree contains them, and the directory they
+// were introduced in. Objects no selected commit reaches get no cluster.
+func (r *Repo) clusters(sel *selection) (map[string]Cluster, error) {
+	var commits []Commit
+	for _, obj := range sel.objects {
+		if obj.Type == "commit" {
+			commits = append(commits, parseCommit(obj))
+		}
+	}
+	if err := r.sortCommits(commits, ORDER_TOPO); err != nil {
+		return nil, err
+	}
+	slices.Reverse(commits)
+	clusters := map[string]Cluster{}
+	var walk func(commit string, dir string, tree string)
+	walk = func(commit string, dir string, tree string) {
+		if _, seen := clusters[tree]; seen || r.getObject(tree) == nil {
+			return
+		}
+		clusters[tree] = Cluster{ID: commit + ":" + dir, Commit: commit, Path: dir}
+		for _, entry := range sortedEntries(r.treeEntries(tree)) {
+			p := path.Join(dir, entry.Name)
+			if isTreeMode(entry.Mode) {
+				walk(commit, p, entry.Hash)
+			} else if _, seen := clusters[entry.Hash]; !seen && r.getObject(entry.Hash) != nil {
+				clusters[entry.Hash] = Cluster{ID: commit + ":" + dir, Commit: commit, Path: dir}
+			}
+		}
+	}
+	for _, commit := range commits {
+		walk(commit.Hash, "", commit.Tree)
+	}
+	return clusters, nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Above this many differing lines a file's stats are estimated from its line counts, so a
+// rewritten huge file doesn't take quadratic time.
+const maxLineEdits = 10000
+
+// A changed file with its line stats, like a line of git diff --numstat.
+type FileChange struct {
+	Change
+	Additions int `json:"additions"`
+	Deletions int `json:"deletions"`
+	// binary files have no line stats, like git's "-"
+	Binary bool `json:"binary,omitempty"`
+}
+
+type DiffStats struct {
+	Files     int `json:"files"`
+	Additions int `json:"additions"`
+	Deletions int `json:"deletions"`
+}
+
+// The changes of a commit against one of its parents.
+type ParentDiff struct {
+	// empty for root commits, which are diffed against an empty tree
+	Parent  string       `json:"parent"`
+	Changes []FileChange `json:"changes"`
+	Stats   DiffStats    `json:"stats"`
+}
+
+// A commit and what it changed, served by /api/commits/{hash}.
+type CommitDetail struct {
+	Hash string `json:"hash"`
+	Commit
+	Diffs []ParentDiff `json:"diffs"`
+}
+
+// Parses the commit rev names and diffs it against each parent, detecting renames as opts
+// says.
+func (r *Repo) commitDetail(rev string, opts RenameOptions) (*CommitDetail, error) {
+	commit, err := r.commitOf(rev)
+	if err != nil {
+		return nil, err
+	}
+	detail := &CommitDetail{Hash: commit.Hash, Commit: r.mailmap.commit(commit), Diffs: []ParentDiff{}}
+	parents := commit.Parents
+	if len(parents) == 0 {
+		parents = []string{""}
+	}
+	for _, parent := range parents {
+		parentTree := ""
+		if obj := r.getObject(parent); obj != nil {
+			parentTree = parseCommit(obj).Tree
+		}
+		diff := ParentDiff{Parent: parent, Changes: []FileChange{}}
+		for _, c := range r.detectRenames(r.diffTrees(parentTree, commit.Tree), opts) {
+			change := r.fileChange(c)
+			diff.Changes = append(diff.Changes, change)
+			diff.Stats.Files++
+			diff.Stats.Additions += change.Additions
+			diff.Stats.Deletions += change.Deletions
+		}
+		detail.Diffs = append(detail.Diffs, diff)
+	}
+	return detail, nil
+}
+
+// Counts the lines a change adds and deletes. Blobs missing from the repo count as empty.
+func (r *Repo) fileChange(c Change) FileChange {
+	var oldData, newData []byte
+	if obj := r.getObject(c.OldHash); obj != nil {
+		oldData = obj.Bytes()
+	}
+	if obj := r.getObject(c.NewHash); obj != nil {
+		newData = obj.Bytes()
+	}
+	change := FileChange{Change: c}
+	if isBinary(oldData) || isBinary(newData) {
+		change.Binary = true
+		return change
+	}
+	change.Additions, change.Deletions = lineStats(splitLines(oldData), splitLines(newData))
+	return change
+}
+
+// Reports whether data looks binary the way git decides, by a NUL in its first 8000 bytes.
+func isBinary(data []byte) bool {
+	return bytes.IndexByte(data[:min(len(data), 8000)], 0) >= 0
+}
+
+func splitLines(data []byte) []string {
+	lines := strings.SplitAfter(string(data), "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Returns the lines added and deleted going from a to b in a minimal line diff, found with
+// Myers' algorithm. Past maxLineEdits they're estimated from how often each line occurs.
+func lineStats(a, b []string) (int, int) {
+	if d, ok := editDistance(a, b, maxLineEdits); ok {
+		// a minimal diff keeps the longest common subsequence and adds and deletes the rest
+		common := (len(a) + len(b) - d) / 2
+		return len(b) - common, len(a) - common
+	}
+	counts := map[string]int{}
+	for _, line := range a {
+		counts[line]++
+	}
+	added := 0
+	for _, line := range b {
+		if counts[line] > 0 {
+			counts[line]--
+		} else {
+			added++
+		}
+	}
+	deleted := 0
+	for _, n := range counts {
+		deleted += n
+	}
+	return added, deleted
+}
+
+// Returns the number of line insertions and deletions turning a into b, or false when it's
+// more than maxD.
+func editDistance(a, b []string, maxD int) (int, bool) {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	// the furthest x reached on each diagonal k = x - y
+	v := make([]int, 2*offset+1)
+	for d := 0; d <= min(n+m, maxD); d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && v[offset+k-1] < v[offset+k+1] {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return d, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// Serves a commit and its diff against each parent. The find-renames and find-copies query
+// parameters work like the diff command's flags.
+func serveCommit(w http.ResponseWriter, r *http.Request) {
+	target, err := requestRepo(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if target == nil {
+		http.Error(w, "commit details need a live repo, not an imported graph", http.StatusNotImplemented)
+		return
+	}
+	defer readLock(target)()
+	opts := RenameOptions{Threshold: 50}
+	query := r.URL.Query()
+	if threshold := query.Get("find-renames"); threshold != "" {
+		if opts.Threshold, err = strconv.Atoi(threshold); err != nil || opts.Threshold < 0 || opts.Threshold > 100 {
+			http.Error(w, fmt.Sprintf("invalid find-renames %q", threshold), http.StatusBadRequest)
+			return
+		}
+	}
+	if copies := query.Get("find-copies"); copies != "" {
+		if opts.Copies, err = strconv.ParseBool(copies); err != nil {
+			http.Error(w, fmt.Sprintf("invalid find-copies %q", copies), http.StatusBadRequest)
+			return
+		}
+	}
+	detail, err := target.commitDetail(r.PathValue("hash"), opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(detail); err != nil {
+		loggerFrom(r.Context()).Error("writing commit", "error", err)
+	}
+}
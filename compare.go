@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+)
+
+const SIDE_BOTH = "both"
+
+// The result of comparing two repos, e.g. a fork and its upstream.
+type Comparison struct {
+	// the repos' namespaces
+	Sides [2]string `json:"sides"`
+	// the revisions compared
+	Heads         [2]string `json:"heads"`
+	SharedCommits int       `json:"sharedCommits"`
+	// commits only in either repo, by side
+	UniqueCommits map[string]int `json:"uniqueCommits"`
+	// the best common ancestors of the heads, where the repos diverged
+	Divergence []string     `json:"divergence"`
+	Graph      *mergedGraph `json:"graph,omitempty"`
+}
+
+// Returns the best common ancestors of a commit of a and a commit of b: the ancestors
+// shared by both that aren't ancestors of another shared one.
+func mergeBases(a *Repo, headA string, b *Repo, headB string) []string {
+	ancestorsB := b.ancestors([]string{headB})
+	common := map[string]bool{}
+	for name := range a.ancestors([]string{headA}) {
+		if ancestorsB[name] {
+			common[name] = true
+		}
+	}
+	// common commits that are ancestors of another common commit
+	var parents []string
+	for name := range common {
+		parents = append(parents, parseCommit(a.getObject(name)).Parents...)
+	}
+	redundant := a.ancestors(parents)
+	var bases []string
+	for name := range common {
+		if !redundant[name] {
+			bases = append(bases, name)
+		}
+	}
+	slices.Sort(bases)
+	return bases
+}
+
+// Compares the objects of two repos. Objects are one node in the combined graph, with a
+// "side" attribute naming the repo that has it or "both". Refs are prefixed with their side
+// and divergence commits are marked with "divergence": true.
+func compareRepos(ctx context.Context, a *Repo, revA string, b *Repo, revB string, sides [2]string) (*Comparison, error) {
+	repos := [2]*Repo{a, b}
+	heads := [2]string{}
+	for i, rev := range [2]string{revA, revB} {
+		commit, err := repos[i].commitOf(rev)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", sides[i], err)
+		}
+		heads[i] = commit.Hash
+	}
+	c := &Comparison{Sides: sides, Heads: heads, UniqueCommits: map[string]int{sides[0]: 0, sides[1]: 0}}
+	c.Divergence = mergeBases(a, heads[0], b, heads[1])
+	if c.Divergence == nil {
+		c.Divergence = []string{}
+	}
+	divergence := map[string]bool{}
+	for _, name := range c.Divergence {
+		divergence[name] = true
+	}
+
+	graph := &mergedGraph{Edges: []mergedEdge{}, Nodes: []map[string]any{}}
+	nodes := map[string]map[string]any{}
+	edges := map[mergedEdge]bool{}
+	for i, r := range repos {
+		data, err := r.toJson(ctx, GraphOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", sides[i], err)
+		}
+		var g graphJson
+		if err := json.Unmarshal(data, &g); err != nil {
+			return nil, fmt.Errorf("%s: %w", sides[i], err)
+		}
+		// refs share names across repos, so they're qualified by side
+		qualify := func(name string) string {
+			if hashRegex.MatchString(name) {
+				return name
+			}
+			return sides[i] + "/" + name
+		}
+		for _, node := range g.Nodes {
+			name := qualify(node["name"].(string))
+			if existing, ok := nodes[name]; ok {
+				existing["side"] = SIDE_BOTH
+				continue
+			}
+			node["name"] = name
+			node["side"] = sides[i]
+			if divergence[name] {
+				node["divergence"] = true
+			}
+			nodes[name] = node
+			graph.Nodes = append(graph.Nodes, node)
+		}
+		for _, e := range g.Edges {
+			edge := mergedEdge{Src: qualify(e.Src), Dest: qualify(e.Dest), Kind: e.Kind, Mode: e.Mode, EntryKind: e.EntryKind}
+			if !edges[edge] {
+				edges[edge] = true
+				graph.Edges = append(graph.Edges, edge)
+			}
+		}
+	}
+	for _, node := range graph.Nodes {
+		if t, _ := nodeObjectType(node["type"].(string)); t != "commit" {
+			continue
+		}
+		if side := node["side"].(string); side == SIDE_BOTH {
+			c.SharedCommits++
+		} else {
+			c.UniqueCommits[side]++
+		}
+	}
+	c.Graph = graph
+	return c, nil
+}
+
+// Prints a summary of a comparison.
+func writeComparison(w io.Writer, c *Comparison) {
+	fmt.Fprintf(w, "%s at %s, %s at %s\n", c.Sides[0], shortHash(c.Heads[0]), c.Sides[1], shortHash(c.Heads[1]))
+	fmt.Fprintf(w, "%d shared %s\n", c.SharedCommits, plural(c.SharedCommits, "commit"))
+	for _, side := range c.Sides {
+		n := c.UniqueCommits[side]
+		fmt.Fprintf(w, "%d %s only in %s\n", n, plural(n, "commit"), side)
+	}
+	if len(c.Divergence) == 0 {
+		fmt.Fprintln(w, "no common history")
+	}
+	for _, name := range c.Divergence {
+		fmt.Fprintf(w, "diverged at %s\n", name)
+	}
+}
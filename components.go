@@ -0,0 +1,142 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+)
+
+// A weakly-connected component of the commit DAG: commits linked by parent edges, whatever
+// their direction. A repo with a gh-pages branch made with git checkout --orphan has two.
+type CommitComponent struct {
+	// 0 for the component holding HEAD, then by size, largest first
+	ID      int `json:"id"`
+	Commits int `json:"commits"`
+	// the component's commits without parents, oldest first
+	Roots []string `json:"roots"`
+	// the refs pointing into the component, HEAD included
+	Refs []string `json:"refs"`
+}
+
+// The component of a commit node. Roots other than the first commit of HEAD's component
+// started a history of their own, e.g. with git checkout --orphan, and are marked orphanRoot.
+type ComponentLabel struct {
+	ID         int  `json:"id"`
+	OrphanRoot bool `json:"orphanRoot,omitempty"`
+}
+
+// Splits the selected commits into weakly-connected components, only following parents that
+// are selected, and returns the components with the component of each commit.
+func (r *Repo) commitComponents(sel *selection) ([]CommitComponent, map[string]ComponentLabel) {
+	commits := map[string]Commit{}
+	for _, obj := range sel.objects {
+		if obj.Type == "commit" {
+			commits[obj.Name] = parseCommit(obj)
+		}
+	}
+	// union-find over commit hashes, with path halving
+	parent := make(map[string]string, len(commits))
+	find := func(hash string) string {
+		for parent[hash] != hash {
+			parent[hash] = parent[parent[hash]]
+			hash = parent[hash]
+		}
+		return hash
+	}
+	for hash := range commits {
+		parent[hash] = hash
+	}
+	for hash, commit := range commits {
+		for _, p := range commit.Parents {
+			if _, ok := commits[p]; ok {
+				parent[find(hash)] = find(p)
+			}
+		}
+	}
+
+	members := map[string][]string{}
+	for hash := range commits {
+		root := find(hash)
+		members[root] = append(members[root], hash)
+	}
+	// the representative of HEAD's component, "" when HEAD isn't selected
+	head := ""
+	if hash, ok := r.resolveRef("HEAD"); ok {
+		if _, ok := commits[r.peel(hash)]; ok {
+			head = find(r.peel(hash))
+		}
+	}
+	components := make([]CommitComponent, 0, len(members))
+	reps := make([]string, 0, len(members))
+	for rep, hashes := range members {
+		component := CommitComponent{Commits: len(hashes), Roots: []string{}, Refs: []string{}}
+		for _, hash := range hashes {
+			if len(commits[hash].Parents) == 0 {
+				component.Roots = append(component.Roots, hash)
+			}
+		}
+		slices.SortFunc(component.Roots, func(a, b string) int {
+			return cmp.Or(commits[a].CommitTime.Compare(commits[b].CommitTime), cmp.Compare(a, b))
+		})
+		components = append(components, component)
+		reps = append(reps, rep)
+	}
+	// HEAD's component first, then the largest, ties broken by oldest root for stable ids
+	order := make([]int, len(components))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int {
+		if (reps[a] == head) != (reps[b] == head) {
+			if reps[a] == head {
+				return -1
+			}
+			return 1
+		}
+		if c := cmp.Compare(components[b].Commits, components[a].Commits); c != 0 {
+			return c
+		}
+		return cmp.Compare(firstOr(components[a].Roots, reps[a]), firstOr(components[b].Roots, reps[b]))
+	})
+
+	ids := map[string]int{}
+	sorted := make([]CommitComponent, len(order))
+	for id, i := range order {
+		sorted[id] = components[i]
+		sorted[id].ID = id
+		ids[reps[i]] = id
+	}
+	for name, hash := range r.allRefs() {
+		if _, ok := commits[r.peel(hash)]; ok {
+			id := ids[find(r.peel(hash))]
+			sorted[id].Refs = append(sorted[id].Refs, shortRefName(name))
+		}
+	}
+	for i := range sorted {
+		slices.Sort(sorted[i].Refs)
+	}
+	if head != "" {
+		sorted[0].Refs = append([]string{"HEAD"}, sorted[0].Refs...)
+	}
+
+	labels := make(map[string]ComponentLabel, len(commits))
+	for hash := range commits {
+		labels[hash] = ComponentLabel{ID: ids[find(hash)]}
+	}
+	for _, component := range sorted {
+		for i, root := range component.Roots {
+			// the first commit of HEAD's history is the repo's own root
+			if component.ID == 0 && head != "" && i == 0 {
+				continue
+			}
+			labels[root] = ComponentLabel{ID: component.ID, OrphanRoot: true}
+		}
+	}
+	return sorted, labels
+}
+
+func firstOr(s []string, fallback string) string {
+	if len(s) > 0 {
+		return s[0]
+	}
+	return fallback
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/dagit/repofs"
+)
+
+// The settings read from git config files. Values holds every setting by its full key
+// (section.subsection.name, with the section and name lowercased), in the order read, so
+// later files override earlier ones.
+type Config struct {
+	// the files read, lowest precedence first
+	Files        []string            `json:"files"`
+	Bare         bool                `json:"bare"`
+	ObjectFormat string              `json:"objectFormat"`
+	User         User                `json:"user"`
+	Remotes      []Remote            `json:"remotes"`
+	Branches     []BranchConfig      `json:"branches"`
+	Extensions   map[string]string   `json:"extensions"`
+	Values       map[string][]string `json:"values"`
+}
+
+type Remote struct {
+	Name     string   `json:"name"`
+	URLs     []string `json:"urls"`
+	PushURLs []string `json:"pushUrls,omitempty"`
+	// refspecs, e.g. +refs/heads/*:refs/remotes/origin/*
+	Fetch []string `json:"fetch"`
+}
+
+// The upstream a branch tracks.
+type BranchConfig struct {
+	Name   string `json:"name"`
+	Remote string `json:"remote"`
+	// the ref on the remote, e.g. refs/heads/main
+	Merge string `json:"merge"`
+}
+
+// Reads .git/config. With global set the user's global config is read first, so the repo's
+// settings take precedence.
+func (r *Repo) Config(global bool) (*Config, error) {
+	var paths []string
+	if global {
+		paths = globalConfigPaths()
+	}
+	return readConfig(append(paths, gitDir(r.location)+"/config"))
+}
+
+// Reads the config files at paths, skipping missing ones. Later files take precedence.
+func readConfig(paths []string) (*Config, error) {
+	config := &Config{Extensions: map[string]string{}, Values: map[string][]string{}}
+	for _, path := range paths {
+		data, err := repofs.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := parseConfig(data, config.Values); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		config.Files = append(config.Files, path)
+	}
+	config.resolve()
+	return config, nil
+}
+
+// The global config files git reads, in the order it reads them.
+func globalConfigPaths() []string {
+	var paths []string
+	home, _ := os.UserHomeDir()
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		paths = append(paths, filepath.Join(xdg, "git", "config"))
+	} else if home != "" {
+		paths = append(paths, filepath.Join(home, ".config", "git", "config"))
+	}
+	if home != "" {
+		paths = append(paths, filepath.Join(home, ".gitconfig"))
+	}
+	return paths
+}
+
+// Returns the last value of a key, which is the one that takes effect.
+func (c *Config) Get(key string) (string, bool) {
+	values := c.Values[configKey(key)]
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[len(values)-1], true
+}
+
+// Returns a key as a boolean. Keys without a value are true.
+func (c *Config) Bool(key string) bool {
+	value, ok := c.Get(key)
+	if !ok {
+		return false
+	}
+	switch strings.ToLower(value) {
+	case "", "true", "yes", "on":
+		return true
+	}
+	n, err := strconv.Atoi(value)
+	return err == nil && n != 0
+}
+
+// Fills in the typed fields from Values.
+func (c *Config) resolve() {
+	c.Bare = c.Bool("core.bare")
+	c.ObjectFormat = "sha1"
+	if format, ok := c.Get("extensions.objectformat"); ok {
+		c.ObjectFormat = strings.ToLower(format)
+	}
+	c.User.Name, _ = c.Get("user.name")
+	c.User.Email, _ = c.Get("user.email")
+	remotes := map[string]*Remote{}
+	branches := map[string]*BranchConfig{}
+	c.Remotes, c.Branches = []Remote{}, []BranchConfig{}
+	keys := make([]string, 0, len(c.Values))
+	for key := range c.Values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		values := c.Values[key]
+		last := values[len(values)-1]
+		section, rest, _ := strings.Cut(key, ".")
+		dot := strings.LastIndex(rest, ".")
+		switch {
+		case section == "extensions":
+			c.Extensions[rest] = last
+		case section == "remote" && dot > 0:
+			name, field := rest[:dot], rest[dot+1:]
+			remote := remotes[name]
+			if remote == nil {
+				remote = &Remote{Name: name, URLs: []string{}, Fetch: []string{}}
+				remotes[name] = remote
+			}
+			switch field {
+			case "url":
+				remote.URLs = values
+			case "pushurl":
+				remote.PushURLs = values
+			case "fetch":
+				remote.Fetch = values
+			}
+		case section == "branch" && dot > 0:
+			name, field := rest[:dot], rest[dot+1:]
+			branch := branches[name]
+			if branch == nil {
+				branch = &BranchConfig{Name: name}
+				branches[name] = branch
+			}
+			switch field {
+			case "remote":
+				branch.Remote = last
+			case "merge":
+				branch.Merge = last
+			}
+		}
+	}
+	for _, key := range sortedKeys(remotes) {
+		c.Remotes = append(c.Remotes, *remotes[key])
+	}
+	for _, key := range sortedKeys(branches) {
+		// branches with only other settings, e.g. a description, don't track anything
+		if b := branches[key]; b.Remote != "" || b.Merge != "" {
+			c.Branches = append(c.Branches, *b)
+		}
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// Lowercases the section and variable name of a key. Subsections are case sensitive.
+func configKey(key string) string {
+	section, rest, found := strings.Cut(key, ".")
+	if !found {
+		return strings.ToLower(key)
+	}
+	dot := strings.LastIndex(rest, ".")
+	if dot < 0 {
+		return strings.ToLower(section) + "." + strings.ToLower(rest)
+	}
+	return strings.ToLower(section) + "." + rest[:dot] + "." + strings.ToLower(rest[dot+1:])
+}
+
+// Parses git config syntax, appending each setting to values. include and includeIf
+// sections are kept as settings but the files they name aren't read.
+func parseConfig(data []byte, values map[string][]string) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	section := ""
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		// a trailing backslash continues the value on the next line
+		for strings.HasSuffix(line, `\`) && !strings.HasSuffix(line, `\\`) && scanner.Scan() {
+			lineNo++
+			line = line[:len(line)-1] + scanner.Text()
+		}
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			header, rest, err := parseSectionHeader(line)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			section = header
+			// a setting can follow the header on the same line
+			line = strings.TrimSpace(rest)
+			if line == "" || line[0] == '#' || line[0] == ';' {
+				continue
+			}
+		}
+		if section == "" {
+			return fmt.Errorf("line %d: setting outside of a section", lineNo)
+		}
+		name, value, hasValue := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if name == "" || strings.ContainsAny(name, " \t") {
+			return fmt.Errorf("line %d: invalid setting %q", lineNo, line)
+		}
+		parsed := ""
+		if hasValue {
+			var err error
+			if parsed, err = parseConfigValue(value); err != nil {
+				return fmt.Errorf("line %d: %w", lineNo, err)
+			}
+		}
+		key := section + "." + strings.ToLower(name)
+		values[key] = append(values[key], parsed)
+	}
+	return scanner.Err()
+}
+
+// Parses [section], [section "subsection"] or the older [section.subsection], returning the
+// key prefix and what follows the closing bracket.
+func parseSectionHeader(line string) (string, string, error) {
+	if quote := strings.IndexByte(line, '"'); quote >= 0 {
+		name := strings.TrimSpace(line[1:quote])
+		var sub strings.Builder
+		for i := quote + 1; i < len(line); i++ {
+			switch line[i] {
+			case '\\':
+				if i+1 < len(line) {
+					i++
+					sub.WriteByte(line[i])
+				}
+			case '"':
+				rest, ok := strings.CutPrefix(strings.TrimSpace(line[i+1:]), "]")
+				if !ok {
+					return "", "", fmt.Errorf("invalid section header %q", line)
+				}
+				return strings.ToLower(name) + "." + sub.String(), rest, nil
+			default:
+				sub.WriteByte(line[i])
+			}
+		}
+		return "", "", fmt.Errorf("unterminated section header %q", line)
+	}
+	end := strings.IndexByte(line, ']')
+	if end < 0 {
+		return "", "", fmt.Errorf("unterminated section header %q", line)
+	}
+	name := strings.TrimSpace(line[1:end])
+	if name == "" {
+		return "", "", fmt.Errorf("empty section header %q", line)
+	}
+	// the subsection of [section.subsection] is lowercased too
+	return strings.ToLower(name), line[end+1:], nil
+}
+
+// Unquotes a value, handling escapes and stripping comments and surrounding whitespace.
+func parseConfigValue(raw string) (string, error) {
+	var value strings.Builder
+	quoted := false
+	// whitespace is only kept when something follows it
+	pending := ""
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		switch {
+		case c == '"':
+			quoted = !quoted
+			value.WriteString(pending)
+			pending = ""
+		case c == '\\':
+			if i+1 == len(raw) {
+				return "", errors.New("value ends with a backslash")
+			}
+			i++
+			escaped, ok := map[byte]string{'n': "\n", 't': "\t", 'b': "\b", '\\': `\`, '"': `"`}[raw[i]]
+			if !ok {
+				return "", fmt.Errorf("invalid escape \\%c", raw[i])
+			}
+			value.WriteString(pending + escaped)
+			pending = ""
+		case !quoted && (c == '#' || c == ';'):
+			i = len(raw)
+		case !quoted && (c == ' ' || c == '\t'):
+			if value.Len() > 0 {
+				pending += string(c)
+			}
+		default:
+			value.WriteString(pending)
+			value.WriteByte(c)
+			pending = ""
+		}
+	}
+	if quoted {
+		return "", errors.New("unterminated quote")
+	}
+	return value.String(), nil
+}
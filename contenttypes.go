@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// The MIME types of extensions sniffing can't tell apart from plain text or binary data.
+// Kept here rather than read from the system's mime.types so graphs don't depend on the host.
+var extensionContentTypes = map[string]string{
+	".svg": "image/svg+xml", ".psd": "image/vnd.adobe.photoshop", ".tif": "image/tiff", ".tiff": "image/tiff",
+	".heic": "image/heic", ".avif": "image/avif", ".jxl": "image/jxl", ".ai": "application/postscript",
+	".eps": "application/postscript", ".json": "application/json", ".xml": "application/xml", ".yaml": "application/yaml", ".yml": "application/yaml",
+	".toml": "application/toml", ".js": "text/javascript", ".mjs": "text/javascript", ".ts": "text/typescript",
+	".css": "text/css", ".html": "text/html", ".htm": "text/html", ".md": "text/markdown", ".csv": "text/csv",
+	".wasm": "application/wasm", ".gltf": "model/gltf+json", ".glb": "model/gltf-binary", ".obj": "model/obj",
+	".stl": "model/stl",
+}
+
+// Images larger than this aren't decoded for thumbnails, to bound the memory an export takes.
+const maxThumbnailPixels = 40_000_000
+
+// Returns the MIME type of a file from its magic bytes, falling back to its extension when
+// they only tell text from binary, e.g. for SVG, JSON or source code.
+func contentTypeOf(name string, data []byte) string {
+	sniffed := http.DetectContentType(data)
+	generic := sniffed == "application/octet-stream" || strings.HasPrefix(sniffed, "text/plain") || strings.HasPrefix(sniffed, "text/xml")
+	if byExtension, ok := extensionContentTypes[strings.ToLower(path.Ext(name))]; ok && generic {
+		if strings.HasPrefix(sniffed, "text/") && (strings.HasPrefix(byExtension, "text/") || strings.HasSuffix(byExtension, "+xml")) {
+			return byExtension + "; charset=utf-8"
+		}
+		return byExtension
+	}
+	return sniffed
+}
+
+// Returns a PNG data URL of an image scaled down to fit in a size by size square, or false
+// when the image isn't a PNG, JPEG or GIF Go can decode.
+func thumbnail(data []byte, size int) (string, bool) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width == 0 || config.Height == 0 || config.Width*config.Height > maxThumbnailPixels {
+		return "", false
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", false
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, scaleDown(img, size)); err != nil {
+		return "", false
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), true
+}
+
+// Scales an image down to fit in a size by size square, averaging the pixels each thumbnail
+// pixel covers. Smaller images are kept as they are.
+func scaleDown(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+	tw, th := size, max(1, h*size/w)
+	if h > w {
+		tw, th = max(1, w*size/h), size
+	}
+	thumb := image.NewNRGBA(image.Rect(0, 0, tw, th))
+	for ty := range th {
+		y0, y1 := bounds.Min.Y+ty*h/th, bounds.Min.Y+max((ty+1)*h/th, ty*h/th+1)
+		for tx := range tw {
+			x0, x1 := bounds.Min.X+tx*w/tw, bounds.Min.X+max((tx+1)*w/tw, tx*w/tw+1)
+			var r, g, b, a, n uint64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+					// weighted by alpha so transparent pixels don't darken the edges
+					r += uint64(c.R) * uint64(c.A)
+					g += uint64(c.G) * uint64(c.A)
+					b += uint64(c.B) * uint64(c.A)
+					a += uint64(c.A)
+					n++
+				}
+			}
+			if a > 0 {
+				thumb.SetNRGBA(tx, ty, color.NRGBA{R: uint8(r / a >> 8), G: uint8(g / a >> 8), B: uint8(b / a >> 8), A: uint8(a / n >> 8)})
+			}
+		}
+	}
+	return thumb
+}
+
+// Adds the MIME type of each blob to its node as a "contentType" attribute and, when
+// thumbnails is above 0, a "thumbnail" data URL to the nodes of images. Blobs are named by the
+// first selected tree holding them, for their extension. Symlinks and LFS pointers are left out
+// since their content isn't the file's.
+func (r *Repo) annotateContentTypes(sel *selection, thumbnails int) {
+	names := map[string]string{}
+	for _, obj := range sel.objects {
+		if obj.Type != "tree" {
+			continue
+		}
+		for _, entry := range *parseTree(obj) {
+			if _, ok := names[entry.Hash]; !ok && entry.Kind != ENTRY_DIRECTORY && entry.Kind != ENTRY_GITLINK {
+				names[entry.Hash] = entry.Name
+			}
+		}
+	}
+	for _, obj := range sel.objects {
+		if _, isLink := sel.symlinks[obj.Name]; obj.Type != "blob" || isLink {
+			continue
+		}
+		if _, isPointer := obj.lfsPointer(); isPointer {
+			continue
+		}
+		data := obj.Bytes()
+		contentType := contentTypeOf(names[obj.Name], data)
+		sel.annotate(obj.Name, "contentType", contentType)
+		if thumbnails > 0 && strings.HasPrefix(contentType, "image/") {
+			if url, ok := thumbnail(data, thumbnails); ok {
+				sel.annotate(obj.Name, "thumbnail", url)
+			}
+		}
+	}
+}
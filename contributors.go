@@ -0,0 +1,184 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/dagit/repofs"
+)
+
+var coAuthorRegex = regexp.MustCompile(`(?im)^co-authored-by:\s*(.+)$`)
+
+type Contributor struct {
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Commits int    `json:"commits"`
+	Files   int    `json:"files"`
+}
+
+// An undirected edge between two contributors. Kind is "files" for people who modified the
+// same files, weighted by the number of files, or "co-authored" for commits with
+// Co-authored-by trailers, weighted by the number of commits.
+type ContributorEdge struct {
+	Src    string `json:"src"`
+	Dest   string `json:"dest"`
+	Kind   string `json:"kind"`
+	Weight int    `json:"weight"`
+}
+
+type ContributorGraph struct {
+	Edges []ContributorEdge `json:"edges"`
+	Nodes []map[string]any  `json:"nodes"`
+}
+
+// Returns the people credited with a commit: its author and any co-authors.
+func commitAuthors(commit Commit) []User {
+	users := []User{commit.Author}
+	for _, match := range coAuthorRegex.FindAllStringSubmatch(commit.Message, -1) {
+		user, _ := parseSignature(strings.TrimSpace(match[1]))
+		users = append(users, user)
+	}
+	return users
+}
+
+func contributorKey(user User) string {
+	email := strings.ToLower(strings.Trim(user.Email, "<>"))
+	if email == "" {
+		return strings.TrimSpace(user.Name)
+	}
+	return email
+}
+
+// Builds the contributor network of the non-merge commits reachable from revs.
+func (r *Repo) contributorGraph(ctx context.Context, revs []string) (*ContributorGraph, error) {
+	commits, err := r.logCommits(ctx, revs, LogOptions{Order: ORDER_TOPO})
+	if err != nil {
+		return nil, err
+	}
+	people := map[string]*Contributor{}
+	files := map[string]map[string]bool{}
+	touched := map[string]map[string]bool{}
+	coAuthored := map[[2]string]int{}
+	for _, commit := range commits {
+		if len(commit.Parents) > 1 {
+			continue
+		}
+		var keys []string
+		for _, user := range commitAuthors(commit) {
+			user = r.mailmap.resolve(user)
+			key := contributorKey(user)
+			if slices.Contains(keys, key) {
+				continue
+			}
+			keys = append(keys, key)
+			if _, ok := people[key]; !ok {
+				people[key] = &Contributor{Name: strings.TrimSpace(user.Name), Email: strings.Trim(user.Email, "<>")}
+				touched[key] = map[string]bool{}
+			}
+			people[key].Commits++
+		}
+		for i := range keys {
+			for j := i + 1; j < len(keys); j++ {
+				coAuthored[pairKey(keys[i], keys[j])]++
+			}
+		}
+		parentTree := ""
+		if len(commit.Parents) == 1 {
+			if parent := r.getObject(commit.Parents[0]); parent != nil {
+				parentTree = parseCommit(parent).Tree
+			}
+		}
+		for _, c := range r.diffTrees(parentTree, commit.Tree) {
+			if files[c.Path] == nil {
+				files[c.Path] = map[string]bool{}
+			}
+			for _, key := range keys {
+				files[c.Path][key] = true
+				touched[key][c.Path] = true
+			}
+		}
+	}
+
+	shared := map[[2]string]int{}
+	for _, authors := range files {
+		keys := make([]string, 0, len(authors))
+		for key := range authors {
+			keys = append(keys, key)
+		}
+		for i := range keys {
+			for j := i + 1; j < len(keys); j++ {
+				shared[pairKey(keys[i], keys[j])]++
+			}
+		}
+	}
+
+	graph := &ContributorGraph{Edges: []ContributorEdge{}, Nodes: []map[string]any{}}
+	for key, person := range people {
+		person.Files = len(touched[key])
+		graph.Nodes = append(graph.Nodes, map[string]any{"name": key, "type": "author", "object": person})
+	}
+	for pair, n := range shared {
+		graph.Edges = append(graph.Edges, ContributorEdge{Src: pair[0], Dest: pair[1], Kind: "files", Weight: n})
+	}
+	for pair, n := range coAuthored {
+		graph.Edges = append(graph.Edges, ContributorEdge{Src: pair[0], Dest: pair[1], Kind: "co-authored", Weight: n})
+	}
+	slices.SortFunc(graph.Nodes, func(a, b map[string]any) int { return strings.Compare(a["name"].(string), b["name"].(string)) })
+	slices.SortFunc(graph.Edges, func(a, b ContributorEdge) int {
+		return cmp.Or(strings.Compare(a.Src, b.Src), strings.Compare(a.Dest, b.Dest), strings.Compare(a.Kind, b.Kind))
+	})
+	return graph, nil
+}
+
+// Orders a pair of keys so undirected edges are only counted once.
+func pairKey(a string, b string) [2]string {
+	if a > b {
+		a, b = b, a
+	}
+	return [2]string{a, b}
+}
+
+func (g *ContributorGraph) toJson() ([]byte, error) {
+	return json.Marshal(g)
+}
+
+// Writes the contributor graph to a SQLite database with the same objects table as the
+// object graph and weighted edges.
+func (g *ContributorGraph) toSQLite(path string) error {
+	if err := repofs.CheckWrite(path); err != nil {
+		return err
+	}
+	os.Remove(path)
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err := db.Exec(`create table objects (name text primary key, type text, object jsonb, attributes jsonb);`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`create table edges (src text, dest text, kind text, weight integer);`); err != nil {
+		return err
+	}
+	for _, node := range g.Nodes {
+		object, err := json.Marshal(node["object"])
+		if err != nil {
+			return err
+		}
+		if _, err := db.Exec("insert into objects(name, type, object) values(?, ?, ?)", node["name"], node["type"], object); err != nil {
+			return err
+		}
+	}
+	for _, e := range g.Edges {
+		if _, err := db.Exec("insert into edges(src, dest, kind, weight) values(?, ?, ?, ?)", e.Src, e.Dest, e.Kind, e.Weight); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	// type(scope)!: subject
+	conventionalRegex = regexp.MustCompile(`^([A-Za-z]+)(?:\(([^()]*)\))?(!)?: +(\S.*)$`)
+	breakingRegex     = regexp.MustCompile(`(?m)^BREAKING[ -]CHANGE: `)
+)
+
+// Parses a commit message's subject as a conventional commit. Returns nil when it doesn't
+// follow the spec. A BREAKING CHANGE footer marks the commit as breaking too.
+func parseConventional(message string) *ConventionalCommit {
+	subject, body, _ := strings.Cut(message, "\n")
+	match := conventionalRegex.FindStringSubmatch(strings.TrimSpace(subject))
+	if match == nil {
+		return nil
+	}
+	return &ConventionalCommit{
+		Type:     strings.ToLower(match[1]),
+		Scope:    match[2],
+		Breaking: match[3] == "!" || breakingRegex.MatchString(body),
+		Subject:  match[4],
+	}
+}
+
+const (
+	PERIOD_DAY   = "day"
+	PERIOD_WEEK  = "week"
+	PERIOD_MONTH = "month"
+	PERIOD_YEAR  = "year"
+)
+
+// Counts of conventional commits by type, overall and per period.
+type ConventionalStats struct {
+	Commits      int                       `json:"commits"`
+	Conventional int                       `json:"conventional"`
+	Breaking     int                       `json:"breaking"`
+	ByType       map[string]int            `json:"byType"`
+	ByScope      map[string]int            `json:"byScope"`
+	ByPeriod     map[string]map[string]int `json:"byPeriod"`
+}
+
+// Returns the key of the period t falls in, e.g. 2024-03 for months or 2024-W09 for ISO weeks.
+func periodKey(t time.Time, period string) (string, error) {
+	switch period {
+	case PERIOD_DAY:
+		return t.Format("2006-01-02"), nil
+	case PERIOD_WEEK:
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week), nil
+	case PERIOD_MONTH:
+		return t.Format("2006-01"), nil
+	case PERIOD_YEAR:
+		return t.Format("2006"), nil
+	default:
+		return "", fmt.Errorf("unknown period %q, expected day, week, month or year", period)
+	}
+}
+
+// Aggregates the conventional commits of the selection by type and by the period of their
+// author time.
+func conventionalStats(sel *selection, period string) (*ConventionalStats, error) {
+	if _, err := periodKey(time.Time{}, period); err != nil {
+		return nil, err
+	}
+	stats := &ConventionalStats{ByType: map[string]int{}, ByScope: map[string]int{}, ByPeriod: map[string]map[string]int{}}
+	for _, obj := range sel.objects {
+		if obj.Type != "commit" {
+			continue
+		}
+		stats.Commits++
+		commit := parseCommit(obj)
+		cc := commit.Conventional
+		if cc == nil {
+			continue
+		}
+		stats.Conventional++
+		if cc.Breaking {
+			stats.Breaking++
+		}
+		stats.ByType[cc.Type]++
+		if cc.Scope != "" {
+			stats.ByScope[cc.Scope]++
+		}
+		key, _ := periodKey(commit.AuthorTime, period)
+		if stats.ByPeriod[key] == nil {
+			stats.ByPeriod[key] = map[string]int{}
+		}
+		stats.ByPeriod[key][cc.Type]++
+	}
+	return stats, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/dagit/repofs"
+)
+
+// Pack directory files git keeps next to a pack.
+var packFileRegex = regexp.MustCompile(`^pack-[0-9a-f]+\.(pack|idx|keep|bitmap|rev|mtimes|promisor)$`)
+
+// A file under .git/objects that is neither a loose object nor part of a pack.
+type GarbageFile struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// Object counts and disk usage, like git count-objects -v. Sizes are bytes, on disk for
+// loose objects and garbage.
+type ObjectCounts struct {
+	Count int   `json:"count"`
+	Size  int64 `json:"size"`
+	// objects in packs, counting an object in several packs once per pack
+	InPack   int   `json:"inPack"`
+	Packs    int   `json:"packs"`
+	SizePack int64 `json:"sizePack"`
+	// loose objects also in a pack, which git prune-packed would delete
+	PrunePackable int           `json:"prunePackable"`
+	Garbage       []GarbageFile `json:"garbage"`
+	SizeGarbage   int64         `json:"sizeGarbage"`
+}
+
+// Counts the loose and packed objects of the repo and finds garbage files under .git/objects.
+// Loose objects come from the loaded objects, plus those that couldn't be parsed.
+func (r *Repo) countObjects() (*ObjectCounts, error) {
+	objectsDir := filepath.Join(gitDir(r.location), "objects")
+	counts := &ObjectCounts{Garbage: []GarbageFile{}}
+	loose := map[string]string{}
+	for name, obj := range r.objects {
+		loose[name] = obj.Location
+	}
+	for _, e := range r.parseErrors {
+		loose[e.Name] = e.Location
+	}
+	for _, location := range loose {
+		info, err := repofs.Stat(location)
+		if err != nil {
+			return nil, err
+		}
+		counts.Count++
+		counts.Size += diskSize(info)
+	}
+
+	garbage := func(path string, reason string, info os.FileInfo) {
+		rel, err := filepath.Rel(r.location, path)
+		if err != nil {
+			rel = path
+		}
+		counts.Garbage = append(counts.Garbage, GarbageFile{Path: filepath.ToSlash(rel), Reason: reason, Bytes: diskSize(info)})
+		counts.SizeGarbage += diskSize(info)
+	}
+	packDir := filepath.Join(objectsDir, "pack")
+	entries, err := repofs.ReadDir(packDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	files := map[string]bool{}
+	for _, entry := range entries {
+		files[entry.Name()] = true
+	}
+	packed := map[string]bool{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		path := filepath.Join(packDir, name)
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		base := strings.TrimSuffix(strings.TrimSuffix(name, ".pack"), ".idx")
+		switch {
+		case !packFileRegex.MatchString(name):
+			garbage(path, "garbage found", info)
+		case strings.HasSuffix(name, ".pack") && !files[base+".idx"]:
+			garbage(path, "no corresponding .idx", info)
+		case strings.HasSuffix(name, ".idx") && !files[base+".pack"]:
+			garbage(path, "no corresponding .pack", info)
+		// like git, packs count their size rather than their disk usage
+		case strings.HasSuffix(name, ".pack"):
+			counts.Packs++
+			counts.SizePack += info.Size()
+		case strings.HasSuffix(name, ".idx"):
+			counts.SizePack += info.Size()
+			idx, err := readPackIndex(path)
+			if err != nil {
+				return nil, err
+			}
+			counts.InPack += len(idx.names)
+			for _, name := range idx.names {
+				packed[name] = true
+			}
+		}
+	}
+	for name := range loose {
+		if packed[name] {
+			counts.PrunePackable++
+		}
+	}
+
+	// anything else in the loose object directories
+	dirs, err := repofs.ReadDir(objectsDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() || !looseDirRegex.MatchString(dir.Name()) {
+			continue
+		}
+		entries, err := repofs.ReadDir(filepath.Join(objectsDir, dir.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if _, ok := loose[dir.Name()+entry.Name()]; ok && looseNameRegex.MatchString(entry.Name()) {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return nil, err
+			}
+			garbage(filepath.Join(objectsDir, dir.Name(), entry.Name()), "garbage found", info)
+		}
+	}
+	slices.SortFunc(counts.Garbage, func(a, b GarbageFile) int { return strings.Compare(a.Path, b.Path) })
+	return counts, nil
+}
+
+// Writes counts like git count-objects -v, in KiB, followed by the garbage files.
+func writeObjectCounts(w io.Writer, counts *ObjectCounts) {
+	fmt.Fprintf(w, "count: %d\n", counts.Count)
+	fmt.Fprintf(w, "size: %d\n", counts.Size/1024)
+	fmt.Fprintf(w, "in-pack: %d\n", counts.InPack)
+	fmt.Fprintf(w, "packs: %d\n", counts.Packs)
+	fmt.Fprintf(w, "size-pack: %d\n", counts.SizePack/1024)
+	fmt.Fprintf(w, "prune-packable: %d\n", counts.PrunePackable)
+	fmt.Fprintf(w, "garbage: %d\n", len(counts.Garbage))
+	fmt.Fprintf(w, "size-garbage: %d\n", counts.SizeGarbage/1024)
+	for _, g := range counts.Garbage {
+		fmt.Fprintf(w, "warning: %s: %s\n", g.Reason, g.Path)
+	}
+}
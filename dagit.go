@@ -1,22 +1,311 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
 	"embed"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/dagit/repofs"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/urfave/cli/v2"
+	"golang.org/x/term"
 )
 
 //go:embed all:nextjs/dist
 var nextFS embed.FS
 var repo *Repo
 
+// set instead of repo when serving an earlier export
+var imported *importedGraph
+
+// ends the command's span and flushes traces, set when tracing is on
+var stopTracing func() error
+
+// removes the command's progress bars, set for every command but start, whose jobs are
+// reported to websocket clients
+var stopProgress func()
+var graphOpts GraphOptions
+
+func repoOptions(cCtx *cli.Context) RepoOptions {
+	return RepoOptions{
+		Workers:          cCtx.Int("workers"),
+		MaxMemory:        cCtx.Int64("max-memory") * 1024 * 1024,
+		CacheSize:        cCtx.Int64("cache-size") * 1024 * 1024,
+		Strict:           cCtx.Bool("strict"),
+		LockTimeout:      cCtx.Duration("lock-timeout"),
+		Namespace:        cCtx.String("namespace"),
+		AnonymizeAuthors: cCtx.Bool("anonymize-authors"),
+		ProfileObjects:   cCtx.Bool("profile-objects"),
+	}
+}
+
+// Loads the repo at location with the command's options, exiting with the error's code when
+// it can't be.
+func loadRepo(cCtx *cli.Context, location string) *Repo {
+	r, err := newRepo(cCtx.Context, location, repoOptions(cCtx))
+	if err != nil {
+		fatal(err)
+	}
+	return r
+}
+
+func snapshotFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "dir",
+			Usage: "The directory snapshots are stored in. Defaults to a directory per repo under dagit/snapshots in the user cache dir.",
+		},
+		&cli.StringFlag{
+			Name:  "db",
+			Usage: "Store snapshots in this SQLite database instead of a directory.",
+		},
+	}
+}
+
+func newSnapshotStore(cCtx *cli.Context) (snapshotStore, error) {
+	store := snapshotStore{dir: cCtx.String("dir"), db: cCtx.String("db")}
+	if store.dir == "" && store.db == "" {
+		var err error
+		if store.dir, err = snapshotDir(cCtx.String("repo")); err != nil {
+			return store, err
+		}
+	}
+	return store, nil
+}
+
+func uploadFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:  "upload",
+		Usage: "Upload the export to object storage: s3://bucket/key, gs://bucket/key, azblob://container/key or file:///dir/key.",
+	}
+}
+
+// Writes the graph to out in the export command's --format. An empty out writes json and
+// replay exports to stdout and sqlite exports to git.sqlite.
+func export(ctx context.Context, cCtx *cli.Context, repo *Repo, out string, opts GraphOptions) (err error) {
+	ctx, _, end := startJob(ctx, JOB_EXPORT, repo.location)
+	defer func() { end(err) }()
+	format := cCtx.String("format")
+	if format == "sqlite" {
+		if cCtx.Bool("gzip") {
+			return &UsageError{errors.New("--gzip only applies to json, dot and replay exports")}
+		}
+		return repo.toSQLite(ctx, cmp.Or(out, "git.sqlite"), opts)
+	}
+	if format != "json" && format != "replay" && format != "dot" {
+		return &UsageError{fmt.Errorf("unknown export format %q", format)}
+	}
+	if split := cCtx.String("split-size"); split != "" {
+		limit, err := splitSize(cCtx, out)
+		if err != nil {
+			return err
+		}
+		return writeSplitGraph(out, limit, cCtx.Bool("gzip"), repo.objectErrors(), func(edge func(any) error, node func(map[string]any) error) error {
+			return repo.streamGraph(ctx, opts, func(e Edge) error { return edge(e) }, node)
+		})
+	}
+	w, err := createOutput(out, cCtx.Bool("gzip"))
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, w.Close()) }()
+	switch format {
+	case "replay":
+		return repo.writeReplay(ctx, w, opts)
+	case "dot":
+		return repo.writeDot(ctx, w, opts)
+	}
+	if err := writeIndented(w, prettyJSON(cCtx), func(w io.Writer) error {
+		return repo.writeJson(ctx, w, opts)
+	}); err != nil {
+		return err
+	}
+	if out == "" && !cCtx.Bool("gzip") {
+		fmt.Println()
+	}
+	return nil
+}
+
+// Writes the merged graph of several repos to out in the export command's --format, json or
+// sqlite.
+func exportMerged(ctx context.Context, cCtx *cli.Context, repos []*Repo, locations []string, out string, opts GraphOptions) (err error) {
+	graph, err := mergeGraphs(ctx, repos, repoNamespaces(locations), opts)
+	if err != nil {
+		return err
+	}
+	switch cCtx.String("format") {
+	case "json":
+		if cCtx.String("split-size") != "" {
+			limit, err := splitSize(cCtx, out)
+			if err != nil {
+				return err
+			}
+			return writeSplitGraph(out, limit, cCtx.Bool("gzip"), nil, func(edge func(any) error, node func(map[string]any) error) error {
+				for _, e := range graph.Edges {
+					if err := edge(e); err != nil {
+						return err
+					}
+				}
+				for _, n := range graph.Nodes {
+					if err := node(n); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		}
+		data, err := json.Marshal(graph)
+		if err != nil {
+			return err
+		}
+		w, err := createOutput(out, cCtx.Bool("gzip"))
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, w.Close()) }()
+		if out == "" && !cCtx.Bool("gzip") {
+			data = append(data, '\n')
+		}
+		return writeIndented(w, prettyJSON(cCtx), func(w io.Writer) error {
+			_, err := w.Write(data)
+			return err
+		})
+	case "sqlite":
+		if cCtx.Bool("gzip") {
+			return &UsageError{errors.New("--gzip only applies to json, dot and replay exports")}
+		}
+		return graph.toSQLite(cmp.Or(out, "git.sqlite"))
+	default:
+		return &UsageError{fmt.Errorf("export format %q doesn't support --merge", cCtx.String("format"))}
+	}
+}
+
+// Parses the export command's --split-size, checking the export can be split into out.
+func splitSize(cCtx *cli.Context, out string) (int64, error) {
+	if cCtx.String("format") != "json" {
+		return 0, &UsageError{errors.New("--split-size only applies to json exports")}
+	}
+	if out == "" || cCtx.String("upload") != "" {
+		return 0, &UsageError{errors.New("--split-size needs --out to be a directory and can't be uploaded")}
+	}
+	return parseSize(cCtx.String("split-size"))
+}
+
+// An export destination and the writers to close, innermost first.
+type output struct {
+	io.Writer
+	closers []io.Closer
+}
+
+func (o *output) Close() error {
+	var errs []error
+	for _, c := range o.closers {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Opens out for writing, or stdout when it's empty. With compress what's written is gzipped.
+func createOutput(out string, compress bool) (io.WriteCloser, error) {
+	o := &output{Writer: os.Stdout}
+	if out != "" {
+		f, err := repofs.Create(out)
+		if err != nil {
+			return nil, err
+		}
+		o.Writer = f
+		o.closers = append(o.closers, f)
+	}
+	if compress {
+		gz := gzip.NewWriter(o.Writer)
+		o.Writer = gz
+		o.closers = append([]io.Closer{gz}, o.closers...)
+	}
+	return o, nil
+}
+
+// Reports whether the export command indents JSON: with --pretty or --compact=false.
+func prettyJSON(cCtx *cli.Context) bool {
+	return cCtx.Bool("pretty") || !cCtx.Bool("compact")
+}
+
+// Writes the JSON written by write to w, indented with 4 spaces when pretty. Compact JSON is
+// streamed, while indenting buffers the whole document.
+func writeIndented(w io.Writer, pretty bool, write func(io.Writer) error) error {
+	if !pretty {
+		return write(w)
+	}
+	var buf, indented bytes.Buffer
+	if err := write(&buf); err != nil {
+		return err
+	}
+	if err := json.Indent(&indented, buf.Bytes(), "", "    "); err != nil {
+		return err
+	}
+	_, err := indented.WriteTo(w)
+	return err
+}
+
+func publishFlag() cli.Flag {
+	return &cli.StringSliceFlag{
+		Name:  "publish",
+		Usage: "Publish repo change events to a message bus: nats://host:4222/subject or kafka://broker:9092/topic. Can be repeated.",
+	}
+}
+
+func journalFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:  "journal",
+		Usage: "Append every change detected in the repo (objects added and removed, refs moved and commits becoming unreachable) with its time to the changes table of this SQLite database.",
+	}
+}
+
+func explainFlag() cli.Flag {
+	return &cli.BoolFlag{
+		Name:  "explain",
+		Usage: "Explain each change to the repo in plain words, e.g. which objects were created and how refs moved.",
+	}
+}
+
+func renameFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.IntFlag{
+			Name:    "find-renames",
+			Value:   50,
+			Aliases: []string{"M"},
+			Usage:   "Minimum similarity percentage for detecting renames. 0 disables rename detection.",
+		},
+		&cli.BoolFlag{
+			Name:    "find-copies",
+			Aliases: []string{"C"},
+			Usage:   "Also detect files copied from modified files.",
+		},
+	}
+}
+
+func renameOptions(cCtx *cli.Context) RenameOptions {
+	return RenameOptions{
+		Threshold: cCtx.Int("find-renames"),
+		Copies:    cCtx.Bool("find-copies"),
+	}
+}
+
 func main() {
 
 	// Root at the `dist` folder generated by the Next.js app.
@@ -44,47 +333,436 @@ func main() {
 				Aliases: []string{"r"},
 				Usage:   "The path to the Git repo.",
 			},
+			&cli.IntFlag{
+				Name:    "workers",
+				Value:   runtime.NumCPU(),
+				Aliases: []string{"w"},
+				Usage:   "The number of goroutines used to parse and serialize objects.",
+			},
+			&cli.Int64Flag{
+				Name:  "max-memory",
+				Value: 0,
+				Usage: "Cap in MiB on compressed object content held in memory. Above it objects are read back from disk and exports are streamed. 0 means no cap.",
+			},
+			&cli.Int64Flag{
+				Name:  "cache-size",
+				Value: 64,
+				Usage: "MiB of decompressed object content cached for reuse. 0 decompresses objects on every read.",
+			},
+			&cli.DurationFlag{
+				Name:  "lock-timeout",
+				Value: 10 * time.Second,
+				Usage: "How long to wait for git commands to release index.lock, packed-refs.lock, ref locks or gc.pid before reading the repo anyway. 0 doesn't wait.",
+			},
+			&cli.StringFlag{
+				Name:    "namespace",
+				EnvVars: []string{"GIT_NAMESPACE"},
+				Usage:   "Show the refs of this ref namespace, stored under refs/namespaces/<namespace>/ by repos served with GIT_NAMESPACE, as the repo's refs. Nested namespaces are separated by /.",
+			},
+			&cli.BoolFlag{
+				Name:  "anonymize-authors",
+				Usage: "Replace the names and emails of authors, committers and taggers with pseudonyms derived from their email, after the mailmap, in every output, so the repo's structure can be shared without personal data. Commit messages and blob content are left as they are, see --redact.",
+			},
+			&cli.BoolFlag{
+				Name:  "profile-objects",
+				Usage: "Time reading each loose object and, by reading every pack, each packed object, and print the slowest objects and packs with their delta depth to stderr after loading. The server also reports them in /debug/stats.",
+			},
+			&cli.BoolFlag{
+				Name:  "paranoid",
+				Usage: "Refuse to write anything inside the repo, e.g. an export or snapshot under it, failing instead. Repo files are always opened read-only.",
+			},
+			&cli.BoolFlag{
+				Name:  "strict",
+				Usage: "Fail on corrupt or truncated objects instead of skipping them and reporting them as parseErrors.",
+			},
+			&cli.StringFlag{
+				Name:  "output",
+				Value: "text",
+				Usage: "How errors are reported on stderr: text, or json for scripts, as {\"error\": {\"kind\", \"code\", \"message\", \"details\"}}. The exit code is 1 for usage errors, 2 when the repo isn't found, 3 for corrupt objects, 4 when an export fails and 5 when drift finds changes.",
+			},
+			&cli.BoolFlag{
+				Name:  "no-progress",
+				Usage: "Don't report the progress of long operations.",
+			},
+			&cli.BoolFlag{
+				Name:  "progress-json",
+				Usage: "Report progress as a JSON object per line on stderr instead of progress bars, or of plain percentage lines when stderr isn't a terminal.",
+			},
+			&cli.StringFlag{
+				Name:    "otlp-endpoint",
+				EnvVars: []string{"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "OTEL_EXPORTER_OTLP_ENDPOINT"},
+				Usage:   "Export OpenTelemetry traces over OTLP/HTTP to this URL, e.g. http://localhost:4318. Tracing is off when empty.",
+			},
+		},
+		OnUsageError: usageError,
+		CommandNotFound: func(cCtx *cli.Context, command string) {
+			jsonErrors = cCtx.String("output") == "json"
+			fatal(&UsageError{fmt.Errorf("no command named %q, see dagit help", command)})
+		},
+		Before: func(cCtx *cli.Context) error {
+			switch output := cCtx.String("output"); output {
+			case "text":
+			case "json":
+				jsonErrors = true
+			default:
+				return &UsageError{fmt.Errorf("unknown --output %q, use text or json", output)}
+			}
+			if err := validNamespace(cCtx.String("namespace")); err != nil {
+				return &UsageError{err}
+			}
+			repofs.SetParanoid(cCtx.Bool("paranoid"))
+			if err := repofs.Protect(cCtx.String("repo")); err != nil {
+				return err
+			}
+			if command := cCtx.Args().First(); command != "start" && command != "serve" && !cCtx.Bool("no-progress") {
+				format := PROGRESS_PLAIN
+				switch {
+				case cCtx.Bool("progress-json"):
+					format = PROGRESS_JSON
+				case term.IsTerminal(int(os.Stderr.Fd())):
+					format = PROGRESS_BAR
+				}
+				stopProgress = showProgress(format)
+			}
+			endpoint := cCtx.String("otlp-endpoint")
+			if endpoint == "" {
+				return nil
+			}
+			shutdown, err := setupTracing(cCtx.Context, endpoint, cCtx.App.Version)
+			if err != nil {
+				return err
+			}
+			ctx, span := tracer.Start(cCtx.Context, "dagit "+cCtx.Args().First())
+			cCtx.Context = ctx
+			stopTracing = func() error {
+				span.End()
+				return shutdown(context.Background())
+			}
+			return nil
+		},
+		After: func(cCtx *cli.Context) error {
+			if stopProgress != nil {
+				stopProgress()
+			}
+			if stopTracing != nil {
+				return stopTracing()
+			}
+			return nil
 		},
 		Commands: []*cli.Command{
 			{
 				Name:  "to-sqlite",
 				Usage: "Generates a SQLite database representing the Git repo.",
-				Flags: []cli.Flag{
+				Flags: append([]cli.Flag{
 					&cli.StringFlag{
 						Name:    "db",
 						Value:   "git.sqlite",
 						Aliases: []string{"d"},
 						Usage:   "The path to the database to output.",
 					},
-				},
+					uploadFlag(),
+				}, graphFlags()...),
 				Action: func(cCtx *cli.Context) error {
-					repo := newRepo(cCtx.String("repo"))
-					repo.toSQLite(cCtx.String("db"))
+					opts, err := graphOptions(cCtx)
+					if err != nil {
+						return err
+					}
+					repo := loadRepo(cCtx, cCtx.String("repo"))
+					if err := repo.toSQLite(cCtx.Context, cCtx.String("db"), opts); err != nil {
+						return exportError("sqlite", err)
+					}
+					if dest := cCtx.String("upload"); dest != "" {
+						return exportError("sqlite", upload(cCtx.Context, dest, cCtx.String("db")))
+					}
 					return nil
 				},
 			},
 			{
-				Name:  "start",
-				Usage: "Starts the dagit visualization in the browser.",
-				Flags: []cli.Flag{
+				Name:  "export",
+				Usage: "Exports the graph of the Git repo.",
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name:    "format",
+						Value:   "json",
+						Aliases: []string{"f"},
+						Usage:   "The export format: json, sqlite, dot (a Graphviz digraph) or replay (newline-delimited JSON events of objects being created and refs moving, in the order they happened).",
+					},
+					&cli.StringFlag{
+						Name:    "out",
+						Aliases: []string{"o"},
+						Usage:   "The path to write the export to. json, dot and replay default to stdout and sqlite to git.sqlite.",
+					},
+					&cli.BoolFlag{
+						Name:  "compact",
+						Value: true,
+						Usage: "Write JSON without whitespace. --compact=false indents it with 4 spaces like --pretty.",
+					},
+					&cli.BoolFlag{
+						Name:  "pretty",
+						Usage: "Indent JSON with 4 spaces. The whole document is buffered in memory to indent it.",
+					},
+					&cli.BoolFlag{
+						Name:  "gzip",
+						Usage: "Gzip json, dot and replay exports.",
+					},
+					&cli.StringFlag{
+						Name:  "split-size",
+						Usage: "Split json exports into numbered newline-delimited JSON files of at most this size, e.g. 100MiB, written with a manifest to the --out directory.",
+					},
+					uploadFlag(),
+					&cli.StringSliceFlag{
+						Name:  "repo",
+						Usage: "The repos to export with --merge. Can be repeated. Defaults to the global --repo.",
+					},
+					&cli.BoolFlag{
+						Name:  "merge",
+						Usage: "Merge the graphs of every --repo into one, namespacing nodes by repo and linking submodule gitlinks and blobs shared across repos.",
+					},
+					&cli.BoolFlag{
+						Name:  "watch",
+						Usage: "Keep running, exporting again whenever the repo changed since the last export. Each export atomically replaces --out unless --timestamped is set.",
+					},
+					&cli.StringFlag{
+						Name:  "every",
+						Value: "10m",
+						Usage: "How often --watch checks the repo for changes: a duration like 10m or a cron spec like \"0 * * * *\" (minute, hour, day of month, month, day of week).",
+					},
+					&cli.BoolFlag{
+						Name:  "timestamped",
+						Usage: "With --watch, write each export to a new file named after --out with the time of the export, e.g. graph-20060102T150405Z.json.",
+					},
+				}, graphFlags()...),
+				Action: func(cCtx *cli.Context) error {
+					opts, err := graphOptions(cCtx)
+					if err != nil {
+						return err
+					}
+					locations := cCtx.StringSlice("repo")
+					if len(locations) == 0 {
+						// the global --repo, shadowed by the command's
+						locations = []string{cCtx.Lineage()[1].String("repo")}
+					}
+					if len(locations) > 1 && !cCtx.Bool("merge") {
+						return &UsageError{errors.New("exporting several repos needs --merge")}
+					}
+					if cCtx.Bool("pretty") && cCtx.IsSet("compact") && cCtx.Bool("compact") {
+						return &UsageError{errors.New("--pretty and --compact can't be used together")}
+					}
+					watch := cCtx.Bool("watch")
+					var sched schedule
+					if watch {
+						if sched, err = parseSchedule(cCtx.String("every")); err != nil {
+							return &UsageError{err}
+						}
+						if cCtx.String("out") == "" && cCtx.String("format") != "sqlite" {
+							return &UsageError{errors.New("--watch needs --out")}
+						}
+						if cCtx.String("split-size") != "" && !cCtx.Bool("timestamped") {
+							return &UsageError{errors.New("--split-size with --watch needs --timestamped, as split directories can't be replaced atomically")}
+						}
+					} else if cCtx.IsSet("every") || cCtx.Bool("timestamped") {
+						return &UsageError{errors.New("--every and --timestamped need --watch")}
+					}
+					var repos []*Repo
+					for _, location := range locations {
+						repos = append(repos, loadRepo(cCtx, location))
+					}
+					dest := cCtx.String("upload")
+					run := func(ctx context.Context, out string) error {
+						if dest != "" && out == "" && cCtx.String("format") != "sqlite" {
+							// stage stdout exports in a temporary file to upload
+							f, err := os.CreateTemp("", "dagit-export-*")
+							if err != nil {
+								return err
+							}
+							f.Close()
+							defer os.Remove(f.Name())
+							out = f.Name()
+						}
+						var err error
+						if cCtx.Bool("merge") {
+							err = exportMerged(ctx, cCtx, repos, locations, out, opts)
+						} else {
+							err = export(ctx, cCtx, repos[0], out, opts)
+						}
+						if err != nil {
+							return exportError(cCtx.String("format"), err)
+						}
+						if dest != "" {
+							return exportError(cCtx.String("format"), upload(ctx, dest, cmp.Or(out, "git.sqlite")))
+						}
+						return nil
+					}
+					if !watch {
+						return run(cCtx.Context, cCtx.String("out"))
+					}
+					out := cmp.Or(cCtx.String("out"), "git.sqlite")
+					return watchExport(cCtx.Context, repos, sched, func(ctx context.Context) error {
+						if cCtx.Bool("timestamped") {
+							return run(ctx, timestampedPath(out, time.Now()))
+						}
+						return replaceAtomically(out, func(tmp string) error { return run(ctx, tmp) })
+					})
+				},
+			},
+			{
+				Name:    "start",
+				Aliases: []string{"serve"},
+				Usage:   "Starts the dagit visualization in the browser.",
+				Flags: append([]cli.Flag{
 					&cli.StringFlag{
 						Name:    "repo-path",
 						Value:   ".",
 						Aliases: []string{"r"},
 						Usage:   "todo",
 					},
-				},
+					&cli.BoolFlag{
+						Name:  "pprof",
+						Usage: "Registers the net/http/pprof handlers under /debug/pprof/.",
+					},
+					explainFlag(),
+					publishFlag(),
+					journalFlag(),
+					&cli.StringFlag{
+						Name:  "from-graph",
+						Usage: "Serve a graph exported with export --format json instead of a repo.",
+					},
+					&cli.StringFlag{
+						Name:  "from-sqlite",
+						Usage: "Serve a graph exported with to-sqlite or export --format sqlite instead of a repo.",
+					},
+					&cli.StringFlag{
+						Name:    "admin-token",
+						EnvVars: []string{"DAGIT_ADMIN_TOKEN"},
+						Usage:   "Turn on the admin API, which adds and removes watched repos at runtime, for requests with an Authorization: Bearer header carrying this token.",
+					},
+					&cli.StringFlag{
+						Name:  "layout-dir",
+						Usage: "The directory node positions arranged in the browser are saved to. Defaults to a directory per repo under dagit/layouts in the user cache dir.",
+					},
+					&cli.BoolFlag{
+						Name:  "log-json",
+						Usage: "Log as JSON lines instead of text, e.g. for a log collector.",
+					},
+					&cli.BoolFlag{
+						Name:  "demo",
+						Usage: "Safely host a public instance: leave blob content out, cap graph depth, rate limit each client IP and turn off exports, the admin API, saving layouts and debug endpoints.",
+					},
+					&cli.IntFlag{
+						Name:  "demo-depth",
+						Value: 100,
+						Usage: "The most commit generations a graph includes in demo mode.",
+					},
+					&cli.Float64Flag{
+						Name:  "demo-rate",
+						Value: 1,
+						Usage: "The requests per second each client IP may make in demo mode.",
+					},
+					&cli.IntFlag{
+						Name:  "demo-burst",
+						Value: 10,
+						Usage: "The requests each client IP may make at once in demo mode before --demo-rate applies.",
+					},
+				}, graphFlags()...),
 				Action: func(cCtx *cli.Context) error {
-					dir := cCtx.String("repo")
-					repo = newRepo(dir)
+					if cCtx.Bool("log-json") {
+						slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+					}
+					opts, err := graphOptions(cCtx)
+					if err != nil {
+						return err
+					}
+					switch {
+					case cCtx.IsSet("from-graph") && cCtx.IsSet("from-sqlite"):
+						return &UsageError{errors.New("--from-graph and --from-sqlite can't be used together")}
+					case cCtx.IsSet("from-graph"):
+						imported, err = importGraphJson(cCtx.String("from-graph"))
+					case cCtx.IsSet("from-sqlite"):
+						imported, err = importGraphSQLite(cCtx.String("from-sqlite"))
+					}
+					if err != nil {
+						return err
+					}
+					if cCtx.Bool("demo") {
+						if demo, err = newDemoMode(cCtx.Int("demo-depth"), cCtx.Float64("demo-rate"), cCtx.Int("demo-burst")); err != nil {
+							return &UsageError{err}
+						}
+						opts = demo.limit(opts)
+						slog.Info("demo mode", "max_depth", demo.MaxDepth, "rate", demo.Rate, "burst", demo.Burst)
+					}
+					if imported != nil {
+						if cCtx.IsSet("publish") || cCtx.IsSet("journal") || cCtx.Bool("explain") {
+							return &UsageError{errors.New("--publish, --journal and --explain need a repo, not an imported graph")}
+						}
+						if demo != nil {
+							return &UsageError{errors.New("--demo needs a repo, not an imported graph, whose blob content can't be left out")}
+						}
+						slog.Info("serving imported graph", "nodes", imported.nodes, "source", imported.source)
+					} else {
+						dir := cCtx.String("repo")
+						publishers, err := repoPublishers(cCtx, dir)
+						if err != nil {
+							return err
+						}
+						defer closePublishers(publishers)
+						repo = loadRepo(cCtx, dir)
+						repo.trackHistory(cCtx.Bool("explain"))
+						repo.publishTo(cCtx.Context, publishers)
+						watched.setPrimary(cCtx.Context, repo)
+						graphOpts = opts
+						if _, err := repo.selectObjects(graphOpts); err != nil {
+							return err
+						}
+					}
+					mux := http.NewServeMux()
 					// The static Next.js app will be served under `/`.
-					http.Handle("/", http.FileServer(http.FS(distFS)))
-					http.HandleFunc("/ws", serveWs)
+					mux.Handle("/", http.FileServer(http.FS(distFS)))
+					mux.HandleFunc("/ws", serveWs)
+					mux.HandleFunc("GET /api/graph", traced("GET /api/graph", serveGraph))
+					mux.HandleFunc("GET /api/metrics", traced("GET /api/metrics", serveMetrics))
+					mux.HandleFunc("GET /api/summary", traced("GET /api/summary", serveSummary))
+					mux.HandleFunc("GET /api/openapi.json", serveOpenAPI(cCtx.App.Version))
+					mux.HandleFunc("GET /api/graph.schema.json", serveGraphSchema)
+					mux.HandleFunc("GET /api/docs", serveAPIDocs)
+					mux.HandleFunc("GET /api/repos", serveRepos)
+					mux.HandleFunc("GET /api/repos/{name}/graph", traced("GET /api/repos/{name}/graph", serveGraph))
+					mux.HandleFunc("GET /api/repos/{name}/metrics", traced("GET /api/repos/{name}/metrics", serveMetrics))
+					mux.HandleFunc("GET /api/repos/{name}/summary", traced("GET /api/repos/{name}/summary", serveSummary))
+					layouts := &layoutStore{dir: cCtx.String("layout-dir")}
+					if layouts.dir == "" && repo != nil {
+						if layouts.dir, err = layoutDir(repo.location); err != nil {
+							return err
+						}
+					} else if layouts.dir == "" {
+						cache, err := os.UserCacheDir()
+						if err != nil {
+							return err
+						}
+						layouts.dir = filepath.Join(cache, "dagit", "layouts")
+					}
+					mux.HandleFunc("GET /api/layout/{clientID}", serveLayout(layouts))
+					mux.HandleFunc("PUT /api/layout/{clientID}", demo.disable(serveLayout(layouts)))
+					mux.HandleFunc("GET /api/config", serveUIConfig)
+					mux.HandleFunc("GET /api/jobs", serveJobs)
+					mux.HandleFunc("GET /api/objects/{prefix}", traced("GET /api/objects/{prefix}", serveObject))
+					// raw objects carry blob content, which demo mode leaves out
+					mux.HandleFunc("GET /api/objects/{prefix}/raw", demo.disable(traced("GET /api/objects/{prefix}/raw", serveRawObject)))
+					mux.HandleFunc("GET /api/commits/{hash}", traced("GET /api/commits/{hash}", serveCommit))
+					mux.HandleFunc("POST /api/export", demo.disable(serveStartExport(cCtx.Context)))
+					mux.HandleFunc("GET /api/export/{id}", demo.disable(serveExport))
+					adminToken := cCtx.String("admin-token")
+					mux.HandleFunc("POST /api/admin/repos", demo.disable(requireAdmin(adminToken, serveAddRepo(cCtx.Context, repoOptions(cCtx)))))
+					mux.HandleFunc("DELETE /api/admin/repos/{name}", demo.disable(requireAdmin(adminToken, serveRemoveRepo)))
+					mux.HandleFunc("/debug/stats", demo.disable(traced("/debug/stats", serveStats)))
+					if cCtx.Bool("pprof") && demo == nil {
+						registerPprof(mux)
+					}
 					server := &http.Server{
 						Addr:              ":8080",
+						Handler:           logRequests(demo.limitRate(mux)),
 						ReadHeaderTimeout: 3 * time.Second,
 					}
-					log.Println("Starting HTTP server at http://localhost:8080 ...")
+					slog.Info("starting HTTP server", "url", "http://localhost:8080")
 					if err := server.ListenAndServe(); err != nil {
 						log.Fatal(err)
 					}
@@ -92,35 +770,1174 @@ func main() {
 				},
 			},
 			{
-				Name:  "show",
-				Usage: "Shows the content of a Git object.",
+				Name:  "snapshot",
+				Usage: "Saves and compares snapshots of the repo's objects and refs, e.g. before and after a rebase.",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "save",
+						Usage: "Saves a snapshot of the current objects and refs.",
+						Flags: append([]cli.Flag{
+							&cli.StringFlag{
+								Name:     "name",
+								Aliases:  []string{"n"},
+								Required: true,
+								Usage:    "The name to save the snapshot as. An existing snapshot with the name is replaced.",
+							},
+						}, snapshotFlags()...),
+						Action: func(cCtx *cli.Context) error {
+							name := cCtx.String("name")
+							if strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+								return &UsageError{fmt.Errorf("invalid snapshot name %q", name)}
+							}
+							repo := loadRepo(cCtx, cCtx.String("repo"))
+							store, err := newSnapshotStore(cCtx)
+							if err != nil {
+								return err
+							}
+							snapshot := repo.snapshot(name)
+							if err := store.save(snapshot); err != nil {
+								return err
+							}
+							fmt.Printf("saved snapshot %s with %d objects and %d refs\n", name, len(snapshot.Objects), len(snapshot.Refs))
+							return nil
+						},
+					},
+					{
+						Name:      "diff",
+						Usage:     "Prints the objects added and removed and the refs moved between two snapshots, or between a snapshot and the current repo.",
+						ArgsUsage: "<from> [to]",
+						Flags: append([]cli.Flag{
+							&cli.BoolFlag{
+								Name:  "json",
+								Usage: "Print the diff as JSON.",
+							},
+						}, snapshotFlags()...),
+						Action: func(cCtx *cli.Context) error {
+							if cCtx.NArg() < 1 || cCtx.NArg() > 2 {
+								return &UsageError{errors.New("expected one or two snapshot names")}
+							}
+							store, err := newSnapshotStore(cCtx)
+							if err != nil {
+								return err
+							}
+							from, err := store.load(cCtx.Args().Get(0))
+							if err != nil {
+								return err
+							}
+							var to *Snapshot
+							if cCtx.NArg() == 2 {
+								if to, err = store.load(cCtx.Args().Get(1)); err != nil {
+									return err
+								}
+							} else {
+								to = loadRepo(cCtx, cCtx.String("repo")).snapshot("current")
+							}
+							diff := diffSnapshots(from, to)
+							if cCtx.Bool("json") {
+								out, err := json.Marshal(diff)
+								if err != nil {
+									return err
+								}
+								fmt.Println(string(out))
+								return nil
+							}
+							writeSnapshotDiff(os.Stdout, diff)
+							return nil
+						},
+					},
+					{
+						Name:  "list",
+						Usage: "Lists the saved snapshots, oldest first.",
+						Flags: snapshotFlags(),
+						Action: func(cCtx *cli.Context) error {
+							store, err := newSnapshotStore(cCtx)
+							if err != nil {
+								return err
+							}
+							snapshots, err := store.list()
+							if err != nil {
+								return err
+							}
+							for _, s := range snapshots {
+								fmt.Printf("%s\t%s\t%d objects\n", s.Name, s.Created.Format(time.RFC3339), len(s.Objects))
+							}
+							return nil
+						},
+					},
+				},
+			},
+			{
+				Name:  "drift",
+				Usage: "Compares the repo against a graph exported earlier with export --format sqlite, printing the objects added and removed and the refs moved since. Exits with code 5 when the repo drifted, e.g. to alert from cron.",
+				Description: "The baseline should be an export without graph filters: objects a filtered export left out show as added. " +
+					"Only HEAD, branches, tags and remote-tracking refs are compared, as exports don't show other refs.",
 				Flags: []cli.Flag{
 					&cli.StringFlag{
-						Name:    "object",
-						Aliases: []string{"o"},
-						Usage:   "Pass multiple greetings",
+						Name:     "baseline",
+						Usage:    "The SQLite export to compare against.",
+						Required: true,
+					},
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "Print the differences as JSON.",
 					},
-					&cli.BoolFlag{Name: "type", Aliases: []string{"t"}},
 				},
 				Action: func(cCtx *cli.Context) error {
-					repo := newRepo(cCtx.String("repo"))
-					if cCtx.String("object") == "" {
-						fmt.Println(string(repo.toJson()))
+					baseline, err := sqliteSnapshot(cCtx.String("baseline"))
+					if err != nil {
+						return err
+					}
+					repo := loadRepo(cCtx, cCtx.String("repo"))
+					diff := repo.drift(baseline)
+					if cCtx.Bool("json") {
+						out, err := json.Marshal(diff)
+						if err != nil {
+							return err
+						}
+						fmt.Println(string(out))
 					} else {
-						obj := repo.getObject(cCtx.String("object"))
-						if cCtx.Bool("type") {
-							fmt.Println(obj.Type)
-						} else {
-							fmt.Println(string(obj.toJson()[:]))
+						writeSnapshotDiff(os.Stdout, diff)
+					}
+					if len(diff.Added) > 0 || len(diff.Removed) > 0 || len(diff.Refs) > 0 {
+						return &DriftError{Baseline: baseline.Name, Added: len(diff.Added), Removed: len(diff.Removed), Refs: len(diff.Refs)}
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "watch",
+				Usage: "Watches the repo and prints history events as it changes: refs created, deleted, fast-forwarded or rewritten commits becoming unreachable and checkouts moving HEAD.",
+				Flags: []cli.Flag{
+					&cli.DurationFlag{
+						Name:  "interval",
+						Value: repoPeriod,
+						Usage: "How often to check the repo for changes.",
+					},
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "Print events as JSON, one per line.",
+					},
+					explainFlag(),
+					publishFlag(),
+					journalFlag(),
+				},
+				Action: func(cCtx *cli.Context) error {
+					publishers, err := repoPublishers(cCtx, cCtx.String("repo"))
+					if err != nil {
+						return err
+					}
+					defer closePublishers(publishers)
+					repo := loadRepo(cCtx, cCtx.String("repo"))
+					repo.trackHistory(cCtx.Bool("explain"))
+					repo.publishTo(cCtx.Context, publishers)
+					ticker := time.NewTicker(cCtx.Duration("interval"))
+					defer ticker.Stop()
+					for {
+						select {
+						case <-cCtx.Context.Done():
+							return nil
+						case <-ticker.C:
+						}
+						changed, err := repo.changed()
+						if err != nil {
+							return err
+						}
+						if !changed {
+							continue
+						}
+						events, err := repo.refresh(cCtx.Context)
+						if err != nil {
+							return err
+						}
+						for _, e := range events {
+							if !cCtx.Bool("json") {
+								fmt.Println(e)
+								continue
+							}
+							out, err := json.Marshal(e)
+							if err != nil {
+								return err
+							}
+							fmt.Println(string(out))
+						}
+					}
+				},
+			},
+			{
+				Name:      "compare",
+				Usage:     "Compares two repos, e.g. a fork and its upstream, printing a combined graph marking each object's side and where they diverged.",
+				ArgsUsage: "<path-a> <path-b>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "rev-a",
+						Value: "HEAD",
+						Usage: "The revision of the first repo to compare.",
+					},
+					&cli.StringFlag{
+						Name:  "rev-b",
+						Value: "HEAD",
+						Usage: "The revision of the second repo to compare.",
+					},
+					&cli.BoolFlag{
+						Name:  "summary",
+						Usage: "Print a summary of shared and unique commits instead of the graph.",
+					},
+					&cli.StringFlag{
+						Name:    "out",
+						Aliases: []string{"o"},
+						Usage:   "The path to write the comparison to. Defaults to stdout.",
+					},
+				},
+				Action: func(cCtx *cli.Context) error {
+					if cCtx.NArg() != 2 {
+						return &UsageError{errors.New("expected two repo paths")}
+					}
+					locations := cCtx.Args().Slice()
+					a := loadRepo(cCtx, locations[0])
+					b := loadRepo(cCtx, locations[1])
+					namespaces := repoNamespaces(locations)
+					comparison, err := compareRepos(cCtx.Context, a, cCtx.String("rev-a"), b, cCtx.String("rev-b"), [2]string{namespaces[0], namespaces[1]})
+					if err != nil {
+						return err
+					}
+					w := os.Stdout
+					if out := cCtx.String("out"); out != "" {
+						f, err := repofs.Create(out)
+						if err != nil {
+							return err
+						}
+						defer f.Close()
+						w = f
+					}
+					if cCtx.Bool("summary") {
+						writeComparison(w, comparison)
+						return nil
+					}
+					return json.NewEncoder(w).Encode(comparison)
+				},
+			},
+			{
+				Name:  "scan-secrets",
+				Usage: "Scans every blob, including unreachable ones, for content that looks like a secret.",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "rules",
+						Usage: "A JSON file of rules replacing the defaults: [{\"name\", \"pattern\", \"group\", \"minEntropy\"}].",
+					},
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "Print the findings as JSON.",
+					},
+				},
+				Action: func(cCtx *cli.Context) error {
+					rules, err := loadSecretRules(cCtx.String("rules"))
+					if err != nil {
+						return err
+					}
+					repo := loadRepo(cCtx, cCtx.String("repo"))
+					findings := repo.scanSecrets(rules)
+					if cCtx.Bool("json") {
+						if findings == nil {
+							findings = []SecretFinding{}
+						}
+						out, err := json.Marshal(findings)
+						if err != nil {
+							return err
 						}
+						fmt.Println(string(out))
+						return nil
 					}
+					writeSecretFindings(os.Stdout, findings)
 					return nil
 				},
 			},
-		},
-	}
-
-	if err := app.Run(os.Args); err != nil {
-		log.Fatal(err)
+			{
+				Name:  "lfs-report",
+				Usage: "Prints the blobs at or above a size that aren't in Git LFS, largest first, as JSON. Candidates to migrate to LFS.",
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name:  "threshold",
+						Value: "1MiB",
+						Usage: "The size from which blobs are reported, e.g. 500K or 10MiB.",
+					},
+				}, graphFlags()...),
+				Action: func(cCtx *cli.Context) error {
+					opts, err := graphOptions(cCtx)
+					if err != nil {
+						return err
+					}
+					threshold, err := parseSize(cCtx.String("threshold"))
+					if err != nil {
+						return err
+					}
+					repo := loadRepo(cCtx, cCtx.String("repo"))
+					report, err := repo.lfsReport(opts, threshold)
+					if err != nil {
+						return err
+					}
+					out, err := json.Marshal(report)
+					if err != nil {
+						return err
+					}
+					fmt.Println(string(out))
+					return nil
+				},
+			},
+			{
+				Name:  "gc-preview",
+				Usage: "Prints the loose objects and bytes git gc --prune would delete as JSON, without modifying the repo.",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "prune",
+						Value: "now",
+						Usage: "Only count unreachable objects last modified before this cutoff: now or a duration before now like 336h.",
+					},
+				},
+				Action: func(cCtx *cli.Context) error {
+					cutoff, err := parsePruneCutoff(cCtx.String("prune"), time.Now())
+					if err != nil {
+						return err
+					}
+					repo := loadRepo(cCtx, cCtx.String("repo"))
+					preview, err := repo.gcPreview(cutoff)
+					if err != nil {
+						return err
+					}
+					out, err := json.Marshal(preview)
+					if err != nil {
+						return err
+					}
+					fmt.Println(string(out))
+					return nil
+				},
+			},
+			{
+				Name:  "growth",
+				Usage: "Prints the objects and bytes added per period, counting each object at the oldest commit introducing it.",
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name:  "period",
+						Value: PERIOD_WEEK,
+						Usage: "The period to count by: day, week, month or year.",
+					},
+					&cli.StringFlag{
+						Name:    "format",
+						Value:   "json",
+						Aliases: []string{"f"},
+						Usage:   "The output format: json or csv.",
+					},
+					&cli.BoolFlag{
+						Name:  "reflog",
+						Usage: "Date commits by when a reflog first recorded them, when it did, rather than by commit time.",
+					},
+				}, graphFlags()...),
+				Action: func(cCtx *cli.Context) error {
+					opts, err := graphOptions(cCtx)
+					if err != nil {
+						return err
+					}
+					repo := loadRepo(cCtx, cCtx.String("repo"))
+					sel, err := repo.selectObjects(opts)
+					if err != nil {
+						return err
+					}
+					points, err := repo.growth(sel, cCtx.String("period"), cCtx.Bool("reflog"))
+					if err != nil {
+						return err
+					}
+					switch cCtx.String("format") {
+					case "json":
+						out, err := json.Marshal(points)
+						if err != nil {
+							return err
+						}
+						fmt.Println(string(out))
+						return nil
+					case "csv":
+						return writeGrowthCSV(os.Stdout, points)
+					default:
+						return &UsageError{fmt.Errorf("unknown format %q", cCtx.String("format"))}
+					}
+				},
+			},
+			{
+				Name:  "timeline",
+				Usage: "Prints the repo's tags oldest first with the commit they tag, their date, tagger and message and the days since the previous tag, to chart release cadence. Lightweight tags are dated by commit time.",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "format",
+						Value:   "json",
+						Aliases: []string{"f"},
+						Usage:   "The output format: json or csv.",
+					},
+				},
+				Action: func(cCtx *cli.Context) error {
+					repo := loadRepo(cCtx, cCtx.String("repo"))
+					entries := repo.timeline(nil)
+					switch cCtx.String("format") {
+					case "json":
+						if entries == nil {
+							entries = []TimelineEntry{}
+						}
+						out, err := json.Marshal(entries)
+						if err != nil {
+							return err
+						}
+						fmt.Println(string(out))
+						return nil
+					case "csv":
+						return writeTimelineCSV(os.Stdout, entries)
+					default:
+						return &UsageError{fmt.Errorf("unknown format %q", cCtx.String("format"))}
+					}
+				},
+			},
+			{
+				Name:  "fsck",
+				Usage: "Checks the repo's objects: loose objects that can't be read are errors, and trees git wouldn't write (entries out of canonical order, duplicate or invalid names, trailing bytes) and commits committed before a parent (clock skew) are warnings.",
+				Description: "Malformed trees are read as they are, and flagged in graphs with a malformed attribute, as skewed commits are with skewed: true. " +
+					"Exits non-zero only on errors, with the corrupt object exit code.",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "Print the report as JSON.",
+					},
+				},
+				Action: func(cCtx *cli.Context) error {
+					repo := loadRepo(cCtx, cCtx.String("repo"))
+					warnings, err := repo.checkTrees()
+					if err != nil {
+						return err
+					}
+					skewed, err := repo.checkClockSkew()
+					if err != nil {
+						return err
+					}
+					report := &FsckReport{Objects: len(repo.objects), Errors: repo.objectErrors(), Warnings: warnings, ClockSkew: skewed}
+					if report.Errors == nil {
+						report.Errors = []ParseError{}
+					}
+					if report.Warnings == nil {
+						report.Warnings = []TreeProblem{}
+					}
+					if report.ClockSkew == nil {
+						report.ClockSkew = []ClockSkew{}
+					}
+					if cCtx.Bool("json") {
+						if err := json.NewEncoder(os.Stdout).Encode(report); err != nil {
+							return err
+						}
+					} else {
+						writeFsckReport(os.Stdout, report)
+					}
+					if len(report.Errors) > 0 {
+						first := report.Errors[0]
+						return &CorruptObjectError{Name: first.Name, Location: first.Location, Err: errors.New(first.Error)}
+					}
+					return nil
+				},
+			},
+			{
+				Name:      "verify-pack",
+				Usage:     "Checks packs against their indexes: checksums, object counts and per-object CRCs. Prints object counts by type and delta chain lengths.",
+				ArgsUsage: "[<pack>...]",
+				Description: "Takes the .pack or .idx paths of packs, defaulting to every pack of the repo. " +
+					"Exits non-zero when a pack has problems.",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "Print the results as JSON.",
+					},
+				},
+				Action: func(cCtx *cli.Context) error {
+					packs := cCtx.Args().Slice()
+					if len(packs) == 0 {
+						var err error
+						repo := &Repo{location: cCtx.String("repo")}
+						if packs, err = repo.packs(); err != nil {
+							return err
+						}
+					}
+					var results []*PackVerification
+					failed := 0
+					for _, pack := range packs {
+						v, err := verifyPack(pack)
+						if err != nil {
+							return err
+						}
+						if len(v.Problems) > 0 {
+							failed++
+						}
+						results = append(results, v)
+					}
+					if cCtx.Bool("json") {
+						out, err := json.Marshal(results)
+						if err != nil {
+							return err
+						}
+						fmt.Println(string(out))
+					} else {
+						for _, v := range results {
+							writePackVerification(os.Stdout, v)
+						}
+					}
+					if failed > 0 {
+						return fmt.Errorf("%d of %d %s failed verification", failed, len(results), plural(len(results), "pack"))
+					}
+					return nil
+				},
+			},
+			{
+				Name:      "unpack",
+				Usage:     "Writes every object of a pack as a loose object, without touching the repo.",
+				ArgsUsage: "<pack>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "out",
+						Aliases:  []string{"o"},
+						Required: true,
+						Usage:    "The directory to write the objects to, laid out like .git/objects.",
+					},
+				},
+				Action: func(cCtx *cli.Context) error {
+					if cCtx.NArg() != 1 {
+						return &UsageError{errors.New("unpack takes the .pack or .idx path of a pack")}
+					}
+					n, err := unpack(cCtx.Args().First(), cCtx.String("out"))
+					if err != nil {
+						return err
+					}
+					fmt.Printf("unpacked %d %s to %s\n", n, plural(n, "object"), cCtx.String("out"))
+					return nil
+				},
+			},
+			{
+				Name:  "count-objects",
+				Usage: "Prints the number and disk usage of loose and packed objects and the garbage files under .git/objects, like git count-objects -v.",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "Print the counts as JSON, with sizes in bytes.",
+					},
+				},
+				Action: func(cCtx *cli.Context) error {
+					repo := loadRepo(cCtx, cCtx.String("repo"))
+					counts, err := repo.countObjects()
+					if err != nil {
+						return err
+					}
+					if !cCtx.Bool("json") {
+						writeObjectCounts(os.Stdout, counts)
+						return nil
+					}
+					out, err := json.Marshal(counts)
+					if err != nil {
+						return err
+					}
+					fmt.Println(string(out))
+					return nil
+				},
+			},
+			{
+				Name:  "summary",
+				Usage: "Prints HEAD, the object counts by type, the number of branches and tags, the latest commit and the size of the repo.",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "Print the summary as JSON, with the size in bytes.",
+					},
+				},
+				Action: func(cCtx *cli.Context) error {
+					repo := loadRepo(cCtx, cCtx.String("repo"))
+					summary, err := repo.Summary()
+					if err != nil {
+						return err
+					}
+					if !cCtx.Bool("json") {
+						writeSummary(os.Stdout, summary)
+						return nil
+					}
+					out, err := json.Marshal(summary)
+					if err != nil {
+						return err
+					}
+					fmt.Println(string(out))
+					return nil
+				},
+			},
+			{
+				Name:      "du",
+				Usage:     "Prints the size of the blobs under every directory of a commit, like du.",
+				ArgsUsage: "[<rev>]",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:  "depth",
+						Usage: "Only list directories at most N levels below the root. 0 means no limit.",
+					},
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "Print the sizes as JSON.",
+					},
+				},
+				Action: func(cCtx *cli.Context) error {
+					repo := loadRepo(cCtx, cCtx.String("repo"))
+					usage, err := repo.du(cmp.Or(cCtx.Args().First(), "HEAD"), cCtx.Int("depth"))
+					if err != nil {
+						return err
+					}
+					if !cCtx.Bool("json") {
+						writeDirUsage(os.Stdout, usage)
+						return nil
+					}
+					out, err := json.Marshal(usage)
+					if err != nil {
+						return err
+					}
+					fmt.Println(string(out))
+					return nil
+				},
+			},
+			{
+				Name:      "tree",
+				Usage:     "Prints the directory structure of a commit like the tree utility, with the hash and size of every file.",
+				ArgsUsage: "[<rev>]",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:    "depth",
+						Aliases: []string{"L"},
+						Usage:   "Only print N levels of directories. 0 means no limit.",
+					},
+					&cli.BoolFlag{
+						Name:  "ascii",
+						Usage: "Draw the tree with ASCII instead of Unicode characters.",
+					},
+				},
+				Action: func(cCtx *cli.Context) error {
+					repo := loadRepo(cCtx, cCtx.String("repo"))
+					charset := unicodeTree
+					if cCtx.Bool("ascii") {
+						charset = asciiTree
+					}
+					return repo.printTree(os.Stdout, cmp.Or(cCtx.Args().First(), "HEAD"), cCtx.Int("depth"), charset)
+				},
+			},
+			{
+				Name:  "show-ref",
+				Usage: "Lists HEAD and every loose, packed and symbolic ref with its target, peeling annotated tags and marking refs to missing objects as broken.",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "heads",
+						Usage: "Only list branches.",
+					},
+					&cli.BoolFlag{
+						Name:  "tags",
+						Usage: "Only list tags.",
+					},
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "Print the refs as JSON.",
+					},
+				},
+				Action: func(cCtx *cli.Context) error {
+					repo := loadRepo(cCtx, cCtx.String("repo"))
+					refs := repo.showRefs()
+					if cCtx.Bool("heads") || cCtx.Bool("tags") {
+						refs = slices.DeleteFunc(refs, func(ref RefInfo) bool {
+							return !(cCtx.Bool("heads") && strings.HasPrefix(ref.Name, "refs/heads/")) &&
+								!(cCtx.Bool("tags") && strings.HasPrefix(ref.Name, "refs/tags/"))
+						})
+					}
+					if !cCtx.Bool("json") {
+						writeRefs(os.Stdout, refs)
+						return nil
+					}
+					out, err := json.Marshal(refs)
+					if err != nil {
+						return err
+					}
+					fmt.Println(string(out))
+					return nil
+				},
+			},
+			{
+				Name:  "config",
+				Usage: "Prints the repo's git config as JSON: remotes, branch upstreams, user identity, extensions and every setting.",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "global",
+						Usage: "Also read the user's global config, which the repo's settings override.",
+					},
+				},
+				Action: func(cCtx *cli.Context) error {
+					repo := loadRepo(cCtx, cCtx.String("repo"))
+					config, err := repo.Config(cCtx.Bool("global"))
+					if err != nil {
+						return err
+					}
+					out, err := json.Marshal(config)
+					if err != nil {
+						return err
+					}
+					fmt.Println(string(out))
+					return nil
+				},
+			},
+			{
+				Name:  "gen",
+				Usage: "Generates code from dagit's formats.",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "types",
+						Usage: "Generates type definitions for the graph JSON and the server's other payloads, derived from the Go types that write them.",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "lang",
+								Required: true,
+								Usage:    "The language to generate: ts, for TypeScript interfaces that JavaScript can use through JSDoc too.",
+							},
+							&cli.StringFlag{
+								Name:    "out",
+								Aliases: []string{"o"},
+								Usage:   "The path to write the definitions to, e.g. graph.d.ts. Defaults to stdout.",
+							},
+						},
+						Action: func(cCtx *cli.Context) error {
+							if lang := cCtx.String("lang"); lang != "ts" {
+								return &UsageError{fmt.Errorf("unsupported --lang %q, expected ts", lang)}
+							}
+							out, err := createOutput(cCtx.String("out"), false)
+							if err != nil {
+								return err
+							}
+							if err := writeTSTypes(out); err != nil {
+								out.Close()
+								return err
+							}
+							return out.Close()
+						},
+					},
+				},
+			},
+			{
+				Name:  "schema",
+				Usage: "Prints the JSON Schema of the graph format written by export and the server.",
+				Action: func(cCtx *cli.Context) error {
+					_, err := os.Stdout.Write(graphSchema)
+					return err
+				},
+			},
+			{
+				Name:      "validate",
+				Usage:     "Validates a graph JSON file against the graph schema.",
+				ArgsUsage: "<file>",
+				Action: func(cCtx *cli.Context) error {
+					if cCtx.NArg() != 1 {
+						return &UsageError{errors.New("validate takes the path of a graph JSON file")}
+					}
+					f, err := repofs.Open(cCtx.Args().First())
+					if err != nil {
+						return err
+					}
+					defer f.Close()
+					if err := validateGraph(f); err != nil {
+						return fmt.Errorf("%s is not a valid dagit graph: %w", f.Name(), err)
+					}
+					fmt.Printf("%s is a valid dagit graph (schema %s)\n", f.Name(), SCHEMA_VERSION)
+					return nil
+				},
+			},
+			{
+				Name:  "metrics",
+				Usage: "Prints degree statistics and other metrics of the graph as JSON.",
+				Flags: graphFlags(),
+				Action: func(cCtx *cli.Context) error {
+					opts, err := graphOptions(cCtx)
+					if err != nil {
+						return err
+					}
+					repo := loadRepo(cCtx, cCtx.String("repo"))
+					metrics, err := repo.metrics(opts)
+					if err != nil {
+						return err
+					}
+					out, err := json.Marshal(metrics)
+					if err != nil {
+						return err
+					}
+					fmt.Println(string(out))
+					return nil
+				},
+			},
+			{
+				Name:  "stats",
+				Usage: "Prints statistics about the objects in the repo as JSON. --dedup reports how blobs are shared across paths and commits and the storage content addressing saves. --components reports the disconnected histories of the commit DAG and their orphan roots.",
+				Flags: append([]cli.Flag{
+					&cli.BoolFlag{
+						Name:  "conventional",
+						Usage: "Count Conventional Commits (type(scope)!: subject) by type and scope, overall and per --period.",
+					},
+					&cli.StringFlag{
+						Name:  "period",
+						Value: PERIOD_MONTH,
+						Usage: "The period to count conventional commits by: day, week, month or year.",
+					},
+					&cli.BoolFlag{
+						Name:  "per-commit",
+						Usage: "With --languages, also report the languages of every selected commit.",
+					},
+				}, graphFlags()...),
+				Action: func(cCtx *cli.Context) error {
+					opts, err := graphOptions(cCtx)
+					if err != nil {
+						return err
+					}
+					// --dedup, --languages and --components report on the repo rather than annotating nodes here
+					opts.Dedup, opts.Languages, opts.Components = false, false, false
+					repo := loadRepo(cCtx, cCtx.String("repo"))
+					statsOpts := StatsOptions{
+						Dedup:              cCtx.Bool("dedup"),
+						Languages:          cCtx.Bool("languages"),
+						LanguagesPerCommit: cCtx.Bool("per-commit"),
+						Components:         cCtx.Bool("components"),
+					}
+					if cCtx.Bool("conventional") {
+						statsOpts.ConventionalPeriod = cCtx.String("period")
+					}
+					stats, err := repo.stats(opts, statsOpts)
+					if err != nil {
+						return err
+					}
+					out, err := json.Marshal(stats)
+					if err != nil {
+						return err
+					}
+					fmt.Println(string(out))
+					return nil
+				},
+			},
+			{
+				Name:      "hotspots",
+				Usage:     "Ranks files by how often they changed in the history of the given revisions (HEAD by default).",
+				ArgsUsage: "[revision...]",
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name:    "format",
+						Value:   "json",
+						Aliases: []string{"f"},
+						Usage:   "The output format: json or csv.",
+					},
+					&cli.DurationFlag{
+						Name:  "half-life",
+						Usage: "Weight changes by recency, a change counting half as much every half-life (e.g. 720h). 0 counts every change the same.",
+					},
+					&cli.IntFlag{
+						Name:  "limit",
+						Usage: "Only output the top N files. 0 means no limit.",
+					},
+				}, renameFlags()...),
+				Action: func(cCtx *cli.Context) error {
+					repo := loadRepo(cCtx, cCtx.String("repo"))
+					spots, err := repo.hotspots(cCtx.Context, cCtx.Args().Slice(), cCtx.Duration("half-life"), renameOptions(cCtx))
+					if err != nil {
+						return err
+					}
+					if n := cCtx.Int("limit"); n > 0 && n < len(spots) {
+						spots = spots[:n]
+					}
+					switch cCtx.String("format") {
+					case "json":
+						out, err := json.Marshal(spots)
+						if err != nil {
+							return err
+						}
+						fmt.Println(string(out))
+						return nil
+					case "csv":
+						return writeHotspotsCSV(os.Stdout, spots)
+					default:
+						return &UsageError{fmt.Errorf("unknown format %q", cCtx.String("format"))}
+					}
+				},
+			},
+			{
+				Name:      "contributors",
+				Usage:     "Exports the network of contributors who modified the same files or co-authored commits.",
+				ArgsUsage: "[revision...]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "format",
+						Value:   "json",
+						Aliases: []string{"f"},
+						Usage:   "The export format: json or sqlite.",
+					},
+					&cli.StringFlag{
+						Name:    "out",
+						Aliases: []string{"o"},
+						Usage:   "The path to write the export to. json defaults to stdout and sqlite to contributors.sqlite.",
+					},
+				},
+				Action: func(cCtx *cli.Context) error {
+					repo := loadRepo(cCtx, cCtx.String("repo"))
+					graph, err := repo.contributorGraph(cCtx.Context, cCtx.Args().Slice())
+					if err != nil {
+						return err
+					}
+					out := cCtx.String("out")
+					switch cCtx.String("format") {
+					case "json":
+						data, err := graph.toJson()
+						if err != nil {
+							return err
+						}
+						if out == "" {
+							fmt.Println(string(data))
+							return nil
+						}
+						return repofs.WriteFile(out, data, 0644)
+					case "sqlite":
+						if out == "" {
+							out = "contributors.sqlite"
+						}
+						return graph.toSQLite(out)
+					default:
+						return &UsageError{fmt.Errorf("unknown export format %q", cCtx.String("format"))}
+					}
+				},
+			},
+			{
+				Name:      "log",
+				Usage:     "Lists the commits reachable from the given revisions (HEAD by default).",
+				ArgsUsage: "[revision...]",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "first-parent",
+						Usage: "Only follow the first parent of merge commits.",
+					},
+					&cli.StringFlag{
+						Name:  "order",
+						Value: ORDER_DATE,
+						Usage: "The order to list commits in: topo, date or author-date.",
+					},
+					&cli.IntFlag{
+						Name:    "max-count",
+						Aliases: []string{"n"},
+						Usage:   "Limit the number of commits to output. 0 means no limit.",
+					},
+					&cli.StringFlag{
+						Name:  "since",
+						Usage: "Only list commits made after this date (e.g. 2024-01-31 or \"1 week ago\").",
+					},
+					&cli.StringFlag{
+						Name:  "until",
+						Usage: "Only list commits made before this date.",
+					},
+					&cli.StringFlag{
+						Name:  "author",
+						Usage: "Only list commits whose author matches this regular expression.",
+					},
+				},
+				Action: func(cCtx *cli.Context) error {
+					filters := GraphOptions{}
+					if err := filters.setFilters(cCtx.String("since"), cCtx.String("until"), cCtx.String("author")); err != nil {
+						return &UsageError{err}
+					}
+					repo := loadRepo(cCtx, cCtx.String("repo"))
+					commits, err := repo.logCommits(cCtx.Context, cCtx.Args().Slice(), LogOptions{
+						Order:       cCtx.String("order"),
+						FirstParent: cCtx.Bool("first-parent"),
+						MaxCount:    cCtx.Int("max-count"),
+						Since:       filters.Since,
+						Until:       filters.Until,
+						Author:      filters.Author,
+					})
+					if err != nil {
+						return err
+					}
+					for _, commit := range commits {
+						fmt.Println(oneline(commit))
+					}
+					return nil
+				},
+			},
+			{
+				Name:      "path",
+				Usage:     "Prints the shortest chain of commits connecting two revisions when one is an ancestor of the other, e.g. how a commit ended up in main, or where they diverged.",
+				ArgsUsage: "<from> <to>",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "json", Usage: "Output the path as JSON."},
+				},
+				Action: func(cCtx *cli.Context) error {
+					if cCtx.NArg() != 2 {
+						return &UsageError{errors.New("expected two revisions")}
+					}
+					from, to := cCtx.Args().Get(0), cCtx.Args().Get(1)
+					repo := loadRepo(cCtx, cCtx.String("repo"))
+					path, err := repo.AncestryPath(from, to)
+					if err != nil {
+						return err
+					}
+					if !cCtx.Bool("json") {
+						writeAncestryPath(os.Stdout, path, from, to)
+						return nil
+					}
+					out, err := json.Marshal(path)
+					if err != nil {
+						return err
+					}
+					fmt.Println(string(out))
+					return nil
+				},
+			},
+			{
+				Name:      "diff",
+				Usage:     "Shows the files changed between two commits, or between a commit and its first parent.",
+				ArgsUsage: "<revision> [revision]",
+				Flags: append([]cli.Flag{
+					&cli.BoolFlag{Name: "json", Usage: "Output the changes as JSON."},
+				}, renameFlags()...),
+				Action: func(cCtx *cli.Context) error {
+					if cCtx.NArg() < 1 || cCtx.NArg() > 2 {
+						return &UsageError{errors.New("expected one or two revisions")}
+					}
+					repo := loadRepo(cCtx, cCtx.String("repo"))
+					newCommit, err := repo.commitOf(cCtx.Args().Get(cCtx.NArg() - 1))
+					if err != nil {
+						return err
+					}
+					oldTree := ""
+					if cCtx.NArg() == 2 {
+						oldCommit, err := repo.commitOf(cCtx.Args().First())
+						if err != nil {
+							return err
+						}
+						oldTree = oldCommit.Tree
+					} else if len(newCommit.Parents) > 0 {
+						parent, err := repo.commitOf(newCommit.Parents[0])
+						if err != nil {
+							return err
+						}
+						oldTree = parent.Tree
+					}
+					changes := repo.detectRenames(repo.diffTrees(oldTree, newCommit.Tree), renameOptions(cCtx))
+					if cCtx.Bool("json") {
+						out, err := json.Marshal(changes)
+						if err != nil {
+							return err
+						}
+						fmt.Println(string(out))
+						return nil
+					}
+					for _, c := range changes {
+						fmt.Println(nameStatus(c))
+					}
+					return nil
+				},
+			},
+			{
+				Name:      "history",
+				Usage:     "Lists the commits that changed a file, following it across renames.",
+				ArgsUsage: "<path>",
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{Name: "rev", Value: "HEAD", Usage: "The revision to start from."},
+					&cli.BoolFlag{Name: "json", Usage: "Output the history as JSON."},
+				}, renameFlags()...),
+				Action: func(cCtx *cli.Context) error {
+					if cCtx.NArg() != 1 {
+						return &UsageError{errors.New("expected a path")}
+					}
+					repo := loadRepo(cCtx, cCtx.String("repo"))
+					history, err := repo.fileHistory(cCtx.Context, cCtx.String("rev"), cCtx.Args().First(), renameOptions(cCtx))
+					if err != nil {
+						return err
+					}
+					if cCtx.Bool("json") {
+						out, err := json.Marshal(history)
+						if err != nil {
+							return err
+						}
+						fmt.Println(string(out))
+						return nil
+					}
+					for _, entry := range history {
+						fmt.Println(oneline(entry.Commit) + "\t" + nameStatus(entry.Change))
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "show",
+				Usage: "Shows the content of a Git object.",
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name:    "object",
+						Aliases: []string{"o"},
+						Usage:   "The object to show, by its name or a unique prefix of at least 4 hex digits.",
+					},
+					&cli.BoolFlag{Name: "type", Aliases: []string{"t"}},
+					&cli.BoolFlag{
+						Name:  "raw-header",
+						Usage: "Write the object's exact decompressed bytes, its type size\\0 header followed by its content, which git hashes into its name.",
+					},
+					&cli.BoolFlag{
+						Name:  "compressed",
+						Usage: "Write the object's zlib compressed loose object file instead, e.g. to copy it into another repo's objects directory.",
+					},
+				}, graphFlags()...),
+				Action: func(cCtx *cli.Context) error {
+					opts, err := graphOptions(cCtx)
+					if err != nil {
+						return err
+					}
+					if cCtx.String("object") == "" && (cCtx.Bool("raw-header") || cCtx.Bool("compressed")) {
+						return &UsageError{errors.New("--raw-header and --compressed need --object")}
+					}
+					if cCtx.Bool("anonymize-authors") && (cCtx.Bool("raw-header") || cCtx.Bool("compressed")) {
+						return &UsageError{errors.New("--raw-header and --compressed write objects as they are and can't be anonymized")}
+					}
+					repo := loadRepo(cCtx, cCtx.String("repo"))
+					if cCtx.String("object") == "" {
+						if err := writeIndented(os.Stdout, true, func(w io.Writer) error {
+							return repo.writeJson(cCtx.Context, w, opts)
+						}); err != nil {
+							return err
+						}
+						fmt.Println()
+					} else {
+						name, err := repo.FindByPrefix(cCtx.String("object"))
+						if err != nil {
+							return err
+						}
+						obj := repo.getObject(name)
+						if cCtx.Bool("raw-header") || cCtx.Bool("compressed") {
+							data, err := obj.rawObject(cCtx.Bool("compressed"))
+							if err != nil {
+								return err
+							}
+							_, err = os.Stdout.Write(data)
+							return err
+						}
+						if cCtx.Bool("type") {
+							fmt.Println(obj.Type)
+						} else {
+							data, err := repo.showJson(obj)
+							if err != nil {
+								return err
+							}
+							var indented bytes.Buffer
+							if err := json.Indent(&indented, data, "", "    "); err != nil {
+								return err
+							}
+							fmt.Println(indented.String())
+						}
+					}
+					return nil
+				},
+			},
+		},
+	}
+
+	setUsageErrors(app.Commands)
+	if err := app.Run(os.Args); err != nil {
+		fatal(err)
 	}
 }
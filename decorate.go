@@ -0,0 +1,97 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+)
+
+// The refs pointing at a commit and the refs whose history contains it.
+type Decoration struct {
+	PointedBy   []string `json:"pointedBy"`
+	ContainedIn []string `json:"containedIn"`
+}
+
+// Shortens a full ref name the way ref nodes are named: branches and remote-tracking
+// branches by their name, tags as tags/<name>.
+func shortRefName(name string) string {
+	if branch, ok := strings.CutPrefix(name, "refs/heads/"); ok {
+		return branch
+	}
+	if tag, ok := strings.CutPrefix(name, "refs/tags/"); ok {
+		return "tags/" + tag
+	}
+	if remote, ok := strings.CutPrefix(name, "refs/remotes/"); ok {
+		return remote
+	}
+	return strings.TrimPrefix(name, "refs/")
+}
+
+// Peels tags until reaching a non-tag object.
+func (r *Repo) peel(hash string) string {
+	for obj := r.getObject(hash); obj != nil && obj.Type == "tag"; obj = r.getObject(hash) {
+		hash = parseTag(obj).Object
+	}
+	return hash
+}
+
+// Computes the decoration of every commit reachable from HEAD or a ref.
+func (r *Repo) decorations() map[string]*Decoration {
+	pointedBy := map[string][]string{}
+	if hash, ok := r.resolveRef("HEAD"); ok {
+		hash = r.peel(hash)
+		pointedBy[hash] = append(pointedBy[hash], "HEAD")
+	}
+	for name, hash := range r.allRefs() {
+		hash = r.peel(hash)
+		pointedBy[hash] = append(pointedBy[hash], shortRefName(name))
+	}
+	var tips []Commit
+	for hash := range pointedBy {
+		if obj := r.getObject(hash); obj != nil && obj.Type == "commit" {
+			tips = append(tips, parseCommit(obj))
+		}
+	}
+	gens := r.generations(tips)
+	// children have higher generations than their parents, so propagating containment in
+	// descending generation order visits every child before its parents
+	commits := make([]string, 0, len(gens))
+	for hash, gen := range gens {
+		if gen > 0 {
+			commits = append(commits, hash)
+		}
+	}
+	slices.SortFunc(commits, func(a, b string) int { return cmp.Compare(gens[b], gens[a]) })
+
+	contained := map[string]map[string]bool{}
+	decorations := map[string]*Decoration{}
+	for _, hash := range commits {
+		refs := contained[hash]
+		if refs == nil {
+			refs = map[string]bool{}
+		}
+		for _, ref := range pointedBy[hash] {
+			refs[ref] = true
+		}
+		if len(refs) == 0 {
+			continue
+		}
+		d := &Decoration{PointedBy: append([]string{}, pointedBy[hash]...), ContainedIn: []string{}}
+		for ref := range refs {
+			d.ContainedIn = append(d.ContainedIn, ref)
+		}
+		slices.Sort(d.PointedBy)
+		slices.Sort(d.ContainedIn)
+		decorations[hash] = d
+		for _, p := range parseCommit(r.getObject(hash)).Parents {
+			if contained[p] == nil {
+				contained[p] = map[string]bool{}
+			}
+			for ref := range refs {
+				contained[p][ref] = true
+			}
+		}
+		delete(contained, hash)
+	}
+	return decorations
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Above this many clients the limiter forgets the ones whose buckets have refilled.
+const maxRateClients = 10000
+
+// The limits of --demo mode, for hosting a public instance of dagit on an open-source repo.
+type DemoMode struct {
+	// the most commit generations a graph may include
+	MaxDepth int
+	// requests per second allowed per client IP, with bursts of up to Burst requests
+	Rate    float64
+	Burst   int
+	limiter *rateLimiter
+}
+
+// The server's demo mode, nil when it isn't in demo mode.
+var demo *DemoMode
+
+func newDemoMode(maxDepth int, rate float64, burst int) (*DemoMode, error) {
+	if maxDepth < 1 {
+		return nil, fmt.Errorf("invalid --demo-depth %d, must be at least 1", maxDepth)
+	}
+	if rate <= 0 || burst < 1 {
+		return nil, fmt.Errorf("invalid --demo-rate %v or --demo-burst %d, must be positive", rate, burst)
+	}
+	return &DemoMode{
+		MaxDepth: maxDepth,
+		Rate:     rate,
+		Burst:    burst,
+		limiter:  &rateLimiter{rate: rate, burst: float64(burst), clients: map[string]*tokenBucket{}},
+	}, nil
+}
+
+// Leaves blob content out of graphs and caps their depth. Options pass through unchanged
+// outside demo mode.
+func (d *DemoMode) limit(opts GraphOptions) GraphOptions {
+	if d == nil {
+		return opts
+	}
+	opts.NoContent = true
+	if opts.Depth == 0 || opts.Depth > d.MaxDepth {
+		opts.Depth = d.MaxDepth
+	}
+	return opts
+}
+
+// Wraps a handler that's turned off in demo mode, e.g. one writing to disk or doing
+// unbounded work.
+func (d *DemoMode) disable(handler http.HandlerFunc) http.HandlerFunc {
+	if d == nil {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "turned off in demo mode", http.StatusForbidden)
+	}
+}
+
+// Reports whether the client at remote, a request's RemoteAddr, may make another request.
+func (d *DemoMode) allow(remote string) bool {
+	if d == nil {
+		return true
+	}
+	host, _, err := net.SplitHostPort(remote)
+	if err != nil {
+		host = remote
+	}
+	return d.limiter.allow(host, time.Now())
+}
+
+// Answers requests over a client's rate with 429 Too Many Requests.
+func (d *DemoMode) limitRate(next http.Handler) http.Handler {
+	if d == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !d.allow(r.RemoteAddr) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(1/d.Rate))))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// A token bucket per client. Clients are keyed by IP rather than a forwarded header, which
+// anyone could set.
+type rateLimiter struct {
+	rate    float64
+	burst   float64
+	mu      sync.Mutex
+	clients map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (l *rateLimiter) allow(client string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	bucket, ok := l.clients[client]
+	if !ok {
+		if len(l.clients) >= maxRateClients {
+			l.forget(now)
+		}
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.clients[client] = bucket
+	}
+	bucket.tokens = min(l.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// Drops the clients whose buckets would be full by now, since a new bucket starts full.
+func (l *rateLimiter) forget(now time.Time) {
+	refill := time.Duration(l.burst / l.rate * float64(time.Second))
+	for client, bucket := range l.clients {
+		if now.Sub(bucket.last) >= refill {
+			delete(l.clients, client)
+		}
+	}
+}
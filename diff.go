@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+const (
+	ADDED    = "added"
+	DELETED  = "deleted"
+	MODIFIED = "modified"
+	RENAMED  = "renamed"
+	COPIED   = "copied"
+)
+
+// A file that differs between two trees.
+type Change struct {
+	Path    string `json:"path"`
+	Status  string `json:"status"`
+	OldHash string `json:"oldHash,omitempty"`
+	NewHash string `json:"newHash,omitempty"`
+	OldMode string `json:"oldMode,omitempty"`
+	NewMode string `json:"newMode,omitempty"`
+	// the source path of renames and copies
+	OldPath string `json:"oldPath,omitempty"`
+	// content similarity percentage of renames and copies
+	Similarity int `json:"similarity,omitempty"`
+}
+
+func isTreeMode(mode string) bool {
+	return entryKind(mode) == ENTRY_DIRECTORY
+}
+
+// Returns the entries of a tree by name. A missing or empty hash is an empty tree.
+func (r *Repo) treeEntries(hash string) map[string]TreeEntry {
+	entries := map[string]TreeEntry{}
+	obj := r.getObject(hash)
+	if obj == nil || obj.Type != "tree" {
+		return entries
+	}
+	for _, entry := range *parseTree(obj) {
+		entries[entry.Name] = entry
+	}
+	return entries
+}
+
+// Lists every file under a tree as added (or deleted when deleted is true).
+func (r *Repo) treeFiles(prefix string, hash string, deleted bool) []Change {
+	var changes []Change
+	for _, entry := range sortedEntries(r.treeEntries(hash)) {
+		p := path.Join(prefix, entry.Name)
+		if isTreeMode(entry.Mode) {
+			changes = append(changes, r.treeFiles(p, entry.Hash, deleted)...)
+		} else if deleted {
+			changes = append(changes, Change{Path: p, Status: DELETED, OldHash: entry.Hash, OldMode: entry.Mode})
+		} else {
+			changes = append(changes, Change{Path: p, Status: ADDED, NewHash: entry.Hash, NewMode: entry.Mode})
+		}
+	}
+	return changes
+}
+
+func sortedEntries(entries map[string]TreeEntry) []TreeEntry {
+	sorted := make([]TreeEntry, 0, len(entries))
+	for _, entry := range entries {
+		sorted = append(sorted, entry)
+	}
+	slices.SortFunc(sorted, func(a, b TreeEntry) int { return strings.Compare(a.Name, b.Name) })
+	return sorted
+}
+
+// Diffs two trees, returning the files that changed between them. Either hash can be
+// empty to diff against an empty tree. Identical subtrees are skipped without being read.
+func (r *Repo) diffTrees(oldTree string, newTree string) []Change {
+	return r.diffTreesAt("", oldTree, newTree)
+}
+
+func (r *Repo) diffTreesAt(prefix string, oldTree string, newTree string) []Change {
+	if oldTree == newTree {
+		return nil
+	}
+	oldEntries, newEntries := r.treeEntries(oldTree), r.treeEntries(newTree)
+	names := map[string]bool{}
+	for name := range oldEntries {
+		names[name] = true
+	}
+	for name := range newEntries {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	slices.Sort(sorted)
+
+	var changes []Change
+	for _, name := range sorted {
+		p := path.Join(prefix, name)
+		oldEntry, inOld := oldEntries[name]
+		newEntry, inNew := newEntries[name]
+		switch {
+		case inOld && inNew && oldEntry.Hash == newEntry.Hash && oldEntry.Mode == newEntry.Mode:
+			continue
+		case inOld && inNew && isTreeMode(oldEntry.Mode) && isTreeMode(newEntry.Mode):
+			changes = append(changes, r.diffTreesAt(p, oldEntry.Hash, newEntry.Hash)...)
+		case inOld && inNew && !isTreeMode(oldEntry.Mode) && !isTreeMode(newEntry.Mode):
+			changes = append(changes, Change{
+				Path:    p,
+				Status:  MODIFIED,
+				OldHash: oldEntry.Hash,
+				NewHash: newEntry.Hash,
+				OldMode: oldEntry.Mode,
+				NewMode: newEntry.Mode,
+			})
+		default:
+			// a file replaced by a directory (or the reverse) is a delete plus an add
+			if inOld {
+				if isTreeMode(oldEntry.Mode) {
+					changes = append(changes, r.treeFiles(p, oldEntry.Hash, true)...)
+				} else {
+					changes = append(changes, Change{Path: p, Status: DELETED, OldHash: oldEntry.Hash, OldMode: oldEntry.Mode})
+				}
+			}
+			if inNew {
+				if isTreeMode(newEntry.Mode) {
+					changes = append(changes, r.treeFiles(p, newEntry.Hash, false)...)
+				} else {
+					changes = append(changes, Change{Path: p, Status: ADDED, NewHash: newEntry.Hash, NewMode: newEntry.Mode})
+				}
+			}
+		}
+	}
+	return changes
+}
+
+// Diffs a commit against each of its parents. A root commit is diffed against an empty tree.
+func (r *Repo) commitChanges(commit Commit) [][]Change {
+	if len(commit.Parents) == 0 {
+		return [][]Change{r.diffTrees("", commit.Tree)}
+	}
+	var changes [][]Change
+	for _, p := range commit.Parents {
+		parentTree := ""
+		if parent := r.getObject(p); parent != nil {
+			parentTree = parseCommit(parent).Tree
+		}
+		changes = append(changes, r.diffTrees(parentTree, commit.Tree))
+	}
+	return changes
+}
+
+// Options for rename and copy detection, mirroring git's -M and -C.
+type RenameOptions struct {
+	// minimum similarity percentage for an added file to pair with a deleted one. 0 disables detection.
+	Threshold int
+	// also pair added files with modified files they were copied from
+	Copies bool
+}
+
+// Above this many candidate pairs only exact renames are detected, like git's diff.renameLimit.
+const renameLimit = 1000 * 1000
+
+// Counts the lines of a blob.
+func lineCounts(data []byte) map[string]int {
+	counts := map[string]int{}
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if len(line) > 0 {
+			counts[string(line)]++
+		}
+	}
+	return counts
+}
+
+// Returns the percentage of bytes two blobs have in common, by line.
+func similarity(a map[string]int, aSize int, b map[string]int, bSize int) int {
+	if aSize == 0 && bSize == 0 {
+		return 100
+	}
+	common := 0
+	for line, n := range a {
+		common += min(n, b[line]) * len(line)
+	}
+	return common * 100 / max(aSize, bSize)
+}
+
+// Pairs deleted and added files of changes into renames (and copies when enabled) whose
+// content is at least opts.Threshold percent similar. Exact content matches are paired first.
+func (r *Repo) detectRenames(changes []Change, opts RenameOptions) []Change {
+	if opts.Threshold <= 0 {
+		return changes
+	}
+	var deleted, added []int
+	for i, c := range changes {
+		switch c.Status {
+		case DELETED:
+			deleted = append(deleted, i)
+		case ADDED:
+			added = append(added, i)
+		}
+	}
+	paired := map[int]bool{}
+	// renames and copies replacing the added changes by index
+	result := map[int]Change{}
+	pair := func(src Change, ai int, status string, score int) {
+		dest := changes[ai]
+		result[ai] = Change{
+			Path:       dest.Path,
+			OldPath:    src.Path,
+			Status:     status,
+			OldHash:    src.OldHash,
+			NewHash:    dest.NewHash,
+			OldMode:    src.OldMode,
+			NewMode:    dest.NewMode,
+			Similarity: score,
+		}
+	}
+	// exact renames
+	for _, ai := range added {
+		for _, di := range deleted {
+			if !paired[di] && changes[di].OldHash == changes[ai].NewHash {
+				paired[di] = true
+				pair(changes[di], ai, RENAMED, 100)
+				break
+			}
+		}
+	}
+
+	contents := map[string]map[string]int{}
+	sizes := map[string]int{}
+	load := func(hash string) (map[string]int, bool) {
+		if counts, ok := contents[hash]; ok {
+			return counts, true
+		}
+		obj := r.getObject(hash)
+		if obj == nil || obj.Type != "blob" {
+			return nil, false
+		}
+		data := obj.Bytes()
+		contents[hash], sizes[hash] = lineCounts(data), len(data)
+		return contents[hash], true
+	}
+	best := func(ai int, sources []int) (int, int) {
+		bestIndex, bestScore := -1, opts.Threshold-1
+		destLines, ok := load(changes[ai].NewHash)
+		if !ok {
+			return -1, 0
+		}
+		for _, si := range sources {
+			srcLines, ok := load(changes[si].OldHash)
+			if !ok {
+				continue
+			}
+			score := similarity(srcLines, sizes[changes[si].OldHash], destLines, sizes[changes[ai].NewHash])
+			if score > bestScore {
+				bestIndex, bestScore = si, score
+			}
+		}
+		return bestIndex, bestScore
+	}
+
+	// inexact renames
+	if len(deleted)*len(added) <= renameLimit {
+		for _, ai := range added {
+			if _, done := result[ai]; done {
+				continue
+			}
+			var sources []int
+			for _, di := range deleted {
+				if !paired[di] {
+					sources = append(sources, di)
+				}
+			}
+			if si, score := best(ai, sources); si >= 0 {
+				paired[si] = true
+				pair(changes[si], ai, RENAMED, score)
+			}
+		}
+	}
+
+	// copies from modified files or from files that were already renamed
+	if opts.Copies {
+		var sources []int
+		for i, c := range changes {
+			if c.Status == MODIFIED || (c.Status == DELETED && paired[i]) {
+				sources = append(sources, i)
+			}
+		}
+		if len(sources)*len(added) <= renameLimit {
+			for _, ai := range added {
+				if _, done := result[ai]; done {
+					continue
+				}
+				if si, score := best(ai, sources); si >= 0 {
+					src := changes[si]
+					src.Path = cmp.Or(src.OldPath, src.Path)
+					pair(src, ai, COPIED, score)
+				}
+			}
+		}
+	}
+
+	var detected []Change
+	for i, c := range changes {
+		if c.Status == DELETED && paired[i] {
+			continue
+		}
+		if replacement, ok := result[i]; ok {
+			c = replacement
+		}
+		detected = append(detected, c)
+	}
+	return detected
+}
+
+// Formats a change like git diff --name-status.
+func nameStatus(c Change) string {
+	switch c.Status {
+	case ADDED:
+		return "A\t" + c.Path
+	case DELETED:
+		return "D\t" + c.Path
+	case RENAMED:
+		return fmt.Sprintf("R%03d\t%s\t%s", c.Similarity, c.OldPath, c.Path)
+	case COPIED:
+		return fmt.Sprintf("C%03d\t%s\t%s", c.Similarity, c.OldPath, c.Path)
+	default:
+		return "M\t" + c.Path
+	}
+}
+
+// A commit that changed a file, along with the change.
+type FileHistoryEntry struct {
+	Commit Commit `json:"commit"`
+	Change Change `json:"change"`
+}
+
+// Returns the first-parent history of file starting from rev, newest first, following the
+// file across renames.
+func (r *Repo) fileHistory(ctx context.Context, rev string, file string, opts RenameOptions) ([]FileHistoryEntry, error) {
+	if _, err := r.resolveRev(rev); err != nil {
+		return nil, err
+	}
+	var history []FileHistoryEntry
+	for commit := range r.Log(ctx, rev, LogOptions{Order: ORDER_TOPO, FirstParent: true}) {
+		parentTree := ""
+		if len(commit.Parents) > 0 {
+			if parent := r.getObject(commit.Parents[0]); parent != nil {
+				parentTree = parseCommit(parent).Tree
+			}
+		}
+		for _, c := range r.detectRenames(r.diffTrees(parentTree, commit.Tree), opts) {
+			if c.Path != file {
+				continue
+			}
+			history = append(history, FileHistoryEntry{Commit: *commit, Change: c})
+			if c.Status == RENAMED {
+				file = c.OldPath
+			}
+			break
+		}
+	}
+	return history, ctx.Err()
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "io/fs"
+
+// Returns the bytes a file takes up on disk. Without block counts this is its size.
+func diskSize(info fs.FileInfo) int64 {
+	return info.Size()
+}
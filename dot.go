@@ -0,0 +1,77 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Graphviz node shapes by node type, filled with the type's color.
+var dotShapes = map[string]string{
+	"commit":      "shape=box, style=filled",
+	OCTOPUS_MERGE: "shape=box, peripheries=2, style=filled",
+	"tree":        "shape=folder, style=filled",
+	"blob":        "shape=note, style=filled",
+	LFS_POINTER:   "shape=note, style=filled",
+	SYMLINK:       "shape=larrow, style=filled",
+	"tag":         "shape=cds, style=filled",
+	"ref":         "shape=ellipse, style=filled",
+	PSEUDO_REF:    `shape=ellipse, style="filled,dashed"`,
+	OPERATION:     "shape=octagon, style=filled",
+}
+
+// Quotes s as a Graphviz ID.
+func dotID(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// Labels object nodes with their type and abbreviated hash and other nodes with their name.
+// Unreachable objects are styled like reachable ones of their type with a dashed border.
+func dotNode(name, type_ string) (string, string) {
+	base, unreachable := strings.CutPrefix(type_, "unreachable-")
+	style := cmp.Or(dotShapes[base], "shape=ellipse")
+	if color := defaultNodeStyles[base].Color; color != "" {
+		style += fmt.Sprintf(", fillcolor=%q", color)
+	}
+	if unreachable {
+		style = strings.Replace(style, "style=filled", `style="filled,dashed"`, 1)
+	}
+	if len(name) == 40 && dotShapes[base] != "" && base != "ref" && base != PSEUDO_REF {
+		return base + " " + name[:7], style
+	}
+	return name, style
+}
+
+// Streams the repo's graph as a Graphviz digraph to w, e.g. for dot -Tsvg.
+func (r *Repo) writeDot(ctx context.Context, w io.Writer, opts GraphOptions) (err error) {
+	ctx, span := tracer.Start(ctx, "graph.writeDot")
+	defer func() { endSpan(span, err) }()
+	if _, err := io.WriteString(w, "digraph git {\n\trankdir=RL;\n\tnode [fontname=monospace];\n"); err != nil {
+		return err
+	}
+	edge := func(e Edge) error {
+		if e.Kind != "" {
+			_, err := fmt.Fprintf(w, "\t%s -> %s [label=%s, style=dashed];\n", dotID(e.Src), dotID(e.Dest), dotID(e.Kind))
+			return err
+		}
+		_, err := fmt.Fprintf(w, "\t%s -> %s;\n", dotID(e.Src), dotID(e.Dest))
+		return err
+	}
+	node := func(n map[string]any) error {
+		name, _ := n["name"].(string)
+		type_, _ := n["type"].(string)
+		label, style := dotNode(name, type_)
+		if l, ok := n["label"].(string); ok {
+			label = l
+		}
+		_, err := fmt.Fprintf(w, "\t%s [label=%s, %s];\n", dotID(name), dotID(label), style)
+		return err
+	}
+	if err := r.streamGraph(ctx, opts, edge, node); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "}\n")
+	return err
+}
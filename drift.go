@@ -0,0 +1,111 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/dagit/repofs"
+)
+
+// Returns the object type behind a node type of an export, e.g. blob for unreachable-symlink.
+// False for nodes that aren't objects, like refs.
+func nodeObjectType(type_ string) (string, bool) {
+	type_ = strings.TrimPrefix(type_, "unreachable-")
+	switch type_ {
+	case SYMLINK, LFS_POINTER:
+		return "blob", true
+	case OCTOPUS_MERGE:
+		return "commit", true
+	}
+	return type_, slices.Contains(objectTypes, type_)
+}
+
+// Returns the full name of the ref behind a ref node of an export, e.g. refs/tags/v1 for
+// tags/v1.
+func refNodeRef(name string) string {
+	switch {
+	case name == "HEAD":
+		return name
+	case strings.HasPrefix(name, "tags/"), strings.HasPrefix(name, "remotes/"):
+		return "refs/" + name
+	}
+	return "refs/heads/" + name
+}
+
+// Snapshots the objects and refs of a graph exported with export --format sqlite, to compare
+// the repo against. Objects a filtered export left out, e.g. with --depth, count as added.
+func sqliteSnapshot(path string) (*Snapshot, error) {
+	info, err := repofs.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	s := &Snapshot{Name: path, Created: info.ModTime().UTC(), Objects: map[string]string{}, Refs: map[string]string{}}
+	rows, err := db.Query("select name, type from objects")
+	if err != nil {
+		return nil, fmt.Errorf("%s: not a dagit SQLite export: %w", path, err)
+	}
+	defer rows.Close()
+	refNodes := map[string]bool{}
+	for rows.Next() {
+		var name, type_ string
+		if err := rows.Scan(&name, &type_); err != nil {
+			return nil, err
+		}
+		if type_ == "ref" {
+			refNodes[name] = true
+		} else if objectType, ok := nodeObjectType(type_); ok && hashRegex.MatchString(name) {
+			s.Objects[name] = objectType
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	edges, err := db.Query("select src, dest from edges")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	defer edges.Close()
+	// where each ref node points: an object or, for symbolic refs, another ref node
+	targets := map[string]string{}
+	for edges.Next() {
+		var src, dest string
+		if err := edges.Scan(&src, &dest); err != nil {
+			return nil, err
+		}
+		if refNodes[src] {
+			targets[src] = dest
+		}
+	}
+	if err := edges.Err(); err != nil {
+		return nil, err
+	}
+	for name := range refNodes {
+		target := targets[name]
+		for i := 0; refNodes[target] && i < len(refNodes); i++ {
+			target = targets[target]
+		}
+		if hashRegex.MatchString(target) {
+			s.Refs[refNodeRef(name)] = target
+		}
+	}
+	return s, nil
+}
+
+// Returns the differences between the repo and a baseline snapshotted from an export. Only
+// the refs exports show are compared: HEAD, branches, tags and remote-tracking refs.
+func (r *Repo) drift(baseline *Snapshot) SnapshotDiff {
+	current := r.snapshot("current")
+	for ref := range current.Refs {
+		if ref != "HEAD" && !strings.HasPrefix(ref, "refs/heads/") && !strings.HasPrefix(ref, "refs/tags/") && !strings.HasPrefix(ref, "refs/remotes/") {
+			delete(current.Refs, ref)
+		}
+	}
+	return diffSnapshots(baseline, current)
+}
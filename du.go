@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path"
+)
+
+// The size of the blobs under a directory of a commit.
+type DirUsage struct {
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+	Files int    `json:"files"`
+}
+
+// Sums the blob sizes under every directory of a commit's tree, children before their parent
+// like du. Only directories at most depth levels below the root are listed, all of them when
+// depth is 0. The root directory is ".".
+func (r *Repo) du(rev string, depth int) ([]DirUsage, error) {
+	commit, err := r.commitOf(rev)
+	if err != nil {
+		return nil, err
+	}
+	var usage []DirUsage
+	var walk func(tree string, dir string, level int) (int64, int)
+	walk = func(tree string, dir string, level int) (int64, int) {
+		var bytes int64
+		files := 0
+		for _, entry := range sortedEntries(r.treeEntries(tree)) {
+			if isTreeMode(entry.Mode) {
+				b, f := walk(entry.Hash, path.Join(dir, entry.Name), level+1)
+				bytes, files = bytes+b, files+f
+			} else if obj := r.getObject(entry.Hash); obj != nil && obj.Type == "blob" {
+				bytes += int64(blobSize(obj))
+				files++
+			}
+		}
+		if depth == 0 || level <= depth {
+			usage = append(usage, DirUsage{Path: dir, Bytes: bytes, Files: files})
+		}
+		return bytes, files
+	}
+	walk(commit.Tree, ".", 0)
+	return usage, nil
+}
+
+// Writes directory sizes like du -b.
+func writeDirUsage(w io.Writer, usage []DirUsage) {
+	for _, u := range usage {
+		fmt.Fprintf(w, "%d\t%s\n", u.Bytes, u.Path)
+	}
+}
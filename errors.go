@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// The exit codes scripts wrapping dagit can branch on.
+const (
+	EXIT_OK = 0
+	// a bad flag, argument or command, and failures with no more specific code
+	EXIT_USAGE          = 1
+	EXIT_REPO_NOT_FOUND = 2
+	EXIT_CORRUPT_OBJECT = 3
+	EXIT_EXPORT_FAILED  = 4
+	// the repo changed since the baseline drift compared it against
+	EXIT_DRIFTED = 5
+)
+
+// An error with its own exit code. The kind names the failure in JSON error reports.
+type typedError interface {
+	error
+	kind() string
+	exitCode() int
+}
+
+type UsageError struct {
+	Err error `json:"-"`
+}
+
+func (e *UsageError) Error() string { return e.Err.Error() }
+func (e *UsageError) Unwrap() error { return e.Err }
+func (e *UsageError) kind() string  { return "usage" }
+func (e *UsageError) exitCode() int { return EXIT_USAGE }
+
+type RepoNotFoundError struct {
+	Path string `json:"path"`
+	// what the repo lacks, e.g. .git/HEAD, when it has a git dir
+	Missing string `json:"missing,omitempty"`
+}
+
+func (e *RepoNotFoundError) Error() string {
+	if e.Missing != "" {
+		return fmt.Sprintf("%s is not a git repo, it has no %s", e.Path, e.Missing)
+	}
+	return fmt.Sprintf("%s is not a git repo, it has no %s dir", e.Path, GIT)
+}
+func (e *RepoNotFoundError) kind() string  { return "repo-not-found" }
+func (e *RepoNotFoundError) exitCode() int { return EXIT_REPO_NOT_FOUND }
+
+// An object that can't be read, with --strict.
+type CorruptObjectError struct {
+	Name     string `json:"name"`
+	Location string `json:"location"`
+	Err      error  `json:"-"`
+}
+
+func (e *CorruptObjectError) Error() string {
+	return fmt.Sprintf("corrupt object %s at %s: %s", e.Name, e.Location, e.Err)
+}
+func (e *CorruptObjectError) Unwrap() error { return e.Err }
+func (e *CorruptObjectError) kind() string  { return "corrupt-object" }
+func (e *CorruptObjectError) exitCode() int { return EXIT_CORRUPT_OBJECT }
+
+// An abbreviated object name shared by several objects.
+type AmbiguousObjectError struct {
+	Prefix     string            `json:"prefix"`
+	Candidates []ObjectCandidate `json:"candidates"`
+}
+
+func (e *AmbiguousObjectError) Error() string {
+	candidates := make([]string, len(e.Candidates))
+	for i, c := range e.Candidates {
+		candidates[i] = c.Name + " " + c.Type
+	}
+	return fmt.Sprintf("short object name %s is ambiguous, candidates are: %s", e.Prefix, strings.Join(candidates, ", "))
+}
+func (e *AmbiguousObjectError) kind() string  { return "ambiguous-object" }
+func (e *AmbiguousObjectError) exitCode() int { return EXIT_USAGE }
+
+type ExportError struct {
+	Format string `json:"format"`
+	Err    error  `json:"-"`
+}
+
+func (e *ExportError) Error() string { return fmt.Sprintf("%s export failed: %s", e.Format, e.Err) }
+func (e *ExportError) Unwrap() error { return e.Err }
+func (e *ExportError) kind() string  { return "export-failed" }
+func (e *ExportError) exitCode() int { return EXIT_EXPORT_FAILED }
+
+// A repo that changed since its drift baseline.
+type DriftError struct {
+	Baseline string `json:"baseline"`
+	Added    int    `json:"added"`
+	Removed  int    `json:"removed"`
+	Refs     int    `json:"refs"`
+}
+
+func (e *DriftError) Error() string {
+	return fmt.Sprintf("the repo drifted from %s: %d added, %d removed, %d refs changed", e.Baseline, e.Added, e.Removed, e.Refs)
+}
+func (e *DriftError) kind() string  { return "drifted" }
+func (e *DriftError) exitCode() int { return EXIT_DRIFTED }
+
+// Wraps an export's error in an ExportError, keeping errors with a more specific code, like
+// a corrupt object, as they are.
+func exportError(format string, err error) error {
+	var typed typedError
+	if err == nil || errors.As(err, &typed) {
+		return err
+	}
+	return &ExportError{Format: format, Err: err}
+}
+
+// Reports bad flags as usage errors. Flags are parsed before the app's Before runs, so
+// --output is read here too.
+func usageError(cCtx *cli.Context, err error, isSubcommand bool) error {
+	jsonErrors = cCtx.String("output") == "json"
+	return &UsageError{err}
+}
+
+func setUsageErrors(commands []*cli.Command) {
+	for _, command := range commands {
+		command.OnUsageError = usageError
+		setUsageErrors(command.Subcommands)
+	}
+}
+
+// Set by --output json to report errors as JSON.
+var jsonErrors bool
+
+// An error as reported with --output json.
+type errorReport struct {
+	Kind    string `json:"kind"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	// the typed error's fields, e.g. the path of a repo that wasn't found
+	Details typedError `json:"details,omitempty"`
+}
+
+// Reports err on stderr, as JSON with --output json, and exits with its code.
+func fatal(err error) {
+	report := errorReport{Kind: "error", Code: EXIT_USAGE, Message: err.Error()}
+	var typed typedError
+	if errors.As(err, &typed) {
+		report.Kind, report.Code = typed.kind(), typed.exitCode()
+		if _, ok := typed.(*UsageError); !ok {
+			report.Details = typed
+		}
+	}
+	if jsonErrors {
+		json.NewEncoder(os.Stderr).Encode(map[string]errorReport{"error": report})
+	} else {
+		log.Print(err)
+	}
+	os.Exit(report.Code)
+}
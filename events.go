@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+const (
+	EVENT_GRAPH_DIFF          = "graph-diff"
+	EVENT_REF_CREATED         = "ref-created"
+	EVENT_REF_DELETED         = "ref-deleted"
+	EVENT_REF_FAST_FORWARD    = "ref-fast-forward"
+	EVENT_REF_REWRITTEN       = "ref-rewritten"
+	EVENT_COMMITS_UNREACHABLE = "commits-unreachable"
+	EVENT_CHECKOUT            = "checkout"
+)
+
+// Returns the names of the commits reachable from roots, peeling tags. Only commits are walked.
+func (r *Repo) ancestors(roots []string) map[string]bool {
+	seen := map[string]bool{}
+	stack := slices.Clone(roots)
+	for len(stack) > 0 {
+		name := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[name] {
+			continue
+		}
+		obj := r.getObject(name)
+		if obj == nil {
+			continue
+		}
+		switch obj.Type {
+		case "tag":
+			stack = append(stack, parseTag(obj).Object)
+		case "commit":
+			seen[name] = true
+			stack = append(stack, parseCommit(obj).Parents...)
+		}
+	}
+	return seen
+}
+
+// Compares the refs of two snapshots of the repo, reporting refs that were created, deleted,
+// fast-forwarded or rewritten (moved to a commit that doesn't contain the old one, e.g. after a
+// rebase, amend or force push), and the commits no ref reaches anymore. HEAD is left out since
+// it moves with checkouts.
+func (r *Repo) historyEvents(old *Snapshot, new *Snapshot) []RepoEvent {
+	now := time.Now().UTC()
+	var events []RepoEvent
+	for _, c := range diffSnapshots(old, new).Refs {
+		if c.Ref == "HEAD" {
+			continue
+		}
+		e := RepoEvent{Time: now, Ref: c.Ref, Old: c.Old, New: c.New}
+		name := shortRefName(c.Ref)
+		switch {
+		case c.Old == "":
+			e.Type = EVENT_REF_CREATED
+			e.Message = fmt.Sprintf("%s created at %s", name, shortHash(c.New))
+		case c.New == "":
+			e.Type = EVENT_REF_DELETED
+			e.Message = fmt.Sprintf("%s deleted (was %s)", name, shortHash(c.Old))
+		case r.ancestors([]string{c.New})[r.peel(c.Old)]:
+			e.Type = EVENT_REF_FAST_FORWARD
+			e.Message = fmt.Sprintf("%s fast-forwarded from %s to %s", name, shortHash(c.Old), shortHash(c.New))
+		default:
+			e.Type = EVENT_REF_REWRITTEN
+			e.Message = fmt.Sprintf("%s moved non-fast-forward from %s to %s (rebase, amend, reset or force push)", name, shortHash(c.Old), shortHash(c.New))
+		}
+		events = append(events, e)
+	}
+
+	var oldRoots, newRoots []string
+	for _, hash := range old.Refs {
+		oldRoots = append(oldRoots, hash)
+	}
+	for _, hash := range new.Refs {
+		newRoots = append(newRoots, hash)
+	}
+	reachable := r.ancestors(newRoots)
+	unreachable := map[string]bool{}
+	for name := range r.ancestors(oldRoots) {
+		if !reachable[name] {
+			unreachable[name] = true
+		}
+	}
+	// commits removed from the object store (e.g. by gc) were unreachable too
+	for name, type_ := range old.Objects {
+		if _, ok := new.Objects[name]; !ok && type_ == "commit" {
+			unreachable[name] = true
+		}
+	}
+	if len(unreachable) > 0 {
+		lost := make([]string, 0, len(unreachable))
+		for name := range unreachable {
+			lost = append(lost, name)
+		}
+		slices.Sort(lost)
+		events = append(events, RepoEvent{
+			Type:    EVENT_COMMITS_UNREACHABLE,
+			Time:    now,
+			Commits: lost,
+			Message: fmt.Sprintf("%d %s became unreachable", len(lost), plural(len(lost), "commit")),
+		})
+	}
+	return events
+}
+
+// Returns a checkout event when HEAD switched to another branch or commit between two
+// snapshots. Old and New are the full ref names, or commit names for a detached HEAD. A commit
+// made on a detached HEAD moves it too, but isn't a checkout.
+func (r *Repo) checkoutEvent(old *Snapshot, new *Snapshot) *RepoEvent {
+	if old.Head == nil || new.Head == nil || *old.Head == *new.Head {
+		return nil
+	}
+	if old.Head.Type == "detached" && new.Head.Type == "detached" {
+		if obj := r.getObject(new.Head.Value); obj != nil && obj.Type == "commit" && slices.Contains(parseCommit(obj).Parents, old.Head.Value) {
+			return nil
+		}
+	}
+	describe := func(head *Head) string {
+		if head.Type == "detached" {
+			return "detached " + shortHash(head.Value)
+		}
+		return shortRefName(head.Value)
+	}
+	e := &RepoEvent{Type: EVENT_CHECKOUT, Time: time.Now().UTC(), Ref: "HEAD", Old: old.Head.Value, New: new.Head.Value}
+	if new.Head.Type == "detached" {
+		e.Detached = true
+		e.Message = fmt.Sprintf("HEAD detached at %s (was %s)", shortHash(new.Head.Value), describe(old.Head))
+	} else {
+		e.Message = fmt.Sprintf("switched from %s to %s", describe(old.Head), describe(new.Head))
+	}
+	return e
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return word
+	}
+	return word + "s"
+}
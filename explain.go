@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const EVENT_EXPLANATION = "explanation"
+
+// Describes a change to the repo in plain words for people learning how git works, e.g.
+// "a commit object 1a2b3c4 was created pointing to tree 5d6e7f8 with parent 9a8b7c6; branch
+// main advanced from 9a8b7c6 to 1a2b3c4". Returns an empty string when nothing changed.
+func (r *Repo) explain(diff SnapshotDiff, events []RepoEvent) string {
+	var parts []string
+	added := map[string]int{}
+	for _, obj := range diff.Added {
+		added[obj.Type]++
+	}
+	if n := added["blob"]; n > 0 {
+		parts = append(parts, fmt.Sprintf("%s %s created holding file contents", count(n, "blob object"), wasWere(n)))
+	}
+	if n := added["tree"]; n > 0 {
+		parts = append(parts, fmt.Sprintf("%s %s created listing directories", count(n, "tree object"), wasWere(n)))
+	}
+	for _, obj := range diff.Added {
+		switch obj.Type {
+		case "commit":
+			commit := parseCommit(r.getObject(obj.Name))
+			var parents string
+			switch len(commit.Parents) {
+			case 0:
+				parents = "and no parent (a root commit)"
+			case 1:
+				parents = "with parent " + shortHash(commit.Parents[0])
+			default:
+				parents = fmt.Sprintf("with %d parents (a merge)", len(commit.Parents))
+			}
+			parts = append(parts, fmt.Sprintf("a commit object %s was created pointing to tree %s %s", shortHash(obj.Name), shortHash(commit.Tree), parents))
+		case "tag":
+			tag := parseTag(r.getObject(obj.Name))
+			parts = append(parts, fmt.Sprintf("an annotated tag object %s was created for %s %s", tag.Tag, tag.Type, shortHash(tag.Object)))
+		}
+	}
+	if added["commit"] == 0 && added["tag"] == 0 && (added["blob"] > 0 || added["tree"] > 0) {
+		n := added["blob"] + added["tree"]
+		parts = append(parts, fmt.Sprintf("no commit points at %s yet, e.g. files were staged with git add", itThem(n)))
+	}
+	if n := len(diff.Removed); n > 0 {
+		parts = append(parts, fmt.Sprintf("%s %s deleted from the object store (garbage collected or packed)", count(n, "loose object"), wasWere(n)))
+	}
+
+	moved := map[string]bool{}
+	for _, e := range events {
+		name := shortRefName(e.Ref)
+		kind := "ref"
+		if strings.HasPrefix(e.Ref, "refs/heads/") {
+			kind = "branch"
+		} else if strings.HasPrefix(e.Ref, "refs/tags/") {
+			kind, name = "tag", strings.TrimPrefix(name, "tags/")
+		}
+		switch e.Type {
+		case EVENT_REF_CREATED:
+			parts = append(parts, fmt.Sprintf("%s %s was created pointing to %s", kind, name, shortHash(e.New)))
+		case EVENT_REF_DELETED:
+			parts = append(parts, fmt.Sprintf("%s %s was deleted; the objects it pointed to still exist", kind, name))
+		case EVENT_REF_FAST_FORWARD:
+			parts = append(parts, fmt.Sprintf("%s %s advanced from %s to %s", kind, name, shortHash(e.Old), shortHash(e.New)))
+		case EVENT_REF_REWRITTEN:
+			parts = append(parts, fmt.Sprintf("%s %s was moved to %s, which doesn't contain %s, so history was rewritten (rebase, amend, reset or force push)", kind, name, shortHash(e.New), shortHash(e.Old)))
+		case EVENT_COMMITS_UNREACHABLE:
+			n := len(e.Commits)
+			parts = append(parts, fmt.Sprintf("%s %s no longer on any branch or tag, but %s kept until garbage collected", count(n, "commit"), isAre(n), itThey(n)))
+		}
+		moved[e.New] = true
+	}
+	for _, c := range diff.Refs {
+		if c.Ref == "HEAD" && c.New != "" && !moved[c.New] {
+			parts = append(parts, fmt.Sprintf("HEAD now points at %s (a checkout)", shortHash(c.New)))
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	explanation := strings.Join(parts, "; ")
+	return strings.ToUpper(explanation[:1]) + explanation[1:] + "."
+}
+
+func count(n int, word string) string {
+	if n == 1 {
+		return "a " + word
+	}
+	return fmt.Sprintf("%d %s", n, plural(n, word))
+}
+
+func wasWere(n int) string {
+	if n == 1 {
+		return "was"
+	}
+	return "were"
+}
+
+func isAre(n int) string {
+	if n == 1 {
+		return "is"
+	}
+	return "are"
+}
+
+func itThem(n int) string {
+	if n == 1 {
+		return "it"
+	}
+	return "them"
+}
+
+func itThey(n int) string {
+	if n == 1 {
+		return "it is"
+	}
+	return "they are"
+}
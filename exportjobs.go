@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dagit/repofs"
+)
+
+const (
+	EXPORT_RUNNING = "running"
+	EXPORT_DONE    = "done"
+	EXPORT_FAILED  = "failed"
+	// How long a finished export can be downloaded before it's deleted.
+	exportJobTTL = time.Hour
+)
+
+// The content types of the formats POST /api/export supports, which are also the exported
+// files' extensions.
+var exportJobFormats = map[string]string{"json": "application/json", "sqlite": "application/vnd.sqlite3", "dot": "text/vnd.graphviz"}
+
+// An export running on the server, written to a temporary file the client downloads once
+// it's done.
+type ExportJob struct {
+	ID     string `json:"id"`
+	Format string `json:"format"`
+	Repo   string `json:"repo,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Bytes  int64  `json:"bytes,omitempty"`
+	// the percentage of the graph written so far
+	Progress   int        `json:"progress"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	// where to download the export from once it's done
+	Download string `json:"download,omitempty"`
+	path     string
+	job      *runningJob
+}
+
+type exportJobs struct {
+	mu   sync.Mutex
+	jobs map[string]*ExportJob
+}
+
+var exports = &exportJobs{jobs: map[string]*ExportJob{}}
+
+// Returns a copy of a job, safe to encode while it runs.
+func (jobs *exportJobs) get(id string) (ExportJob, bool) {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	jobs.expire()
+	job, ok := jobs.jobs[id]
+	if !ok {
+		return ExportJob{}, false
+	}
+	status := *job
+	if job.job != nil {
+		status.Progress = job.job.snapshot().Percent
+	}
+	return status, true
+}
+
+// Deletes the jobs finished more than exportJobTTL ago and their files. Callers hold mu.
+func (jobs *exportJobs) expire() {
+	for id, job := range jobs.jobs {
+		if job.FinishedAt != nil && time.Since(*job.FinishedAt) > exportJobTTL {
+			os.Remove(job.path)
+			delete(jobs.jobs, id)
+		}
+	}
+}
+
+func (jobs *exportJobs) finish(job *ExportJob, err error) {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	now := time.Now()
+	job.FinishedAt = &now
+	if err != nil {
+		job.Status = EXPORT_FAILED
+		job.Error = err.Error()
+		os.Remove(job.path)
+		return
+	}
+	if info, err := repofs.Stat(job.path); err == nil {
+		job.Bytes = info.Size()
+	}
+	job.Status = EXPORT_DONE
+	job.Download = fmt.Sprintf("/api/export/%s?download=true", job.ID)
+}
+
+// Writes target's graph in format to path.
+func runExport(ctx context.Context, target *Repo, format, path string, opts GraphOptions) (err error) {
+	if format == "sqlite" {
+		return target.toSQLite(ctx, path, opts)
+	}
+	f, err := repofs.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, f.Close()) }()
+	if format == "dot" {
+		return target.writeDot(ctx, f, opts)
+	}
+	return target.writeJson(ctx, f, opts)
+}
+
+// Starts exporting the graph of the server's repo, or the watched one named by the repo
+// query parameter, in the format query parameter. Other query parameters override the
+// server's graph options as for /api/graph. Exports outlive the request, so they run with
+// the server's context.
+func serveStartExport(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		format := query.Get("format")
+		if format == "" {
+			format = "json"
+		}
+		if _, ok := exportJobFormats[format]; !ok {
+			http.Error(w, fmt.Sprintf("unknown export format %q, use json, sqlite or dot", format), http.StatusBadRequest)
+			return
+		}
+		target, err := requestRepo(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if target == nil {
+			http.Error(w, "exports need a live repo, not an imported graph", http.StatusNotImplemented)
+			return
+		}
+		opts, err := graphOptionsFromQuery(query, graphOpts)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f, err := os.CreateTemp("", "dagit-export-*."+format)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		f.Close()
+		jobCtx, running, end := startJob(ctx, JOB_EXPORT, target.location)
+		job := &ExportJob{ID: running.snapshot().ID, Format: format, Repo: query.Get("repo"), Status: EXPORT_RUNNING, CreatedAt: time.Now(), path: f.Name(), job: running}
+		exports.mu.Lock()
+		exports.expire()
+		exports.jobs[job.ID] = job
+		exports.mu.Unlock()
+		logger := loggerFrom(r.Context()).With("export_id", job.ID)
+		logger.Info("export started", "format", format, "repo", job.Repo)
+		// the export reads the repo as it is now, so refreshes needn't wait for it
+		state := target.frozen()
+		go func() {
+			start := time.Now()
+			err := runExport(jobCtx, state, format, job.path, opts)
+			end(err)
+			exports.finish(job, err)
+			if err != nil {
+				logger.Error("export failed", "error", err)
+				return
+			}
+			logger.Info("export done", "bytes", job.Bytes, "duration_ms", time.Since(start).Milliseconds())
+		}()
+		status, _ := exports.get(job.ID)
+		w.Header().Set("Location", "/api/export/"+job.ID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(status)
+	}
+}
+
+// Serves the status of an export, or with download=true the exported file once it's done.
+func serveExport(w http.ResponseWriter, r *http.Request) {
+	job, ok := exports.get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, fmt.Sprintf("no export %q, it may have expired", r.PathValue("id")), http.StatusNotFound)
+		return
+	}
+	if r.URL.Query().Get("download") != "true" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(job)
+		return
+	}
+	if job.Status != EXPORT_DONE {
+		http.Error(w, fmt.Sprintf("export %s is %s", job.ID, job.Status), http.StatusConflict)
+		return
+	}
+	f, err := repofs.Open(job.path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", exportJobFormats[job.Format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="git.%s"`, job.Format))
+	http.ServeContent(w, r, "git."+job.Format, *job.FinishedAt, f)
+}
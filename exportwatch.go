@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dagit/repofs"
+)
+
+// When a watched export checks the repo next.
+type schedule interface {
+	next(after time.Time) time.Time
+}
+
+type interval time.Duration
+
+func (i interval) next(after time.Time) time.Time {
+	return after.Add(time.Duration(i))
+}
+
+// A cron spec's minutes, hours, days of the month, months and days of the week as bit sets.
+type cronSchedule struct {
+	minutes, hours, days, months, weekdays uint64
+	// set when the field isn't *, as cron matches either day field when both are restricted
+	daysSet, weekdaysSet bool
+}
+
+// The fields of a cron spec and their ranges.
+var cronFields = []struct {
+	name     string
+	min, max int
+}{{"minute", 0, 59}, {"hour", 0, 23}, {"day of month", 1, 31}, {"month", 1, 12}, {"day of week", 0, 7}}
+
+// Parses --every: a duration like 10m or a cron spec of five fields (minute, hour, day of
+// month, month, day of week) each *, a number, a range like 1-5 or a list of them, optionally
+// stepped like */15.
+func parseSchedule(spec string) (schedule, error) {
+	if d, err := time.ParseDuration(spec); err == nil {
+		if d <= 0 {
+			return nil, fmt.Errorf("--every %q isn't a positive duration", spec)
+		}
+		return interval(d), nil
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("--every %q is neither a duration nor a cron spec of 5 fields", spec)
+	}
+	sets := make([]uint64, len(fields))
+	for i, field := range fields {
+		set, err := parseCronField(field, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("--every %q: %s: %w", spec, cronFields[i].name, err)
+		}
+		sets[i] = set
+	}
+	// both 0 and 7 are Sunday
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	c := &cronSchedule{
+		minutes: sets[0], hours: sets[1], days: sets[2], months: sets[3], weekdays: sets[4],
+		daysSet: fields[2] != "*", weekdaysSet: fields[4] != "*",
+	}
+	if c.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("--every %q never matches", spec)
+	}
+	return c, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepText, stepped := strings.Cut(part, "/")
+		step := 1
+		if stepped {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepText)
+			}
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			loText, hiText, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(loText); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(hiText); err != nil {
+					return 0, fmt.Errorf("invalid value %q", part)
+				}
+			} else if stepped {
+				// 5/15 is every 15 from 5
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q isn't within %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	day := c.days&(1<<t.Day()) != 0
+	weekday := c.weekdays&(1<<int(t.Weekday())) != 0
+	if c.daysSet && c.weekdaysSet {
+		return day || weekday
+	}
+	return day && weekday
+}
+
+// Returns the first minute after after matching the spec, skipping whole months, days and
+// hours that don't. Specs that never match, e.g. February 30th, give the zero time.
+func (c *cronSchedule) next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	// every combination of day and month recurs within 28 years
+	for limit := t.AddDate(28, 0, 0); t.Before(limit); {
+		switch {
+		case c.months&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hours&(1<<t.Hour()) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case c.minutes&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// Returns out with the time of a run before its extensions, e.g. graph-20060102T150405Z.json.gz.
+func timestampedPath(out string, t time.Time) string {
+	dir, base := filepath.Split(out)
+	name, ext, _ := strings.Cut(base, ".")
+	return filepath.Join(dir, name+"-"+t.UTC().Format("20060102T150405Z")+strings.TrimSuffix("."+ext, "."))
+}
+
+// Runs write into a temporary file next to out and renames it over out, so readers of out
+// never see a partial export.
+func replaceAtomically(out string, write func(tmp string) error) error {
+	f, err := repofs.CreateTemp(filepath.Dir(out), "."+filepath.Base(out)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	err = errors.Join(f.Chmod(0o644), f.Close())
+	if err == nil {
+		err = write(tmp)
+	}
+	if err == nil {
+		err = repofs.Rename(tmp, out)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// Runs an export right away, then again on sched whenever one of repos changed since, until
+// interrupted. A failed run is logged and retried at the next check.
+func watchExport(ctx context.Context, repos []*Repo, sched schedule, run func(ctx context.Context) error) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+	if err := run(ctx); err != nil {
+		return err
+	}
+	pending := false
+	for {
+		next := sched.next(time.Now())
+		slog.Debug("waiting for the next check", "at", next.Format(time.RFC3339))
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+		for _, r := range repos {
+			changed, err := r.changed()
+			if err != nil {
+				slog.Error("checking for changes failed, retrying at the next check", "repo", r.location, "err", err)
+				continue
+			}
+			if changed {
+				if _, err := r.refresh(ctx); err != nil {
+					slog.Error("refresh failed, retrying at the next check", "repo", r.location, "err", err)
+					continue
+				}
+				pending = true
+			}
+		}
+		if !pending {
+			slog.Info("repo unchanged, skipping export")
+			continue
+		}
+		start := time.Now()
+		if err := run(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			slog.Error("export failed, retrying at the next check", "err", err)
+			continue
+		}
+		pending = false
+		slog.Info("exported", "duration_ms", time.Since(start).Milliseconds())
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dagit/repofs"
+)
+
+// A loose object git gc would delete.
+type PrunableObject struct {
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	Bytes    int64     `json:"bytes"`
+	Modified time.Time `json:"modified"`
+}
+
+// The loose objects git gc --prune=<cutoff> would delete.
+type GCPreview struct {
+	Cutoff  time.Time        `json:"cutoff"`
+	Objects []PrunableObject `json:"objects"`
+	Count   int              `json:"count"`
+	// bytes of the loose object files on disk
+	Bytes        int64          `json:"bytes"`
+	CountsByType map[string]int `json:"countsByType"`
+}
+
+// Parses a --prune value: "now" or a duration before now like 336h.
+func parsePruneCutoff(s string, now time.Time) (time.Time, error) {
+	if s == "now" {
+		return now, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("invalid prune cutoff %q, expected now or a duration like 336h", s)
+	}
+	return now.Add(-d), nil
+}
+
+// Returns the blobs of the entries and the trees of the cache-tree extension of .git/index.
+// A missing index has no objects.
+func (r *Repo) indexObjects() ([]string, error) {
+	data, err := repofs.ReadFile(gitDir(r.location) + "/index")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	invalid := errors.New("invalid index file")
+	// header, checksum
+	if len(data) < 12+20 || string(data[:4]) != "DIRC" {
+		return nil, invalid
+	}
+	version := binary.BigEndian.Uint32(data[4:8])
+	if version < 2 || version > 4 {
+		return nil, fmt.Errorf("unsupported index version %d", version)
+	}
+	count := binary.BigEndian.Uint32(data[8:12])
+	end := len(data) - 20
+	pos := 12
+	var objects []string
+	for range count {
+		// ctime, mtime, dev, ino, mode, uid, gid, size, then the object name and flags
+		start := pos
+		if pos+62 > end {
+			return nil, invalid
+		}
+		objects = append(objects, fmt.Sprintf("%x", data[pos+40:pos+60]))
+		flags := binary.BigEndian.Uint16(data[pos+60 : pos+62])
+		pos += 62
+		if version >= 3 && flags&0x4000 != 0 {
+			pos += 2
+		}
+		if version == 4 {
+			// the length of the prefix shared with the previous path as a varint
+			for pos < end && data[pos]&0x80 != 0 {
+				pos++
+			}
+			pos++
+		}
+		nul := bytes.IndexByte(data[min(pos, end):end], 0)
+		if nul < 0 {
+			return nil, invalid
+		}
+		pos += nul + 1
+		if version < 4 {
+			// entries are NUL padded to a multiple of 8 bytes
+			pos = start + (pos-start+7)/8*8
+		}
+	}
+	// extensions: a 4-byte signature and a 4-byte length
+	for pos+8 <= end {
+		signature := string(data[pos : pos+4])
+		size := int(binary.BigEndian.Uint32(data[pos+4 : pos+8]))
+		pos += 8
+		if pos+size > end {
+			return nil, invalid
+		}
+		if signature == "TREE" {
+			objects = append(objects, cacheTreeObjects(data[pos:pos+size])...)
+		}
+		pos += size
+	}
+	return objects, nil
+}
+
+// Parses the trees of a cache-tree index extension. Each entry is a NUL-terminated path,
+// an ASCII entry count (-1 when invalidated), a space, an ASCII subtree count, a newline and,
+// unless invalidated, the tree's object name.
+func cacheTreeObjects(data []byte) []string {
+	var trees []string
+	for len(data) > 0 {
+		nul := bytes.IndexByte(data, 0)
+		newline := bytes.IndexByte(data, '\n')
+		if nul < 0 || newline < nul {
+			break
+		}
+		entries, _, _ := strings.Cut(string(data[nul+1:newline]), " ")
+		data = data[newline+1:]
+		if n, err := strconv.Atoi(entries); err != nil || n < 0 {
+			continue
+		}
+		if len(data) < 20 {
+			break
+		}
+		trees = append(trees, fmt.Sprintf("%x", data[:20]))
+		data = data[20:]
+	}
+	return trees
+}
+
+// Reports the loose objects git gc --prune would delete: those unreachable from HEAD, the
+// refs, the reflogs and the index whose files were last modified before cutoff. Like git,
+// objects reachable from unreachable objects newer than cutoff are kept too. The repo isn't
+// modified.
+func (r *Repo) gcPreview(cutoff time.Time) (*GCPreview, error) {
+	// every namespace's refs, whichever is shown
+	roots := r.refRoots()
+	for _, entry := range r.reflogs() {
+		roots = append(roots, entry.Old, entry.New)
+	}
+	indexed, err := r.indexObjects()
+	if err != nil {
+		return nil, err
+	}
+	roots = append(roots, indexed...)
+	reachable := r.reachable(roots)
+
+	modified := map[string]os.FileInfo{}
+	var recent []string
+	for name, obj := range r.objects {
+		if reachable[name] {
+			continue
+		}
+		info, err := repofs.Stat(obj.Location)
+		if err != nil {
+			return nil, err
+		}
+		modified[name] = info
+		if info.ModTime().After(cutoff) {
+			recent = append(recent, name)
+		}
+	}
+	kept := r.reachable(recent)
+
+	preview := &GCPreview{Cutoff: cutoff, Objects: []PrunableObject{}, CountsByType: map[string]int{}}
+	for name, info := range modified {
+		if kept[name] {
+			continue
+		}
+		obj := r.getObject(name)
+		preview.Objects = append(preview.Objects, PrunableObject{
+			Name:     name,
+			Type:     obj.Type,
+			Bytes:    info.Size(),
+			Modified: info.ModTime(),
+		})
+		preview.Count++
+		preview.Bytes += info.Size()
+		preview.CountsByType[obj.Type]++
+	}
+	slices.SortFunc(preview.Objects, func(a, b PrunableObject) int {
+		if c := a.Modified.Compare(b.Modified); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return preview, nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/dagit/graphjson"
+)
+
+//go:generate go run . gen types --lang ts --out nextjs/src/graph.d.ts
+
+// The sources of the payload types, read for their doc comments.
+//
+//go:embed graphjson/*.go
+var graphjsonSources embed.FS
+
+// The types TypeScript definitions are generated for, with the types they use.
+var tsRoots = []reflect.Type{
+	reflect.TypeFor[graphjson.Graph](),
+	reflect.TypeFor[graphjson.Commit](),
+	reflect.TypeFor[graphjson.Tree](),
+	reflect.TypeFor[graphjson.Blob](),
+	reflect.TypeFor[graphjson.BlobDigest](),
+	reflect.TypeFor[graphjson.Tag](),
+	reflect.TypeFor[graphjson.LFSPointer](),
+	reflect.TypeFor[graphjson.Symlink](),
+	reflect.TypeFor[graphjson.RepoEvent](),
+	reflect.TypeFor[graphjson.Job](),
+	reflect.TypeFor[graphjson.Head](),
+	reflect.TypeFor[graphjson.Branch](),
+	reflect.TypeFor[graphjson.TagRef](),
+	reflect.TypeFor[graphjson.RemoteRef](),
+	reflect.TypeFor[graphjson.PseudoRef](),
+}
+
+// Node marshals itself as one object with its attributes next to its name and type.
+const tsNode = `/** A Git object, ref or placeholder. */
+export interface Node {
+  name: string;
+  /** commit, octopus-merge, tree, blob, tag, lfs-pointer, symlink, ref, more and others. Objects no ref reaches are prefixed with unreachable-. */
+  type: string;
+  object?: Commit | Tree | Blob | BlobDigest | Tag | LFSPointer | Symlink;
+  /** computed by --label-template */
+  label?: string;
+  /** the other properties, added by graph options, e.g. reachable, decorations or dedup */
+  [attribute: string]: unknown;
+}
+`
+
+// The doc comments of the payload types and their fields, by type and Type.Field.
+func graphjsonDocs() (map[string]string, error) {
+	docs := map[string]string{}
+	files, err := graphjsonSources.ReadDir("graphjson")
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	for _, file := range files {
+		src, err := graphjsonSources.ReadFile("graphjson/" + file.Name())
+		if err != nil {
+			return nil, err
+		}
+		f, err := parser.ParseFile(fset, file.Name(), src, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		for _, decl := range f.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				spec := spec.(*ast.TypeSpec)
+				docs[spec.Name.Name] = gen.Doc.Text()
+				st, ok := spec.Type.(*ast.StructType)
+				if !ok {
+					continue
+				}
+				for _, field := range st.Fields.List {
+					for _, name := range field.Names {
+						docs[spec.Name.Name+"."+name.Name] = field.Doc.Text()
+					}
+				}
+			}
+		}
+	}
+	return docs, nil
+}
+
+// Writes a doc comment as JSDoc, which editors show for TypeScript and JavaScript alike.
+func writeTSDoc(w io.Writer, indent string, doc string) {
+	doc = strings.TrimSpace(doc)
+	if doc == "" {
+		return
+	}
+	lines := strings.Split(doc, "\n")
+	if len(lines) == 1 {
+		fmt.Fprintf(w, "%s/** %s */\n", indent, doc)
+		return
+	}
+	fmt.Fprintf(w, "%s/**\n", indent)
+	for _, line := range lines {
+		fmt.Fprintf(w, "%s * %s\n", indent, line)
+	}
+	fmt.Fprintf(w, "%s */\n", indent)
+}
+
+// Writes TypeScript interfaces for the graph JSON and the other payloads of the server,
+// derived from the types in graphjson so the frontend can't drift from what's served.
+func writeTSTypes(w io.Writer) error {
+	docs, err := graphjsonDocs()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "// Generated by dagit gen types --lang ts for graph schema %s. Do not edit.\n", SCHEMA_VERSION)
+	written := map[reflect.Type]bool{reflect.TypeFor[graphjson.Node](): true}
+	var queue []reflect.Type
+	// returns the TypeScript type of a Go type, queueing the structs it uses
+	var tsType func(t reflect.Type) string
+	tsType = func(t reflect.Type) string {
+		switch {
+		case t == reflect.TypeFor[time.Time]():
+			return "string"
+		case t == reflect.TypeFor[json.RawMessage]():
+			return "unknown"
+		}
+		switch t.Kind() {
+		case reflect.String:
+			return "string"
+		case reflect.Bool:
+			return "boolean"
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+			return "number"
+		case reflect.Pointer:
+			return tsType(t.Elem())
+		case reflect.Slice:
+			return tsType(t.Elem()) + "[]"
+		case reflect.Map:
+			return fmt.Sprintf("Record<string, %s>", tsType(t.Elem()))
+		case reflect.Struct:
+			if !written[t] {
+				written[t] = true
+				queue = append(queue, t)
+			}
+			return t.Name()
+		}
+		return "unknown"
+	}
+	for _, root := range tsRoots {
+		tsType(root)
+	}
+	for len(queue) > 0 {
+		t := queue[0]
+		queue = queue[1:]
+		fmt.Fprintln(w)
+		writeTSDoc(w, "", docs[t.Name()])
+		fmt.Fprintf(w, "export interface %s {\n", t.Name())
+		for i := range t.NumField() {
+			field := t.Field(i)
+			name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" || !field.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			optional := ""
+			if strings.Contains(opts, "omitempty") {
+				optional = "?"
+			}
+			type_ := tsType(field.Type)
+			// nil slices, maps and pointers are written as null unless omitted
+			if k := field.Type.Kind(); optional == "" && (k == reflect.Slice || k == reflect.Map || k == reflect.Pointer) {
+				type_ += " | null"
+			}
+			writeTSDoc(w, "  ", docs[t.Name()+"."+field.Name])
+			fmt.Fprintf(w, "  %s%s: %s;\n", name, optional, type_)
+		}
+		fmt.Fprintln(w, "}")
+		if t == reflect.TypeFor[graphjson.Graph]() {
+			fmt.Fprintln(w)
+			io.WriteString(w, tsNode)
+		}
+	}
+	return nil
+}
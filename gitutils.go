@@ -3,28 +3,41 @@ package main
 import (
 	"bytes"
 	"compress/zlib"
+	"context"
+	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"log"
+	"maps"
+	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/dagit/graphjson"
+	"github.com/dagit/repofs"
 	"github.com/gosimple/hashdir"
-	"github.com/schollz/progressbar/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	SPACE byte   = 32
 	NUL   byte   = 0
 	GIT   string = ".git"
+	// bytes of a binary object name
+	HASH_SIZE int = 20
 )
 
 // Given a byte find the first byte in a data slice that equals the match_byte, returning the index.
@@ -38,67 +51,106 @@ func findFirstMatch(match_byte byte, start_index int, data *[]byte) int {
 	return -1
 }
 
-func getTime(unixTime string) time.Time {
-	i, err := strconv.ParseInt(unixTime, 10, 64)
-	if err != nil {
-		log.Fatal(err)
-	}
-	return time.Unix(i, 0)
-}
-
-type Edge struct {
-	Src  string `json:"src"`
-	Dest string `json:"dest"`
-}
-
-type Head struct {
-	Type  string `json:"type"`
-	Value string `json:"value"`
-}
-
-type Branch struct {
-	Name   string `json:"name"`
-	Commit string `json:"commit"`
-}
-
 type Object struct {
 	Type     string `json:"type"`
 	Size     string `json:"size"`
 	Location string `json:"location"`
 	Name     string `json:"name"`
-	Content  []byte `json:"content"`
-}
-
-type Blob struct {
-	Content string `json:"content"`
-	Size    int    `json:"size"`
+	// the zlib compressed object file, nil when dropped to stay under the memory cap
+	compressed []byte
+	// where the content starts in the decompressed object
+	contentStart int
+	// decompressed content shared by the repo's objects
+	cache *contentCache
+	// where the object is reported when it can't be read again
+	stale *staleObjects
 }
 
-type TreeEntry struct {
-	Mode string `json:"mode"`
-	Name string `json:"name"`
-	Hash string `json:"hash"`
+// Loose objects of a load that couldn't be read again, e.g. because git gc packed or pruned
+// them while the repo is served. They read as empty and make the repo refresh on its next
+// check, which drops them.
+type staleObjects struct {
+	mu     sync.Mutex
+	errors map[string]ParseError
 }
 
-type User struct {
-	Name  string `json:"name"`
-	Email string `json:"email"`
+func (s *staleObjects) add(e ParseError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.errors[e.Name]; ok {
+		return
+	}
+	if s.errors == nil {
+		s.errors = map[string]ParseError{}
+	}
+	s.errors[e.Name] = e
+	log.Printf("object %s can no longer be read, reloading the repo: %s", e.Name, e.Error)
 }
 
-type Commit struct {
-	Tree       string    `json:"tree"`
-	Parents    []string  `json:"parents"`
-	Author     User      `json:"author"`
-	Committer  User      `json:"committer"`
-	Message    string    `json:"message"`
-	CommitTime time.Time `json:"commitTime"`
-	AuthorTime time.Time `json:"authorTime"`
+// Returns the stale objects' errors sorted by name.
+func (s *staleObjects) list() []ParseError {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	errs := slices.Collect(maps.Values(s.errors))
+	slices.SortFunc(errs, func(a, b ParseError) int { return strings.Compare(a.Name, b.Name) })
+	return errs
 }
 
 type Repo struct {
+	// guards what refresh replaces (the objects, checksum, parse errors, load times, mailmap,
+	// profile and last snapshot) against goroutines reading them, e.g. server handlers, which
+	// hold it for reading with readLock
+	mu       sync.RWMutex
 	location string
 	objects  map[string]*Object
 	checksum string
+	opts     RepoOptions
+	// when the objects were last loaded and how long it took
+	loadedAt     time.Time
+	loadDuration time.Duration
+	// canonical identities from .mailmap, or pseudonyms, nil when the repo has neither
+	mailmap *Mailmap
+	// the state history events are reported against, nil unless tracked
+	last *Snapshot
+	// also explain each change in plain words
+	explainChanges bool
+	// called with the events of every refresh
+	listeners []func(RepoEvent)
+	// objects skipped on the last load because they couldn't be read
+	parseErrors []ParseError
+	// objects of the last load that couldn't be read again since
+	stale *staleObjects
+	// the slowest objects and packs of the last load, nil unless profiled
+	profile *ObjectProfile
+	// names of the objects in packs, read on first use
+	packed map[string]bool
+	// derived from the objects on first use after a load: the sorted object names prefixes
+	// are looked up in and the generation numbers of commits
+	index   []string
+	gens    map[string]int
+	cacheMu sync.Mutex
+}
+
+type RepoOptions struct {
+	// number of goroutines used to parse and serialize objects
+	Workers int
+	// cap in bytes on the compressed object content kept in memory. 0 means no cap.
+	MaxMemory int64
+	// bytes of decompressed content kept for reuse. 0 decompresses on every read.
+	CacheSize int64
+	// fail on unreadable objects instead of skipping them
+	Strict bool
+	// how long loading waits for git commands to release their locks on the repo
+	LockTimeout time.Duration
+	// the ref namespace shown as the repo's refs, like GIT_NAMESPACE
+	Namespace string
+	// replace the names and emails of authors, committers and taggers with pseudonyms
+	AnonymizeAuthors bool
+	// time reading each object and pack, reporting the slowest
+	ProfileObjects bool
 }
 
 func getType(data *[]byte) (string, int) {
@@ -121,30 +173,89 @@ func getObjectName(object_path string) string {
 	return name
 }
 
-func newObject(object_path string) *Object {
-	zlib_bytes, err := os.ReadFile(object_path)
+// reads and decompresses a loose object file
+func inflate(object_path string) ([]byte, error) {
+	zlib_bytes, err := repofs.ReadFile(object_path)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+	return decompress(zlib_bytes)
+}
+
+func decompress(zlib_bytes []byte) ([]byte, error) {
 	// zlib expects an io.Reader object
 	reader, err := zlib.NewReader(bytes.NewReader(zlib_bytes))
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	bytes, err := io.ReadAll(reader)
+	return io.ReadAll(reader)
+}
+
+// Reads a loose object, checking its header against its content.
+func newObject(object_path string) (*Object, error) {
+	zlib_bytes, err := repofs.ReadFile(object_path)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	data, err := decompress(zlib_bytes)
+	if err != nil {
+		return nil, err
+	}
+	first_space_index := findFirstMatch(SPACE, 0, &data)
+	if first_space_index < 0 || findFirstMatch(NUL, first_space_index, &data) < 0 {
+		return nil, errors.New("invalid object header")
+	}
+	type_, first_space_index := getType(&data)
+	size, content_start_index := getSize(first_space_index, &data)
+	switch type_ {
+	case "blob", "tree", "commit", "tag":
+	default:
+		return nil, fmt.Errorf("unknown object type %q", type_)
+	}
+	if n, err := strconv.Atoi(size); err != nil || n != len(data)-content_start_index {
+		return nil, fmt.Errorf("object size %q doesn't match its %d content bytes", size, len(data)-content_start_index)
+	}
+	return &Object{
+		Type:         type_,
+		Size:         size,
+		Location:     object_path,
+		Name:         getObjectName(object_path),
+		compressed:   zlib_bytes,
+		contentStart: content_start_index,
+	}, nil
+}
+
+// Returns the object's decompressed content. It's decompressed on each use unless it's still
+// in the repo's content cache, reading the object file again if it was dropped from memory.
+// The content may be shared, so it must not be modified. Content that can't be read again,
+// e.g. after git gc pruned the object file, is empty and reported as stale.
+func (obj *Object) Bytes() []byte {
+	if data, ok := obj.cache.get(obj.Name); ok {
+		return data
+	}
+	var data []byte
+	var err error
+	if obj.compressed != nil {
+		data, err = decompress(obj.compressed)
+	} else {
+		data, err = inflate(obj.Location)
 	}
-	data_ptr := &bytes
-	type_, first_space_index := getType(data_ptr)
-	size, content_start_index := getSize(first_space_index, data_ptr)
-	return &Object{type_, size, object_path, getObjectName(object_path), bytes[content_start_index:]}
+	if err != nil {
+		if obj.stale == nil {
+			fatal(&CorruptObjectError{Name: obj.Name, Location: obj.Location, Err: err})
+		}
+		obj.stale.add(ParseError{Name: obj.Name, Location: obj.Location, Error: err.Error()})
+		return nil
+	}
+	data = data[obj.contentStart:]
+	obj.cache.put(obj.Name, data)
+	return data
 }
 
 func (obj *Object) toJson() []byte {
 	switch obj.Type {
 	case "tree":
-		json_tree, err := json.Marshal(map[string][]TreeEntry{"entries": *parseTree(obj)})
+		json_tree, err := json.Marshal(graphjson.Tree{Entries: *parseTree(obj)})
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -156,191 +267,732 @@ func (obj *Object) toJson() []byte {
 		}
 		return json_commit
 	case "blob":
+		if pointer, ok := obj.lfsPointer(); ok {
+			json_pointer, err := json.Marshal(pointer)
+			if err != nil {
+				log.Fatal(err)
+			}
+			return json_pointer
+		}
 		json_blob, err := json.Marshal(parseBlob(obj))
 		if err != nil {
 			log.Fatal(err)
 		}
 		return json_blob
+	case "tag":
+		json_tag, err := json.Marshal(parseTag(obj))
+		if err != nil {
+			log.Fatal(err)
+		}
+		return json_tag
 	default:
 		return make([]byte, 0)
 	}
 }
 
-func getObjects(objects_dir string) map[string]*Object {
-	objects := make(map[string]*Object)
-	filepath.WalkDir(objects_dir, func(path string, d fs.DirEntry, err error) error {
+var (
+	looseDirRegex  = regexp.MustCompile("^[0-9a-fA-F]{2}$")
+	looseNameRegex = regexp.MustCompile("^[0-9a-fA-F]{38}$")
+)
+
+// Loads the loose objects of the repo at location. Unreadable objects are skipped and
+// returned as parse errors, unless opts.Strict is set, which makes them a CorruptObjectError.
+// With opts.ProfileObjects, how long each object took to read is returned too. Objects that
+// can't be read again later are reported to stale.
+func getObjects(ctx context.Context, location string, opts RepoOptions, stale *staleObjects) (map[string]*Object, []ParseError, []ObjectTiming, error) {
+	objects_dir := gitDir(location) + "/objects"
+	var paths []string
+	err := repofs.WalkDir(objects_dir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
-			log.Fatal(err)
+			return err
+		}
+		// loose objects are objects/<first 2 hex digits>/<other 38>
+		if !d.IsDir() && looseNameRegex.MatchString(d.Name()) && looseDirRegex.MatchString(filepath.Base(filepath.Dir(path))) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ctx, job, end := startJob(ctx, JOB_SCAN, location)
+	defer end(nil)
+	job.setTotal(len(paths))
+	// compressed bytes currently held in memory
+	var used atomic.Int64
+	var cache *contentCache
+	if opts.CacheSize > 0 {
+		cache = newContentCache(opts.CacheSize)
+	}
+	var mu sync.Mutex
+	var parseErrors []ParseError
+	var timings []ObjectTiming
+	loaded, err := parallelWork(ctx, paths, func(_ context.Context, path string) (*Object, error) {
+		defer job.add(1)
+		start := time.Now()
+		obj, err := newObject(path)
+		if opts.ProfileObjects && err == nil {
+			t := ObjectTiming{Name: obj.Name, Type: obj.Type, Size: int64(len(obj.compressed)), Duration: time.Since(start)}
+			mu.Lock()
+			timings = append(timings, t)
+			mu.Unlock()
 		}
-		is_hex, err := regexp.MatchString("^[a-fA-F0-9]+$", filepath.Base(path))
 		if err != nil {
-			log.Fatal(err)
+			if opts.Strict {
+				return nil, &CorruptObjectError{Name: getObjectName(path), Location: path, Err: err}
+			}
+			mu.Lock()
+			parseErrors = append(parseErrors, ParseError{Name: getObjectName(path), Location: path, Error: err.Error()})
+			mu.Unlock()
+			return nil, nil
+		}
+		obj.cache, obj.stale = cache, stale
+		size := int64(len(obj.compressed))
+		if opts.MaxMemory > 0 && used.Add(size) > opts.MaxMemory {
+			used.Add(-size)
+			obj.compressed = nil
 		}
-		if !d.IsDir() && is_hex {
-			obj := newObject(path)
+		return obj, nil
+	}, opts.Workers)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	objects := make(map[string]*Object)
+	for _, obj := range loaded {
+		if obj != nil {
 			objects[obj.Name] = obj
 		}
-		return nil
-	})
-	return objects
+	}
+	slices.SortFunc(parseErrors, func(a, b ParseError) int { return strings.Compare(a.Name, b.Name) })
+	for _, e := range parseErrors {
+		log.Printf("skipping unreadable object %s: %s", e.Name, e.Error)
+	}
+	return objects, parseErrors, timings, nil
 }
 
 func gitDir(location string) string {
 	return location + "/" + GIT
 }
 
-func newRepo(location string) *Repo {
-	objects := getObjects(gitDir(location) + "/objects")
+// Checks location has a git dir with what git needs of it: objects and, outside namespaces,
+// a HEAD. A location without one is a RepoNotFoundError.
+func checkGitDir(location string, opts RepoOptions) error {
+	if info, err := repofs.Stat(gitDir(location)); err != nil || !info.IsDir() {
+		return &RepoNotFoundError{Path: location}
+	}
+	required := []string{"objects"}
+	if opts.Namespace == "" {
+		required = append(required, "HEAD")
+	}
+	for _, name := range required {
+		if _, err := repofs.Stat(gitDir(location) + "/" + name); err != nil {
+			return &RepoNotFoundError{Path: location, Missing: GIT + "/" + name}
+		}
+	}
+	return nil
+}
+
+// Loads the repo at location, checked with checkGitDir.
+func newRepo(ctx context.Context, location string, opts RepoOptions) (_ *Repo, err error) {
+	ctx, span := tracer.Start(ctx, "repo.load", trace.WithAttributes(attribute.String("repo.location", location)))
+	defer func() { endSpan(span, err) }()
+	start := time.Now()
+	if err := checkGitDir(location, opts); err != nil {
+		return nil, err
+	}
+	if err := repofs.Protect(location); err != nil {
+		return nil, err
+	}
+	waitForLocks(ctx, gitDir(location), opts.LockTimeout)
+	stale := &staleObjects{}
+	objects, parseErrors, timings, err := getObjects(ctx, location, opts, stale)
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("repo.objects", len(objects)), attribute.Int("repo.parse_errors", len(parseErrors)))
 	dirHash, err := hashdir.Make(gitDir(location), "md5")
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	r := &Repo{
+		location:     location,
+		objects:      objects,
+		checksum:     dirHash,
+		opts:         opts,
+		loadedAt:     start,
+		loadDuration: time.Since(start),
+		parseErrors:  parseErrors,
+		stale:        stale,
+	}
+	r.mailmap = r.identities()
+	if opts.ProfileObjects {
+		r.profile = r.profileObjects(timings)
+		writeObjectProfile(os.Stderr, r.profile)
+	}
+	return r, nil
+}
+
+// Returns the objects that couldn't be read: those skipped on the last load, then those
+// that went stale since.
+func (r *Repo) objectErrors() []ParseError {
+	return append(slices.Clone(r.parseErrors), r.stale.list()...)
+}
+
+// Holds off refreshes of r until the returned function is called, for handlers reading it
+// while a poller refreshes it. Imported graphs, with a nil repo, aren't locked.
+func readLock(r *Repo) func() {
+	if r == nil {
+		return func() {}
 	}
+	r.mu.RLock()
+	return r.mu.RUnlock
+}
+
+// Returns a copy of the repo's current state for long reads, like exports, that shouldn't
+// hold off refreshes. Refreshes replace the objects rather than change them, so the copy
+// stays consistent without the lock. It tracks no history.
+func (r *Repo) frozen() *Repo {
+	defer readLock(r)()
 	return &Repo{
-		location: location,
-		objects:  objects,
-		checksum: dirHash,
+		location:     r.location,
+		objects:      r.objects,
+		checksum:     r.checksum,
+		opts:         r.opts,
+		loadedAt:     r.loadedAt,
+		loadDuration: r.loadDuration,
+		mailmap:      r.mailmap,
+		parseErrors:  r.parseErrors,
+		stale:        r.stale,
+		profile:      r.profile,
 	}
 }
 
-func (r *Repo) changed() bool {
+// Reports whether the git dir changed since the last call or load. It's an error when the
+// git dir can't be read, e.g. after it was deleted.
+func (r *Repo) changed() (bool, error) {
 	dirHash, err := hashdir.Make(gitDir(r.location), "md5")
 	if err != nil {
-		log.Fatal(err)
+		return false, err
 	}
-	if r.checksum != dirHash {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// stale objects mean git changed the objects under the last load
+	if r.checksum != dirHash || len(r.stale.list()) > 0 {
 		r.checksum = dirHash
-		return true
+		return true, nil
 	}
-	return false
+	return false, nil
 }
 
 func (r *Repo) getObject(name string) *Object {
 	return r.objects[name]
 }
 
-func (r *Repo) toJson() []byte {
-	edges := []Edge{}
-	nodes := []map[string]any{}
-	// add objects
+func (r *Repo) objectList() []*Object {
+	objects := make([]*Object, 0, len(r.objects))
 	for _, obj := range r.objects {
-		var objMap map[string]json.RawMessage
-		err := json.Unmarshal(obj.toJson(), &objMap)
-		if err != nil {
-			log.Fatal(err)
+		objects = append(objects, obj)
+	}
+	return objects
+}
+
+// Number of objects serialized at a time. When a memory cap is set objects are
+// streamed in batches instead of all at once.
+func (r *Repo) batchSize() int {
+	if r.opts.MaxMemory > 0 {
+		return max(r.opts.Workers, 1) * 64
+	}
+	return 0
+}
+
+// Returns the edges from obj to the objects it references.
+func (obj *Object) edges() []Edge {
+	edges := []Edge{}
+	switch obj.Type {
+	case "commit":
+		commit := parseCommit(obj)
+		// commit edges to parents
+		for _, p := range commit.Parents {
+			edges = append(edges, Edge{Src: obj.Name, Dest: p})
+		}
+		// commit edge to tree
+		edges = append(edges, Edge{Src: obj.Name, Dest: commit.Tree})
+	case "tree":
+		entries := *parseTree(obj)
+		// tree to blob edges
+		for _, entry := range entries {
+			edges = append(edges, Edge{Src: obj.Name, Dest: entry.Hash, Mode: entry.Mode, EntryKind: entry.Kind})
+		}
+	case "tag":
+		// tag edge to the tagged object, possibly another tag
+		edges = append(edges, Edge{Src: obj.Name, Dest: parseTag(obj).Object})
+	}
+	return edges
+}
+
+func (obj *Object) node() (map[string]any, error) {
+	var objMap map[string]json.RawMessage
+	err := json.Unmarshal(obj.toJson(), &objMap)
+	if err != nil {
+		return nil, fmt.Errorf("object %s: %w", obj.Name, err)
+	}
+	type_ := obj.Type
+	if _, ok := obj.lfsPointer(); ok {
+		type_ = LFS_POINTER
+	}
+	return map[string]any{"name": obj.Name, "type": type_, "object": objMap}, nil
+}
+
+// Returns the node name of a branch, tag or remote-tracking ref, or "" for other refs.
+func refNodeName(ref string) string {
+	if branch, ok := strings.CutPrefix(ref, "refs/heads/"); ok {
+		return branch
+	}
+	if tag, ok := strings.CutPrefix(ref, "refs/tags/"); ok {
+		return "tags/" + tag
+	}
+	if remote, ok := strings.CutPrefix(ref, "refs/remotes/"); ok {
+		return "remotes/" + remote
+	}
+	return ""
+}
+
+// Returns the ref nodes and edges. When selected is not nil, refs pointing at objects
+// outside of it are left out.
+func (r *Repo) refs(selected map[string]bool) ([]map[string]any, []Edge) {
+	var nodes []map[string]any
+	var edges []Edge
+	// names of the ref nodes added, which HEAD can point at
+	added := map[string]bool{}
+	remotes, err := r.Remotes()
+	if err != nil {
+		log.Printf("remote-tracking refs won't show their remotes: %s", err)
+	}
+	for _, ref := range r.showRefs() {
+		if ref.Broken || (selected != nil && !selected[ref.Target]) {
+			continue
 		}
-		nodes = append(nodes, map[string]any{"name": obj.Name, "type": obj.Type, "object": objMap})
-		switch obj.Type {
-		case "commit":
-			commit := parseCommit(obj)
-			// commit edges to parents
-			for _, p := range commit.Parents {
-				edges = append(edges, Edge{Src: obj.Name, Dest: p})
+		// symbolic refs other than HEAD point at the node of the ref they name, e.g.
+		// remotes/origin/HEAD at remotes/origin/main
+		if ref.Symref != "" {
+			if name, dest := refNodeName(ref.Name), refNodeName(ref.Symref); name != "" && dest != "" {
+				added[name] = true
+				nodes = append(nodes, map[string]any{"name": name, "type": "ref", "object": ref})
+				edges = append(edges, Edge{Src: name, Dest: dest})
 			}
-			// commit edge to tree
-			edges = append(edges, Edge{Src: obj.Name, Dest: commit.Tree})
-		case "tree":
-			entries := *parseTree(obj)
-			// tree to blob edges
-			for _, entry := range entries {
-				edges = append(edges, Edge{Src: obj.Name, Dest: entry.Hash})
+			continue
+		}
+		if branch, ok := strings.CutPrefix(ref.Name, "refs/heads/"); ok {
+			added[branch] = true
+			nodes = append(nodes, map[string]any{"name": branch, "type": "ref", "object": Branch{Name: branch, Commit: ref.Target}})
+			edges = append(edges, Edge{Src: branch, Dest: ref.Target})
+		} else if tag, ok := strings.CutPrefix(ref.Name, "refs/tags/"); ok {
+			name := "tags/" + tag
+			added[name] = true
+			nodes = append(nodes, map[string]any{"name": name, "type": "ref", "object": TagRef{Name: tag, Target: ref.Target}})
+			edges = append(edges, Edge{Src: name, Dest: ref.Target})
+		} else if strings.HasPrefix(ref.Name, "refs/remotes/") {
+			remote := remoteRef(ref.Name, ref.Target, remotes)
+			name := "remotes/" + remote.Name
+			added[name] = true
+			nodes = append(nodes, map[string]any{"name": name, "type": "ref", "object": remote})
+			edges = append(edges, Edge{Src: name, Dest: ref.Target})
+		}
+	}
+	for _, ref := range r.pseudoRefs() {
+		var targets []Edge
+		for _, target := range ref.Targets {
+			if selected == nil || selected[target.Hash] {
+				targets = append(targets, Edge{Src: ref.Name, Dest: target.Hash})
 			}
 		}
+		if len(targets) > 0 {
+			nodes = append(nodes, map[string]any{"name": ref.Name, "type": PSEUDO_REF, "object": ref})
+			edges = append(edges, targets...)
+		}
+	}
+	if node, opEdges := r.operationNode(selected); node != nil {
+		nodes = append(nodes, node)
+		edges = append(edges, opEdges...)
 	}
-	// add refs/branches
 	head := r.head()
-	nodes = append(nodes, map[string]any{"name": "HEAD", "type": "ref", "object": head})
-	edges = append(edges, Edge{Src: "HEAD", Dest: filepath.Base(head.Value)})
-	for _, b := range r.branches() {
-		nodes = append(nodes, map[string]any{"name": b.Name, "type": "ref", "object": b})
-		edges = append(edges, Edge{Src: b.Name, Dest: b.Commit})
+	dest := refNodeName(head.Value)
+	if !added[dest] {
+		// detached, or at a ref without a node: HEAD points at the commit itself. A HEAD
+		// written by hand may name an annotated tag, which git checkout would have peeled.
+		_, hash, _ := r.ResolveSymref("HEAD")
+		dest = r.peel(hash)
 	}
+	if added[dest] || r.getObject(dest) != nil && (selected == nil || selected[dest]) {
+		nodes = append([]map[string]any{{"name": "HEAD", "type": "ref", "object": head}}, nodes...)
+		edges = append([]Edge{{Src: "HEAD", Dest: dest}}, edges...)
+	}
+	return nodes, edges
+}
 
-	repo_json, err := json.Marshal(map[string]any{"nodes": nodes, "edges": edges})
+// Writes the items of a JSON array to an io.Writer one at a time.
+type jsonArray struct {
+	w     io.Writer
+	count int
+}
+
+func (a *jsonArray) add(v any) error {
+	item, err := json.Marshal(v)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	if a.count > 0 {
+		item = append([]byte{','}, item...)
 	}
-	return repo_json
+	a.count++
+	_, err = a.w.Write(item)
+	return err
 }
 
-func exec(db *sql.DB, query string) sql.Result {
-	result, err := db.Exec(query)
+// Streams the edges and then the nodes of the graph selected by opts to the callbacks,
+// serializing objects in parallel batches.
+func (r *Repo) streamGraph(ctx context.Context, opts GraphOptions, edge func(Edge) error, node func(map[string]any) error) (err error) {
+	ctx, job, end := startJob(ctx, JOB_BUILD_GRAPH, r.location)
+	defer func() { end(err) }()
+	sel, err := r.traceSelect(ctx, opts)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	// objects are walked twice, once for edges and once for nodes
+	job.setTotal(2 * len(sel.objects))
+	batches := chunks(sel.objects, r.batchSize())
+	refNodes, refEdges := r.refs(sel.names)
+	edgesOf := func(_ context.Context, obj *Object) ([]Edge, error) {
+		defer job.add(1)
+		return obj.edges(), nil
+	}
+	nodeOf := func(_ context.Context, obj *Object) (map[string]any, error) {
+		defer job.add(1)
+		return sel.node(obj)
+	}
+
+	for _, batch := range batches {
+		results, err := parallelWork(ctx, batch, edgesOf, r.opts.Workers)
+		if err != nil {
+			return err
+		}
+		for _, edges := range results {
+			for _, e := range edges {
+				if !sel.hasEdge(e) {
+					continue
+				}
+				if err := edge(e); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, e := range append(refEdges, sel.edges...) {
+		if err := edge(e); err != nil {
+			return err
+		}
+	}
+	for _, batch := range batches {
+		nodes, err := parallelWork(ctx, batch, nodeOf, r.opts.Workers)
+		if err != nil {
+			return err
+		}
+		for _, n := range nodes {
+			if err := node(n); err != nil {
+				return err
+			}
+		}
+	}
+	for _, n := range append(sel.extra, refNodes...) {
+		if err := node(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Streams the repo's graph as JSON to w.
+func (r *Repo) writeJson(ctx context.Context, w io.Writer, opts GraphOptions) (err error) {
+	ctx, span := tracer.Start(ctx, "graph.writeJson")
+	defer func() { endSpan(span, err) }()
+	if _, err := fmt.Fprintf(w, `{"schemaVersion":%q,"edges":[`, SCHEMA_VERSION); err != nil {
+		return err
+	}
+	edges, nodes := &jsonArray{w: w}, &jsonArray{w: w}
+	nodesStarted := false
+	edge := func(e Edge) error {
+		return edges.add(e)
+	}
+	node := func(n map[string]any) error {
+		if !nodesStarted {
+			nodesStarted = true
+			if _, err := io.WriteString(w, `],"nodes":[`); err != nil {
+				return err
+			}
+		}
+		return nodes.add(n)
+	}
+	if err := r.streamGraph(ctx, opts, edge, node); err != nil {
+		return err
+	}
+	closing := "]"
+	if !nodesStarted {
+		closing = `],"nodes":[]`
+	}
+	if _, err := io.WriteString(w, closing); err != nil {
+		return err
+	}
+	if errs := r.objectErrors(); len(errs) > 0 {
+		parseErrors, err := json.Marshal(errs)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, `,"parseErrors":%s`, parseErrors); err != nil {
+			return err
+		}
 	}
-	return result
+	_, err = io.WriteString(w, "}")
+	return err
 }
 
-func (r *Repo) toSQLite(path string) {
+func (r *Repo) toJson(ctx context.Context, opts GraphOptions) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := r.writeJson(ctx, &buf, opts); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Splits a node into its object and attributes columns, the attributes being every key but
+// name, type and object. Attributes are nil when there are none.
+func nodeColumns(node map[string]any) ([]byte, []byte, error) {
+	object, err := json.Marshal(node["object"])
+	if err != nil {
+		return nil, nil, err
+	}
+	attrs := maps.Clone(node)
+	delete(attrs, "name")
+	delete(attrs, "type")
+	delete(attrs, "object")
+	if len(attrs) == 0 {
+		return object, nil, nil
+	}
+	attributes, err := json.Marshal(attrs)
+	return object, attributes, err
+}
+
+func (r *Repo) toSQLite(ctx context.Context, path string, opts GraphOptions) (err error) {
+	ctx, span := tracer.Start(ctx, "graph.toSQLite", trace.WithAttributes(attribute.String("db.path", path)))
+	defer func() { endSpan(span, err) }()
+	ctx, job, end := startJob(ctx, JOB_EXPORT, r.location)
+	defer func() { end(err) }()
+	sel, err := r.traceSelect(ctx, opts)
+	if err != nil {
+		return err
+	}
+	if err := repofs.CheckWrite(path); err != nil {
+		return err
+	}
 	os.Remove(path)
 
 	db, err := sql.Open("sqlite3", path)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer db.Close()
 
-	exec(db, `create table objects (name text primary key, type text, object jsonb);`)
-	exec(db, `create table edges (src text, dest text);`)
-	objs_stmt, err := db.Prepare("insert into objects(name, type, object) values(?, ?, ?)")
+	if _, err := db.Exec(`create table objects (name text primary key, type text, object jsonb, attributes jsonb);`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`create table edges (src text, dest text, kind text, mode text, entry_kind text);`); err != nil {
+		return err
+	}
+	objs_stmt, err := db.Prepare("insert into objects(name, type, object, attributes) values(?, ?, ?, ?)")
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	edges_stmt, err := db.Prepare("insert into edges(src, dest) values(?, ?)")
+	edges_stmt, err := db.Prepare("insert into edges(src, dest, kind, mode, entry_kind) values(?, ?, nullif(?, ''), nullif(?, ''), nullif(?, ''))")
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer objs_stmt.Close()
 	defer edges_stmt.Close()
 
-	fmt.Println("[info] generating Git SQLite database...")
-	bar := progressbar.Default(int64(len(r.objects)))
-	for name, obj := range r.objects {
-		_, err = objs_stmt.Exec(name, obj.Type, obj.toJson())
+	type row struct {
+		name       any
+		type_      any
+		object     []byte
+		attributes []byte
+		edges      []Edge
+	}
+	nodeRow := func(node map[string]any) (row, error) {
+		object, attributes, err := nodeColumns(node)
+		return row{name: node["name"], type_: node["type"], object: object, attributes: attributes}, err
+	}
+	toRow := func(_ context.Context, obj *Object) (row, error) {
+		node, err := sel.node(obj)
+		if err != nil {
+			return row{}, err
+		}
+		row, err := nodeRow(node)
+		row.edges = obj.edges()
+		return row, err
+	}
+
+	job.setTotal(len(sel.objects))
+	for _, batch := range chunks(sel.objects, r.batchSize()) {
+		rows, err := parallelWork(ctx, batch, toRow, r.opts.Workers)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
-		switch obj.Type {
-		case "commit":
-			commit := parseCommit(obj)
-			// commit edges to parents
-			for _, p := range commit.Parents {
-				_, err = edges_stmt.Exec(obj.Name, p)
-				if err != nil {
-					log.Fatal(err)
-				}
+		for _, row := range rows {
+			if _, err := objs_stmt.Exec(row.name, row.type_, row.object, row.attributes); err != nil {
+				return err
 			}
-			// commit edge to tree
-			_, err = edges_stmt.Exec(obj.Name, commit.Tree)
-			if err != nil {
-				log.Fatal(err)
-			}
-		case "tree":
-			entries := *parseTree(obj)
-			// tree to blob edges
-			for _, entry := range entries {
-				_, err = edges_stmt.Exec(obj.Name, entry.Hash)
-				if err != nil {
-					log.Fatal(err)
+			for _, e := range row.edges {
+				if !sel.hasEdge(e) {
+					continue
+				}
+				if _, err := edges_stmt.Exec(e.Src, e.Dest, e.Kind, e.Mode, e.EntryKind); err != nil {
+					return err
 				}
 			}
+			job.add(1)
+		}
+	}
+	refNodes, refEdges := r.refs(sel.names)
+	for _, node := range append(sel.extra, refNodes...) {
+		row, err := nodeRow(node)
+		if err != nil {
+			return err
+		}
+		if _, err := objs_stmt.Exec(row.name, row.type_, row.object, row.attributes); err != nil {
+			return err
+		}
+	}
+	for _, e := range append(refEdges, sel.edges...) {
+		if _, err := edges_stmt.Exec(e.Src, e.Dest, e.Kind, e.Mode, e.EntryKind); err != nil {
+			return err
 		}
-		bar.Add(1)
 	}
+	if _, err := db.Exec(`create table parse_errors (name text, location text, error text);`); err != nil {
+		return err
+	}
+	for _, e := range r.objectErrors() {
+		if _, err := db.Exec("insert into parse_errors values(?, ?, ?)", e.Name, e.Location, e.Error); err != nil {
+			return err
+		}
+	}
+	if err := r.writeRemotesTable(db); err != nil {
+		return err
+	}
+	if err := r.writeGrowthTable(db, sel); err != nil {
+		return err
+	}
+	return r.writeTimelineTable(db, sel)
 }
 
-func (r *Repo) refresh() {
-	objects := getObjects(r.location)
+// Reloads the repo's objects. When history is tracked, returns the events since the last refresh.
+// The repo is left as it was when its objects can't be loaded.
+func (r *Repo) refresh(ctx context.Context) (_ []RepoEvent, err error) {
+	ctx, span := tracer.Start(ctx, "repo.refresh", trace.WithAttributes(attribute.String("repo.location", r.location)))
+	defer func() { endSpan(span, err) }()
+	start := time.Now()
+	defer func() {
+		if err != nil {
+			// forget the checksum so the next check tries again
+			r.mu.Lock()
+			r.checksum = ""
+			r.mu.Unlock()
+		}
+	}()
+	waitForLocks(ctx, gitDir(r.location), r.opts.LockTimeout)
+	if err := checkGitDir(r.location, r.opts); err != nil {
+		return nil, err
+	}
+	stale := &staleObjects{}
+	objects, parseErrors, timings, err := getObjects(ctx, r.location, r.opts, stale)
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("repo.objects", len(objects)), attribute.Int("repo.parse_errors", len(parseErrors)))
+	events := r.replaceObjects(objects, parseErrors, stale, timings, start)
+	// listeners may take their time, e.g. publishing, so they're called without the lock
+	for _, e := range events {
+		for _, listener := range r.listeners {
+			listener(e)
+		}
+	}
+	return events, nil
+}
+
+// Replaces the repo's objects with newly loaded ones and returns the history events since
+// the last refresh, holding the repo's lock so readers never see a partial refresh.
+func (r *Repo) replaceObjects(objects map[string]*Object, parseErrors []ParseError, stale *staleObjects, timings []ObjectTiming, start time.Time) []RepoEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.objects = objects
+	r.parseErrors, r.stale = parseErrors, stale
+	r.cacheMu.Lock()
+	r.index, r.gens = nil, nil
+	r.cacheMu.Unlock()
+	r.loadedAt = start
+	r.loadDuration = time.Since(start)
+	r.mailmap = r.identities()
+	if r.opts.ProfileObjects {
+		r.profile = r.profileObjects(timings)
+	}
+	if r.last == nil {
+		return nil
+	}
+	current := r.snapshot("")
+	diff := diffSnapshots(r.last, current)
+	var events []RepoEvent
+	if len(diff.Added) > 0 || len(diff.Removed) > 0 || len(diff.Refs) > 0 {
+		events = append(events, RepoEvent{
+			Type:    EVENT_GRAPH_DIFF,
+			Time:    time.Now().UTC(),
+			Diff:    &diff,
+			Message: fmt.Sprintf("%d added, %d removed, %d refs changed", len(diff.Added), len(diff.Removed), len(diff.Refs)),
+		})
+	}
+	if e := r.checkoutEvent(r.last, current); e != nil {
+		events = append(events, *e)
+	}
+	events = append(events, r.historyEvents(r.last, current)...)
+	if r.explainChanges {
+		if msg := r.explain(diff, events); msg != "" {
+			events = append(events, RepoEvent{Type: EVENT_EXPLANATION, Time: time.Now().UTC(), Message: msg})
+		}
+	}
+	r.last = current
+	return events
+}
+
+// Registers fn to be called with the events of every refresh. History must be tracked.
+func (r *Repo) onEvent(fn func(RepoEvent)) {
+	r.listeners = append(r.listeners, fn)
+}
+
+// Starts reporting history events on refresh, relative to the repo's current state. With
+// explain, every change is also described in an explanation event.
+func (r *Repo) trackHistory(explain bool) {
+	r.last = r.snapshot("")
+	r.explainChanges = explain
 }
 
 func (r *Repo) head() Head {
-	bytes, err := os.ReadFile(gitDir(r.location) + "/HEAD")
-	if err != nil {
-		log.Fatal(err)
+	content, ok := r.readRef("HEAD")
+	if !ok {
+		// namespaces needn't have a HEAD
+		if r.opts.Namespace != "" {
+			return Head{}
+		}
+		log.Fatalf("%s has no HEAD", gitDir(r.location))
 	}
 	var type_ string
 	var value string
-	arr := strings.Split(string(bytes), ":")
+	arr := strings.Split(content, ":")
 	if len(arr) > 1 {
 		type_ = strings.TrimSpace(arr[0])
 		value = strings.TrimSpace(arr[1])
@@ -349,21 +1001,19 @@ func (r *Repo) head() Head {
 		type_ = "detached"
 		value = strings.TrimSpace(arr[0])
 	}
-	return Head{Type: type_, Value: value}
-}
-
-func newBranch(f string) Branch {
-	name := filepath.Base(f)
-	bytes, err := os.ReadFile(f)
-	if err != nil {
-		log.Fatal(err)
+	head := Head{Type: type_, Value: value}
+	if chain, _, _ := r.ResolveSymref("HEAD"); len(chain) > 1 {
+		head.Target = chain[len(chain)-1]
 	}
-	return Branch{Name: name, Commit: strings.Trim(string(bytes), "\n")}
+	return head
 }
 
 func (r *Repo) currBranch() Branch {
-	head := r.head()
-	return newBranch(r.location + fmt.Sprintf("/%s/", GIT) + head.Value)
+	chain, hash, _ := r.ResolveSymref("HEAD")
+	if len(chain) == 0 {
+		return Branch{Name: "HEAD", Commit: hash}
+	}
+	return Branch{Name: strings.TrimPrefix(chain[len(chain)-1], "refs/heads/"), Commit: hash}
 }
 
 func (r *Repo) currCommit() Commit {
@@ -373,92 +1023,133 @@ func (r *Repo) currCommit() Commit {
 
 func (r *Repo) branches() []Branch {
 	branches := []Branch{}
-	filepath.WalkDir(r.location+fmt.Sprintf("/%s/refs/heads", GIT), func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			log.Fatal(err)
+	for name, hash := range r.allRefs() {
+		if branch, ok := strings.CutPrefix(name, "refs/heads/"); ok {
+			branches = append(branches, Branch{Name: branch, Commit: hash})
 		}
-		if !d.IsDir() {
-			branches = append(branches, newBranch(path))
-		}
-		return nil
-	})
+	}
 	return branches
 }
 
+func blobDigest(obj *Object) BlobDigest {
+	data := obj.Bytes()
+	sum := sha256.Sum256(data)
+	return BlobDigest{Size: len(data), ContentType: http.DetectContentType(data), Sha256: hex.EncodeToString(sum[:])}
+}
+
 func parseBlob(obj *Object) Blob {
 	size, err := strconv.Atoi(obj.Size)
 	if err != nil {
 		log.Fatal(err)
 	}
-	return Blob{Content: string(obj.Content), Size: size}
+	return Blob{Content: string(obj.Bytes()), Size: size}
 }
 
+// Parses a tree's entries. Each is an octal mode up to a space, a name up to a NUL and the
+// entry's binary object name. Parsing stops at a truncated entry.
 func parseTree(obj *Object) *[]TreeEntry {
 	var entries []TreeEntry
-	content_len := len(obj.Content)
-	entry_item, start, stop := 1, 0, 6 // TODO: don't use magic numbers. Define constants.
-	mode, name, hash := "", "", ""
-	for stop <= content_len {
-		switch entry_item {
-		// get the mode
-		case 1:
-			mode = strings.TrimSpace(string(obj.Content[start:stop]))
-			entry_item += 1
-			start = stop
-		// get the name (file or dir)
-		case 2:
-			i := start
-			for obj.Content[i] != NUL && i < content_len-1 {
-				i += 1
-			}
-			name = strings.TrimSpace(string(obj.Content[start:i]))
-			entry_item += 1
-			start = i + 1
-			stop = start + 20 // TODO: don't use magic numbers. Define constants.
-		// get the hash (object name)
-		case 3:
-			hash = strings.TrimSpace(hex.EncodeToString(obj.Content[start:stop]))
-			entry_item = 1
-			start = stop
-			stop = start + 6 // TODO: don't use magic numbers. Define constants.
-			entries = append(entries, TreeEntry{mode, name, hash})
+	data := obj.Bytes()
+	for len(data) > 0 {
+		mode, rest, found := bytes.Cut(data, []byte{SPACE})
+		if !found {
+			break
+		}
+		name, rest, found := bytes.Cut(rest, []byte{NUL})
+		if !found || len(rest) < HASH_SIZE {
+			break
 		}
+		entries = append(entries, TreeEntry{Mode: string(mode), Kind: entryKind(string(mode)), Name: string(name), Hash: hex.EncodeToString(rest[:HASH_SIZE])})
+		data = rest[HASH_SIZE:]
 	}
 	return &entries
 }
 
-func parseCommit(obj *Object) Commit {
-	tree_hash := string(obj.Content[5:45]) // TODO: don't use magic numbers. Define constants.
-	content := string(obj.Content[46:])
-	rest_of_content := strings.Split(content, "\n")
-	// The commit message looks to be separated by two newlines and ends with a newline
-	msg := strings.Trim(strings.Split(content, "\n\n")[1], "\n")
-
-	var parents []string
-	var author User
-	var committer User
-	var commitTime time.Time
-	var authorTime time.Time
-
-	for _, line := range rest_of_content {
-		if len(line) < 9 {
+// A header of a commit or tag object. Values spanning several lines (e.g. gpgsig) are joined
+// with newlines.
+type objectHeader struct {
+	key   string
+	value string
+}
+
+// Splits a commit or tag object into its headers and message. Headers are "key value" lines
+// up to the first blank line, where lines starting with a space continue the previous value.
+func parseHeaders(data []byte) ([]objectHeader, string) {
+	header, msg, _ := strings.Cut(string(data), "\n\n")
+	var headers []objectHeader
+	for _, line := range strings.Split(header, "\n") {
+		if continued, ok := strings.CutPrefix(line, " "); ok && len(headers) > 0 {
+			headers[len(headers)-1].value += "\n" + continued
 			continue
 		}
-		if line[:6] == "parent" {
-			parents = append(parents, line[7:47]) // TODO: don't use magic numbers. Define constants.
-		} else if line[:6] == "author" {
-			nameEnd := strings.Index(line, "<")
-			name := line[7:nameEnd]
-			var authorLine []string = strings.Split(line[nameEnd:], " ")
-			authorTime = getTime(authorLine[1])
-			author = User{Name: name, Email: authorLine[0]}
-		} else if line[:9] == "committer" {
-			nameEnd := strings.Index(line, "<")
-			name := line[10:nameEnd]
-			var commiterLine []string = strings.Split(line[nameEnd:], " ")
-			commitTime = getTime(commiterLine[1])
-			committer = User{Name: name, Email: commiterLine[0]}
-		}
-	}
-	return Commit{tree_hash, parents, author, committer, msg, commitTime, authorTime}
+		key, value, _ := strings.Cut(line, " ")
+		if key != "" {
+			headers = append(headers, objectHeader{key, value})
+		}
+	}
+	// The message can span several paragraphs (e.g. trailers), so keep everything after the headers.
+	return headers, strings.Trim(msg, "\n")
+}
+
+func parseCommit(obj *Object) Commit {
+	return parseCommitData(obj.Name, obj.Bytes())
+}
+
+// Parses the content of the commit name, e.g. read from a pack.
+func parseCommitData(name string, data []byte) Commit {
+	headers, msg := parseHeaders(data)
+	commit := Commit{Hash: name, Message: msg, Conventional: parseConventional(msg)}
+	for _, h := range headers {
+		switch h.key {
+		case "tree":
+			commit.Tree = h.value
+		case "parent":
+			commit.Parents = append(commit.Parents, h.value)
+		case "author":
+			commit.Author, commit.AuthorTime = parseSignature(h.value)
+		case "committer":
+			commit.Committer, commit.CommitTime = parseSignature(h.value)
+		}
+	}
+	return commit
+}
+
+// the unix time and timezone ending a signature
+var signatureTimeRegex = regexp.MustCompile(`\s*(-?\d+)\s+[+-]\d{4}$`)
+
+// Parses a "Name <email> unix-time tz" signature line value. The name and email are kept as
+// they're written, the name with the space before the email and the email in its angle
+// brackets, as exports have always carried them. Signatures missing the email or time keep
+// what they have: the name is whatever precedes the email, or the whole identity.
+func parseSignature(value string) (User, time.Time) {
+	var when time.Time
+	if match := signatureTimeRegex.FindStringSubmatchIndex(value); match != nil {
+		if i, err := strconv.ParseInt(value[match[2]:match[3]], 10, 64); err == nil {
+			when = time.Unix(i, 0)
+			value = value[:match[0]]
+		}
+	}
+	start, end := strings.Index(value, "<"), strings.LastIndex(value, ">")
+	if start < 0 || end < start {
+		return User{Name: value}, when
+	}
+	return User{Name: value[:start], Email: value[start : end+1]}, when
+}
+
+func parseTag(obj *Object) Tag {
+	headers, msg := parseHeaders(obj.Bytes())
+	tag := Tag{Message: msg}
+	for _, h := range headers {
+		switch h.key {
+		case "object":
+			tag.Object = h.value
+		case "type":
+			tag.Type = h.value
+		case "tag":
+			tag.Tag = h.value
+		case "tagger":
+			tag.Tagger, tag.TagTime = parseSignature(h.value)
+		}
+	}
+	return tag
 }
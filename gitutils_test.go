@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dagit/testrepo"
+)
+
+// A tree written by git mktree with an entry of each mode, names with spaces and a submodule.
+const fixtureTree = "175762480143eb626b71ea4ac18818064043343a"
+
+// Returns an object with the given content, as if read from a loose object file.
+func testObject(t *testing.T, type_ string, content []byte) *Object {
+	t.Helper()
+	header := fmt.Sprintf("%s %d\x00", type_, len(content))
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	w.Write([]byte(header))
+	w.Write(content)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &Object{Type: type_, Size: fmt.Sprint(len(content)), Name: "test", compressed: compressed.Bytes(), contentStart: len(header)}
+}
+
+// Reads a loose object from testdata/objects.
+func fixtureObject(t *testing.T, name string) *Object {
+	t.Helper()
+	obj, err := newObject(filepath.Join("testdata", "objects", name[:2], name[2:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return obj
+}
+
+func TestParseTree(t *testing.T) {
+	obj := fixtureObject(t, fixtureTree)
+	if obj.Name != fixtureTree || obj.Type != "tree" {
+		t.Fatalf("loaded %s %s, want tree %s", obj.Type, obj.Name, fixtureTree)
+	}
+	blob := "ce013625030ba8dba906f756967f9e9ca394464a"
+	want := []TreeEntry{
+		{Mode: "100644", Kind: ENTRY_FILE, Name: "README.md", Hash: blob},
+		{Mode: "100755", Kind: ENTRY_EXECUTABLE, Name: "build.sh", Hash: blob},
+		{Mode: "120000", Kind: ENTRY_SYMLINK, Name: "link", Hash: "4cbb553f3f4ac2ee7b01ff6c951d6bf583c39c15"},
+		{Mode: "100644", Kind: ENTRY_FILE, Name: "name with spaces.txt", Hash: blob},
+		{Mode: "40000", Kind: ENTRY_DIRECTORY, Name: "src dir", Hash: "66c31ab1b81cb3c5a77682b67611d2d6984ba73d"},
+		{Mode: "160000", Kind: ENTRY_GITLINK, Name: "vendor lib", Hash: "1111111111111111111111111111111111111111"},
+	}
+	if got := *parseTree(obj); !slices.Equal(got, want) {
+		t.Errorf("parseTree() = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseTreeTruncated(t *testing.T) {
+	obj := fixtureObject(t, fixtureTree)
+	content := obj.Bytes()
+	// the first entry is "100644 README.md\x00" and its 20 byte hash
+	first := len("100644 README.md\x00") + HASH_SIZE
+	tests := []struct {
+		name    string
+		content []byte
+		entries int
+	}{
+		{"empty", nil, 0},
+		{"mode without space", []byte("100644"), 0},
+		{"name without NUL", []byte("100644 README.md"), 0},
+		{"short hash", content[:first-1], 0},
+		{"second entry's mode", content[:first+3], 1},
+		{"second entry's name", content[:first+len("100755 build")], 1},
+		{"second entry's hash", content[:first+len("100755 build.sh\x00")+10], 1},
+		{"whole entries", content[:first], 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := *parseTree(testObject(t, "tree", tt.content))
+			if len(entries) != tt.entries {
+				t.Fatalf("parsed %d entries, want %d: %+v", len(entries), tt.entries, entries)
+			}
+			if tt.entries > 0 && entries[0].Name != "README.md" {
+				t.Errorf("first entry is %q, want README.md", entries[0].Name)
+			}
+		})
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	tree := "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n"
+	author := "author A U Thor <author@example.com> 1577836800 +0000\n"
+	tests := []struct {
+		name    string
+		data    string
+		headers []objectHeader
+		msg     string
+	}{
+		{
+			name:    "root commit",
+			data:    tree + author + "\ninitial\n",
+			headers: []objectHeader{{"tree", "4b825dc642cb6eb9a060e54bf8d69288fbee4904"}, {"author", "A U Thor <author@example.com> 1577836800 +0000"}},
+			msg:     "initial",
+		},
+		{
+			name: "octopus merge",
+			data: tree + "parent 1111111111111111111111111111111111111111\nparent 2222222222222222222222222222222222222222\nparent 3333333333333333333333333333333333333333\n\nmerge a, b and c\n",
+			headers: []objectHeader{
+				{"tree", "4b825dc642cb6eb9a060e54bf8d69288fbee4904"},
+				{"parent", "1111111111111111111111111111111111111111"},
+				{"parent", "2222222222222222222222222222222222222222"},
+				{"parent", "3333333333333333333333333333333333333333"},
+			},
+			msg: "merge a, b and c",
+		},
+		{
+			name: "gpgsig continuation lines",
+			data: tree + "gpgsig -----BEGIN PGP SIGNATURE-----\n \n iQEzBAABCAAdFiEE\n -----END PGP SIGNATURE-----\n" + author + "\nsigned\n",
+			headers: []objectHeader{
+				{"tree", "4b825dc642cb6eb9a060e54bf8d69288fbee4904"},
+				{"gpgsig", "-----BEGIN PGP SIGNATURE-----\n\niQEzBAABCAAdFiEE\n-----END PGP SIGNATURE-----"},
+				{"author", "A U Thor <author@example.com> 1577836800 +0000"},
+			},
+			msg: "signed",
+		},
+		{
+			name:    "message paragraphs",
+			data:    tree + "\nsubject\n\nbody\n\nSigned-off-by: A U Thor <author@example.com>\n",
+			headers: []objectHeader{{"tree", "4b825dc642cb6eb9a060e54bf8d69288fbee4904"}},
+			msg:     "subject\n\nbody\n\nSigned-off-by: A U Thor <author@example.com>",
+		},
+		{
+			name:    "no message",
+			data:    tree,
+			headers: []objectHeader{{"tree", "4b825dc642cb6eb9a060e54bf8d69288fbee4904"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers, msg := parseHeaders([]byte(tt.data))
+			if !slices.Equal(headers, tt.headers) {
+				t.Errorf("headers = %q, want %q", headers, tt.headers)
+			}
+			if msg != tt.msg {
+				t.Errorf("message = %q, want %q", msg, tt.msg)
+			}
+		})
+	}
+}
+
+func TestParseSignature(t *testing.T) {
+	when := time.Unix(1577836800, 0)
+	tests := []struct {
+		name  string
+		value string
+		user  User
+		when  time.Time
+	}{
+		{"name and email", "A U Thor <author@example.com> 1577836800 +0000", User{Name: "A U Thor ", Email: "<author@example.com>"}, when},
+		{"negative timezone", "A U Thor <author@example.com> 1577836800 -0730", User{Name: "A U Thor ", Email: "<author@example.com>"}, when},
+		{"email with spaces", "A U Thor <author at example dot com> 1577836800 +0000", User{Name: "A U Thor ", Email: "<author at example dot com>"}, when},
+		{"empty email", "A U Thor <> 1577836800 +0000", User{Name: "A U Thor ", Email: "<>"}, when},
+		{"missing <", "A U Thor author@example.com> 1577836800 +0000", User{Name: "A U Thor author@example.com>"}, when},
+		{"missing email", "A U Thor 1577836800 +0000", User{Name: "A U Thor"}, when},
+		{"missing time", "A U Thor <author@example.com>", User{Name: "A U Thor ", Email: "<author@example.com>"}, time.Time{}},
+		{"empty", "", User{}, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, got := parseSignature(tt.value)
+			if user != tt.user {
+				t.Errorf("user = %+v, want %+v", user, tt.user)
+			}
+			if !got.Equal(tt.when) {
+				t.Errorf("time = %v, want %v", got, tt.when)
+			}
+		})
+	}
+}
+
+func TestParseCommitData(t *testing.T) {
+	data := "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n" +
+		"parent 1111111111111111111111111111111111111111\n" +
+		"parent 2222222222222222222222222222222222222222\n" +
+		"parent 3333333333333333333333333333333333333333\n" +
+		"author A U Thor <author at example dot com> 1577836800 +0000\n" +
+		"committer C O Mitter <committer@example.com> 1577836860 +0100\n" +
+		"gpgsig -----BEGIN PGP SIGNATURE-----\n \n iQEzBAABCAAdFiEE\n -----END PGP SIGNATURE-----\n" +
+		"\nfeat: merge a, b and c\n"
+	commit := parseCommitData("c0ffee", []byte(data))
+	if commit.Hash != "c0ffee" || commit.Tree != "4b825dc642cb6eb9a060e54bf8d69288fbee4904" {
+		t.Errorf("hash and tree = %s %s", commit.Hash, commit.Tree)
+	}
+	if len(commit.Parents) != 3 || commit.Parents[2] != "3333333333333333333333333333333333333333" {
+		t.Errorf("parents = %v, want the 3 of the octopus merge", commit.Parents)
+	}
+	if commit.Author != (User{Name: "A U Thor ", Email: "<author at example dot com>"}) || !commit.AuthorTime.Equal(time.Unix(1577836800, 0)) {
+		t.Errorf("author = %+v at %v", commit.Author, commit.AuthorTime)
+	}
+	if commit.Committer != (User{Name: "C O Mitter ", Email: "<committer@example.com>"}) || !commit.CommitTime.Equal(time.Unix(1577836860, 0)) {
+		t.Errorf("committer = %+v at %v", commit.Committer, commit.CommitTime)
+	}
+	if commit.Message != "feat: merge a, b and c" || commit.Conventional == nil {
+		t.Errorf("message = %q, conventional = %v", commit.Message, commit.Conventional)
+	}
+}
+
+func TestLoadTestRepo(t *testing.T) {
+	built := testrepo.NewTestRepo().
+		Commit("initial", testrepo.Files{"README.md": "# demo\n"}).
+		Branch("dev").
+		Commit("add main", testrepo.Files{"src/main.go": "package main\n"}).
+		Checkout("main").
+		Commit("docs", testrepo.Files{"README.md": "# demo\n\ndocs\n"}).
+		Merge("dev", "merge dev").
+		AnnotatedTag("v1", "first release")
+	dir := t.TempDir()
+	if err := built.Write(dir); err != nil {
+		t.Fatal(err)
+	}
+	r, err := newRepo(context.Background(), dir, RepoOptions{Workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.trackHistory(false)
+
+	for _, ref := range []string{"refs/heads/main", "refs/heads/dev", "refs/tags/v1"} {
+		if got := r.allRefs()[ref]; got != built.Ref(ref) {
+			t.Errorf("%s = %q, want %q", ref, got, built.Ref(ref))
+		}
+	}
+	merge := r.currCommit()
+	if merge.Hash != built.Head() || merge.Message != "merge dev" {
+		t.Fatalf("HEAD is %s %q, want the merge %s", merge.Hash, merge.Message, built.Head())
+	}
+	if len(merge.Parents) != 2 || merge.Parents[1] != built.Ref("refs/heads/dev") {
+		t.Errorf("merge parents = %v, want main's previous commit and dev", merge.Parents)
+	}
+	if merge.Author != (User{Name: "Test Author ", Email: "<author@example.com>"}) || !merge.AuthorTime.Equal(testrepo.Epoch.Add(3*time.Minute)) {
+		t.Errorf("merge author = %+v at %v", merge.Author, merge.AuthorTime)
+	}
+	// the first commit is a root commit, reached by first parents
+	root := merge
+	for len(root.Parents) > 0 {
+		root = parseCommit(r.getObject(root.Parents[0]))
+	}
+	if root.Message != "initial" {
+		t.Errorf("root commit is %q, want initial", root.Message)
+	}
+	var names []string
+	for _, e := range *parseTree(r.getObject(merge.Tree)) {
+		names = append(names, e.Kind+" "+e.Name)
+	}
+	if want := []string{"file README.md", "directory src"}; !slices.Equal(names, want) {
+		t.Errorf("merge tree = %q, want %q", names, want)
+	}
+	if tag := parseTag(r.getObject(built.Ref("refs/tags/v1"))); tag.Object != merge.Hash || tag.Message != "first release" {
+		t.Errorf("v1 tags %s with %q, want the merge", tag.Object, tag.Message)
+	}
+
+	// a commit on top is picked up by a refresh as main fast-forwarding
+	if err := built.Commit("more", testrepo.Files{"more.txt": "more\n"}).Write(dir); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := r.changed(); err != nil || !changed {
+		t.Fatalf("changed() = %v, %v after a new commit", changed, err)
+	}
+	var types []string
+	events, err := r.refresh(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range events {
+		if e.Ref == "refs/heads/main" {
+			types = append(types, e.Type)
+		}
+	}
+	if !slices.Equal(types, []string{EVENT_REF_FAST_FORWARD}) {
+		t.Errorf("main's events = %v, want a fast-forward", types)
+	}
+	if r.currCommit().Message != "more" {
+		t.Errorf("HEAD is %q after the refresh, want more", r.currCommit().Message)
+	}
+}
+
+func TestNewRepoNotFound(t *testing.T) {
+	empty := t.TempDir()
+	noHead := t.TempDir()
+	if err := testrepo.NewTestRepo().Commit("initial", testrepo.Files{"a": "a\n"}).Write(noHead); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(noHead, ".git", "HEAD")); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{empty, noHead} {
+		_, err := newRepo(context.Background(), dir, RepoOptions{})
+		var notFound *RepoNotFoundError
+		if !errors.As(err, &notFound) || notFound.Path != dir {
+			t.Errorf("newRepo(%s) error = %v, want a RepoNotFoundError", dir, err)
+		}
+	}
+}
+
+func TestStaleObject(t *testing.T) {
+	built := testrepo.NewTestRepo().
+		Commit("initial", testrepo.Files{"README.md": "# demo\n"}).
+		Commit("docs", testrepo.Files{"README.md": "# demo\n\ndocs\n"})
+	dir := t.TempDir()
+	if err := built.Write(dir); err != nil {
+		t.Fatal(err)
+	}
+	// a byte of memory drops every object's content, so it's read again from its file
+	r, err := newRepo(context.Background(), dir, RepoOptions{MaxMemory: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// as if git gc packed the first commit, noticed before the commit is read
+	first := parseCommit(r.getObject(built.Head())).Parents[0]
+	obj := r.getObject(first)
+	if err := os.Remove(obj.Location); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.changed(); err != nil {
+		t.Fatal(err)
+	}
+	if data := obj.Bytes(); len(data) != 0 {
+		t.Errorf("read %q from a removed object", data)
+	}
+	if errs := r.objectErrors(); len(errs) != 1 || errs[0].Name != first {
+		t.Errorf("object errors = %+v, want the removed object", errs)
+	}
+	if changed, err := r.changed(); err != nil || !changed {
+		t.Fatalf("changed() = %v, %v after an object went stale", changed, err)
+	}
+	if _, err := r.refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if errs := r.objectErrors(); len(errs) != 0 {
+		t.Errorf("object errors after a refresh = %+v, want none", errs)
+	}
+	if r.getObject(first) != nil {
+		t.Errorf("removed object %s still loaded", first)
+	}
+}
+
+func TestDetachedHead(t *testing.T) {
+	base := func() *testrepo.Repo {
+		return testrepo.NewTestRepo().
+			Commit("initial", testrepo.Files{"README.md": "# demo\n"}).
+			AnnotatedTag("v1", "first release").
+			Commit("docs", testrepo.Files{"README.md": "# demo\n\ndocs\n"})
+	}
+	tag := base().Ref("refs/tags/v1")
+	tests := []struct {
+		name  string
+		built *testrepo.Repo
+	}{
+		{"commit", base().Detach("v1")},
+		// HEAD names the tag object, which refs and decorate peel to its commit
+		{"annotated tag", base().DetachAt(tag)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := tt.built.Write(dir); err != nil {
+				t.Fatal(err)
+			}
+			r, err := newRepo(context.Background(), dir, RepoOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			commit := parseTag(r.getObject(tag)).Object
+			_, edges := r.refs(nil)
+			if !slices.Contains(edges, Edge{Src: "HEAD", Dest: commit}) {
+				t.Errorf("refs edges = %v, want HEAD -> %s", edges, commit)
+			}
+			sel, err := r.selectObjects(GraphOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, attrs := range sel.attrs {
+				if checkedOut := attrs["checkedOut"] == true; checkedOut != (name == commit) {
+					t.Errorf("%s checkedOut = %v, want only %s checked out", name, checkedOut, commit)
+				}
+			}
+			if sel.attrs[commit]["checkedOut"] != true {
+				t.Errorf("%s isn't marked checked out", commit)
+			}
+		})
+	}
+}
@@ -0,0 +1,1037 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Options that filter the graph written by the exports and served to the browser.
+type GraphOptions struct {
+	// when set only objects reachable from these revisions are included
+	ReachableFrom []string
+	// when > 0 only this many commit generations from the roots are included
+	Depth int
+	// commit filters. Trees and blobs only referenced by filtered out commits are left out too.
+	Since  time.Time
+	Until  time.Time
+	Author *regexp.Regexp
+	// only follow the first parent of merge commits
+	FirstParent bool
+	// when set only commits touching these pathspecs, and the trees and blobs along them, are included
+	Paths []string
+	// trees and blobs the selected commits only have at paths matching these pathspecs are left out
+	ExcludePaths []string
+	// when > 0 blobs larger than this many bytes are left out
+	ExcludeBlobsLargerThan int64
+	// when > 0 only this many of the trees and blobs each commit adds are included
+	Sample int
+	// when set commits are output in this order (topo, date or author-date) ahead of other objects
+	Order string
+	// adds the paths and number of commits sharing each blob to blob nodes
+	Dedup bool
+	// adds the language of blobs to blob nodes and of tree entries to tree nodes
+	Languages bool
+	// adds the MIME type of blobs, by magic bytes and extension, to blob nodes
+	ContentTypes bool
+	// when > 0 a thumbnail of this size is added to the nodes of PNG, JPEG and GIF images
+	Thumbnails int
+	// adds the row and column of each commit in a rail layout to commit nodes
+	Lanes bool
+	// adds the commit that introduced each tree and blob and its directory to their nodes
+	Clusters bool
+	// adds the weakly-connected component of the commit DAG each commit is in to commit nodes
+	Components bool
+	// adds introduces edges from each commit to the blobs it introduced
+	Introduces bool
+	// adds target edges from each symlink to the object at its target path
+	SymlinkTargets bool
+	// when set only objects of these types (commit, tree, blob or tag) are included
+	Types []string
+	// replaces the content of blob nodes with its size, MIME type and SHA-256 digest
+	NoContent bool
+	// applied to blob content and commit and tag messages, nil to leave them as they are
+	Redact Redactor
+	// executed with each commit to add a label to its node
+	LabelTemplate *template.Template
+}
+
+func (opts GraphOptions) filtersCommits() bool {
+	return !opts.Since.IsZero() || !opts.Until.IsZero() || opts.Author != nil
+}
+
+func (opts GraphOptions) keepCommit(commit Commit) bool {
+	if !opts.Since.IsZero() && commit.CommitTime.Before(opts.Since) {
+		return false
+	}
+	if !opts.Until.IsZero() && commit.CommitTime.After(opts.Until) {
+		return false
+	}
+	if opts.Author != nil && !opts.Author.MatchString(fmt.Sprintf("%s %s", commit.Author.Name, commit.Author.Email)) {
+		return false
+	}
+	return true
+}
+
+// The objects and synthetic nodes making up a graph.
+type selection struct {
+	objects []*Object
+	// names of the selected objects, nil when every object is selected
+	names map[string]bool
+	// synthetic nodes, e.g. depth boundaries
+	extra []map[string]any
+	// synthetic edges, e.g. from commits to the blobs they introduced
+	edges []Edge
+	// edges left out even though both ends are selected
+	dropped map[Edge]bool
+	// extra attributes merged into object nodes by name
+	attrs map[string]map[string]any
+	// applied to the author and committer of commit nodes and the tagger of tag nodes
+	mailmap *Mailmap
+	// target paths of the blobs shown as symlink nodes
+	symlinks map[string]string
+	// blob nodes carry a digest instead of their content
+	noContent bool
+	redact    Redactor
+	// computes the label of commit nodes, nil for no labels
+	label *template.Template
+}
+
+// Sets an attribute on the node of the named object. A "type" attribute replaces the node's type.
+func (sel *selection) annotate(name string, key string, value any) {
+	if sel.attrs == nil {
+		sel.attrs = map[string]map[string]any{}
+	}
+	if sel.attrs[name] == nil {
+		sel.attrs[name] = map[string]any{}
+	}
+	sel.attrs[name][key] = value
+}
+
+// Returns the node of a selected object including its attributes.
+func (sel *selection) node(obj *Object) (map[string]any, error) {
+	var node map[string]any
+	var err error
+	if target, ok := sel.symlinks[obj.Name]; ok {
+		node = map[string]any{"name": obj.Name, "type": SYMLINK, "object": Symlink{Target: target}}
+	} else if _, isPointer := obj.lfsPointer(); sel.noContent && obj.Type == "blob" && !isPointer {
+		node = map[string]any{"name": obj.Name, "type": obj.Type, "object": blobDigest(obj)}
+	} else if node, err = obj.node(); err != nil {
+		return nil, err
+	}
+	if object, ok := node["object"].(map[string]json.RawMessage); ok && sel.redact != nil {
+		if err := redactNode(obj, object, sel.redact); err != nil {
+			return nil, fmt.Errorf("redacting %s: %w", obj.Name, err)
+		}
+	}
+	if sel.mailmap != nil && obj.Type == "commit" {
+		commit := sel.mailmap.commit(parseCommit(obj))
+		object := node["object"].(map[string]json.RawMessage)
+		if object["author"], err = json.Marshal(commit.Author); err != nil {
+			return nil, err
+		}
+		if object["committer"], err = json.Marshal(commit.Committer); err != nil {
+			return nil, err
+		}
+	}
+	if sel.mailmap != nil && obj.Type == "tag" {
+		object := node["object"].(map[string]json.RawMessage)
+		if object["tagger"], err = json.Marshal(sel.mailmap.resolve(parseTag(obj).Tagger)); err != nil {
+			return nil, err
+		}
+	}
+	if sel.label != nil && obj.Type == "commit" {
+		if node["label"], err = sel.commitLabel(parseCommit(obj)); err != nil {
+			return nil, fmt.Errorf("labeling %s: %w", obj.Name, err)
+		}
+	}
+	maps.Copy(node, sel.attrs[obj.Name])
+	return node, nil
+}
+
+// Reports whether an edge between selected objects is part of the graph.
+func (sel *selection) hasEdge(e Edge) bool {
+	return sel.has(e.Dest) && !sel.dropped[e]
+}
+
+func (sel *selection) has(name string) bool {
+	if sel.names == nil || sel.names[name] {
+		return true
+	}
+	for _, node := range sel.extra {
+		if node["name"] == name {
+			return true
+		}
+	}
+	return false
+}
+
+var relativeDateRegex = regexp.MustCompile(`^(\d+)\s*(second|minute|hour|day|week|month|year)s?(\s+ago)?$`)
+
+// Parses an absolute (2006-01-02, RFC 3339) or relative ("2 weeks ago") date.
+func parseDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	match := relativeDateRegex.FindStringSubmatch(value)
+	if match == nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+	n, _ := strconv.Atoi(match[1])
+	now := time.Now()
+	switch match[2] {
+	case "second":
+		return now.Add(-time.Duration(n) * time.Second), nil
+	case "minute":
+		return now.Add(-time.Duration(n) * time.Minute), nil
+	case "hour":
+		return now.Add(-time.Duration(n) * time.Hour), nil
+	case "day":
+		return now.AddDate(0, 0, -n), nil
+	case "week":
+		return now.AddDate(0, 0, -7*n), nil
+	case "month":
+		return now.AddDate(0, -n, 0), nil
+	default:
+		return now.AddDate(-n, 0, 0), nil
+	}
+}
+
+func graphFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "reachable-from",
+			Usage: "Only include objects reachable from this ref or object name. Can be passed multiple times.",
+		},
+		&cli.IntFlag{
+			Name:  "depth",
+			Usage: "Only include N commit generations from HEAD (or the --reachable-from roots). 0 means no limit.",
+		},
+		&cli.StringFlag{
+			Name:  "since",
+			Usage: "Only include commits made after this date (e.g. 2024-01-31 or \"1 week ago\").",
+		},
+		&cli.StringFlag{
+			Name:  "until",
+			Usage: "Only include commits made before this date.",
+		},
+		&cli.StringFlag{
+			Name:  "author",
+			Usage: "Only include commits whose author matches this regular expression.",
+		},
+		&cli.BoolFlag{
+			Name:  "first-parent",
+			Usage: "Only follow the first parent of merge commits, collapsing merged side branches.",
+		},
+		&cli.StringSliceFlag{
+			Name:  "path",
+			Usage: "Only include commits touching paths matching this pathspec (e.g. 'src/**') and the trees and blobs along them. Can be passed multiple times.",
+		},
+		&cli.StringSliceFlag{
+			Name:  "exclude-path",
+			Usage: "Leave out the trees and blobs found only at paths matching this pathspec (e.g. 'vendor/**'), so vendored dependencies don't dominate the graph. Commits are kept. Can be passed multiple times.",
+		},
+		&cli.StringFlag{
+			Name:  "exclude-blob-larger-than",
+			Usage: "Leave out blobs larger than this size, e.g. 1MB or 512K, such as binaries.",
+		},
+		&cli.IntFlag{
+			Name:  "sample",
+			Usage: "Keep every commit and its root tree but only the first N trees and blobs each commit adds, breadth first, so huge repos stay renderable. Left out objects are counted in omitted attributes of tree and commit nodes. 0 means no sampling.",
+		},
+		&cli.StringFlag{
+			Name:  "order",
+			Usage: "Output commits in topo, date or author-date order ahead of the other objects.",
+		},
+		&cli.BoolFlag{
+			Name:  "dedup",
+			Usage: "Add the paths and number of commits sharing each blob to blob nodes.",
+		},
+		&cli.BoolFlag{
+			Name:  "languages",
+			Usage: "Add the language of each blob, by file extension or shebang, to blob nodes and to the entries of tree nodes.",
+		},
+		&cli.BoolFlag{
+			Name:  "content-types",
+			Usage: "Add the MIME type of each blob, by magic bytes and then file extension, to blob nodes as contentType.",
+		},
+		&cli.IntFlag{
+			Name:  "thumbnails",
+			Usage: "Add a PNG data URL thumbnail at most N pixels wide and high to the nodes of PNG, JPEG and GIF images, so they can be previewed. Implies --content-types. 0 means no thumbnails.",
+		},
+		&cli.BoolFlag{
+			Name:  "lanes",
+			Usage: "Add a lane, the row and column of the commit in a rail layout like git log --graph's, to commit nodes. Rows follow --order, by default date order.",
+		},
+		&cli.BoolFlag{
+			Name:  "clusters",
+			Usage: "Add a cluster, the commit that introduced the object and the directory it was introduced in, to tree and blob nodes, so the objects a commit introduced can be collapsed into one group.",
+		},
+		&cli.BoolFlag{
+			Name:  "components",
+			Usage: "Add a component, the id of the disconnected history the commit is in (0 for HEAD's), to commit nodes, with orphanRoot: true on the first commits of histories started apart from HEAD's, e.g. a gh-pages branch.",
+		},
+		&cli.BoolFlag{
+			Name:  "introduces",
+			Usage: "Add edges of kind introduces from each commit to the blobs it introduced, the content that's new compared to every parent, rather than its whole tree.",
+		},
+		&cli.BoolFlag{
+			Name:  "symlink-targets",
+			Usage: "Add edges of kind target from each symlink to the blob or tree at its target path in each selected commit, when the target is inside the repo.",
+		},
+		&cli.StringSliceFlag{
+			Name:  "types",
+			Usage: "Only include objects of these types, e.g. commit,tree. Edges to left out objects are dropped.",
+		},
+		&cli.BoolFlag{
+			Name:  "no-content",
+			Usage: "Replace the content of blob nodes with their size, MIME type and SHA-256 digest, so the graph can be shared without the source code.",
+		},
+		&cli.GenericFlag{
+			Name:  "redact",
+			Value: &patternList{},
+			Usage: "Replace matches of this regular expression in blob content and commit and tag messages with [REDACTED]. Can be passed multiple times.",
+		},
+		&cli.BoolFlag{
+			Name:  "redact-secrets",
+			Usage: "Redact what scan-secrets would report (API keys, tokens, private keys) from blob content and commit and tag messages.",
+		},
+		&cli.StringFlag{
+			Name:  "label-template",
+			Usage: "Add a label to commit nodes from this Go text/template executed with the commit, e.g. '{{short .Hash}} {{.Author.Name}}: {{truncate (subject .Message) 40}}'. Besides text/template's functions it can call truncate, subject and short.",
+		},
+		&cli.BoolFlag{
+			Name:  "exclude-blobs",
+			Usage: "Leave out blobs, keeping commits, trees and tags. Shorthand for --types commit,tree,tag.",
+		},
+	}
+}
+
+// Reads the graph flags of a command. Bad values are returned as usage errors.
+func graphOptions(cCtx *cli.Context) (GraphOptions, error) {
+	opts, err := graphOptionsFromFlags(cCtx)
+	if err != nil {
+		return opts, &UsageError{err}
+	}
+	return opts, nil
+}
+
+func graphOptionsFromFlags(cCtx *cli.Context) (GraphOptions, error) {
+	opts := GraphOptions{
+		ReachableFrom:  cCtx.StringSlice("reachable-from"),
+		Depth:          cCtx.Int("depth"),
+		FirstParent:    cCtx.Bool("first-parent"),
+		Paths:          cCtx.StringSlice("path"),
+		ExcludePaths:   cCtx.StringSlice("exclude-path"),
+		Sample:         cCtx.Int("sample"),
+		Order:          cCtx.String("order"),
+		Dedup:          cCtx.Bool("dedup"),
+		Languages:      cCtx.Bool("languages"),
+		ContentTypes:   cCtx.Bool("content-types"),
+		Thumbnails:     cCtx.Int("thumbnails"),
+		Lanes:          cCtx.Bool("lanes"),
+		Clusters:       cCtx.Bool("clusters"),
+		Components:     cCtx.Bool("components"),
+		Introduces:     cCtx.Bool("introduces"),
+		SymlinkTargets: cCtx.Bool("symlink-targets"),
+		NoContent:      cCtx.Bool("no-content"),
+	}
+	if opts.Sample < 0 {
+		return opts, fmt.Errorf("invalid --sample %d", opts.Sample)
+	}
+	if size := cCtx.String("exclude-blob-larger-than"); size != "" {
+		var err error
+		if opts.ExcludeBlobsLargerThan, err = parseSize(size); err != nil {
+			return opts, fmt.Errorf("invalid --exclude-blob-larger-than: %w", err)
+		}
+	}
+	if opts.Thumbnails < 0 {
+		return opts, fmt.Errorf("invalid --thumbnails %d", opts.Thumbnails)
+	}
+	if opts.Thumbnails > 0 && opts.NoContent {
+		return opts, errors.New("--thumbnails would show blob content, it can't be combined with --no-content")
+	}
+	if text := cCtx.String("label-template"); text != "" {
+		var err error
+		if opts.LabelTemplate, err = parseLabelTemplate(text); err != nil {
+			return opts, fmt.Errorf("invalid --label-template: %w", err)
+		}
+	}
+	if err := opts.setTypes(cCtx.StringSlice("types"), cCtx.Bool("exclude-blobs")); err != nil {
+		return opts, err
+	}
+	var patterns []string
+	if p, ok := cCtx.Generic("redact").(*patternList); ok {
+		patterns = *p
+	}
+	var err error
+	if opts.Redact, err = newRedactor(patterns, cCtx.Bool("redact-secrets")); err != nil {
+		return opts, err
+	}
+	return opts, opts.setFilters(cCtx.String("since"), cCtx.String("until"), cCtx.String("author"))
+}
+
+var objectTypes = []string{"commit", "tree", "blob", "tag"}
+
+// Sets the object types to include. Types can be comma separated.
+func (opts *GraphOptions) setTypes(types []string, excludeBlobs bool) error {
+	var selected []string
+	for _, t := range types {
+		for _, type_ := range strings.Split(t, ",") {
+			type_ = strings.TrimSpace(type_)
+			if !slices.Contains(objectTypes, type_) {
+				return fmt.Errorf("unknown object type %q, expected commit, tree, blob or tag", type_)
+			}
+			selected = append(selected, type_)
+		}
+	}
+	if excludeBlobs {
+		if selected == nil {
+			selected = objectTypes
+		}
+		selected = slices.DeleteFunc(slices.Clone(selected), func(type_ string) bool { return type_ == "blob" })
+	}
+	opts.Types = selected
+	return nil
+}
+
+func (opts *GraphOptions) setFilters(since, until, author string) error {
+	if !slices.Contains([]string{"", ORDER_TOPO, ORDER_DATE, ORDER_AUTHOR_DATE}, opts.Order) {
+		return fmt.Errorf("unknown order %q, expected topo, date or author-date", opts.Order)
+	}
+	var err error
+	if since != "" {
+		if opts.Since, err = parseDate(since); err != nil {
+			return err
+		}
+	}
+	if until != "" {
+		if opts.Until, err = parseDate(until); err != nil {
+			return err
+		}
+	}
+	if author != "" {
+		if opts.Author, err = regexp.Compile(author); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parses graph options from URL query parameters, falling back to base for missing ones.
+func graphOptionsFromQuery(query url.Values, base GraphOptions) (GraphOptions, error) {
+	opts := base
+	if revs, ok := query["reachable-from"]; ok {
+		opts.ReachableFrom = revs
+	}
+	if paths, ok := query["path"]; ok {
+		opts.Paths = paths
+	}
+	if paths, ok := query["exclude-path"]; ok {
+		opts.ExcludePaths = paths
+	}
+	if size := query.Get("exclude-blob-larger-than"); size != "" {
+		n, err := parseSize(size)
+		if err != nil {
+			return opts, fmt.Errorf("invalid exclude-blob-larger-than %q", size)
+		}
+		opts.ExcludeBlobsLargerThan = n
+	}
+	if firstParent := query.Get("first-parent"); firstParent != "" {
+		fp, err := strconv.ParseBool(firstParent)
+		if err != nil {
+			return opts, fmt.Errorf("invalid first-parent %q", firstParent)
+		}
+		opts.FirstParent = fp
+	}
+	if order := query.Get("order"); order != "" {
+		opts.Order = order
+	}
+	if dedup := query.Get("dedup"); dedup != "" {
+		d, err := strconv.ParseBool(dedup)
+		if err != nil {
+			return opts, fmt.Errorf("invalid dedup %q", dedup)
+		}
+		opts.Dedup = d
+	}
+	if languages := query.Get("languages"); languages != "" {
+		l, err := strconv.ParseBool(languages)
+		if err != nil {
+			return opts, fmt.Errorf("invalid languages %q", languages)
+		}
+		opts.Languages = l
+	}
+	if contentTypes := query.Get("content-types"); contentTypes != "" {
+		c, err := strconv.ParseBool(contentTypes)
+		if err != nil {
+			return opts, fmt.Errorf("invalid content-types %q", contentTypes)
+		}
+		opts.ContentTypes = c
+	}
+	if thumbnails := query.Get("thumbnails"); thumbnails != "" {
+		t, err := strconv.Atoi(thumbnails)
+		if err != nil || t < 0 {
+			return opts, fmt.Errorf("invalid thumbnails %q", thumbnails)
+		}
+		opts.Thumbnails = t
+	}
+	if lanes := query.Get("lanes"); lanes != "" {
+		l, err := strconv.ParseBool(lanes)
+		if err != nil {
+			return opts, fmt.Errorf("invalid lanes %q", lanes)
+		}
+		opts.Lanes = l
+	}
+	if clusters := query.Get("clusters"); clusters != "" {
+		c, err := strconv.ParseBool(clusters)
+		if err != nil {
+			return opts, fmt.Errorf("invalid clusters %q", clusters)
+		}
+		opts.Clusters = c
+	}
+	if components := query.Get("components"); components != "" {
+		c, err := strconv.ParseBool(components)
+		if err != nil {
+			return opts, fmt.Errorf("invalid components %q", components)
+		}
+		opts.Components = c
+	}
+	if introduces := query.Get("introduces"); introduces != "" {
+		i, err := strconv.ParseBool(introduces)
+		if err != nil {
+			return opts, fmt.Errorf("invalid introduces %q", introduces)
+		}
+		opts.Introduces = i
+	}
+	if symlinkTargets := query.Get("symlink-targets"); symlinkTargets != "" {
+		s, err := strconv.ParseBool(symlinkTargets)
+		if err != nil {
+			return opts, fmt.Errorf("invalid symlink-targets %q", symlinkTargets)
+		}
+		opts.SymlinkTargets = s
+	}
+	if noContent := query.Get("no-content"); noContent != "" {
+		n, err := strconv.ParseBool(noContent)
+		if err != nil {
+			return opts, fmt.Errorf("invalid no-content %q", noContent)
+		}
+		opts.NoContent = n
+	}
+	excludeBlobs := false
+	if e := query.Get("exclude-blobs"); e != "" {
+		var err error
+		if excludeBlobs, err = strconv.ParseBool(e); err != nil {
+			return opts, fmt.Errorf("invalid exclude-blobs %q", e)
+		}
+	}
+	types, ok := query["types"]
+	if !ok {
+		types = opts.Types
+	}
+	if ok || excludeBlobs {
+		if err := opts.setTypes(types, excludeBlobs); err != nil {
+			return opts, err
+		}
+	}
+	if depth := query.Get("depth"); depth != "" {
+		d, err := strconv.Atoi(depth)
+		if err != nil || d < 0 {
+			return opts, fmt.Errorf("invalid depth %q", depth)
+		}
+		opts.Depth = d
+	}
+	if text := query.Get("label-template"); text != "" {
+		t, err := parseLabelTemplate(text)
+		if err != nil {
+			return opts, fmt.Errorf("invalid label-template: %w", err)
+		}
+		opts.LabelTemplate = t
+	}
+	if sample := query.Get("sample"); sample != "" {
+		n, err := strconv.Atoi(sample)
+		if err != nil || n < 0 {
+			return opts, fmt.Errorf("invalid sample %q", sample)
+		}
+		opts.Sample = n
+	}
+	if err := opts.setFilters(query.Get("since"), query.Get("until"), query.Get("author")); err != nil {
+		return opts, err
+	}
+	return demo.limit(opts), nil
+}
+
+// Walks the DAG from the given object names, returning the names of every object reached.
+func (r *Repo) reachable(roots []string) map[string]bool {
+	seen := map[string]bool{}
+	r.markReachable(roots, seen)
+	return seen
+}
+
+// Adds the names of the objects reachable from roots to seen, not walking past objects
+// already in it, so walks sharing one set visit each object once.
+func (r *Repo) markReachable(roots []string, seen map[string]bool) {
+	stack := append([]string{}, roots...)
+	for len(stack) > 0 {
+		name := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[name] {
+			continue
+		}
+		obj := r.getObject(name)
+		if obj == nil {
+			continue
+		}
+		seen[name] = true
+		for _, e := range obj.edges() {
+			stack = append(stack, e.Dest)
+		}
+	}
+}
+
+// Walks at most depth commit generations from roots (all of them when depth is 0),
+// returning the names of every object reached and the commits just past the limit. With
+// firstParent only the first parent of merge commits is followed.
+func (r *Repo) walkCommits(roots []string, depth int, firstParent bool) (map[string]bool, []string) {
+	seen := map[string]bool{}
+	frontier := roots
+	for gen := 0; (depth <= 0 || gen < depth) && len(frontier) > 0; gen++ {
+		var next []string
+		// indexed since peeled tags are appended to the frontier
+		for i := 0; i < len(frontier); i++ {
+			name := frontier[i]
+			if seen[name] {
+				continue
+			}
+			obj := r.getObject(name)
+			if obj == nil {
+				continue
+			}
+			if obj.Type == "tag" {
+				// peel tags within the same generation
+				seen[name] = true
+				frontier = append(frontier, parseTag(obj).Object)
+				continue
+			}
+			if obj.Type != "commit" {
+				r.markReachable([]string{name}, seen)
+				continue
+			}
+			seen[name] = true
+			commit := parseCommit(obj)
+			// trees and blobs shared with commits already walked aren't walked again
+			r.markReachable([]string{commit.Tree}, seen)
+			if firstParent && len(commit.Parents) > 1 {
+				next = append(next, commit.Parents[0])
+			} else {
+				next = append(next, commit.Parents...)
+			}
+		}
+		frontier = next
+	}
+	var boundary []string
+	for _, name := range frontier {
+		if !seen[name] && !slices.Contains(boundary, name) {
+			boundary = append(boundary, name)
+		}
+	}
+	return seen, boundary
+}
+
+// Returns the objects and synthetic nodes included in the graph for opts.
+func (r *Repo) selectObjects(opts GraphOptions) (*selection, error) {
+	sel, err := r.selectRoots(opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.filtersCommits() {
+		sel = r.filterCommits(sel, opts)
+	}
+	if len(opts.Paths) > 0 {
+		sel = r.filterPaths(sel, opts.Paths)
+	}
+	if len(opts.ExcludePaths) > 0 || opts.ExcludeBlobsLargerThan > 0 {
+		sel = r.excludeObjects(sel, opts.ExcludePaths, opts.ExcludeBlobsLargerThan)
+	}
+	if opts.Sample > 0 {
+		sel = r.sample(sel, opts.Sample)
+	}
+	if opts.Order != "" {
+		if err := r.orderObjects(sel, opts.Order); err != nil {
+			return nil, err
+		}
+	}
+	r.markUnreachable(sel)
+	markMalformedTrees(sel)
+	r.markSkewedCommits(sel)
+	r.decorate(sel)
+	sel.symlinks = r.symlinkTargets(sel)
+	sel.markSymlinks()
+	sel.mailmap = r.mailmap
+	sel.noContent = opts.NoContent
+	sel.redact = opts.Redact
+	sel.label = opts.LabelTemplate
+	sel.markOctopusMerges()
+	if opts.Dedup {
+		for hash, u := range r.blobUsage(sel) {
+			if sel.has(hash) {
+				sel.annotate(hash, "dedup", map[string]any{"paths": u.Paths, "commits": u.Commits})
+			}
+		}
+	}
+	if opts.Languages {
+		r.annotateLanguages(sel)
+	}
+	if opts.ContentTypes || opts.Thumbnails > 0 {
+		r.annotateContentTypes(sel, opts.Thumbnails)
+	}
+	if opts.Lanes {
+		lanes, err := r.lanes(sel, opts.Order)
+		if err != nil {
+			return nil, err
+		}
+		for hash, lane := range lanes {
+			sel.annotate(hash, "lane", lane)
+		}
+	}
+	if opts.Clusters {
+		clusters, err := r.clusters(sel)
+		if err != nil {
+			return nil, err
+		}
+		for hash, cluster := range clusters {
+			if sel.has(hash) {
+				sel.annotate(hash, "cluster", cluster)
+			}
+		}
+	}
+	if opts.Components {
+		_, labels := r.commitComponents(sel)
+		for hash, label := range labels {
+			sel.annotate(hash, "component", label)
+		}
+	}
+	if opts.Types != nil {
+		sel = filterTypes(sel, opts.Types)
+	}
+	// after filtering types so edges to left out objects aren't added
+	if opts.Introduces {
+		sel.edges = append(sel.edges, r.introducesEdges(sel)...)
+	}
+	if opts.SymlinkTargets {
+		sel.edges = append(sel.edges, r.symlinkTargetEdges(sel)...)
+	}
+	return sel, nil
+}
+
+// Leaves out the objects whose type isn't one of types. Filtering by type happens last so
+// the other filters and attributes still see every object.
+func filterTypes(sel *selection, types []string) *selection {
+	filtered := *sel
+	filtered.names, filtered.objects = map[string]bool{}, nil
+	for _, obj := range sel.objects {
+		if slices.Contains(types, obj.Type) {
+			filtered.names[obj.Name] = true
+			filtered.objects = append(filtered.objects, obj)
+		}
+	}
+	return &filtered
+}
+
+// Adds the refs pointing at or containing each selected commit to its node, and marks the
+// commit HEAD resolves to, attached or detached, with `checkedOut: true`.
+func (r *Repo) decorate(sel *selection) {
+	decorations := r.decorations()
+	for _, obj := range sel.objects {
+		if d, ok := decorations[obj.Name]; ok {
+			sel.annotate(obj.Name, "decorations", d)
+		}
+	}
+	if _, hash, err := r.ResolveSymref("HEAD"); err == nil && hash != "" {
+		if commit := r.peel(hash); sel.has(commit) {
+			sel.annotate(commit, "checkedOut", true)
+		}
+	}
+}
+
+// Marks the selected objects that no ref reaches (e.g. commits orphaned by a rebase,
+// amend or deleted branch) with `reachable: false` and an unreachable-<type> node type.
+func (r *Repo) markUnreachable(sel *selection) {
+	reachable := r.reachableFromRefs()
+	for _, obj := range sel.objects {
+		if !reachable[obj.Name] {
+			sel.annotate(obj.Name, "reachable", false)
+			sel.annotate(obj.Name, "type", "unreachable-"+obj.Type)
+		}
+	}
+}
+
+// Sorts the selected commits by order followed by the other objects by name.
+func (r *Repo) orderObjects(sel *selection, order string) error {
+	var commits []Commit
+	var others []*Object
+	for _, obj := range sel.objects {
+		if obj.Type == "commit" {
+			commits = append(commits, parseCommit(obj))
+		} else {
+			others = append(others, obj)
+		}
+	}
+	if err := r.sortCommits(commits, order); err != nil {
+		return err
+	}
+	slices.SortFunc(others, func(a, b *Object) int { return strings.Compare(a.Name, b.Name) })
+	sel.objects = sel.objects[:0]
+	for _, c := range commits {
+		sel.objects = append(sel.objects, r.objects[c.Hash])
+	}
+	sel.objects = append(sel.objects, others...)
+	return nil
+}
+
+// Reports whether p matches a pathspec pattern. `**` matches any number of directories
+// and a pattern also matches everything under the paths it matches, like git pathspecs.
+func matchPathspec(pattern string, p string) bool {
+	patternSegments := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathSegments := strings.Split(p, "/")
+	for i := 1; i <= len(pathSegments); i++ {
+		if matchSegments(patternSegments, pathSegments[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSegments(pattern []string, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if ok, err := path.Match(pattern[0], segments[0]); err != nil || !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], segments[1:])
+}
+
+// Keeps the commits of sel that change a path matching patterns in comparison to every
+// parent, along with the trees and blobs on the matching paths.
+func (r *Repo) filterPaths(sel *selection, patterns []string) *selection {
+	match := func(p string) bool {
+		return slices.ContainsFunc(patterns, func(pattern string) bool { return matchPathspec(pattern, p) })
+	}
+	names := map[string]bool{}
+	// memoizes whether a tree at a path contains a match
+	marked := map[string]bool{}
+	for _, obj := range sel.objects {
+		if obj.Type != "commit" {
+			continue
+		}
+		commit := parseCommit(obj)
+		touches := true
+		for _, changes := range r.commitChanges(commit) {
+			if !slices.ContainsFunc(changes, func(c Change) bool { return match(c.Path) }) {
+				touches = false
+				break
+			}
+		}
+		if touches {
+			names[obj.Name] = true
+			r.markPaths("", commit.Tree, match, names, marked)
+		}
+	}
+	filtered := *sel
+	filtered.names, filtered.objects = map[string]bool{}, nil
+	for name := range names {
+		if obj := r.getObject(name); obj != nil && sel.has(name) {
+			filtered.names[name] = true
+			filtered.objects = append(filtered.objects, obj)
+		}
+	}
+	return &filtered
+}
+
+// Leaves out the trees and blobs the selected commits only have at paths matching patterns,
+// and the blobs larger than maxBlob bytes when it's above 0. Objects no selected commit has
+// are only left out by size.
+func (r *Repo) excludeObjects(sel *selection, patterns []string, maxBlob int64) *selection {
+	excluded := map[string]bool{}
+	if len(patterns) > 0 {
+		match := func(p string) bool {
+			return slices.ContainsFunc(patterns, func(pattern string) bool { return matchPathspec(pattern, p) })
+		}
+		var trees []string
+		for _, obj := range sel.objects {
+			if obj.Type == "commit" {
+				trees = append(trees, parseCommit(obj).Tree)
+			}
+		}
+		// the objects found at a path that doesn't match, walking each tree once per path
+		kept := map[string]bool{}
+		visited := map[string]bool{}
+		var walk func(prefix string, tree string)
+		walk = func(prefix string, tree string) {
+			key := prefix + "\x00" + tree
+			if visited[key] {
+				return
+			}
+			visited[key] = true
+			kept[tree] = true
+			for _, entry := range r.treeEntries(tree) {
+				p := path.Join(prefix, entry.Name)
+				if match(p) {
+					continue
+				}
+				kept[entry.Hash] = true
+				if isTreeMode(entry.Mode) {
+					walk(p, entry.Hash)
+				}
+			}
+		}
+		for _, tree := range trees {
+			walk("", tree)
+		}
+		for name := range r.reachable(trees) {
+			if !kept[name] {
+				excluded[name] = true
+			}
+		}
+	}
+	filtered := *sel
+	filtered.names, filtered.objects = map[string]bool{}, nil
+	for _, obj := range sel.objects {
+		if excluded[obj.Name] || (maxBlob > 0 && obj.Type == "blob" && int64(blobSize(obj)) > maxBlob) {
+			continue
+		}
+		filtered.names[obj.Name] = true
+		filtered.objects = append(filtered.objects, obj)
+	}
+	return &filtered
+}
+
+// Adds the trees and blobs under tree lying along paths that match to names. Returns
+// whether anything under tree matched.
+func (r *Repo) markPaths(prefix string, tree string, match func(string) bool, names map[string]bool, marked map[string]bool) bool {
+	key := prefix + "\x00" + tree
+	if found, ok := marked[key]; ok {
+		return found
+	}
+	found := false
+	for _, entry := range r.treeEntries(tree) {
+		p := path.Join(prefix, entry.Name)
+		if match(p) {
+			for n := range r.reachable([]string{entry.Hash}) {
+				names[n] = true
+			}
+			found = true
+		} else if isTreeMode(entry.Mode) && r.markPaths(p, entry.Hash, match, names, marked) {
+			found = true
+		}
+	}
+	if found {
+		names[tree] = true
+	}
+	marked[key] = found
+	return found
+}
+
+// Keeps the commits of sel matching the commit filters of opts, along with the trees and
+// blobs they reference. Objects no commit references are kept as they are.
+func (r *Repo) filterCommits(sel *selection, opts GraphOptions) *selection {
+	var kept, all []string
+	for _, obj := range sel.objects {
+		if obj.Type != "commit" {
+			continue
+		}
+		all = append(all, obj.Name)
+		if opts.keepCommit(r.mailmap.commit(parseCommit(obj))) {
+			kept = append(kept, obj.Name)
+		}
+	}
+	// only walk from the commits themselves so excluded parents aren't pulled back in
+	walk := func(commits []string) map[string]bool {
+		names := map[string]bool{}
+		for _, name := range commits {
+			names[name] = true
+			for n := range r.reachable([]string{parseCommit(r.objects[name]).Tree}) {
+				names[n] = true
+			}
+		}
+		return names
+	}
+	keptNames, allNames := walk(kept), walk(all)
+	filtered := *sel
+	filtered.names, filtered.objects = map[string]bool{}, nil
+	for _, obj := range sel.objects {
+		if keptNames[obj.Name] || (!allNames[obj.Name] && obj.Type != "commit") {
+			filtered.names[obj.Name] = true
+			filtered.objects = append(filtered.objects, obj)
+		}
+	}
+	return &filtered
+}
+
+// Selects the objects reachable from the roots of opts, limited to opts.Depth generations.
+func (r *Repo) selectRoots(opts GraphOptions) (*selection, error) {
+	if len(opts.ReachableFrom) == 0 && opts.Depth == 0 && !opts.FirstParent {
+		return &selection{objects: r.objectList()}, nil
+	}
+	revs := opts.ReachableFrom
+	if len(revs) == 0 {
+		revs = []string{"HEAD"}
+	}
+	var roots []string
+	for _, rev := range revs {
+		hash, err := r.resolveRev(rev)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, hash)
+	}
+	sel := &selection{}
+	var boundary []string
+	sel.names, boundary = r.walkCommits(roots, opts.Depth, opts.FirstParent)
+	for _, name := range boundary {
+		sel.extra = append(sel.extra, map[string]any{
+			"name":   name,
+			"type":   "more",
+			"object": map[string]string{"label": "…more"},
+		})
+	}
+	for name := range sel.names {
+		obj := r.objects[name]
+		sel.objects = append(sel.objects, obj)
+		if opts.FirstParent && obj.Type == "commit" {
+			commit := parseCommit(obj)
+			for _, p := range commit.Parents[min(1, len(commit.Parents)):] {
+				if sel.dropped == nil {
+					sel.dropped = map[Edge]bool{}
+				}
+				sel.dropped[Edge{Src: name, Dest: p}] = true
+			}
+		}
+	}
+	return sel, nil
+}
@@ -0,0 +1,65 @@
+package graphjson
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The messages the server's /ws websocket sends besides graphs, told apart from them by
+// their type.
+
+// Something that happened to the repo's history between two refreshes: graph-diff,
+// ref-created, ref-deleted, ref-fast-forward, ref-rewritten, commits-unreachable or checkout.
+type RepoEvent struct {
+	Type    string    `json:"type"`
+	Time    time.Time `json:"time"`
+	Ref     string    `json:"ref,omitempty"`
+	Old     string    `json:"old,omitempty"`
+	New     string    `json:"new,omitempty"`
+	Commits []string  `json:"commits,omitempty"`
+	// set on checkout events that leave HEAD detached
+	Detached bool `json:"detached,omitempty"`
+	// the objects and refs that changed, set on graph-diff events
+	Diff    *SnapshotDiff `json:"diff,omitempty"`
+	Message string        `json:"message"`
+}
+
+// Formats an event as a single line.
+func (e RepoEvent) String() string {
+	return fmt.Sprintf("%s %s: %s", e.Time.Local().Format(time.TimeOnly), e.Type, strings.TrimSpace(e.Message))
+}
+
+type SnapshotObject struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// A ref that was created, deleted or moved. Old is empty for created refs and New for deleted ones.
+type RefChange struct {
+	Ref string `json:"ref"`
+	Old string `json:"old,omitempty"`
+	New string `json:"new,omitempty"`
+}
+
+type SnapshotDiff struct {
+	From    string           `json:"from"`
+	To      string           `json:"to"`
+	Added   []SnapshotObject `json:"added"`
+	Removed []SnapshotObject `json:"removed"`
+	Refs    []RefChange      `json:"refs"`
+}
+
+// The progress of a job, as shown by the CLI and sent to websocket clients in messages of
+// type progress.
+type Job struct {
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	Repo      string    `json:"repo"`
+	Done      int64     `json:"done"`
+	Total     int64     `json:"total"`
+	Percent   int       `json:"percent"`
+	Finished  bool      `json:"finished"`
+	Error     string    `json:"error,omitempty"`
+	StartedAt time.Time `json:"startedAt"`
+}
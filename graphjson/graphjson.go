@@ -0,0 +1,313 @@
+// Package graphjson has the types of the graph JSON written by dagit export, to-json and the
+// server's /api/graph, so Go programs can read dagit's output into structs:
+//
+//	f, err := os.Open("graph.json")
+//	g, err := graphjson.Decode(f)
+//	for _, node := range g.Nodes {
+//		if commit, err := node.Commit(); err == nil {
+//			fmt.Println(commit.Hash, commit.Author.Name)
+//		}
+//	}
+//
+// The format is described by the JSON Schema dagit schema prints.
+package graphjson
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// The major version of the graph format this package reads.
+const MajorVersion = "1"
+
+type Graph struct {
+	// the version of the graph format, major.minor
+	SchemaVersion string  `json:"schemaVersion,omitempty"`
+	Edges         []Edge  `json:"edges"`
+	Nodes         []*Node `json:"nodes"`
+	// loose objects skipped because they couldn't be read
+	ParseErrors []ParseError `json:"parseErrors,omitempty"`
+}
+
+// Reads a graph, failing on graphs of another major version.
+func Decode(r io.Reader) (*Graph, error) {
+	var g struct {
+		Graph
+		// parseErrors before schema 1.7
+		LegacyParseErrors []ParseError `json:"parse_errors"`
+	}
+	if err := json.NewDecoder(r).Decode(&g); err != nil {
+		return nil, err
+	}
+	if g.ParseErrors == nil {
+		g.ParseErrors = g.LegacyParseErrors
+	}
+	if g.Nodes == nil {
+		return nil, fmt.Errorf("not a dagit graph, no nodes")
+	}
+	if major, _, _ := strings.Cut(g.SchemaVersion, "."); g.SchemaVersion != "" && major != MajorVersion {
+		return nil, fmt.Errorf("graph schema version %s isn't supported, expected %s.x", g.SchemaVersion, MajorVersion)
+	}
+	return &g.Graph, nil
+}
+
+type Edge struct {
+	Src  string `json:"src"`
+	Dest string `json:"dest"`
+	// set on edges that aren't references between objects, e.g. introduces edges
+	Kind string `json:"kind,omitempty"`
+	// set on edges from trees to their entries
+	Mode      string `json:"mode,omitempty"`
+	EntryKind string `json:"entryKind,omitempty"`
+}
+
+// A Git object, ref or placeholder. Its object is decoded by the method of its type, e.g.
+// Commit for commit nodes.
+type Node struct {
+	Name string
+	// commit, octopus-merge, tree, blob, tag, lfs-pointer, symlink, ref, more and others. Objects no ref
+	// reaches are prefixed with unreachable-.
+	Type   string
+	Object json.RawMessage
+	// computed by --label-template
+	Label string
+	// the other properties, added by graph options, e.g. reachable, decorations or dedup
+	Attributes map[string]json.RawMessage
+}
+
+func (n *Node) UnmarshalJSON(data []byte) error {
+	var props map[string]json.RawMessage
+	if err := json.Unmarshal(data, &props); err != nil {
+		return err
+	}
+	*n = Node{Object: props["object"]}
+	for key, target := range map[string]*string{"name": &n.Name, "type": &n.Type, "label": &n.Label} {
+		if value, ok := props[key]; ok {
+			if err := json.Unmarshal(value, target); err != nil {
+				return fmt.Errorf("node %s: %w", key, err)
+			}
+		}
+	}
+	for _, key := range []string{"name", "type", "object", "label"} {
+		delete(props, key)
+	}
+	if len(props) > 0 {
+		n.Attributes = props
+	}
+	return nil
+}
+
+func (n *Node) MarshalJSON() ([]byte, error) {
+	props := map[string]any{"name": n.Name, "type": n.Type}
+	for key, value := range n.Attributes {
+		props[key] = value
+	}
+	if n.Object != nil {
+		props["object"] = n.Object
+	}
+	if n.Label != "" {
+		props["label"] = n.Label
+	}
+	return json.Marshal(props)
+}
+
+// Returns the node's type without the unreachable- prefix.
+func (n *Node) ObjectType() string {
+	return strings.TrimPrefix(n.Type, "unreachable-")
+}
+
+// Reports whether a ref reaches the node's object. False only with the reachable: false
+// attribute dagit adds to unreachable objects.
+func (n *Node) Reachable() bool {
+	var reachable bool
+	if err := json.Unmarshal(n.Attributes["reachable"], &reachable); err != nil {
+		return true
+	}
+	return reachable
+}
+
+// Decodes an attribute into v, e.g. the decorations of a commit into a []string. False
+// when the node doesn't have it.
+func (n *Node) Attribute(key string, v any) (bool, error) {
+	value, ok := n.Attributes[key]
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(value, v)
+}
+
+func decodeObject[T any](n *Node, types ...string) (*T, error) {
+	for _, type_ := range types {
+		if n.ObjectType() == type_ {
+			var object T
+			if err := json.Unmarshal(n.Object, &object); err != nil {
+				return nil, fmt.Errorf("%s node %s: %w", n.Type, n.Name, err)
+			}
+			return &object, nil
+		}
+	}
+	return nil, fmt.Errorf("node %s is a %s, not a %s", n.Name, n.Type, strings.Join(types, " or "))
+}
+
+// Decodes a commit node, including octopus merges. Hash is set from the node's name.
+func (n *Node) Commit() (*Commit, error) {
+	commit, err := decodeObject[Commit](n, "commit", "octopus-merge")
+	if err == nil {
+		commit.Hash = n.Name
+	}
+	return commit, err
+}
+
+func (n *Node) Tree() (*Tree, error) {
+	return decodeObject[Tree](n, "tree")
+}
+
+// Decodes a blob node with its content. Graphs exported with --no-content have BlobDigest
+// objects instead.
+func (n *Node) Blob() (*Blob, error) {
+	return decodeObject[Blob](n, "blob")
+}
+
+// Decodes a blob node of a graph exported with --no-content.
+func (n *Node) BlobDigest() (*BlobDigest, error) {
+	return decodeObject[BlobDigest](n, "blob")
+}
+
+func (n *Node) Tag() (*Tag, error) {
+	return decodeObject[Tag](n, "tag")
+}
+
+func (n *Node) LFSPointer() (*LFSPointer, error) {
+	return decodeObject[LFSPointer](n, "lfs-pointer")
+}
+
+func (n *Node) Symlink() (*Symlink, error) {
+	return decodeObject[Symlink](n, "symlink")
+}
+
+type User struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type Commit struct {
+	Hash       string    `json:"-"`
+	Tree       string    `json:"tree"`
+	Parents    []string  `json:"parents"`
+	Author     User      `json:"author"`
+	Committer  User      `json:"committer"`
+	Message    string    `json:"message"`
+	CommitTime time.Time `json:"commitTime"`
+	AuthorTime time.Time `json:"authorTime"`
+	// set when the subject follows the Conventional Commits spec
+	Conventional *ConventionalCommit `json:"conventional,omitempty"`
+}
+
+// The fields of a commit subject following the Conventional Commits spec.
+type ConventionalCommit struct {
+	Type     string `json:"type"`
+	Scope    string `json:"scope,omitempty"`
+	Breaking bool   `json:"breaking"`
+	Subject  string `json:"subject"`
+}
+
+type Tree struct {
+	Entries []TreeEntry `json:"entries"`
+}
+
+type TreeEntry struct {
+	Mode string `json:"mode"`
+	// the mode decoded: file, executable, symlink, gitlink, directory or unknown
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	Hash string `json:"hash"`
+}
+
+type Blob struct {
+	Content string `json:"content"`
+	Size    int    `json:"size"`
+}
+
+// A blob node without its content, for graphs that shouldn't leak source code.
+type BlobDigest struct {
+	Size int `json:"size"`
+	// the MIME type sniffed from the content, e.g. text/plain; charset=utf-8
+	ContentType string `json:"contentType"`
+	Sha256      string `json:"sha256"`
+}
+
+type Tag struct {
+	Object  string    `json:"object"`
+	Type    string    `json:"type"`
+	Tag     string    `json:"tag"`
+	Tagger  User      `json:"tagger"`
+	TagTime time.Time `json:"tagTime"`
+	Message string    `json:"message"`
+}
+
+// A blob standing in for a file stored in Git LFS.
+type LFSPointer struct {
+	Version string `json:"version"`
+	Oid     string `json:"oid"`
+	Size    int64  `json:"size"`
+}
+
+// A blob checked out as a symlink.
+type Symlink struct {
+	Target string `json:"target"`
+}
+
+// A loose object that couldn't be read and was skipped.
+type ParseError struct {
+	Name     string `json:"name"`
+	Location string `json:"location"`
+	Error    string `json:"error"`
+}
+
+// The refs of a repo, as the server's ref endpoints return them.
+
+type Head struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	// the ref HEAD ends up at when Value is itself a symbolic ref
+	Target string `json:"target,omitempty"`
+}
+
+type Branch struct {
+	Name   string `json:"name"`
+	Commit string `json:"commit"`
+}
+
+// A lightweight or annotated tag ref. The target of an annotated tag is the tag object.
+type TagRef struct {
+	Name   string `json:"name"`
+	Target string `json:"target"`
+}
+
+// A remote-tracking branch, e.g. refs/remotes/origin/main, with the remote it was fetched from.
+type RemoteRef struct {
+	Name   string `json:"name"`
+	Target string `json:"target"`
+	// empty when no configured remote fetches into the ref
+	Remote string   `json:"remote,omitempty"`
+	URLs   []string `json:"urls,omitempty"`
+	// the branch on the remote, e.g. refs/heads/main
+	Branch string `json:"branch,omitempty"`
+}
+
+// A pseudo-ref file in .git, e.g. FETCH_HEAD, and the objects it lists.
+type PseudoRef struct {
+	Name    string            `json:"name"`
+	Targets []PseudoRefTarget `json:"targets"`
+}
+
+type PseudoRefTarget struct {
+	Hash string `json:"hash"`
+	// FETCH_HEAD only: fetched refs that git pull won't merge
+	NotForMerge bool `json:"notForMerge,omitempty"`
+	// FETCH_HEAD only: where the object was fetched from, e.g. branch 'main' of https://...
+	Description string `json:"description,omitempty"`
+}
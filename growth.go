@@ -0,0 +1,129 @@
+package main
+
+import (
+	"cmp"
+	"database/sql"
+	"encoding/csv"
+	"io"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// The objects added to a repo during a period and the repo's size at its end.
+type GrowthPoint struct {
+	Period       string `json:"period"`
+	Commits      int    `json:"commits"`
+	Objects      int    `json:"objects"`
+	Bytes        int64  `json:"bytes"`
+	TotalObjects int    `json:"totalObjects"`
+	TotalBytes   int64  `json:"totalBytes"`
+}
+
+// Computes how the selected objects accumulated over time. Every object is counted in the
+// period of the oldest commit introducing it, by commit time or, with reflog, by the time a
+// reflog first recorded the commit when it did. Periods without new objects are left out.
+func (r *Repo) growth(sel *selection, period string, reflog bool) ([]GrowthPoint, error) {
+	if _, err := periodKey(time.Time{}, period); err != nil {
+		return nil, err
+	}
+	var commits []Commit
+	for _, obj := range sel.objects {
+		if obj.Type == "commit" {
+			commits = append(commits, parseCommit(obj))
+		}
+	}
+	if err := r.sortCommits(commits, ORDER_TOPO); err != nil {
+		return nil, err
+	}
+	slices.Reverse(commits)
+	recorded := map[string]time.Time{}
+	if reflog {
+		for _, e := range r.reflogs() {
+			if _, ok := recorded[e.New]; !ok && e.New != "" {
+				recorded[e.New] = e.Time
+			}
+		}
+	}
+
+	points := map[string]*GrowthPoint{}
+	seen := map[string]bool{}
+	var add func(point *GrowthPoint, name string)
+	add = func(point *GrowthPoint, name string) {
+		obj := r.getObject(name)
+		if seen[name] || obj == nil || !sel.has(name) {
+			return
+		}
+		seen[name] = true
+		point.Objects++
+		point.Bytes += int64(blobSize(obj))
+		if obj.Type == "tree" {
+			for _, entry := range *parseTree(obj) {
+				add(point, entry.Hash)
+			}
+		}
+	}
+	for _, commit := range commits {
+		when := commit.CommitTime
+		if t, ok := recorded[commit.Hash]; ok {
+			when = t
+		}
+		key, _ := periodKey(when, period)
+		point, ok := points[key]
+		if !ok {
+			point = &GrowthPoint{Period: key}
+			points[key] = point
+		}
+		point.Commits++
+		add(point, commit.Hash)
+		add(point, commit.Tree)
+	}
+
+	result := make([]GrowthPoint, 0, len(points))
+	for _, point := range points {
+		result = append(result, *point)
+	}
+	slices.SortFunc(result, func(a, b GrowthPoint) int { return cmp.Compare(a.Period, b.Period) })
+	var objects int
+	var bytes int64
+	for i := range result {
+		objects += result[i].Objects
+		bytes += result[i].Bytes
+		result[i].TotalObjects, result[i].TotalBytes = objects, bytes
+	}
+	return result, nil
+}
+
+func writeGrowthCSV(w io.Writer, points []GrowthPoint) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"period", "commits", "objects", "bytes", "totalObjects", "totalBytes"})
+	for _, p := range points {
+		out.Write([]string{
+			p.Period,
+			strconv.Itoa(p.Commits),
+			strconv.Itoa(p.Objects),
+			strconv.FormatInt(p.Bytes, 10),
+			strconv.Itoa(p.TotalObjects),
+			strconv.FormatInt(p.TotalBytes, 10),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// Adds a growth table of the selected objects by day to a SQLite export.
+func (r *Repo) writeGrowthTable(db *sql.DB, sel *selection) error {
+	points, err := r.growth(sel, PERIOD_DAY, false)
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec(`create table growth (period text primary key, commits integer, objects integer, bytes integer, total_objects integer, total_bytes integer);`); err != nil {
+		return err
+	}
+	for _, p := range points {
+		if _, err := db.Exec("insert into growth values(?, ?, ?, ?, ?, ?)", p.Period, p.Commits, p.Objects, p.Bytes, p.TotalObjects, p.TotalBytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/csv"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// How often a file changed over the history.
+type Hotspot struct {
+	Path    string `json:"path"`
+	Changes int    `json:"changes"`
+	// changes weighted by recency when a half-life is set, otherwise the number of changes
+	Score        float64   `json:"score"`
+	Authors      int       `json:"authors"`
+	FirstChanged time.Time `json:"firstChanged"`
+	LastChanged  time.Time `json:"lastChanged"`
+}
+
+// Computes the change frequency of every file in the history of revs, following files
+// across renames. Merge commits are skipped since their changes belong to the merged
+// commits. With a halfLife > 0 a change counts half as much every halfLife before now.
+func (r *Repo) hotspots(ctx context.Context, revs []string, halfLife time.Duration, renames RenameOptions) ([]Hotspot, error) {
+	commits, err := r.logCommits(ctx, revs, LogOptions{Order: ORDER_TOPO})
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	spots := map[string]*Hotspot{}
+	authors := map[string]map[string]bool{}
+	// oldest first so renames carry a file's history over to its new path
+	for i := len(commits) - 1; i >= 0; i-- {
+		commit := commits[i]
+		if len(commit.Parents) > 1 {
+			continue
+		}
+		parentTree := ""
+		if len(commit.Parents) == 1 {
+			if parent := r.getObject(commit.Parents[0]); parent != nil {
+				parentTree = parseCommit(parent).Tree
+			}
+		}
+		weight := 1.0
+		if halfLife > 0 {
+			weight = math.Pow(0.5, float64(now.Sub(commit.CommitTime))/float64(halfLife))
+		}
+		for _, c := range r.detectRenames(r.diffTrees(parentTree, commit.Tree), renames) {
+			if c.Status == RENAMED {
+				if spot, ok := spots[c.OldPath]; ok {
+					spot.Path = c.Path
+					spots[c.Path], authors[c.Path] = spot, authors[c.OldPath]
+					delete(spots, c.OldPath)
+					delete(authors, c.OldPath)
+				}
+			}
+			spot, ok := spots[c.Path]
+			if !ok {
+				spot = &Hotspot{Path: c.Path, FirstChanged: commit.CommitTime}
+				spots[c.Path] = spot
+				authors[c.Path] = map[string]bool{}
+			}
+			spot.Changes++
+			spot.Score += weight
+			spot.LastChanged = commit.CommitTime
+			authors[c.Path][commit.Author.Email] = true
+			spot.Authors = len(authors[c.Path])
+		}
+	}
+	var result []Hotspot
+	for _, spot := range spots {
+		result = append(result, *spot)
+	}
+	slices.SortFunc(result, func(a, b Hotspot) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), cmp.Compare(a.Path, b.Path))
+	})
+	return result, nil
+}
+
+func writeHotspotsCSV(w io.Writer, spots []Hotspot) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"path", "changes", "score", "authors", "first_changed", "last_changed"})
+	for _, s := range spots {
+		out.Write([]string{
+			s.Path,
+			strconv.Itoa(s.Changes),
+			strconv.FormatFloat(s.Score, 'f', 3, 64),
+			strconv.Itoa(s.Authors),
+			s.FirstChanged.Format(time.RFC3339),
+			s.LastChanged.Format(time.RFC3339),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"maps"
+	"slices"
+)
+
+// The kind of the edges from a commit to the blobs it introduced.
+const EDGE_INTRODUCES = "introduces"
+
+// Returns the edges from each selected commit to the selected blobs it introduced: blobs at
+// a path where no parent had the same content. Root commits introduce every blob of their
+// tree, and merges only the content that differs from all of their parents, e.g. conflict
+// resolutions.
+func (r *Repo) introducesEdges(sel *selection) []Edge {
+	var edges []Edge
+	for _, obj := range sel.objects {
+		if obj.Type != "commit" {
+			continue
+		}
+		commit := parseCommit(obj)
+		parents := commit.Parents
+		if len(parents) == 0 {
+			parents = []string{""}
+		}
+		// blobs by path, kept while they're new compared to every parent so far
+		var introduced map[string]string
+		for i, parent := range parents {
+			parentTree := ""
+			if p := r.getObject(parent); p != nil {
+				parentTree = parseCommit(p).Tree
+			}
+			changed := map[string]string{}
+			for _, c := range r.diffTrees(parentTree, commit.Tree) {
+				if c.Status != DELETED {
+					changed[c.Path] = c.NewHash
+				}
+			}
+			if i == 0 {
+				introduced = changed
+				continue
+			}
+			for p, hash := range introduced {
+				if changed[p] != hash {
+					delete(introduced, p)
+				}
+			}
+		}
+		// the same content at several paths gets one edge
+		seen := map[string]bool{}
+		for _, p := range slices.Sorted(maps.Keys(introduced)) {
+			hash := introduced[p]
+			if seen[hash] || !sel.has(hash) {
+				continue
+			}
+			if blob := r.getObject(hash); blob == nil || blob.Type != "blob" {
+				continue
+			}
+			seen[hash] = true
+			edges = append(edges, Edge{Src: commit.Hash, Dest: hash, Kind: EDGE_INTRODUCES})
+		}
+	}
+	return edges
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+// The kinds of jobs, the long operations whose progress is reported.
+const (
+	// reading a repo's objects
+	JOB_SCAN = "scan"
+	// serializing a graph
+	JOB_BUILD_GRAPH = "build-graph"
+	// writing an export
+	JOB_EXPORT = "export"
+)
+
+// Jobs running longer than this get a progress bar in the terminal, so quick commands stay quiet.
+const progressDelay = 500 * time.Millisecond
+
+// A job in progress. Its methods are safe to call from several goroutines and on nil, which
+// operations reporting progress get when nobody started a job for them.
+type runningJob struct {
+	mu  sync.Mutex
+	job Job
+}
+
+func (j *runningJob) snapshot() Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.job
+}
+
+// Sets the number of steps the job takes.
+func (j *runningJob) setTotal(total int) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	j.job.Total = int64(total)
+	j.job.Done = 0
+	j.job.Percent = 0
+	job := j.job
+	j.mu.Unlock()
+	jobs.publish(job)
+}
+
+// Records n more steps done, publishing the job when its percentage changes.
+func (j *runningJob) add(n int) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	j.job.Done += int64(n)
+	percent := j.job.Percent
+	if j.job.Total > 0 {
+		percent = int(min(j.job.Done*100/j.job.Total, 100))
+	}
+	changed := percent != j.job.Percent
+	j.job.Percent = percent
+	job := j.job
+	j.mu.Unlock()
+	if changed {
+		jobs.publish(job)
+	}
+}
+
+type jobKey struct{}
+
+// Returns the job ctx carries, or nil.
+func jobFrom(ctx context.Context) *runningJob {
+	j, _ := ctx.Value(jobKey{}).(*runningJob)
+	return j
+}
+
+// Tracks the running jobs and who's watching them.
+type jobManager struct {
+	mu          sync.Mutex
+	running     map[string]*runningJob
+	subscribers map[*jobSubscription]bool
+}
+
+var jobs = &jobManager{running: map[string]*runningJob{}, subscribers: map[*jobSubscription]bool{}}
+
+// Starts a job of kind on repo. When ctx already carries a job, like the export a graph is
+// built for, that job is returned instead so nested operations report to it, and end does
+// nothing. Otherwise end finishes the job with the operation's error.
+func startJob(ctx context.Context, kind, repo string) (context.Context, *runningJob, func(error)) {
+	if j := jobFrom(ctx); j != nil {
+		return ctx, j, func(error) {}
+	}
+	j := &runningJob{job: Job{ID: newRequestID(), Kind: kind, Repo: repo, StartedAt: time.Now()}}
+	jobs.mu.Lock()
+	jobs.running[j.job.ID] = j
+	jobs.mu.Unlock()
+	jobs.publish(j.snapshot())
+	end := func(err error) {
+		j.mu.Lock()
+		j.job.Finished = true
+		if err != nil {
+			j.job.Error = err.Error()
+		} else {
+			j.job.Done = j.job.Total
+			j.job.Percent = 100
+		}
+		job := j.job
+		j.mu.Unlock()
+		jobs.mu.Lock()
+		delete(jobs.running, job.ID)
+		jobs.mu.Unlock()
+		jobs.publish(job)
+	}
+	return context.WithValue(ctx, jobKey{}, j), j, end
+}
+
+// Lists the running jobs, oldest first.
+func (m *jobManager) list() []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := []Job{}
+	for _, j := range m.running {
+		list = append(list, j.snapshot())
+	}
+	slices.SortFunc(list, func(a, b Job) int { return a.StartedAt.Compare(b.StartedAt) })
+	return list
+}
+
+func (m *jobManager) publish(job Job) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for sub := range m.subscribers {
+		sub.update(job)
+	}
+}
+
+// A watcher of job progress. Updates are coalesced to each job's latest state, so a slow
+// subscriber never holds up a job and never misses one finishing.
+type jobSubscription struct {
+	mu      sync.Mutex
+	pending map[string]Job
+	// signaled when there are pending updates
+	notify chan struct{}
+}
+
+func (m *jobManager) subscribe() *jobSubscription {
+	sub := &jobSubscription{pending: map[string]Job{}, notify: make(chan struct{}, 1)}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subscribers[sub] = true
+	return sub
+}
+
+func (m *jobManager) unsubscribe(sub *jobSubscription) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.subscribers, sub)
+}
+
+func (sub *jobSubscription) update(job Job) {
+	sub.mu.Lock()
+	sub.pending[job.ID] = job
+	sub.mu.Unlock()
+	select {
+	case sub.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Returns the pending updates, oldest job first.
+func (sub *jobSubscription) take() []Job {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	updates := make([]Job, 0, len(sub.pending))
+	for _, job := range sub.pending {
+		updates = append(updates, job)
+	}
+	clear(sub.pending)
+	slices.SortFunc(updates, func(a, b Job) int { return a.StartedAt.Compare(b.StartedAt) })
+	return updates
+}
+
+// How showProgress reports jobs.
+const (
+	// a progress bar per job, for terminals
+	PROGRESS_BAR = "bar"
+	// a line with each job's percentage now and then, for logs
+	PROGRESS_PLAIN = "plain"
+	// a Job as JSON per line, for tools
+	PROGRESS_JSON = "json"
+)
+
+// Progress lines of a running job are written at most this often.
+const progressLineInterval = 2 * time.Second
+
+// Reports every job running longer than progressDelay on stderr in format until stop is
+// called.
+func showProgress(format string) (stop func()) {
+	sub := jobs.subscribe()
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		bars := map[string]*progressbar.ProgressBar{}
+		// when each job's last line was written
+		printed := map[string]time.Time{}
+		latest := map[string]Job{}
+		ticker := time.NewTicker(progressDelay / 5)
+		defer ticker.Stop()
+		line := func(job Job) {
+			if format == PROGRESS_JSON {
+				json.NewEncoder(os.Stderr).Encode(job)
+				return
+			}
+			kind := strings.ReplaceAll(job.Kind, "-", " ")
+			switch {
+			case job.Finished && job.Error != "":
+				fmt.Fprintf(os.Stderr, "%s %s: failed after %s: %s\n", kind, job.Repo, time.Since(job.StartedAt).Round(time.Millisecond), job.Error)
+			case job.Finished:
+				fmt.Fprintf(os.Stderr, "%s %s: done in %s\n", kind, job.Repo, time.Since(job.StartedAt).Round(time.Millisecond))
+			default:
+				fmt.Fprintf(os.Stderr, "%s %s: %d%% (%d/%d)\n", kind, job.Repo, job.Percent, job.Done, job.Total)
+			}
+		}
+		draw := func() {
+			for _, job := range sub.take() {
+				latest[job.ID] = job
+			}
+			for id, job := range latest {
+				started := time.Since(job.StartedAt) >= progressDelay
+				if format == PROGRESS_BAR {
+					bar := bars[id]
+					if bar == nil && !job.Finished && job.Total > 0 && started {
+						bar = progressbar.Default(job.Total, strings.ReplaceAll(job.Kind, "-", " "))
+						bars[id] = bar
+					}
+					if bar != nil {
+						if bar.GetMax64() != job.Total {
+							bar.ChangeMax64(job.Total)
+						}
+						bar.Set64(job.Done)
+						if job.Finished && job.Error == "" {
+							bar.Finish()
+						} else if job.Finished {
+							bar.Exit()
+						}
+					}
+				} else if last, ok := printed[id]; ok && job.Finished || started && time.Since(last) >= progressLineInterval {
+					line(job)
+					printed[id] = time.Now()
+				}
+				if job.Finished {
+					delete(bars, id)
+					delete(printed, id)
+					delete(latest, id)
+				}
+			}
+		}
+		for {
+			select {
+			case <-sub.notify:
+			case <-ticker.C:
+			case <-done:
+				draw()
+				return
+			}
+			draw()
+		}
+	}()
+	return func() {
+		jobs.unsubscribe(sub)
+		close(done)
+		<-stopped
+	}
+}
+
+// Serves the running jobs.
+func serveJobs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(jobs.list()); err != nil {
+		loggerFrom(r.Context()).Error("writing jobs", "error", err)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"time"
+
+	"github.com/dagit/repofs"
+)
+
+// The kinds of changes in a journal.
+const (
+	CHANGE_OBJECT_ADDED   = "object-added"
+	CHANGE_OBJECT_REMOVED = "object-removed"
+	CHANGE_REF_MOVED      = "ref-moved"
+	CHANGE_UNREACHABLE    = "unreachable"
+)
+
+// Appends the changes detected in a repo to the changes table of a SQLite database, one row
+// per object added or removed, ref moved and commit that became unreachable, e.g.
+//
+//	select time, old, new from changes where kind = 'ref-moved' and name = 'refs/heads/main';
+//
+// Created refs have no old value and deleted ones no new value. The journal keeps growing
+// across runs, so it records what the reflog expires or never saw, like deleted branches.
+type journal struct {
+	db   *sql.DB
+	repo string
+}
+
+// Opens the journal at path for the repo at location, creating it if needed.
+func openJournal(path string, location string) (*journal, error) {
+	if err := repofs.CheckWrite(path); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(`create table if not exists changes (
+		id integer primary key,
+		time text not null,
+		repo text not null,
+		kind text not null,
+		name text not null,
+		type text,
+		old text,
+		new text
+	);
+	create index if not exists changes_name on changes(name);
+	create index if not exists changes_time on changes(time);`); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if abs, err := filepath.Abs(location); err == nil {
+		location = abs
+	}
+	return &journal{db: db, repo: location}, nil
+}
+
+// Records the changes of graph-diff and commits-unreachable events. Other events describe
+// the same changes.
+func (j *journal) Publish(ctx context.Context, e RepoEvent) error {
+	type change struct{ kind, name, type_, old, new string }
+	var changes []change
+	switch e.Type {
+	case EVENT_GRAPH_DIFF:
+		for _, obj := range e.Diff.Added {
+			changes = append(changes, change{CHANGE_OBJECT_ADDED, obj.Name, obj.Type, "", ""})
+		}
+		for _, obj := range e.Diff.Removed {
+			changes = append(changes, change{CHANGE_OBJECT_REMOVED, obj.Name, obj.Type, "", ""})
+		}
+		for _, ref := range e.Diff.Refs {
+			changes = append(changes, change{CHANGE_REF_MOVED, ref.Ref, "", ref.Old, ref.New})
+		}
+	case EVENT_COMMITS_UNREACHABLE:
+		for _, commit := range e.Commits {
+			changes = append(changes, change{CHANGE_UNREACHABLE, commit, "commit", "", ""})
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	tx, err := j.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, "insert into changes(time, repo, kind, name, type, old, new) values(?, ?, ?, ?, nullif(?, ''), nullif(?, ''), nullif(?, ''))")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	at := e.Time.UTC().Format(time.RFC3339Nano)
+	for _, c := range changes {
+		if _, err := stmt.ExecContext(ctx, at, j.repo, c.kind, c.name, c.type_, c.old, c.new); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (j *journal) Close() error {
+	return j.db.Close()
+}
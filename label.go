@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"text/template"
+)
+
+// The functions label templates can call besides text/template's.
+var labelFuncs = template.FuncMap{
+	// cuts s to at most n characters, ending it with … when it's cut
+	"truncate": func(s string, n int) string {
+		runes := []rune(s)
+		if n < 1 || len(runes) <= n {
+			return s
+		}
+		return string(runes[:n-1]) + "…"
+	},
+	// the first line of a message
+	"subject": func(s string) string {
+		subject, _, _ := strings.Cut(s, "\n")
+		return subject
+	},
+	// the abbreviated object name, like git log --oneline's
+	"short": func(hash string) string {
+		return hash[:min(7, len(hash))]
+	},
+}
+
+// Parses a --label-template, a text/template executed with each commit, e.g.
+// {{short .Hash}} {{.Author.Name}}: {{truncate (subject .Message) 40}}.
+func parseLabelTemplate(text string) (*template.Template, error) {
+	return template.New("label").Funcs(labelFuncs).Parse(text)
+}
+
+// Returns the label of a commit node, after the mailmap and redaction are applied.
+func (sel *selection) commitLabel(commit Commit) (string, error) {
+	if sel.redact != nil {
+		commit.Message = sel.redact(commit.Message)
+	}
+	var label strings.Builder
+	if err := sel.label.Execute(&label, sel.mailmap.commit(commit)); err != nil {
+		return "", err
+	}
+	return label.String(), nil
+}
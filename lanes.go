@@ -0,0 +1,82 @@
+package main
+
+// A commit's place in a rail layout like git log --graph: its row, newest first, and the
+// column of the lane it's drawn in.
+type Lane struct {
+	Row    int `json:"row"`
+	Column int `json:"column"`
+}
+
+// Assigns every selected commit a lane. Commits are laid out newest first in order, falling
+// back to date order. A commit continues the lane of the child that reached it first, its
+// first parent continues its lane and other parents open lanes in the first free columns,
+// so a linear history stays in column 0 and a branch keeps its column until it's merged.
+// Lanes only follow parents that are selected.
+func (r *Repo) lanes(sel *selection, order string) (map[string]Lane, error) {
+	var commits []Commit
+	for _, obj := range sel.objects {
+		if obj.Type == "commit" {
+			commits = append(commits, parseCommit(obj))
+		}
+	}
+	if order == "" {
+		order = ORDER_DATE
+	}
+	if err := r.sortCommits(commits, order); err != nil {
+		return nil, err
+	}
+	// the commit each column expects next, "" for free columns
+	var columns []string
+	place := func(hash string, from int) int {
+		for i := from; i < len(columns); i++ {
+			if columns[i] == "" {
+				columns[i] = hash
+				return i
+			}
+		}
+		columns = append(columns, hash)
+		return len(columns) - 1
+	}
+	lanes := make(map[string]Lane, len(commits))
+	for row, commit := range commits {
+		column := -1
+		for i, expected := range columns {
+			if expected != commit.Hash {
+				continue
+			}
+			if column < 0 {
+				column = i
+			} else {
+				// lanes of other children converge here
+				columns[i] = ""
+			}
+		}
+		if column < 0 {
+			column = place(commit.Hash, 0)
+		}
+		lanes[commit.Hash] = Lane{Row: row, Column: column}
+		columns[column] = ""
+		first := true
+		for _, parent := range commit.Parents {
+			if !sel.has(parent) || r.getObject(parent) == nil {
+				continue
+			}
+			tracked := false
+			for _, expected := range columns {
+				tracked = tracked || expected == parent
+			}
+			switch {
+			case tracked:
+			case first:
+				columns[column] = parent
+			default:
+				place(parent, column+1)
+			}
+			first = false
+		}
+		for len(columns) > 0 && columns[len(columns)-1] == "" {
+			columns = columns[:len(columns)-1]
+		}
+	}
+	return lanes, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"path"
+	"slices"
+	"strings"
+)
+
+var extensionLanguages = map[string]string{
+	".go": "Go", ".js": "JavaScript", ".mjs": "JavaScript", ".cjs": "JavaScript", ".jsx": "JavaScript",
+	".ts": "TypeScript", ".tsx": "TypeScript", ".py": "Python", ".rb": "Ruby", ".rs": "Rust",
+	".java": "Java", ".kt": "Kotlin", ".kts": "Kotlin", ".scala": "Scala", ".swift": "Swift",
+	".c": "C", ".h": "C", ".cc": "C++", ".cpp": "C++", ".cxx": "C++", ".hpp": "C++", ".hh": "C++",
+	".cs": "C#", ".fs": "F#", ".php": "PHP", ".pl": "Perl", ".pm": "Perl", ".lua": "Lua",
+	".sh": "Shell", ".bash": "Shell", ".zsh": "Shell", ".ps1": "PowerShell",
+	".html": "HTML", ".htm": "HTML", ".css": "CSS", ".scss": "SCSS", ".sass": "Sass", ".less": "Less",
+	".vue": "Vue", ".svelte": "Svelte", ".json": "JSON", ".yaml": "YAML", ".yml": "YAML",
+	".toml": "TOML", ".xml": "XML", ".md": "Markdown", ".markdown": "Markdown", ".rst": "reStructuredText",
+	".sql": "SQL", ".proto": "Protocol Buffers", ".graphql": "GraphQL", ".tf": "HCL", ".hcl": "HCL",
+	".ex": "Elixir", ".exs": "Elixir", ".erl": "Erlang", ".hs": "Haskell", ".ml": "OCaml",
+	".clj": "Clojure", ".dart": "Dart", ".r": "R", ".jl": "Julia", ".zig": "Zig", ".nim": "Nim",
+	".m": "Objective-C", ".mm": "Objective-C++", ".tex": "TeX", ".txt": "Text",
+}
+
+var filenameLanguages = map[string]string{
+	"Makefile": "Makefile", "GNUmakefile": "Makefile", "Dockerfile": "Dockerfile", "Containerfile": "Dockerfile",
+	"CMakeLists.txt": "CMake", "Rakefile": "Ruby", "Gemfile": "Ruby", "Jenkinsfile": "Groovy",
+	"go.mod": "Go Module", "go.sum": "Go Module",
+}
+
+var interpreterLanguages = map[string]string{
+	"sh": "Shell", "bash": "Shell", "zsh": "Shell", "dash": "Shell", "ksh": "Shell",
+	"python": "Python", "python2": "Python", "python3": "Python", "node": "JavaScript", "deno": "TypeScript",
+	"ruby": "Ruby", "perl": "Perl", "php": "PHP", "lua": "Lua", "Rscript": "R", "pwsh": "PowerShell",
+}
+
+// Classifies a file by its name, falling back to the interpreter of a shebang line. Returns
+// an empty string for binary content and files it can't classify.
+func languageOf(name string, data []byte) string {
+	if bytes.IndexByte(data[:min(len(data), 8000)], 0) >= 0 {
+		return ""
+	}
+	base := path.Base(name)
+	if lang, ok := filenameLanguages[base]; ok {
+		return lang
+	}
+	if lang, ok := extensionLanguages[strings.ToLower(path.Ext(base))]; ok {
+		return lang
+	}
+	if shebang, ok := bytes.CutPrefix(data, []byte("#!")); ok {
+		line, _, _ := bytes.Cut(shebang, []byte("\n"))
+		fields := strings.Fields(string(line))
+		if len(fields) > 0 {
+			interpreter := path.Base(fields[0])
+			// #!/usr/bin/env python3
+			if interpreter == "env" {
+				interpreter = ""
+				for _, f := range fields[1:] {
+					if !strings.HasPrefix(f, "-") {
+						interpreter = path.Base(f)
+						break
+					}
+				}
+			}
+			return interpreterLanguages[interpreter]
+		}
+	}
+	return ""
+}
+
+func countLines(data []byte) int {
+	lines := bytes.Count(data, []byte("\n"))
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		lines++
+	}
+	return lines
+}
+
+// The files, lines and bytes of a language in a tree.
+type LanguageStats struct {
+	Language string `json:"language"`
+	Files    int    `json:"files"`
+	Lines    int    `json:"lines"`
+	Bytes    int    `json:"bytes"`
+}
+
+// Aggregates the files under a tree by language, most bytes first. Blobs are classified once
+// across calls sharing cache.
+func (r *Repo) treeLanguages(tree string, files map[string][]pathBlob, cache map[string]LanguageStats) []LanguageStats {
+	byLanguage := map[string]*LanguageStats{}
+	for _, f := range r.flattenTree(tree, files) {
+		key := f.path + "\x00" + f.hash
+		blob, ok := cache[key]
+		if !ok {
+			data := r.getObject(f.hash).Bytes()
+			blob = LanguageStats{Language: languageOf(f.path, data), Files: 1, Lines: countLines(data), Bytes: len(data)}
+			cache[key] = blob
+		}
+		if blob.Language == "" {
+			continue
+		}
+		stats, ok := byLanguage[blob.Language]
+		if !ok {
+			stats = &LanguageStats{Language: blob.Language}
+			byLanguage[blob.Language] = stats
+		}
+		stats.Files++
+		stats.Lines += blob.Lines
+		stats.Bytes += blob.Bytes
+	}
+	result := make([]LanguageStats, 0, len(byLanguage))
+	for _, stats := range byLanguage {
+		result = append(result, *stats)
+	}
+	slices.SortFunc(result, func(a, b LanguageStats) int {
+		return cmp.Or(cmp.Compare(b.Bytes, a.Bytes), strings.Compare(a.Language, b.Language))
+	})
+	return result
+}
+
+// Adds the language of each blob entry to tree nodes as a "languages" attribute mapping entry
+// names to languages, and to blob nodes as a "language" attribute.
+func (r *Repo) annotateLanguages(sel *selection) {
+	for _, obj := range sel.objects {
+		if obj.Type != "tree" {
+			continue
+		}
+		languages := map[string]string{}
+		for _, entry := range *parseTree(obj) {
+			blob := r.getObject(entry.Hash)
+			if blob == nil || blob.Type != "blob" {
+				continue
+			}
+			if lang := languageOf(entry.Name, blob.Bytes()); lang != "" {
+				languages[entry.Name] = lang
+				if sel.has(entry.Hash) {
+					sel.annotate(entry.Hash, "language", lang)
+				}
+			}
+		}
+		if len(languages) > 0 {
+			sel.annotate(obj.Name, "languages", languages)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/dagit/repofs"
+)
+
+// Layouts larger than this are refused, a few hundred thousand positions.
+const maxLayoutBytes = 16 << 20
+
+var clientIDRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Where a user dragged a node to.
+type Position struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	// pinned in place instead of moved by the simulation
+	Fixed bool `json:"fixed,omitempty"`
+}
+
+// The node positions a client arranged, by node name. Positions of nodes that left the
+// graph are kept, so they're back in place if the nodes return.
+type Layout struct {
+	ClientID  string              `json:"clientId"`
+	Updated   time.Time           `json:"updated"`
+	Positions map[string]Position `json:"positions"`
+}
+
+// Stores layouts as JSON files in a directory.
+type layoutStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// The directory dagit keeps its kind of data about a repo in, e.g. layouts, named by a hash
+// of the repo's git dir. It's under the user cache dir rather than the git dir, since writes
+// there would change the repo's checksum, making the server refresh, and paranoid mode
+// refuses them.
+func repoCacheDir(location string, kind string) (string, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	dir, err := filepath.Abs(gitDir(location))
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(dir))
+	return filepath.Join(cache, "dagit", kind, hex.EncodeToString(sum[:8])), nil
+}
+
+// The default layout directory of a repo.
+func layoutDir(location string) (string, error) {
+	return repoCacheDir(location, "layouts")
+}
+
+func (s *layoutStore) path(clientID string) string {
+	return filepath.Join(s.dir, clientID+".json")
+}
+
+func (s *layoutStore) load(clientID string) (*Layout, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := repofs.ReadFile(s.path(clientID))
+	if err != nil {
+		return nil, err
+	}
+	var layout Layout
+	if err := json.Unmarshal(data, &layout); err != nil {
+		return nil, fmt.Errorf("layout %s: %w", clientID, err)
+	}
+	return &layout, nil
+}
+
+func (s *layoutStore) save(layout *Layout) error {
+	data, err := json.Marshal(layout)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := repofs.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	return repofs.WriteFile(s.path(layout.ClientID), data, 0644)
+}
+
+// Serves GET and PUT /api/layout/{client-id}, loading and saving the node positions of a
+// browser, identified by an ID it generates.
+func serveLayout(store *layoutStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientID := r.PathValue("clientID")
+		if !clientIDRegex.MatchString(clientID) {
+			http.Error(w, fmt.Sprintf("invalid client ID %q, use up to 64 letters, digits, '_' and '-'", clientID), http.StatusBadRequest)
+			return
+		}
+		var layout *Layout
+		var err error
+		switch r.Method {
+		case http.MethodGet:
+			layout, err = store.load(clientID)
+			if errors.Is(err, os.ErrNotExist) {
+				http.Error(w, fmt.Sprintf("no layout saved for %s", clientID), http.StatusNotFound)
+				return
+			}
+		case http.MethodPut:
+			layout = &Layout{}
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLayoutBytes)).Decode(layout); err != nil {
+				http.Error(w, fmt.Sprintf("invalid layout: %s", err), http.StatusBadRequest)
+				return
+			}
+			if layout.Positions == nil {
+				layout.Positions = map[string]Position{}
+			}
+			layout.ClientID, layout.Updated = clientID, time.Now().UTC()
+			err = store.save(layout)
+			if errors.Is(err, repofs.ErrWriteInRepo) {
+				http.Error(w, err.Error()+", start the server with --layout-dir outside the repo to save layouts", http.StatusForbidden)
+				return
+			}
+		}
+		if err != nil {
+			loggerFrom(r.Context()).Error("layout", "client", clientID, "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(layout)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const LFS_POINTER = "lfs-pointer"
+
+// Pointer files are small, so larger blobs aren't parsed.
+const maxLFSPointerSize = 1024
+
+var lfsPointerRegex = regexp.MustCompile(`\Aversion (https://git-lfs\.github\.com/spec/v1|https://hawser\.github\.com/spec/v1)\n(?:[a-z0-9.-]+ [^\n]*\n)*?oid sha256:([0-9a-f]{64})\n(?:[a-z0-9.-]+ [^\n]*\n)*?size ([0-9]+)\n`)
+
+// Parses a blob's content as a Git LFS pointer file.
+func parseLFSPointer(data []byte) (LFSPointer, bool) {
+	if len(data) > maxLFSPointerSize || !bytes.HasPrefix(data, []byte("version ")) {
+		return LFSPointer{}, false
+	}
+	match := lfsPointerRegex.FindSubmatch(data)
+	if match == nil {
+		return LFSPointer{}, false
+	}
+	size, err := strconv.ParseInt(string(match[3]), 10, 64)
+	if err != nil {
+		return LFSPointer{}, false
+	}
+	return LFSPointer{Version: string(match[1]), Oid: "sha256:" + string(match[2]), Size: size}, true
+}
+
+// Reports whether an object is a blob holding an LFS pointer.
+func (obj *Object) lfsPointer() (LFSPointer, bool) {
+	if obj.Type != "blob" || blobSize(obj) > maxLFSPointerSize {
+		return LFSPointer{}, false
+	}
+	return parseLFSPointer(obj.Bytes())
+}
+
+// Blobs that should move to LFS and the pointers already there.
+type LFSReport struct {
+	Pointers int `json:"pointers"`
+	// the size of the files the pointers stand in for
+	PointerBytes int64 `json:"pointerBytes"`
+	// blobs of at least this many bytes are candidates
+	Threshold      int64       `json:"threshold"`
+	Candidates     []BlobUsage `json:"candidates"`
+	CandidateBytes int64       `json:"candidateBytes"`
+}
+
+// Lists the blobs committed in the commits selected by opts that are at least threshold bytes
+// and aren't LFS pointers, largest first.
+func (r *Repo) lfsReport(opts GraphOptions, threshold int64) (*LFSReport, error) {
+	sel, err := r.selectObjects(opts)
+	if err != nil {
+		return nil, err
+	}
+	report := &LFSReport{Threshold: threshold, Candidates: []BlobUsage{}}
+	for hash, u := range r.blobUsage(sel) {
+		obj := r.getObject(hash)
+		if pointer, ok := obj.lfsPointer(); ok {
+			report.Pointers++
+			report.PointerBytes += pointer.Size
+			continue
+		}
+		if int64(u.Size) >= threshold {
+			report.Candidates = append(report.Candidates, *u)
+			report.CandidateBytes += int64(u.Size)
+		}
+	}
+	slices.SortFunc(report.Candidates, func(a, b BlobUsage) int {
+		return cmp.Or(cmp.Compare(b.Size, a.Size), strings.Compare(a.Hash, b.Hash))
+	})
+	return report, nil
+}
+
+var sizeRegex = regexp.MustCompile(`(?i)^([0-9]+(?:\.[0-9]+)?)\s*([KMG]?)(?:i?B)?$`)
+
+// Parses a size like 512, 100K, 1.5MiB or 2GB. Units are powers of 1024.
+func parseSize(s string) (int64, error) {
+	match := sizeRegex.FindStringSubmatch(strings.TrimSpace(s))
+	if match == nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	n, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	shift := map[string]int{"": 0, "K": 10, "M": 20, "G": 30}[strings.ToUpper(match[2])]
+	return int64(n * float64(int64(1)<<shift)), nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dagit/repofs"
+)
+
+// git considers a gc.pid older than this left behind by a gc that died.
+const staleGCPid = 12 * time.Hour
+
+const lockPollInterval = 100 * time.Millisecond
+
+// Lists the lock files of a git dir showing a git command is writing to it: index.lock,
+// packed-refs.lock, ref locks and the like, and gc.pid while a gc is running. Paths are
+// relative to the git dir.
+func activeLocks(git string) []string {
+	var locks []string
+	for _, name := range []string{"index.lock", "HEAD.lock", "packed-refs.lock", "config.lock", "shallow.lock"} {
+		if _, err := repofs.Stat(filepath.Join(git, name)); err == nil {
+			locks = append(locks, name)
+		}
+	}
+	if info, err := repofs.Stat(filepath.Join(git, "gc.pid")); err == nil && time.Since(info.ModTime()) < staleGCPid {
+		locks = append(locks, "gc.pid")
+	}
+	repofs.WalkDir(filepath.Join(git, "refs"), func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && strings.HasSuffix(d.Name(), ".lock") {
+			if rel, err := filepath.Rel(git, path); err == nil {
+				locks = append(locks, filepath.ToSlash(rel))
+			}
+		}
+		return nil
+	})
+	slices.Sort(locks)
+	return locks
+}
+
+// Waits until no git command holds a lock on the git dir, so refs and the index aren't read
+// half written. Gives up after timeout, since a crashed git command can leave a lock behind,
+// and returns the locks still held. A timeout of 0 doesn't wait.
+func waitForLocks(ctx context.Context, git string, timeout time.Duration) []string {
+	locks := activeLocks(git)
+	if len(locks) == 0 || timeout <= 0 {
+		return locks
+	}
+	log.Printf("waiting up to %s for git to release %s", timeout, strings.Join(locks, ", "))
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(lockPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return locks
+		case <-deadline:
+			log.Printf("reading the repo anyway, %s may be left from a crashed git command", strings.Join(locks, ", "))
+			return locks
+		case <-ticker.C:
+			if locks = activeLocks(git); len(locks) == 0 {
+				return nil
+			}
+		}
+	}
+}
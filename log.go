@@ -0,0 +1,234 @@
+package main
+
+import (
+	"cmp"
+	"container/heap"
+	"context"
+	"fmt"
+	"iter"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	ORDER_TOPO        = "topo"
+	ORDER_DATE        = "date"
+	ORDER_AUTHOR_DATE = "author-date"
+)
+
+// Returns the generation number of every commit in commits and their ancestors. Root
+// commits are generation 1 and every other commit is one more than its highest parent,
+// so sorting by descending generation is a topological order.
+func (r *Repo) generations(commits []Commit) map[string]int {
+	gens := map[string]int{}
+	for _, c := range commits {
+		stack := []string{c.Hash}
+		for len(stack) > 0 {
+			name := stack[len(stack)-1]
+			if _, done := gens[name]; done {
+				stack = stack[:len(stack)-1]
+				continue
+			}
+			obj := r.getObject(name)
+			if obj == nil || obj.Type != "commit" {
+				// missing parents (e.g. shallow clones) are treated as roots
+				gens[name] = 0
+				stack = stack[:len(stack)-1]
+				continue
+			}
+			gen, pending := 1, false
+			for _, p := range parseCommit(obj).Parents {
+				parentGen, done := gens[p]
+				if !done {
+					stack = append(stack, p)
+					pending = true
+				}
+				gen = max(gen, parentGen+1)
+			}
+			if !pending {
+				gens[name] = gen
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+	return gens
+}
+
+// Sorts commits newest first by order: topo, date (commit time) or author-date. Ties are
+// broken by generation number so children always come before their parents.
+func (r *Repo) sortCommits(commits []Commit, order string) error {
+	gens := r.generations(commits)
+	byGen := func(a, b Commit) int {
+		return cmp.Or(cmp.Compare(gens[b.Hash], gens[a.Hash]), strings.Compare(a.Hash, b.Hash))
+	}
+	switch order {
+	case ORDER_TOPO:
+		slices.SortFunc(commits, func(a, b Commit) int {
+			return cmp.Or(byGen(a, b), b.CommitTime.Compare(a.CommitTime))
+		})
+	case ORDER_DATE, "":
+		slices.SortFunc(commits, func(a, b Commit) int {
+			return cmp.Or(b.CommitTime.Compare(a.CommitTime), byGen(a, b))
+		})
+	case ORDER_AUTHOR_DATE:
+		slices.SortFunc(commits, func(a, b Commit) int {
+			return cmp.Or(b.AuthorTime.Compare(a.AuthorTime), byGen(a, b))
+		})
+	default:
+		return fmt.Errorf("unknown order %q, expected topo, date or author-date", order)
+	}
+	return nil
+}
+
+// How Log walks commits.
+type LogOptions struct {
+	// topo, date (the default) or author-date
+	Order string
+	// only follow the first parent of merge commits
+	FirstParent bool
+	// stop after this many commits, 0 for no limit. Filtered out commits don't count.
+	MaxCount int
+	// commit filters. The walk goes on through filtered out commits to their parents.
+	Since  time.Time
+	Until  time.Time
+	Author *regexp.Regexp
+}
+
+func (opts LogOptions) keep(commit Commit) bool {
+	return GraphOptions{Since: opts.Since, Until: opts.Until, Author: opts.Author}.keepCommit(commit)
+}
+
+// Returns the generation numbers of the repo's commits, computed on first use after a load.
+func (r *Repo) commitGenerations() map[string]int {
+	r.cacheMu.Lock()
+	defer r.cacheMu.Unlock()
+	if r.gens == nil {
+		var commits []Commit
+		for _, obj := range r.objects {
+			if obj.Type == "commit" {
+				commits = append(commits, parseCommit(obj))
+			}
+		}
+		r.gens = r.generations(commits)
+	}
+	return r.gens
+}
+
+// Walks the commits reachable from the revision start along their parents, newest first by
+// opts.Order, with the repo's mailmap applied. Unlike scanning every object it never yields
+// unreachable commits, and it stops early at opts.MaxCount or when ctx is done. A start
+// that doesn't resolve to a commit yields nothing, so callers reporting bad revisions
+// resolve it first.
+func (r *Repo) Log(ctx context.Context, start string, opts LogOptions) iter.Seq[*Commit] {
+	hash, err := r.resolveRev(start)
+	if err != nil {
+		return func(yield func(*Commit) bool) {}
+	}
+	return r.walkLog(ctx, []string{hash}, opts)
+}
+
+// Walks the commits reachable from the given commits like Log. Tags are peeled.
+func (r *Repo) walkLog(ctx context.Context, roots []string, opts LogOptions) iter.Seq[*Commit] {
+	return func(yield func(*Commit) bool) {
+		gens := r.commitGenerations()
+		queue := &commitQueue{less: commitLess(opts.Order, gens)}
+		seen := map[string]bool{}
+		push := func(name string) {
+			obj := r.getObject(name)
+			for obj != nil && obj.Type == "tag" {
+				obj = r.getObject(parseTag(obj).Object)
+			}
+			if obj == nil || obj.Type != "commit" || seen[obj.Name] {
+				return
+			}
+			seen[obj.Name] = true
+			commit := r.mailmap.commit(parseCommit(obj))
+			heap.Push(queue, &commit)
+		}
+		for _, root := range roots {
+			push(root)
+		}
+		yielded := 0
+		for queue.Len() > 0 && ctx.Err() == nil {
+			commit := heap.Pop(queue).(*Commit)
+			parents := commit.Parents
+			if opts.FirstParent && len(parents) > 1 {
+				parents = parents[:1]
+			}
+			for _, parent := range parents {
+				push(parent)
+			}
+			if !opts.keep(*commit) {
+				continue
+			}
+			if !yield(commit) {
+				return
+			}
+			if yielded++; opts.MaxCount > 0 && yielded >= opts.MaxCount {
+				return
+			}
+		}
+	}
+}
+
+// Orders commits like sortCommits, newest first.
+func commitLess(order string, gens map[string]int) func(a, b *Commit) bool {
+	byGen := func(a, b *Commit) int {
+		return cmp.Or(cmp.Compare(gens[b.Hash], gens[a.Hash]), strings.Compare(a.Hash, b.Hash))
+	}
+	switch order {
+	case ORDER_TOPO:
+		return func(a, b *Commit) bool { return cmp.Or(byGen(a, b), b.CommitTime.Compare(a.CommitTime)) < 0 }
+	case ORDER_AUTHOR_DATE:
+		return func(a, b *Commit) bool { return cmp.Or(b.AuthorTime.Compare(a.AuthorTime), byGen(a, b)) < 0 }
+	default:
+		return func(a, b *Commit) bool { return cmp.Or(b.CommitTime.Compare(a.CommitTime), byGen(a, b)) < 0 }
+	}
+}
+
+// The commits a walk has reached but not yet yielded, next first.
+type commitQueue struct {
+	commits []*Commit
+	less    func(a, b *Commit) bool
+}
+
+func (q *commitQueue) Len() int           { return len(q.commits) }
+func (q *commitQueue) Less(i, j int) bool { return q.less(q.commits[i], q.commits[j]) }
+func (q *commitQueue) Swap(i, j int)      { q.commits[i], q.commits[j] = q.commits[j], q.commits[i] }
+func (q *commitQueue) Push(x any)         { q.commits = append(q.commits, x.(*Commit)) }
+func (q *commitQueue) Pop() any {
+	last := q.commits[len(q.commits)-1]
+	q.commits = q.commits[:len(q.commits)-1]
+	return last
+}
+
+// Returns the commits reachable from revs (HEAD by default) walked with Log.
+func (r *Repo) logCommits(ctx context.Context, revs []string, opts LogOptions) ([]Commit, error) {
+	if !slices.Contains([]string{"", ORDER_TOPO, ORDER_DATE, ORDER_AUTHOR_DATE}, opts.Order) {
+		return nil, fmt.Errorf("unknown order %q, expected topo, date or author-date", opts.Order)
+	}
+	if len(revs) == 0 {
+		revs = []string{"HEAD"}
+	}
+	var roots []string
+	for _, rev := range revs {
+		hash, err := r.resolveRev(rev)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, hash)
+	}
+	var commits []Commit
+	for commit := range r.walkLog(ctx, roots, opts) {
+		commits = append(commits, *commit)
+	}
+	return commits, ctx.Err()
+}
+
+// Formats a commit as its abbreviated name and subject line.
+func oneline(commit Commit) string {
+	subject, _, _ := strings.Cut(commit.Message, "\n")
+	return commit.Hash[:7] + " " + subject
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"regexp"
+	"strings"
+
+	"github.com/dagit/repofs"
+)
+
+// Proper Name <proper@email> Commit Name <commit@email>, every part but one email optional
+var mailmapRegex = regexp.MustCompile(`^([^<]*)<([^>]*)>(?:([^<]*)<([^>]*)>)?\s*$`)
+
+type mailmapEntry struct {
+	name  string
+	email string
+}
+
+// Maps the names and emails people committed with to their canonical identity, following
+// the .mailmap format described in gitmailmap(5).
+type Mailmap struct {
+	// keyed by lowercased commit email, then by commit name ("" matches any name)
+	entries map[string]map[string]mailmapEntry
+	// replaces canonical identities with pseudonyms
+	anonymize bool
+}
+
+// Parses mailmap lines into m. Later entries override earlier ones.
+func (m *Mailmap) parse(data string) {
+	if m.entries == nil {
+		m.entries = map[string]map[string]mailmapEntry{}
+	}
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		match := mailmapRegex.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+		properName, properEmail := strings.TrimSpace(match[1]), strings.TrimSpace(match[2])
+		commitName, commitEmail := strings.TrimSpace(match[3]), strings.TrimSpace(match[4])
+		if match[4] == "" && match[3] == "" {
+			// Proper Name <commit@email>
+			commitEmail, properEmail = properEmail, ""
+		}
+		key := strings.ToLower(commitEmail)
+		if m.entries[key] == nil {
+			m.entries[key] = map[string]mailmapEntry{}
+		}
+		m.entries[key][strings.ToLower(commitName)] = mailmapEntry{name: properName, email: properEmail}
+	}
+}
+
+// Returns the canonical identity of user, or its pseudonym when anonymizing. Users without
+// an entry are returned unchanged.
+func (m *Mailmap) resolve(user User) User {
+	if m == nil {
+		return user
+	}
+	if m.anonymize {
+		return pseudonym(m.canonical(user))
+	}
+	return m.canonical(user)
+}
+
+func (m *Mailmap) canonical(user User) User {
+	if len(m.entries) == 0 {
+		return user
+	}
+	name, email := strings.TrimSpace(user.Name), strings.Trim(user.Email, "<>")
+	byName, ok := m.entries[strings.ToLower(email)]
+	if !ok {
+		return user
+	}
+	entry, ok := byName[strings.ToLower(name)]
+	if !ok {
+		if entry, ok = byName[""]; !ok {
+			return user
+		}
+	}
+	if entry.name != "" {
+		name = entry.name
+	}
+	if entry.email != "" {
+		email = entry.email
+	}
+	return User{Name: name, Email: email}
+}
+
+// Returns commit with its author and committer resolved to their canonical identities.
+func (m *Mailmap) commit(commit Commit) Commit {
+	commit.Author = m.resolve(commit.Author)
+	commit.Committer = m.resolve(commit.Committer)
+	return commit
+}
+
+// Reads the .mailmap in HEAD's tree and the one at the root of the work tree, which takes
+// precedence. Returns nil when neither exists.
+func (r *Repo) loadMailmap() *Mailmap {
+	m := &Mailmap{}
+	if head, err := r.commitOf("HEAD"); err == nil {
+		if entry, ok := r.treeEntries(head.Tree)[".mailmap"]; ok {
+			if obj := r.getObject(entry.Hash); obj != nil && obj.Type == "blob" {
+				m.parse(string(obj.Bytes()))
+			}
+		}
+	}
+	if data, err := repofs.ReadFile(r.location + "/.mailmap"); err == nil {
+		m.parse(string(data))
+	}
+	if len(m.entries) == 0 {
+		return nil
+	}
+	return m
+}
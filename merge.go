@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/dagit/repofs"
+)
+
+const (
+	// a gitlink in one repo's tree pointing at a commit of another repo
+	EDGE_SUBMODULE = "submodule"
+	// the same blob stored in two repos
+	EDGE_IDENTICAL = "identical"
+)
+
+type mergedEdge struct {
+	Src  string `json:"src"`
+	Dest string `json:"dest"`
+	// set on edges between repos
+	Kind string `json:"kind,omitempty"`
+	// set on edges from trees to their entries
+	Mode      string `json:"mode,omitempty"`
+	EntryKind string `json:"entryKind,omitempty"`
+}
+
+// The graphs of several repos in one, every node name prefixed with its repo's namespace
+// (e.g. "api:<hash>") and a "repo" attribute.
+type mergedGraph struct {
+	SchemaVersion string           `json:"schemaVersion"`
+	Edges         []mergedEdge     `json:"edges"`
+	Nodes         []map[string]any `json:"nodes"`
+}
+
+// Returns a namespace per repo location from the base names of the locations, suffixed with
+// a number when two repos share a base name.
+func repoNamespaces(locations []string) []string {
+	namespaces := make([]string, len(locations))
+	used := map[string]int{}
+	for i, location := range locations {
+		abs, err := filepath.Abs(location)
+		if err != nil {
+			abs = location
+		}
+		name := filepath.Base(abs)
+		used[name]++
+		if used[name] > 1 {
+			name = fmt.Sprintf("%s-%d", name, used[name])
+		}
+		namespaces[i] = name
+	}
+	return namespaces
+}
+
+// Merges the graphs of repos selected with opts. Edges whose target is missing from a repo
+// but is a commit of another become submodule edges to it, and blobs found in several repos
+// are linked by identical edges from the first repo storing them.
+func mergeGraphs(ctx context.Context, repos []*Repo, namespaces []string, opts GraphOptions) (*mergedGraph, error) {
+	merged := &mergedGraph{SchemaVersion: SCHEMA_VERSION, Edges: []mergedEdge{}, Nodes: []map[string]any{}}
+	graphs := make([]graphJson, len(repos))
+	// node names to their type, per repo
+	types := make([]map[string]string, len(repos))
+	for i, r := range repos {
+		data, err := r.toJson(ctx, opts)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", namespaces[i], err)
+		}
+		if err := json.Unmarshal(data, &graphs[i]); err != nil {
+			return nil, fmt.Errorf("%s: %w", namespaces[i], err)
+		}
+		types[i] = map[string]string{}
+		for _, node := range graphs[i].Nodes {
+			name, _ := node["name"].(string)
+			type_, _ := node["type"].(string)
+			types[i][name] = strings.TrimPrefix(type_, "unreachable-")
+		}
+	}
+	qualify := func(i int, name string) string {
+		return namespaces[i] + ":" + name
+	}
+	for i, graph := range graphs {
+		for _, node := range graph.Nodes {
+			node["name"] = qualify(i, node["name"].(string))
+			node["repo"] = namespaces[i]
+			merged.Nodes = append(merged.Nodes, node)
+		}
+		for _, e := range graph.Edges {
+			if _, ok := types[i][e.Dest]; ok {
+				merged.Edges = append(merged.Edges, mergedEdge{Src: qualify(i, e.Src), Dest: qualify(i, e.Dest), Kind: e.Kind, Mode: e.Mode, EntryKind: e.EntryKind})
+				continue
+			}
+			for j := range graphs {
+				if t, _ := nodeObjectType(types[j][e.Dest]); j != i && t == "commit" {
+					merged.Edges = append(merged.Edges, mergedEdge{Src: qualify(i, e.Src), Dest: qualify(j, e.Dest), Kind: EDGE_SUBMODULE})
+				}
+			}
+		}
+	}
+	blobRepos := map[string][]int{}
+	for i := range graphs {
+		for name, type_ := range types[i] {
+			if type_ == "blob" {
+				blobRepos[name] = append(blobRepos[name], i)
+			}
+		}
+	}
+	for blob, in := range blobRepos {
+		slices.Sort(in)
+		for _, j := range in[1:] {
+			merged.Edges = append(merged.Edges, mergedEdge{Src: qualify(in[0], blob), Dest: qualify(j, blob), Kind: EDGE_IDENTICAL})
+		}
+	}
+	return merged, nil
+}
+
+// Writes the merged graph to a SQLite database with the export's objects table and edges
+// that have a kind.
+func (g *mergedGraph) toSQLite(path string) error {
+	if err := repofs.CheckWrite(path); err != nil {
+		return err
+	}
+	os.Remove(path)
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err := db.Exec(`create table objects (name text primary key, type text, object jsonb, attributes jsonb);`); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`create table edges (src text, dest text, kind text, mode text, entry_kind text);`); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, node := range g.Nodes {
+		object, attributes, err := nodeColumns(node)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("insert into objects(name, type, object, attributes) values(?, ?, ?, ?)", node["name"], node["type"], object, attributes); err != nil {
+			return err
+		}
+	}
+	for _, e := range g.Edges {
+		if _, err := tx.Exec("insert into edges(src, dest, kind, mode, entry_kind) values(?, ?, nullif(?, ''), nullif(?, ''), nullif(?, ''))", e.Src, e.Dest, e.Kind, e.Mode, e.EntryKind); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,87 @@
+package main
+
+type Degree struct {
+	In  int `json:"in"`
+	Out int `json:"out"`
+}
+
+// Structural statistics of a graph.
+type Metrics struct {
+	Nodes        int               `json:"nodes"`
+	Edges        int               `json:"edges"`
+	CountsByType map[string]int    `json:"countsByType"`
+	Degrees      map[string]Degree `json:"degrees"`
+	// length of the longest chain of commits
+	Depth        int     `json:"depth"`
+	MergeCommits int     `json:"mergeCommits"`
+	MergeRatio   float64 `json:"mergeRatio"`
+	// average number of entries per tree
+	AvgTreeFanout float64 `json:"avgTreeFanout"`
+	// tree entries pointing at blobs per distinct blob
+	BlobReuse float64 `json:"blobReuse"`
+}
+
+func ratio(a int, b int) float64 {
+	if b == 0 {
+		return 0
+	}
+	return float64(a) / float64(b)
+}
+
+// Computes metrics over the objects and edges selected by opts.
+func (r *Repo) metrics(opts GraphOptions) (*Metrics, error) {
+	sel, err := r.selectObjects(opts)
+	if err != nil {
+		return nil, err
+	}
+	m := &Metrics{
+		Nodes:        len(sel.objects),
+		CountsByType: map[string]int{},
+		Degrees:      map[string]Degree{},
+	}
+	var commits []Commit
+	treeEntries, blobRefs := 0, 0
+	for _, obj := range sel.objects {
+		m.CountsByType[obj.Type]++
+		switch obj.Type {
+		case "commit":
+			commit := parseCommit(obj)
+			commits = append(commits, commit)
+			if len(commit.Parents) > 1 {
+				m.MergeCommits++
+			}
+		case "tree":
+			for _, entry := range *parseTree(obj) {
+				treeEntries++
+				if dest := r.getObject(entry.Hash); dest != nil && dest.Type == "blob" && sel.has(entry.Hash) {
+					blobRefs++
+				}
+			}
+		}
+		for _, e := range obj.edges() {
+			if !sel.hasEdge(e) || r.getObject(e.Dest) == nil {
+				continue
+			}
+			m.Edges++
+			src, dest := m.Degrees[e.Src], m.Degrees[e.Dest]
+			src.Out++
+			m.Degrees[e.Src] = src
+			dest.In++
+			m.Degrees[e.Dest] = dest
+		}
+	}
+	// objects without edges still get a degree entry
+	for _, obj := range sel.objects {
+		if _, ok := m.Degrees[obj.Name]; !ok {
+			m.Degrees[obj.Name] = Degree{}
+		}
+	}
+	gens := r.generations(commits)
+	for _, c := range commits {
+		m.Depth = max(m.Depth, gens[c.Hash])
+	}
+	m.MergeRatio = ratio(m.MergeCommits, m.CountsByType["commit"])
+	m.AvgTreeFanout = ratio(treeEntries, m.CountsByType["tree"])
+	m.BlobReuse = ratio(blobRefs, m.CountsByType["blob"])
+	return m, nil
+}
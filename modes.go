@@ -0,0 +1,39 @@
+package main
+
+import "strconv"
+
+// The kinds of tree entries, decoded from their modes.
+const (
+	ENTRY_FILE       = "file"
+	ENTRY_EXECUTABLE = "executable"
+	ENTRY_SYMLINK    = "symlink"
+	// a submodule's commit
+	ENTRY_GITLINK   = "gitlink"
+	ENTRY_DIRECTORY = "directory"
+	// a mode git wouldn't write, e.g. from a corrupt or hand-made tree
+	ENTRY_UNKNOWN = "unknown"
+)
+
+// Decodes a tree entry's octal mode into its kind by the file type bits, like git does.
+// Directories are 40000 but some old trees have 040000, and old git versions wrote
+// regular files with group write permission (e.g. 100664), which are files too.
+func entryKind(mode string) string {
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return ENTRY_UNKNOWN
+	}
+	switch m & 0o170000 {
+	case 0o040000:
+		return ENTRY_DIRECTORY
+	case 0o120000:
+		return ENTRY_SYMLINK
+	case 0o160000:
+		return ENTRY_GITLINK
+	case 0o100000:
+		if m&0o111 != 0 {
+			return ENTRY_EXECUTABLE
+		}
+		return ENTRY_FILE
+	}
+	return ENTRY_UNKNOWN
+}
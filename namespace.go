@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/dagit/repofs"
+)
+
+// Checks a --namespace, which like GIT_NAMESPACE nests with / (e.g. team/project).
+func validNamespace(namespace string) error {
+	for _, part := range strings.Split(strings.Trim(namespace, "/"), "/") {
+		if part == "" && namespace != "" || part == "." || part == ".." {
+			return fmt.Errorf("invalid namespace %q", namespace)
+		}
+	}
+	return nil
+}
+
+// Returns where the refs of a namespace are stored, like git: a/b is stored under
+// refs/namespaces/a/refs/namespaces/b/. Empty without a namespace.
+func namespacePrefix(namespace string) string {
+	var prefix strings.Builder
+	for _, part := range strings.Split(strings.Trim(namespace, "/"), "/") {
+		if part != "" {
+			prefix.WriteString("refs/namespaces/" + part + "/")
+		}
+	}
+	return prefix.String()
+}
+
+// Maps a ref name in the repo's namespace to the name it's stored under. Pseudo-refs other
+// than HEAD are shared by every namespace.
+func (r *Repo) storedRef(name string) string {
+	if name != "HEAD" && !strings.HasPrefix(name, "refs/") {
+		return name
+	}
+	return namespacePrefix(r.opts.Namespace) + name
+}
+
+// Maps a stored ref name to its name in the repo's namespace. False for refs outside of it.
+func (r *Repo) namespacedRef(stored string) (string, bool) {
+	return strings.CutPrefix(stored, namespacePrefix(r.opts.Namespace))
+}
+
+// Maps the stored ref a symbolic ref's value points at into the namespace, as git stores
+// e.g. ref: refs/namespaces/a/refs/heads/main. Other values are returned as they are.
+func (r *Repo) namespacedValue(value string) string {
+	if target, ok := strings.CutPrefix(value, "ref:"); ok {
+		if name, ok := r.namespacedRef(strings.TrimSpace(target)); ok {
+			return "ref: " + name
+		}
+	}
+	return value
+}
+
+// Calls fn with the name in the namespace and the path of every loose ref in it.
+func (r *Repo) walkLooseRefs(fn func(name string, path string)) {
+	root := gitDir(r.location)
+	repofs.WalkDir(root+"/"+r.storedRef("refs/"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil
+		}
+		if name, ok := r.namespacedRef(filepath.ToSlash(rel)); ok {
+			fn(name, path)
+		}
+		return nil
+	})
+}
+
+// Returns the objects HEAD and every ref of every namespace hold, which keep objects from
+// being garbage collected whatever namespace is shown.
+func (r *Repo) refRoots() []string {
+	root := gitDir(r.location)
+	var roots []string
+	add := func(value string) {
+		if hash := strings.TrimSpace(value); hashRegex.MatchString(hash) {
+			roots = append(roots, hash)
+		}
+	}
+	if head, err := repofs.ReadFile(root + "/HEAD"); err == nil {
+		add(string(head))
+	}
+	stored, _ := r.readStoredPackedRefs()
+	for _, hash := range stored {
+		add(hash)
+	}
+	repofs.WalkDir(root+"/refs", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if value, err := repofs.ReadFile(path); err == nil {
+			add(string(value))
+		}
+		return nil
+	})
+	// symbolic refs, like namespaced HEADs, hold what the refs they point at hold
+	return roots
+}
@@ -28,21 +28,46 @@ function toObj(arr, keyFunc) {
     return rv;
   }
 
-function processData(data, currNodes) {
+// The ID the server saves this browser's node positions under.
+function clientId() {
+    let id = localStorage.getItem("dagit-client-id");
+    if (!id) {
+        id = crypto.randomUUID();
+        localStorage.setItem("dagit-client-id", id);
+    }
+    return id;
+}
+
+function saveLayout(nodes) {
+    let positions = {};
+    nodes.filter(n => n.fx !== undefined).forEach(n => positions[n.id] = { x: n.fx, y: n.fy, fixed: true });
+    fetch(`/api/layout/${clientId()}`, {
+        method: "PUT",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ positions: positions }),
+    }).catch(console.log);
+}
+
+function processData(data, currNodes, layout) {
     let treeEntries = {};
     const gData = {
         nodes: data.nodes.map(obj => {
-            let value = obj.type === "blob" ? obj.object.content: obj
-            let node = { id: obj.name, type: obj.type, value: value };
+            // blobs served without content (e.g. in demo mode) are shown like other objects
+            let value = obj.type === "blob" && "content" in obj.object ? obj.object.content: obj
+            let node = { id: obj.name, type: obj.type, value: value, reachable: obj.reachable !== false };
             if (node.id in currNodes) {
                 node = {...currNodes[node.id], ...node}
+            } else if (node.id in layout) {
+                // pinned where it was dragged before the page reloaded
+                node.fx = node.x = layout[node.id].x;
+                node.fy = node.y = layout[node.id].y;
             }
             if (node.type === "tree") {
                 node.value.object.entries.forEach(e => treeEntries[e.hash] = e);
             }
             return node;
         }),
-        links: data.edges.map(e => ({ source: e.src, target: e.dest }))
+        links: data.edges.map(e => ({ source: e.src, target: e.dest, kind: e.kind }))
     };
     return {gData: gData, treeEntries: treeEntries};
 }
@@ -54,6 +79,25 @@ const ForceGraph = () => {
     const [treeEntries, setTreeEntries] = useState({})
     const [modalNode, setModalNode] = useState({});
     const [show, setShow] = useState(false);
+    const [progress, setProgress] = useState(null);
+    const layout = useRef({});
+    // colors, labels and features from the server's /api/config
+    const [config, setConfig] = useState({ types: {}, features: { blobs: true } });
+    const style = (node) => config.types[node.type.replace(/^unreachable-/, "")] || {};
+
+    useEffect(() => {
+        fetch("/api/config")
+            .then(res => res.json())
+            .then(setConfig)
+            .catch(console.log);
+    }, []);
+
+    useEffect(() => {
+        fetch(`/api/layout/${clientId()}`)
+            .then(res => res.ok ? res.json() : { positions: {} })
+            .then(saved => layout.current = saved.positions)
+            .catch(console.log);
+    }, []);
 
     const { sendMessage, lastMessage, readyState } = useWebSocket("ws://localhost:8080/ws", {
         onOpen: () => {
@@ -61,7 +105,17 @@ const ForceGraph = () => {
         },
         onMessage: (e) => {
             let data = JSON.parse(e.data);
-            let {gData, treeEntries} = processData(data, toObj(graphData.nodes, n => n.id));
+            // progress of the server's jobs, shown until they finish
+            if (data.type === "progress") {
+                setProgress(data.job.finished ? null : data.job);
+                return;
+            }
+            // history events (ref rewrites, unreachable commits) are logged, not drawn
+            if (!data.nodes) {
+                console.log(data);
+                return;
+            }
+            let {gData, treeEntries} = processData(data, toObj(graphData.nodes, n => n.id), layout.current);
             setGraphData(gData);
             setTreeEntries(treeEntries)
         },
@@ -96,6 +150,7 @@ const ForceGraph = () => {
                         case "ref":
                             return -150
                         case "commit":
+                        case "octopus-merge":
                             return -100
                         case "tree":
                             return 0
@@ -109,25 +164,46 @@ const ForceGraph = () => {
 
     return (
     <div>
+        {progress && (
+            <div style={{position: "absolute", top: 10, left: 10, zIndex: 1, background: "white", borderRadius: 6, padding: 5}}>
+                {progress.kind.replace("-", " ")}: {progress.percent}%
+            </div>
+        )}
         <ForceGraph2D
             ref={fgRef}
-            graphData={graphData}
+            graphData={config.features.blobs ? graphData : {
+                // symlinks are blobs too
+                nodes: graphData.nodes.filter(n => !/(blob|symlink)$/.test(n.type)),
+                links: graphData.links.filter(l => !/(blob|symlink)$/.test(l.target.type || "")),
+            }}
             linkDirectionalArrowLength={5}
             linkDirectionalArrowRelPos={1}
             nodeRelSize={10}
             linkOpacity={.7}
+            // the blobs a commit introduced (--introduces) and symlink targets (--symlink-targets)
+            // stand out from tree entries
+            linkLineDash={l => l.kind === "introduces" || l.kind === "target" ? [4, 2] : null}
+            linkColor={l => l.kind === "introduces" ? "orange" : l.kind === "target" ? "teal" : "rgba(0,0,0,0.3)"}
             nodeAutoColorBy={(n) => n.type}
             nodeLabel={n => {
-                let style = "background-color:white; color:black; border-radius: 6px; padding:5px;";
-                return `<div style="'${style}'">Type: '${n.type}'<br>objectname: '${n.id}'</div>`;
+                let css = "background-color:white; color:black; border-radius: 6px; padding:5px;";
+                return `<div style="'${css}'">Type: '${style(n).label || n.type}'<br>objectname: '${n.id}'</div>`;
             }}
             onNodeDragEnd={node => {
                 node.fx = node.x;
                 node.fy = node.y;
+                saveLayout(graphData.nodes);
             }}
             onNodeClick={node => {
                 handleShow(true)
                 setModalNode(node)
+                // show what the commit changed against each parent
+                if (node.type === "commit" || node.type === "octopus-merge") {
+                    fetch(`/api/commits/${node.id}`)
+                        .then(res => res.json())
+                        .then(detail => setModalNode({...node, value: detail}))
+                        .catch(console.log);
+                }
             }}
             nodeCanvasObject={(node, ctx, globalScale) => {
                 if (node.type === "ref") {
@@ -142,15 +218,22 @@ const ForceGraph = () => {
         
                     ctx.textAlign = 'center';
                     ctx.textBaseline = 'middle';
-                    ctx.fillStyle = node.color;
+                    ctx.fillStyle = style(node).color || node.color;
                     ctx.fillText(label, node.x, node.y);
         
                     node.__bckgDimensions = bckgDimensions; // to re-use in nodePointerAreaPaint
                 } else {
-                    ctx.fillStyle = node.color;
+                    // grey out objects no ref reaches
+                    ctx.fillStyle = node.reachable ? style(node).color || node.color : "lightgray";
                     ctx.beginPath();
                     ctx.arc(node.x, node.y, 10, 0, 2 * Math.PI, false); 
                     ctx.fill();
+                    // ring the commit HEAD has checked out
+                    if (node.value.checkedOut) {
+                        ctx.lineWidth = 3 / globalScale;
+                        ctx.strokeStyle = "black";
+                        ctx.stroke();
+                    }
                 }
             }}
         />
@@ -159,7 +242,7 @@ const ForceGraph = () => {
             handleClose={handleClose} 
             name={modalNode.id}
             content={
-                modalNode.type !== "blob" ? 
+                typeof modalNode.value !== "string" ? 
                 <ReactJson src={modalNode.value} name={null} />: 
                 <SyntaxHighlighter language={extToLanguage(treeEntries[modalNode.id].name)} style={docco}>
                     {modalNode.value}
@@ -0,0 +1,207 @@
+// Generated by dagit gen types --lang ts for graph schema 1.7. Do not edit.
+
+export interface Graph {
+  /** the version of the graph format, major.minor */
+  schemaVersion?: string;
+  edges: Edge[] | null;
+  nodes: Node[] | null;
+  /** loose objects skipped because they couldn't be read */
+  parseErrors?: ParseError[];
+}
+
+/** A Git object, ref or placeholder. */
+export interface Node {
+  name: string;
+  /** commit, octopus-merge, tree, blob, tag, lfs-pointer, symlink, ref, more and others. Objects no ref reaches are prefixed with unreachable-. */
+  type: string;
+  object?: Commit | Tree | Blob | BlobDigest | Tag | LFSPointer | Symlink;
+  /** computed by --label-template */
+  label?: string;
+  /** the other properties, added by graph options, e.g. reachable, decorations or dedup */
+  [attribute: string]: unknown;
+}
+
+export interface Commit {
+  tree: string;
+  parents: string[] | null;
+  author: User;
+  committer: User;
+  message: string;
+  commitTime: string;
+  authorTime: string;
+  /** set when the subject follows the Conventional Commits spec */
+  conventional?: ConventionalCommit;
+}
+
+export interface Tree {
+  entries: TreeEntry[] | null;
+}
+
+export interface Blob {
+  content: string;
+  size: number;
+}
+
+/** A blob node without its content, for graphs that shouldn't leak source code. */
+export interface BlobDigest {
+  size: number;
+  /** the MIME type sniffed from the content, e.g. text/plain; charset=utf-8 */
+  contentType: string;
+  sha256: string;
+}
+
+export interface Tag {
+  object: string;
+  type: string;
+  tag: string;
+  tagger: User;
+  tagTime: string;
+  message: string;
+}
+
+/** A blob standing in for a file stored in Git LFS. */
+export interface LFSPointer {
+  version: string;
+  oid: string;
+  size: number;
+}
+
+/** A blob checked out as a symlink. */
+export interface Symlink {
+  target: string;
+}
+
+/**
+ * Something that happened to the repo's history between two refreshes: graph-diff,
+ * ref-created, ref-deleted, ref-fast-forward, ref-rewritten, commits-unreachable or checkout.
+ */
+export interface RepoEvent {
+  type: string;
+  time: string;
+  ref?: string;
+  old?: string;
+  new?: string;
+  commits?: string[];
+  /** set on checkout events that leave HEAD detached */
+  detached?: boolean;
+  /** the objects and refs that changed, set on graph-diff events */
+  diff?: SnapshotDiff;
+  message: string;
+}
+
+/**
+ * The progress of a job, as shown by the CLI and sent to websocket clients in messages of
+ * type progress.
+ */
+export interface Job {
+  id: string;
+  kind: string;
+  repo: string;
+  done: number;
+  total: number;
+  percent: number;
+  finished: boolean;
+  error?: string;
+  startedAt: string;
+}
+
+export interface Head {
+  type: string;
+  value: string;
+  /** the ref HEAD ends up at when Value is itself a symbolic ref */
+  target?: string;
+}
+
+export interface Branch {
+  name: string;
+  commit: string;
+}
+
+/** A lightweight or annotated tag ref. The target of an annotated tag is the tag object. */
+export interface TagRef {
+  name: string;
+  target: string;
+}
+
+/** A remote-tracking branch, e.g. refs/remotes/origin/main, with the remote it was fetched from. */
+export interface RemoteRef {
+  name: string;
+  target: string;
+  /** empty when no configured remote fetches into the ref */
+  remote?: string;
+  urls?: string[];
+  /** the branch on the remote, e.g. refs/heads/main */
+  branch?: string;
+}
+
+/** A pseudo-ref file in .git, e.g. FETCH_HEAD, and the objects it lists. */
+export interface PseudoRef {
+  name: string;
+  targets: PseudoRefTarget[] | null;
+}
+
+export interface Edge {
+  src: string;
+  dest: string;
+  /** set on edges that aren't references between objects, e.g. introduces edges */
+  kind?: string;
+  /** set on edges from trees to their entries */
+  mode?: string;
+  entryKind?: string;
+}
+
+/** A loose object that couldn't be read and was skipped. */
+export interface ParseError {
+  name: string;
+  location: string;
+  error: string;
+}
+
+export interface User {
+  name: string;
+  email: string;
+}
+
+/** The fields of a commit subject following the Conventional Commits spec. */
+export interface ConventionalCommit {
+  type: string;
+  scope?: string;
+  breaking: boolean;
+  subject: string;
+}
+
+export interface TreeEntry {
+  mode: string;
+  /** the mode decoded: file, executable, symlink, gitlink, directory or unknown */
+  kind: string;
+  name: string;
+  hash: string;
+}
+
+export interface SnapshotDiff {
+  from: string;
+  to: string;
+  added: SnapshotObject[] | null;
+  removed: SnapshotObject[] | null;
+  refs: RefChange[] | null;
+}
+
+export interface PseudoRefTarget {
+  hash: string;
+  /** FETCH_HEAD only: fetched refs that git pull won't merge */
+  notForMerge?: boolean;
+  /** FETCH_HEAD only: where the object was fetched from, e.g. branch 'main' of https://... */
+  description?: string;
+}
+
+export interface SnapshotObject {
+  name: string;
+  type: string;
+}
+
+/** A ref that was created, deleted or moved. Old is empty for created refs and New for deleted ones. */
+export interface RefChange {
+  ref: string;
+  old?: string;
+  new?: string;
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Decompressed object content, most recently used first, capped at a total size in bytes.
+type contentCache struct {
+	mu       sync.Mutex
+	capacity int64
+	size     int64
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type cachedContent struct {
+	name string
+	data []byte
+}
+
+// A cache holding up to capacity bytes of content. A capacity of 0 caches nothing.
+func newContentCache(capacity int64) *contentCache {
+	return &contentCache{capacity: capacity, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+func (c *contentCache) get(name string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[name]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*cachedContent).data, true
+}
+
+// Adds an object's content, evicting the least recently used content to make room. Content
+// larger than the whole cache isn't kept.
+func (c *contentCache) put(name string, data []byte) {
+	if c == nil || int64(len(data)) > c.capacity {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[name]; ok {
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[name] = c.order.PushFront(&cachedContent{name, data})
+	c.size += int64(len(data))
+	for c.size > c.capacity {
+		oldest := c.order.Back()
+		content := c.order.Remove(oldest).(*cachedContent)
+		delete(c.entries, content.name)
+		c.size -= int64(len(content.data))
+	}
+}
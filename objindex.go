@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Abbreviated object names are at least 4 hex digits, like git's core.abbrev minimum, and at
+// most a full SHA-256 name.
+var prefixRegex = regexp.MustCompile("^[a-fA-F0-9]{4,64}$")
+
+// An object a prefix could name.
+type ObjectCandidate struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// Returns the sorted names of the repo's objects, built on first use after each load.
+func (r *Repo) objectIndex() []string {
+	r.cacheMu.Lock()
+	defer r.cacheMu.Unlock()
+	if r.index == nil {
+		r.index = make([]string, 0, len(r.objects))
+		for name := range r.objects {
+			r.index = append(r.index, name)
+		}
+		slices.Sort(r.index)
+	}
+	return r.index
+}
+
+// Returns the object names starting with prefix.
+func (r *Repo) withPrefix(prefix string) []string {
+	index := r.objectIndex()
+	i := sort.SearchStrings(index, prefix)
+	j := i
+	for j < len(index) && strings.HasPrefix(index[j], prefix) {
+		j++
+	}
+	return index[i:j]
+}
+
+// Returns the full name of the object a unique prefix of its name abbreviates. A prefix
+// shared by several objects is an AmbiguousObjectError listing them.
+func (r *Repo) FindByPrefix(prefix string) (string, error) {
+	if !prefixRegex.MatchString(prefix) {
+		return "", &UsageError{fmt.Errorf("invalid object name %q, need 4 to 64 hex digits", prefix)}
+	}
+	prefix = strings.ToLower(prefix)
+	names := r.withPrefix(prefix)
+	switch len(names) {
+	case 0:
+		return "", fmt.Errorf("no object named %s", prefix)
+	case 1:
+		return names[0], nil
+	}
+	err := &AmbiguousObjectError{Prefix: prefix}
+	for _, name := range names {
+		err.Candidates = append(err.Candidates, ObjectCandidate{Name: name, Type: r.getObject(name).Type})
+	}
+	return "", err
+}
+
+// The reply to an "object <prefix>" websocket message: the object's node, or the error
+// looking it up with the candidates of an ambiguous prefix.
+type objectMessage struct {
+	Type       string            `json:"type"`
+	Prefix     string            `json:"prefix"`
+	Node       map[string]any    `json:"node,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	Candidates []ObjectCandidate `json:"candidates,omitempty"`
+}
+
+// Looks up the object prefix abbreviates and returns its node as the graph would show it.
+func lookupObject(target *Repo, prefix string) objectMessage {
+	msg := objectMessage{Type: "object", Prefix: prefix}
+	defer readLock(target)()
+	name, err := target.FindByPrefix(prefix)
+	if err == nil {
+		sel := &selection{mailmap: target.mailmap, noContent: graphOpts.NoContent, redact: graphOpts.Redact}
+		msg.Node, err = sel.node(target.getObject(name))
+	}
+	if err != nil {
+		msg.Error = err.Error()
+		var ambiguous *AmbiguousObjectError
+		if errors.As(err, &ambiguous) {
+			msg.Candidates = ambiguous.Candidates
+		}
+	}
+	return msg
+}
+
+// Serves the object a unique prefix of its name abbreviates. An ambiguous prefix is a 409
+// listing the candidates.
+func serveObject(w http.ResponseWriter, r *http.Request) {
+	target, err := requestRepo(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if target == nil {
+		http.Error(w, "object lookups need a live repo, not an imported graph", http.StatusNotImplemented)
+		return
+	}
+	msg := lookupObject(target, r.PathValue("prefix"))
+	status := http.StatusOK
+	switch {
+	case msg.Candidates != nil:
+		status = http.StatusConflict
+	case !prefixRegex.MatchString(msg.Prefix):
+		status = http.StatusBadRequest
+	case msg.Error != "":
+		status = http.StatusNotFound
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(msg); err != nil {
+		loggerFrom(r.Context()).Error("writing object", "error", err)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// The node type of commits with more than two parents.
+const OCTOPUS_MERGE = "octopus-merge"
+
+var (
+	// git merge's message for several heads, e.g. Merge branches 'a', 'b' and 'c' into main
+	octopusMessageRegex = regexp.MustCompile(`^Merge (?:remote-tracking )?(?:branches|tags|commits|branch|tag|commit) (.+?)(?: into \S+)?$`)
+	quotedRefRegex      = regexp.MustCompile(`'([^']+)'`)
+	// a strategy named in the message, e.g. -s ours or strategy: resolve
+	strategyRegex = regexp.MustCompile(`(?i)(?:-s|--strategy[= ]|\bstrategy:?)\s*(octopus|ours|resolve|recursive|ort|subtree)\b`)
+)
+
+// What an octopus merge node adds to the commit: its parent count, the merge strategy its
+// message hints at and the branches it merged, as named by git merge's default message.
+type OctopusMerge struct {
+	Parents int `json:"parents"`
+	// octopus for git merge's default message, which only the octopus strategy writes for
+	// several heads, or the strategy the message names
+	Strategy string   `json:"strategy,omitempty"`
+	Branches []string `json:"branches,omitempty"`
+}
+
+// Reads what an octopus merge's message tells about how it was made.
+func parseOctopusMerge(commit Commit) OctopusMerge {
+	octopus := OctopusMerge{Parents: len(commit.Parents)}
+	subject, _, _ := strings.Cut(commit.Message, "\n")
+	if match := octopusMessageRegex.FindStringSubmatch(strings.TrimSpace(subject)); match != nil {
+		for _, ref := range quotedRefRegex.FindAllStringSubmatch(match[1], -1) {
+			octopus.Branches = append(octopus.Branches, ref[1])
+		}
+		if len(octopus.Branches) > 0 {
+			octopus.Strategy = "octopus"
+		}
+	}
+	if match := strategyRegex.FindStringSubmatch(commit.Message); match != nil {
+		octopus.Strategy = strings.ToLower(match[1])
+	}
+	return octopus
+}
+
+// Marks the selected commits with more than two parents as octopus merge nodes, keeping the
+// unreachable- prefix, with an "octopus" attribute read from their redacted message.
+func (sel *selection) markOctopusMerges() {
+	for _, obj := range sel.objects {
+		if obj.Type != "commit" {
+			continue
+		}
+		commit := parseCommit(obj)
+		if len(commit.Parents) < 3 {
+			continue
+		}
+		if sel.redact != nil {
+			commit.Message = sel.redact(commit.Message)
+		}
+		type_ := OCTOPUS_MERGE
+		if reachable, ok := sel.attrs[obj.Name]["reachable"]; ok && reachable == false {
+			type_ = "unreachable-" + OCTOPUS_MERGE
+		}
+		sel.annotate(obj.Name, "type", type_)
+		sel.annotate(obj.Name, "octopus", parseOctopusMerge(commit))
+	}
+}
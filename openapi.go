@@ -0,0 +1,644 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// A minimal page rendering the OpenAPI document with Swagger UI from its CDN.
+const swaggerPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="utf-8">
+	<title>dagit API</title>
+	<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+	<div id="swagger-ui"></div>
+	<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js" crossorigin></script>
+	<script>
+		window.onload = () => {
+			window.ui = SwaggerUIBundle({ url: "/api/openapi.json", dom_id: "#swagger-ui" });
+		};
+	</script>
+</body>
+</html>
+`
+
+type object = map[string]any
+
+// Generates the query parameters of the graph options from the graph flags, so the API and
+// CLI document the same options.
+func graphParameters() []object {
+	var params []object
+	for _, flag := range graphFlags() {
+		var schema object
+		var usage string
+		switch f := flag.(type) {
+		case *cli.BoolFlag:
+			schema, usage = object{"type": "boolean"}, f.Usage
+		case *cli.IntFlag:
+			schema, usage = object{"type": "integer", "minimum": 0}, f.Usage
+		case *cli.StringSliceFlag:
+			schema, usage = object{"type": "array", "items": object{"type": "string"}}, f.Usage
+		case *cli.StringFlag:
+			schema, usage = object{"type": "string"}, f.Usage
+		default:
+			continue
+		}
+		params = append(params, object{
+			"name":        flag.Names()[0],
+			"in":          "query",
+			"description": strings.ReplaceAll(usage, "Can be passed multiple times.", "Can be repeated."),
+			"schema":      schema,
+		})
+	}
+	return params
+}
+
+func jsonResponse(description string, schema object) object {
+	return object{
+		"description": description,
+		"content":     object{"application/json": object{"schema": schema}},
+	}
+}
+
+var errorResponse = object{
+	"description": "An error message.",
+	"content":     object{"text/plain": object{"schema": object{"type": "string"}}},
+}
+
+var notModifiedResponse = object{"description": "The response for the request's If-None-Match ETag hasn't changed."}
+
+// Returns the OpenAPI document of the server's REST endpoints.
+func openAPI(version string) object {
+	ifNoneMatch := object{
+		"name":        "If-None-Match",
+		"in":          "header",
+		"description": "An ETag of a previous response. Unchanged responses are 304 Not Modified.",
+		"schema":      object{"type": "string"},
+	}
+	repoName := object{
+		"name":     "name",
+		"in":       "path",
+		"required": true,
+		"schema":   object{"type": "string"},
+	}
+	intMap := object{"type": "object", "additionalProperties": object{"type": "integer"}}
+	return object{
+		"openapi": "3.1.0",
+		"info": object{
+			"title":       "dagit",
+			"description": "The graph of a Git repo's objects and refs.",
+			"version":     version,
+		},
+		"paths": object{
+			"/api/graph": object{
+				"get": object{
+					"summary":     "The graph of the repo's objects and refs",
+					"description": "Query parameters override the server's graph options. Imported graphs (serve --from-graph or --from-sqlite) don't take any.",
+					"operationId": "getGraph",
+					"parameters":  append(graphParameters(), ifNoneMatch),
+					"responses": object{
+						"200": jsonResponse("The graph.", object{"$ref": "/api/graph.schema.json"}),
+						"304": notModifiedResponse,
+						"400": errorResponse,
+					},
+				},
+			},
+			"/api/metrics": object{
+				"get": object{
+					"summary":     "Structural metrics of the graph",
+					"operationId": "getMetrics",
+					"parameters":  append(graphParameters(), ifNoneMatch),
+					"responses": object{
+						"200": jsonResponse("The metrics.", object{"$ref": "#/components/schemas/Metrics"}),
+						"304": notModifiedResponse,
+						"400": errorResponse,
+						"501": errorResponse,
+					},
+				},
+			},
+			"/api/summary": object{
+				"get": object{
+					"summary":     "HEAD, object and ref counts, the latest commit and the size of the repo",
+					"operationId": "getSummary",
+					"parameters":  []object{ifNoneMatch},
+					"responses": object{
+						"200": jsonResponse("The summary.", object{"$ref": "#/components/schemas/Summary"}),
+						"304": notModifiedResponse,
+						"501": errorResponse,
+					},
+				},
+			},
+			"/api/export": object{
+				"post": object{
+					"summary":     "Start an export job",
+					"description": "Exports the graph server-side in the background. Poll the job at the returned Location and download the file once it's done. Finished exports are deleted after an hour.",
+					"operationId": "startExport",
+					"parameters": append([]object{
+						{"name": "format", "in": "query", "schema": object{"type": "string", "enum": []string{"json", "sqlite", "dot"}, "default": "json"}},
+						{"name": "repo", "in": "query", "description": "A watched repo to export instead of the server's.", "schema": object{"type": "string"}},
+					}, graphParameters()...),
+					"responses": object{
+						"202": jsonResponse("The started job.", object{"$ref": "#/components/schemas/ExportJob"}),
+						"400": errorResponse,
+						"404": errorResponse,
+						"501": errorResponse,
+					},
+				},
+			},
+			"/api/export/{id}": object{
+				"get": object{
+					"summary":     "The status or file of an export job",
+					"operationId": "getExport",
+					"parameters": []object{
+						{"name": "id", "in": "path", "required": true, "schema": object{"type": "string"}},
+						{"name": "download", "in": "query", "description": "Download the exported file instead of the job's status.", "schema": object{"type": "boolean"}},
+					},
+					"responses": object{
+						"200": object{
+							"description": "The job, or with download=true the exported file.",
+							"content": object{
+								"application/json":        object{"schema": object{"$ref": "#/components/schemas/ExportJob"}},
+								"application/vnd.sqlite3": object{"schema": object{"type": "string", "format": "binary"}},
+								"text/vnd.graphviz":       object{"schema": object{"type": "string"}},
+							},
+						},
+						"404": errorResponse,
+						"409": errorResponse,
+					},
+				},
+			},
+			"/api/objects/{prefix}": object{
+				"get": object{
+					"summary":     "Look up an object by a unique prefix of its name",
+					"description": "Returns the object's node as the graph shows it. Websocket clients can send \"object <prefix>\" for the same reply.",
+					"operationId": "getObject",
+					"parameters": []object{
+						{"name": "prefix", "in": "path", "required": true, "description": "An object name or a prefix of at least 4 hex digits.", "schema": object{"type": "string", "pattern": prefixRegex.String()}},
+					},
+					"responses": object{
+						"200": jsonResponse("The object's node.", object{"$ref": "#/components/schemas/ObjectLookup"}),
+						"400": jsonResponse("The prefix isn't 4 to 64 hex digits.", object{"$ref": "#/components/schemas/ObjectLookup"}),
+						"404": jsonResponse("No object has the prefix.", object{"$ref": "#/components/schemas/ObjectLookup"}),
+						"409": jsonResponse("Several objects have the prefix, listed as candidates.", object{"$ref": "#/components/schemas/ObjectLookup"}),
+						"501": errorResponse,
+					},
+				},
+			},
+			"/api/objects/{prefix}/raw": object{
+				"get": object{
+					"summary":     "The raw bytes of an object",
+					"description": "The object's exact decompressed bytes, its `type size\\0` header followed by its content, or its zlib compressed loose object file. The object's type and name are in the X-Git-Object-Type and X-Git-Object-Name headers. Turned off in demo mode and when the server anonymizes authors.",
+					"operationId": "getRawObject",
+					"parameters": []object{
+						{"name": "prefix", "in": "path", "required": true, "description": "An object name or a prefix of at least 4 hex digits.", "schema": object{"type": "string", "pattern": prefixRegex.String()}},
+						{"name": "compressed", "in": "query", "description": "Return the loose object file instead.", "schema": object{"type": "boolean"}},
+					},
+					"responses": object{
+						"200": object{
+							"description": "The object's bytes.",
+							"content":     object{"application/octet-stream": object{"schema": object{"type": "string", "format": "binary"}}},
+						},
+						"400": errorResponse,
+						"403": errorResponse,
+						"404": errorResponse,
+						"409": errorResponse,
+						"501": errorResponse,
+					},
+				},
+			},
+			"/api/commits/{hash}": object{
+				"get": object{
+					"summary":     "What a commit changed",
+					"description": "The parsed commit and its tree diff against each parent, with per-file line stats. Root commits are diffed against an empty tree.",
+					"operationId": "getCommit",
+					"parameters": []object{
+						{"name": "hash", "in": "path", "required": true, "description": "A commit hash or any revision that resolves to a commit.", "schema": object{"type": "string"}},
+						{"name": "find-renames", "in": "query", "description": "Similarity percentage above which a deleted and an added file are a rename.", "schema": object{"type": "integer", "minimum": 0, "maximum": 100, "default": 50}},
+						{"name": "find-copies", "in": "query", "description": "Also detect copies of files the commit didn't change.", "schema": object{"type": "boolean"}},
+					},
+					"responses": object{
+						"200": jsonResponse("The commit and its diffs.", object{"$ref": "#/components/schemas/CommitDetail"}),
+						"400": errorResponse,
+						"404": errorResponse,
+						"501": errorResponse,
+					},
+				},
+			},
+			"/api/config": object{
+				"get": object{
+					"summary":     "How UIs should draw the graph",
+					"description": "Colors and labels by node type and feature flags, from the [dagit] section of the repo's and the user's git config.",
+					"operationId": "getConfig",
+					"responses": object{
+						"200": jsonResponse("The UI config.", object{"$ref": "#/components/schemas/UIConfig"}),
+					},
+				},
+			},
+			"/api/layout/{clientID}": object{
+				"parameters": []object{
+					{"name": "clientID", "in": "path", "required": true, "description": "An ID the browser generates, up to 64 letters, digits, '_' and '-'.", "schema": object{"type": "string", "pattern": clientIDRegex.String()}},
+				},
+				"get": object{
+					"summary":     "The node positions a client saved",
+					"operationId": "getLayout",
+					"responses": object{
+						"200": jsonResponse("The layout.", object{"$ref": "#/components/schemas/Layout"}),
+						"400": errorResponse,
+						"404": errorResponse,
+					},
+				},
+				"put": object{
+					"summary":     "Save a client's node positions",
+					"description": "Replaces the client's layout. The server sets clientId and updated.",
+					"operationId": "putLayout",
+					"requestBody": object{
+						"required": true,
+						"content":  object{"application/json": object{"schema": object{"$ref": "#/components/schemas/Layout"}}},
+					},
+					"responses": object{
+						"200": jsonResponse("The saved layout.", object{"$ref": "#/components/schemas/Layout"}),
+						"400": errorResponse,
+						"403": errorResponse,
+					},
+				},
+			},
+			"/api/repos": object{
+				"get": object{
+					"summary":     "The watched repos",
+					"operationId": "listRepos",
+					"responses": object{
+						"200": jsonResponse("The repos, the one the server was started with marked primary.", object{"type": "array", "items": object{"$ref": "#/components/schemas/WatchedRepo"}}),
+					},
+				},
+			},
+			"/api/repos/{name}/graph": object{
+				"get": object{
+					"summary":     "The graph of a watched repo",
+					"operationId": "getRepoGraph",
+					"parameters":  append([]object{repoName}, append(graphParameters(), ifNoneMatch)...),
+					"responses": object{
+						"200": jsonResponse("The graph.", object{"$ref": "/api/graph.schema.json"}),
+						"304": notModifiedResponse,
+						"400": errorResponse,
+						"404": errorResponse,
+					},
+				},
+			},
+			"/api/repos/{name}/metrics": object{
+				"get": object{
+					"summary":     "Structural metrics of a watched repo's graph",
+					"operationId": "getRepoMetrics",
+					"parameters":  append([]object{repoName}, append(graphParameters(), ifNoneMatch)...),
+					"responses": object{
+						"200": jsonResponse("The metrics.", object{"$ref": "#/components/schemas/Metrics"}),
+						"304": notModifiedResponse,
+						"400": errorResponse,
+						"404": errorResponse,
+					},
+				},
+			},
+			"/api/repos/{name}/summary": object{
+				"get": object{
+					"summary":     "HEAD, object and ref counts, the latest commit and the size of a watched repo",
+					"operationId": "getRepoSummary",
+					"parameters":  []object{repoName, ifNoneMatch},
+					"responses": object{
+						"200": jsonResponse("The summary.", object{"$ref": "#/components/schemas/Summary"}),
+						"304": notModifiedResponse,
+						"404": errorResponse,
+					},
+				},
+			},
+			"/api/admin/repos": object{
+				"post": object{
+					"summary":     "Start watching a repo",
+					"description": "Needs the server's --admin-token. The name defaults to the repo's directory name.",
+					"operationId": "addRepo",
+					"security":    []object{{"adminToken": []string{}}},
+					"requestBody": object{
+						"required": true,
+						"content": object{"application/json": object{"schema": object{
+							"type":     "object",
+							"required": []string{"path"},
+							"properties": object{
+								"name": object{"type": "string", "pattern": repoNameRegex.String()},
+								"path": object{"type": "string"},
+							},
+						}}},
+					},
+					"responses": object{
+						"201": jsonResponse("The added repo.", object{"$ref": "#/components/schemas/WatchedRepo"}),
+						"400": errorResponse,
+						"401": errorResponse,
+						"404": errorResponse,
+						"409": errorResponse,
+						"500": errorResponse,
+					},
+				},
+			},
+			"/api/admin/repos/{name}": object{
+				"delete": object{
+					"summary":     "Stop watching a repo",
+					"description": "Needs the server's --admin-token. The repo the server was started with can't be removed.",
+					"operationId": "removeRepo",
+					"security":    []object{{"adminToken": []string{}}},
+					"parameters":  []object{repoName},
+					"responses": object{
+						"204": object{"description": "The repo is no longer watched."},
+						"401": errorResponse,
+						"404": errorResponse,
+						"409": errorResponse,
+					},
+				},
+			},
+			"/debug/stats": object{
+				"get": object{
+					"summary":     "Runtime and repo diagnostics",
+					"operationId": "getStats",
+					"responses": object{
+						"200": jsonResponse("The diagnostics.", object{"$ref": "#/components/schemas/Stats"}),
+					},
+				},
+			},
+		},
+		"components": object{
+			"securitySchemes": object{
+				"adminToken": object{"type": "http", "scheme": "bearer"},
+			},
+			"schemas": object{
+				"ExportJob": object{
+					"type":     "object",
+					"required": []string{"id", "format", "status", "createdAt"},
+					"properties": object{
+						"id":         object{"type": "string"},
+						"format":     object{"type": "string", "enum": []string{"json", "sqlite", "dot"}},
+						"repo":       object{"type": "string"},
+						"status":     object{"type": "string", "enum": []string{EXPORT_RUNNING, EXPORT_DONE, EXPORT_FAILED}},
+						"error":      object{"type": "string"},
+						"bytes":      object{"type": "integer"},
+						"createdAt":  object{"type": "string", "format": "date-time"},
+						"finishedAt": object{"type": "string", "format": "date-time"},
+						"download":   object{"type": "string"},
+					},
+				},
+				"ObjectLookup": object{
+					"type":     "object",
+					"required": []string{"type", "prefix"},
+					"properties": object{
+						"type":   object{"type": "string", "enum": []string{"object"}},
+						"prefix": object{"type": "string"},
+						"node":   object{"type": "object"},
+						"error":  object{"type": "string"},
+						"candidates": object{
+							"type": "array",
+							"items": object{
+								"type": "object",
+								"properties": object{
+									"name": object{"type": "string"},
+									"type": object{"type": "string"},
+								},
+							},
+						},
+					},
+				},
+				"CommitDetail": object{
+					"type":     "object",
+					"required": []string{"hash", "tree", "parents", "diffs"},
+					"properties": object{
+						"hash":       object{"type": "string"},
+						"tree":       object{"type": "string"},
+						"parents":    object{"type": "array", "items": object{"type": "string"}},
+						"author":     object{"type": "object"},
+						"committer":  object{"type": "object"},
+						"message":    object{"type": "string"},
+						"commitTime": object{"type": "string", "format": "date-time"},
+						"authorTime": object{"type": "string", "format": "date-time"},
+						"diffs": object{
+							"type": "array",
+							"items": object{
+								"type": "object",
+								"properties": object{
+									"parent": object{"type": "string"},
+									"changes": object{
+										"type": "array",
+										"items": object{
+											"type":     "object",
+											"required": []string{"path", "status"},
+											"properties": object{
+												"path":       object{"type": "string"},
+												"status":     object{"type": "string", "enum": []string{ADDED, DELETED, MODIFIED, RENAMED, COPIED}},
+												"oldHash":    object{"type": "string"},
+												"newHash":    object{"type": "string"},
+												"oldMode":    object{"type": "string"},
+												"newMode":    object{"type": "string"},
+												"oldPath":    object{"type": "string"},
+												"similarity": object{"type": "integer"},
+												"additions":  object{"type": "integer"},
+												"deletions":  object{"type": "integer"},
+												"binary":     object{"type": "boolean"},
+											},
+										},
+									},
+									"stats": object{
+										"type": "object",
+										"properties": object{
+											"files":     object{"type": "integer"},
+											"additions": object{"type": "integer"},
+											"deletions": object{"type": "integer"},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+				"UIConfig": object{
+					"type": "object",
+					"properties": object{
+						"types": object{
+							"type": "object",
+							"additionalProperties": object{
+								"type": "object",
+								"properties": object{
+									"color": object{"type": "string"},
+									"label": object{"type": "string"},
+								},
+							},
+						},
+						"features": object{
+							"type": "object",
+							"properties": object{
+								"blobs":  object{"type": "boolean"},
+								"reflog": object{"type": "boolean"},
+							},
+						},
+						"files": object{"type": "array", "items": object{"type": "string"}},
+					},
+				},
+				"Layout": object{
+					"type":     "object",
+					"required": []string{"positions"},
+					"properties": object{
+						"clientId": object{"type": "string"},
+						"updated":  object{"type": "string", "format": "date-time"},
+						"positions": object{
+							"type": "object",
+							"additionalProperties": object{
+								"type":     "object",
+								"required": []string{"x", "y"},
+								"properties": object{
+									"x":     object{"type": "number"},
+									"y":     object{"type": "number"},
+									"fixed": object{"type": "boolean"},
+								},
+							},
+						},
+					},
+				},
+				"WatchedRepo": object{
+					"type": "object",
+					"properties": object{
+						"name":     object{"type": "string"},
+						"path":     object{"type": "string"},
+						"objects":  object{"type": "integer"},
+						"loadedAt": object{"type": "string", "format": "date-time"},
+						"primary":  object{"type": "boolean"},
+					},
+				},
+				"Metrics": object{
+					"type": "object",
+					"properties": object{
+						"nodes":        object{"type": "integer"},
+						"edges":        object{"type": "integer"},
+						"countsByType": intMap,
+						"degrees": object{
+							"type": "object",
+							"additionalProperties": object{
+								"type":       "object",
+								"properties": object{"in": object{"type": "integer"}, "out": object{"type": "integer"}},
+							},
+						},
+						"depth":         object{"type": "integer", "description": "The length of the longest chain of commits."},
+						"mergeCommits":  object{"type": "integer"},
+						"mergeRatio":    object{"type": "number"},
+						"avgTreeFanout": object{"type": "number", "description": "The average number of entries per tree."},
+						"blobReuse":     object{"type": "number", "description": "Tree entries pointing at blobs per distinct blob."},
+					},
+				},
+				"Summary": object{
+					"type":     "object",
+					"required": []string{"head", "objects", "branches", "tags", "size"},
+					"properties": object{
+						"head": object{
+							"type": "object",
+							"properties": object{
+								"type":   object{"type": "string"},
+								"value":  object{"type": "string"},
+								"target": object{"type": "string"},
+							},
+						},
+						"branch":   object{"type": "string", "description": "The branch HEAD is on, missing when it's detached."},
+						"objects":  object{"allOf": []object{intMap}, "description": "The loaded loose objects by type."},
+						"branches": object{"type": "integer"},
+						"tags":     object{"type": "integer"},
+						"latestCommit": object{
+							"type":        "object",
+							"description": "The most recently committed commit a ref or HEAD points at.",
+							"properties": object{
+								"hash":       object{"type": "string"},
+								"refs":       object{"type": "array", "items": object{"type": "string"}},
+								"tree":       object{"type": "string"},
+								"parents":    object{"type": "array", "items": object{"type": "string"}},
+								"author":     object{"type": "object"},
+								"committer":  object{"type": "object"},
+								"message":    object{"type": "string"},
+								"commitTime": object{"type": "string", "format": "date-time"},
+								"authorTime": object{"type": "string", "format": "date-time"},
+							},
+						},
+						"size": object{"type": "integer", "description": "Bytes on disk of the loose and packed objects."},
+					},
+				},
+				"Stats": object{
+					"type": "object",
+					"properties": object{
+						"heapAlloc":     object{"type": "integer"},
+						"heapInuse":     object{"type": "integer"},
+						"heapObjects":   object{"type": "integer"},
+						"sys":           object{"type": "integer"},
+						"numGC":         object{"type": "integer"},
+						"goroutines":    object{"type": "integer"},
+						"objects":       object{"type": "integer"},
+						"objectsByType": intMap,
+						"lastRefresh":   object{"type": "string", "format": "date-time"},
+						"lastRefreshMs": object{"type": "integer"},
+						"profile": object{
+							"type":        "object",
+							"description": "The slowest objects and packs to read, with --profile-objects.",
+							"properties": object{
+								"loose":           object{"type": "integer"},
+								"looseDurationNs": object{"type": "integer"},
+								"objects": object{
+									"type": "array",
+									"items": object{
+										"type": "object",
+										"properties": object{
+											"name":       object{"type": "string"},
+											"type":       object{"type": "string"},
+											"pack":       object{"type": "string"},
+											"size":       object{"type": "integer"},
+											"deltaDepth": object{"type": "integer"},
+											"durationNs": object{"type": "integer"},
+										},
+									},
+								},
+								"packs": object{
+									"type": "array",
+									"items": object{
+										"type": "object",
+										"properties": object{
+											"pack":          object{"type": "string"},
+											"objects":       object{"type": "integer"},
+											"maxDeltaDepth": object{"type": "integer"},
+											"durationNs":    object{"type": "integer"},
+											"error":         object{"type": "string"},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// Serves the OpenAPI document.
+func serveOpenAPI(version string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(openAPI(version)); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// Serves the graph JSON schema the OpenAPI document references.
+func serveGraphSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Write(graphSchema)
+}
+
+// Serves the Swagger UI page.
+func serveAPIDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(swaggerPage))
+}
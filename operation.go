@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/dagit/repofs"
+)
+
+// The node type and name of the merge, rebase, cherry-pick or revert in progress.
+const OPERATION = "operation"
+
+// A merge, rebase, am, cherry-pick or revert that stopped part way, e.g. on a conflict.
+type Operation struct {
+	// merge, rebase, rebase-interactive, am, cherry-pick or revert
+	Kind string `json:"kind"`
+	// the branch being rebased
+	Branch string `json:"branch,omitempty"`
+	// the commit a rebase replays onto
+	Onto string `json:"onto,omitempty"`
+	// where the branch was before a rebase started
+	OrigHead string `json:"origHead,omitempty"`
+	// the commits being merged, picked or reverted, or the commit a rebase stopped at
+	Targets []string `json:"targets"`
+	// the step a rebase or am is at, counting from 1, and the number of steps
+	Step  int `json:"step,omitempty"`
+	Steps int `json:"steps,omitempty"`
+	// the prepared commit message, e.g. from MERGE_MSG
+	Message string `json:"message,omitempty"`
+}
+
+// Reads a file in .git, trimmed. Missing files are empty.
+func (r *Repo) readGitFile(name string) string {
+	data, err := repofs.ReadFile(gitDir(r.location) + "/" + name)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func (r *Repo) gitPathExists(name string) bool {
+	_, err := repofs.Stat(gitDir(r.location) + "/" + name)
+	return err == nil
+}
+
+// Detects the operation in progress from the state git leaves in .git, or returns nil.
+func (r *Repo) operation() *Operation {
+	step := func(name string) int {
+		n, _ := strconv.Atoi(r.readGitFile(name))
+		return n
+	}
+	targets := func(name string) []string {
+		var hashes []string
+		for _, line := range strings.Split(r.readGitFile(name), "\n") {
+			if hash := strings.TrimSpace(line); hashRegex.MatchString(hash) {
+				hashes = append(hashes, hash)
+			}
+		}
+		return hashes
+	}
+	var op *Operation
+	switch {
+	case r.gitPathExists("rebase-merge"):
+		op = &Operation{
+			Kind:     "rebase",
+			Branch:   strings.TrimPrefix(r.readGitFile("rebase-merge/head-name"), "refs/heads/"),
+			Onto:     r.readGitFile("rebase-merge/onto"),
+			OrigHead: r.readGitFile("rebase-merge/orig-head"),
+			Targets:  targets("rebase-merge/stopped-sha"),
+			Step:     step("rebase-merge/msgnum"),
+			Steps:    step("rebase-merge/end"),
+			Message:  r.readGitFile("rebase-merge/message"),
+		}
+		if r.gitPathExists("rebase-merge/interactive") {
+			op.Kind = "rebase-interactive"
+		}
+	case r.gitPathExists("rebase-apply"):
+		op = &Operation{
+			Kind:     "rebase",
+			Branch:   strings.TrimPrefix(r.readGitFile("rebase-apply/head-name"), "refs/heads/"),
+			Onto:     r.readGitFile("rebase-apply/onto"),
+			OrigHead: r.readGitFile("rebase-apply/orig-head"),
+			Step:     step("rebase-apply/next"),
+			Steps:    step("rebase-apply/last"),
+		}
+		// git am also uses rebase-apply
+		if r.gitPathExists("rebase-apply/applying") {
+			op.Kind = "am"
+		}
+	case r.gitPathExists("MERGE_HEAD"):
+		op = &Operation{Kind: "merge", Targets: targets("MERGE_HEAD")}
+	case r.gitPathExists("CHERRY_PICK_HEAD"):
+		op = &Operation{Kind: "cherry-pick", Targets: targets("CHERRY_PICK_HEAD")}
+	case r.gitPathExists("REVERT_HEAD"):
+		op = &Operation{Kind: "revert", Targets: targets("REVERT_HEAD")}
+	default:
+		return nil
+	}
+	if op.Message == "" {
+		op.Message = r.readGitFile("MERGE_MSG")
+	}
+	if op.Targets == nil {
+		op.Targets = []string{}
+	}
+	return op
+}
+
+// Returns the operation node and its edges to the commits it's working on, or nil when
+// nothing is in progress. When selected is not nil, edges to commits outside of it are left out.
+func (r *Repo) operationNode(selected map[string]bool) (map[string]any, []Edge) {
+	op := r.operation()
+	if op == nil {
+		return nil, nil
+	}
+	var edges []Edge
+	for _, hash := range append([]string{op.Onto}, op.Targets...) {
+		if hash != "" && (selected == nil || selected[hash]) {
+			edges = append(edges, Edge{Src: OPERATION, Dest: hash})
+		}
+	}
+	return map[string]any{"name": OPERATION, "type": OPERATION, "object": op}, edges
+}
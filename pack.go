@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/dagit/repofs"
+)
+
+// Object types of pack entries. Deltas store their object as changes to a base object.
+const (
+	PACK_COMMIT    = 1
+	PACK_TREE      = 2
+	PACK_BLOB      = 3
+	PACK_TAG       = 4
+	PACK_OFS_DELTA = 6
+	PACK_REF_DELTA = 7
+)
+
+var packTypeNames = map[int]string{
+	PACK_COMMIT:    "commit",
+	PACK_TREE:      "tree",
+	PACK_BLOB:      "blob",
+	PACK_TAG:       "tag",
+	PACK_OFS_DELTA: "ofs-delta",
+	PACK_REF_DELTA: "ref-delta",
+}
+
+// A version 2 pack index (.idx): the names of a pack's objects, sorted, with their offsets
+// in the pack and the CRC32 of their packed data.
+type packIndex struct {
+	fanout  [256]uint32
+	names   []string
+	crcs    []uint32
+	offsets []int64
+	// the checksum of the pack the index is for
+	packChecksum []byte
+}
+
+// Returns the .pack and .idx paths of a pack given either of them.
+func packPaths(path string) (string, string) {
+	base := strings.TrimSuffix(strings.TrimSuffix(path, ".pack"), ".idx")
+	return base + ".pack", base + ".idx"
+}
+
+// Lists the .pack files of a repo.
+func (r *Repo) packs() ([]string, error) {
+	return filepath.Glob(filepath.Join(gitDir(r.location), "objects", "pack", "*.pack"))
+}
+
+// Reads a version 2 pack index, checking its own checksum and layout.
+func readPackIndex(path string) (*packIndex, error) {
+	data, err := repofs.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// magic, version, fanout, then the pack and index checksums at the end
+	if len(data) < 8+256*4+2*HASH_SIZE {
+		return nil, fmt.Errorf("%s: too short for a pack index", path)
+	}
+	if !bytes.Equal(data[:4], []byte("\377tOc")) {
+		return nil, fmt.Errorf("%s: version 1 pack indexes aren't supported", path)
+	}
+	if version := binary.BigEndian.Uint32(data[4:8]); version != 2 {
+		return nil, fmt.Errorf("%s: unsupported pack index version %d", path, version)
+	}
+	end := len(data) - HASH_SIZE
+	if sum := sha1.Sum(data[:end]); !bytes.Equal(sum[:], data[end:]) {
+		return nil, fmt.Errorf("%s: index checksum mismatch", path)
+	}
+	idx := &packIndex{packChecksum: data[end-HASH_SIZE : end]}
+	pos := 8
+	for i := range idx.fanout {
+		idx.fanout[i] = binary.BigEndian.Uint32(data[pos:])
+		if i > 0 && idx.fanout[i] < idx.fanout[i-1] {
+			return nil, fmt.Errorf("%s: fanout table isn't sorted", path)
+		}
+		pos += 4
+	}
+	count := int(idx.fanout[255])
+	// names, CRCs and 32-bit offsets
+	if pos+count*(HASH_SIZE+8) > end-HASH_SIZE {
+		return nil, fmt.Errorf("%s: truncated for %d objects", path, count)
+	}
+	for i := range count {
+		name := data[pos+i*HASH_SIZE : pos+(i+1)*HASH_SIZE]
+		idx.names = append(idx.names, hex.EncodeToString(name))
+		if i > 0 && idx.names[i] <= idx.names[i-1] {
+			return nil, fmt.Errorf("%s: object names aren't sorted", path)
+		}
+		// the fanout counts the objects whose first byte is at most its index
+		if first := int(name[0]); uint32(i) >= idx.fanout[first] || (first > 0 && uint32(i) < idx.fanout[first-1]) {
+			return nil, fmt.Errorf("%s: fanout table doesn't match object %s", path, idx.names[i])
+		}
+	}
+	pos += count * HASH_SIZE
+	for i := range count {
+		idx.crcs = append(idx.crcs, binary.BigEndian.Uint32(data[pos+i*4:]))
+	}
+	pos += count * 4
+	large := pos + count*4
+	for i := range count {
+		offset := binary.BigEndian.Uint32(data[pos+i*4:])
+		if offset&0x80000000 == 0 {
+			idx.offsets = append(idx.offsets, int64(offset))
+			continue
+		}
+		// the offset is in the table of 64-bit offsets
+		at := large + int(offset&0x7fffffff)*8
+		if at+8 > end-HASH_SIZE {
+			return nil, fmt.Errorf("%s: large offset of %s out of range", path, idx.names[i])
+		}
+		idx.offsets = append(idx.offsets, int64(binary.BigEndian.Uint64(data[at:])))
+	}
+	return idx, nil
+}
+
+// The header of an object in a pack.
+type packEntry struct {
+	offset int64
+	type_  int
+	// inflated size of the object, or of the delta
+	size int64
+	// where the compressed data starts
+	dataOffset int64
+	// the offset of an ofs-delta's base or the name of a ref-delta's base
+	baseOffset int64
+	baseName   string
+}
+
+// Parses the header of the pack entry at offset.
+func parsePackEntry(pack []byte, offset int64) (packEntry, error) {
+	entry := packEntry{offset: offset}
+	pos := offset
+	next := func() (byte, error) {
+		if pos >= int64(len(pack)) {
+			return 0, fmt.Errorf("entry at %d is truncated", offset)
+		}
+		pos++
+		return pack[pos-1], nil
+	}
+	c, err := next()
+	if err != nil {
+		return entry, err
+	}
+	// type in bits 4-6, then the size in little-endian groups of 7 bits
+	entry.type_ = int(c>>4) & 7
+	entry.size = int64(c & 0x0f)
+	for shift := 4; c&0x80 != 0; shift += 7 {
+		if c, err = next(); err != nil {
+			return entry, err
+		}
+		entry.size |= int64(c&0x7f) << shift
+	}
+	switch entry.type_ {
+	case PACK_OFS_DELTA:
+		// the distance back to the base in big-endian groups of 7 bits, each continuation adding one
+		if c, err = next(); err != nil {
+			return entry, err
+		}
+		distance := int64(c & 0x7f)
+		for c&0x80 != 0 {
+			if c, err = next(); err != nil {
+				return entry, err
+			}
+			distance = (distance+1)<<7 | int64(c&0x7f)
+		}
+		entry.baseOffset = offset - distance
+		if entry.baseOffset < 12 {
+			return entry, fmt.Errorf("entry at %d has a base before the start of the pack", offset)
+		}
+	case PACK_REF_DELTA:
+		if pos+int64(HASH_SIZE) > int64(len(pack)) {
+			return entry, fmt.Errorf("entry at %d is truncated", offset)
+		}
+		entry.baseName = hex.EncodeToString(pack[pos : pos+int64(HASH_SIZE)])
+		pos += int64(HASH_SIZE)
+	case PACK_COMMIT, PACK_TREE, PACK_BLOB, PACK_TAG:
+	default:
+		return entry, fmt.Errorf("entry at %d has invalid type %d", offset, entry.type_)
+	}
+	entry.dataOffset = pos
+	return entry, nil
+}
+
+// Reads a pack file, checking its header. Returns the data and the number of objects the
+// header declares.
+func readPack(path string) ([]byte, int, error) {
+	pack, err := repofs.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(pack) < 12+HASH_SIZE || !bytes.Equal(pack[:4], []byte("PACK")) {
+		return nil, 0, fmt.Errorf("%s: not a pack file", path)
+	}
+	if version := binary.BigEndian.Uint32(pack[4:8]); version != 2 && version != 3 {
+		return nil, 0, fmt.Errorf("%s: unsupported pack version %d", path, version)
+	}
+	return pack, int(binary.BigEndian.Uint32(pack[8:12])), nil
+}
+
+// Reports whether a pack's trailing checksum matches its content.
+func packChecksumOK(pack []byte) bool {
+	end := len(pack) - HASH_SIZE
+	sum := sha1.Sum(pack[:end])
+	return bytes.Equal(sum[:], pack[end:])
+}
+
+// The entries of a pack in pack order, with the size of their packed data.
+func packEntries(pack []byte, idx *packIndex) ([]packEntry, []int64, error) {
+	order := make([]int, len(idx.offsets))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int { return int(idx.offsets[a] - idx.offsets[b]) })
+	end := int64(len(pack) - HASH_SIZE)
+	entries := make([]packEntry, len(order))
+	packed := make([]int64, len(order))
+	for n, i := range order {
+		next := end
+		if n+1 < len(order) {
+			next = idx.offsets[order[n+1]]
+		}
+		if idx.offsets[i] < 12 || idx.offsets[i] >= next {
+			return nil, nil, fmt.Errorf("object %s has invalid offset %d", idx.names[i], idx.offsets[i])
+		}
+		entry, err := parsePackEntry(pack[:next], idx.offsets[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		entries[i], packed[i] = entry, next-idx.offsets[i]
+	}
+	return entries, packed, nil
+}
+
+// Inflates the data of a pack entry: the object, or the delta for delta entries.
+func inflatePackEntry(pack []byte, entry packEntry) ([]byte, error) {
+	reader, err := zlib.NewReader(bytes.NewReader(pack[entry.dataOffset:]))
+	if err != nil {
+		return nil, fmt.Errorf("entry at %d: %w", entry.offset, err)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("entry at %d: %w", entry.offset, err)
+	}
+	if int64(len(data)) != entry.size {
+		return nil, fmt.Errorf("entry at %d: inflated to %d bytes instead of %d", entry.offset, len(data), entry.size)
+	}
+	return data, nil
+}
+
+// Reads a little-endian base-128 size from the start of a delta.
+func deltaSize(delta []byte) (int, []byte, error) {
+	size, shift := 0, 0
+	for i, c := range delta {
+		size |= int(c&0x7f) << shift
+		shift += 7
+		if c&0x80 == 0 {
+			return size, delta[i+1:], nil
+		}
+	}
+	return 0, nil, errors.New("truncated delta size")
+}
+
+// Rebuilds an object from its base and a delta: the base and result sizes followed by
+// instructions copying ranges of the base or inserting new bytes.
+func applyDelta(base []byte, delta []byte) ([]byte, error) {
+	baseSize, delta, err := deltaSize(delta)
+	if err != nil {
+		return nil, err
+	}
+	if baseSize != len(base) {
+		return nil, fmt.Errorf("delta expects a %d byte base, got %d", baseSize, len(base))
+	}
+	size, delta, err := deltaSize(delta)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]byte, 0, size)
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		switch {
+		case op&0x80 != 0:
+			// bits 0-3 say which offset bytes follow and bits 4-6 which size bytes
+			var offset, n int
+			for bit := range 7 {
+				if op&(1<<bit) == 0 {
+					continue
+				}
+				if len(delta) == 0 {
+					return nil, errors.New("truncated delta copy")
+				}
+				if bit < 4 {
+					offset |= int(delta[0]) << (8 * bit)
+				} else {
+					n |= int(delta[0]) << (8 * (bit - 4))
+				}
+				delta = delta[1:]
+			}
+			if n == 0 {
+				n = 0x10000
+			}
+			if offset+n > len(base) {
+				return nil, errors.New("delta copies past the end of its base")
+			}
+			result = append(result, base[offset:offset+n]...)
+		case op != 0:
+			if int(op) > len(delta) {
+				return nil, errors.New("truncated delta insert")
+			}
+			result = append(result, delta[:op]...)
+			delta = delta[op:]
+		default:
+			return nil, errors.New("invalid delta instruction 0")
+		}
+	}
+	if len(result) != size {
+		return nil, fmt.Errorf("delta produced %d bytes instead of %d", len(result), size)
+	}
+	return result, nil
+}
+
+// Reads the objects of a pack, resolving delta chains. Bases are kept only until the last
+// delta using them is resolved.
+type packReader struct {
+	pack     []byte
+	idx      *packIndex
+	entries  []packEntry
+	byOffset map[int64]int
+	// the number of deltas not yet read per base, and the resolved bases
+	pending map[int]int
+	bases   map[int]packBase
+}
+
+type packBase struct {
+	type_ string
+	data  []byte
+}
+
+// Opens a pack (given its .pack or .idx path) for reading, checking its checksums.
+func openPack(path string) (*packReader, error) {
+	packPath, idxPath := packPaths(path)
+	idx, err := readPackIndex(idxPath)
+	if err != nil {
+		return nil, err
+	}
+	pack, _, err := readPack(packPath)
+	if err != nil {
+		return nil, err
+	}
+	if !packChecksumOK(pack) || !bytes.Equal(idx.packChecksum, pack[len(pack)-HASH_SIZE:]) {
+		return nil, fmt.Errorf("%s: pack checksum mismatch", packPath)
+	}
+	entries, _, err := packEntries(pack, idx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", packPath, err)
+	}
+	p := &packReader{pack: pack, idx: idx, entries: entries, byOffset: map[int64]int{}, pending: map[int]int{}, bases: map[int]packBase{}}
+	for i, entry := range entries {
+		p.byOffset[entry.offset] = i
+	}
+	for i := range entries {
+		if b, ok := p.base(i); ok {
+			p.pending[b]++
+		}
+	}
+	return p, nil
+}
+
+// Returns the index of the base of a delta entry.
+func (p *packReader) base(i int) (int, bool) {
+	switch p.entries[i].type_ {
+	case PACK_OFS_DELTA:
+		b, ok := p.byOffset[p.entries[i].baseOffset]
+		return b, ok
+	case PACK_REF_DELTA:
+		return slices.BinarySearch(p.idx.names, p.entries[i].baseName)
+	}
+	return -1, false
+}
+
+// Returns the type and content of the i-th object of the index.
+func (p *packReader) object(i int) (string, []byte, error) {
+	type_, data, err := p.resolve(i, 0)
+	// one less delta needs its base
+	if b, ok := p.base(i); ok {
+		p.pending[b]--
+		if p.pending[b] <= 0 {
+			delete(p.bases, b)
+		}
+	}
+	return type_, data, err
+}
+
+func (p *packReader) resolve(i int, depth int) (string, []byte, error) {
+	if base, ok := p.bases[i]; ok {
+		return base.type_, base.data, nil
+	}
+	if depth > len(p.entries) {
+		return "", nil, fmt.Errorf("object %s: delta chain cycle", p.idx.names[i])
+	}
+	entry := p.entries[i]
+	var type_ string
+	var data []byte
+	if entry.type_ != PACK_OFS_DELTA && entry.type_ != PACK_REF_DELTA {
+		inflated, err := inflatePackEntry(p.pack, entry)
+		if err != nil {
+			return "", nil, err
+		}
+		type_, data = packTypeNames[entry.type_], inflated
+	} else {
+		b, ok := p.base(i)
+		if !ok {
+			return "", nil, fmt.Errorf("object %s: delta base not in the pack", p.idx.names[i])
+		}
+		baseType, base, err := p.resolve(b, depth+1)
+		if err != nil {
+			return "", nil, err
+		}
+		delta, err := inflatePackEntry(p.pack, entry)
+		if err != nil {
+			return "", nil, err
+		}
+		if data, err = applyDelta(base, delta); err != nil {
+			return "", nil, fmt.Errorf("object %s: %w", p.idx.names[i], err)
+		}
+		type_ = baseType
+	}
+	if p.pending[i] > 0 {
+		p.bases[i] = packBase{type_, data}
+	}
+	return type_, data, nil
+}
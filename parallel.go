@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Runs task over every item in data using at most workers goroutines. The results are
+// returned in the same order as data. The first error returned by a task cancels the
+// context passed to the remaining tasks and is returned.
+func parallelWork[T any, R any](ctx context.Context, data []T, task func(context.Context, T) (R, error), workers int) ([]R, error) {
+	results := make([]R, len(data))
+	g, gCtx := errgroup.WithContext(ctx)
+	g.SetLimit(max(workers, 1))
+	for i, item := range data {
+		if gCtx.Err() != nil {
+			break
+		}
+		g.Go(func() error {
+			result, err := task(gCtx, item)
+			if err != nil {
+				return err
+			}
+			results[i] = result
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Splits data into chunks of at most size items. A size less than 1 returns a single chunk.
+func chunks[T any](data []T, size int) [][]T {
+	if size < 1 || size >= len(data) {
+		return [][]T{data}
+	}
+	var out [][]T
+	for start := 0; start < len(data); start += size {
+		stop := min(start+size, len(data))
+		out = append(out, data[start:stop])
+	}
+	return out
+}
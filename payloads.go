@@ -0,0 +1,30 @@
+package main
+
+import "github.com/dagit/graphjson"
+
+// The JSON payloads of graphs and the API are defined in graphjson, for Go programs reading
+// dagit's output.
+type (
+	Edge               = graphjson.Edge
+	User               = graphjson.User
+	Commit             = graphjson.Commit
+	ConventionalCommit = graphjson.ConventionalCommit
+	TreeEntry          = graphjson.TreeEntry
+	Blob               = graphjson.Blob
+	BlobDigest         = graphjson.BlobDigest
+	Tag                = graphjson.Tag
+	LFSPointer         = graphjson.LFSPointer
+	Symlink            = graphjson.Symlink
+	ParseError         = graphjson.ParseError
+	Head               = graphjson.Head
+	Branch             = graphjson.Branch
+	TagRef             = graphjson.TagRef
+	RemoteRef          = graphjson.RemoteRef
+	PseudoRef          = graphjson.PseudoRef
+	PseudoRefTarget    = graphjson.PseudoRefTarget
+	RepoEvent          = graphjson.RepoEvent
+	SnapshotObject     = graphjson.SnapshotObject
+	RefChange          = graphjson.RefChange
+	SnapshotDiff       = graphjson.SnapshotDiff
+	Job                = graphjson.Job
+)
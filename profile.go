@@ -0,0 +1,134 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The number of objects and packs an object profile lists.
+const PROFILE_TOP = 10
+
+// How long reading an object took: reading and decompressing a loose object file, or
+// inflating a packed object and applying its delta chain.
+type ObjectTiming struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// the pack holding the object, empty for loose objects
+	Pack string `json:"pack,omitempty"`
+	// compressed bytes of a loose object, decompressed bytes of a packed one
+	Size int64 `json:"size"`
+	// the number of deltas applied to a packed object
+	DeltaDepth int           `json:"deltaDepth,omitempty"`
+	Duration   time.Duration `json:"durationNs"`
+}
+
+// How long reading every object of a pack took, including checking its checksums.
+type PackTiming struct {
+	Pack          string        `json:"pack"`
+	Objects       int           `json:"objects"`
+	MaxDeltaDepth int           `json:"maxDeltaDepth"`
+	Duration      time.Duration `json:"durationNs"`
+	// set when the pack couldn't be read to the end
+	Error string `json:"error,omitempty"`
+}
+
+// The slowest objects and packs to read, set by --profile-objects, to find the blobs and
+// delta chains that make loading a repo slow.
+type ObjectProfile struct {
+	Loose int `json:"loose"`
+	// summed over the workers reading them in parallel
+	LooseDuration time.Duration `json:"looseDurationNs"`
+	// the slowest loose and packed objects, slowest first
+	Objects []ObjectTiming `json:"objects"`
+	// slowest first
+	Packs []PackTiming `json:"packs"`
+}
+
+// Builds the profile of a load from the timings of its loose objects, reading every pack to
+// time its objects too. Packs aren't otherwise read on load, so they're only timed here.
+func (r *Repo) profileObjects(loose []ObjectTiming) *ObjectProfile {
+	profile := &ObjectProfile{Loose: len(loose), Objects: slices.Clone(loose), Packs: []PackTiming{}}
+	for _, t := range loose {
+		profile.LooseDuration += t.Duration
+	}
+	paths, _ := r.packs()
+	for _, path := range paths {
+		pack, objects := timePack(path)
+		profile.Packs = append(profile.Packs, pack)
+		profile.Objects = append(profile.Objects, objects...)
+	}
+	slices.SortFunc(profile.Objects, func(a, b ObjectTiming) int {
+		return cmp.Or(cmp.Compare(b.Duration, a.Duration), strings.Compare(a.Name, b.Name))
+	})
+	slices.SortFunc(profile.Packs, func(a, b PackTiming) int {
+		return cmp.Or(cmp.Compare(b.Duration, a.Duration), strings.Compare(a.Pack, b.Pack))
+	})
+	profile.Objects = profile.Objects[:min(PROFILE_TOP, len(profile.Objects))]
+	profile.Packs = profile.Packs[:min(PROFILE_TOP, len(profile.Packs))]
+	return profile
+}
+
+// Reads every object of a pack, timing each.
+func timePack(path string) (PackTiming, []ObjectTiming) {
+	name := filepath.Base(path)
+	pack := PackTiming{Pack: name}
+	start := time.Now()
+	p, err := openPack(path)
+	if err != nil {
+		pack.Error = err.Error()
+		pack.Duration = time.Since(start)
+		return pack, nil
+	}
+	objects := make([]ObjectTiming, 0, len(p.entries))
+	for i := range p.entries {
+		depth := 0
+		for b, ok := p.base(i); ok && depth <= len(p.entries); b, ok = p.base(b) {
+			depth++
+		}
+		objectStart := time.Now()
+		type_, data, err := p.object(i)
+		if err != nil {
+			pack.Error = err.Error()
+			break
+		}
+		objects = append(objects, ObjectTiming{
+			Name:       p.idx.names[i],
+			Type:       type_,
+			Pack:       name,
+			Size:       int64(len(data)),
+			DeltaDepth: depth,
+			Duration:   time.Since(objectStart),
+		})
+		pack.MaxDeltaDepth = max(pack.MaxDeltaDepth, depth)
+	}
+	pack.Objects = len(objects)
+	pack.Duration = time.Since(start)
+	return pack, objects
+}
+
+func writeObjectProfile(w io.Writer, profile *ObjectProfile) {
+	fmt.Fprintf(w, "read %d loose objects in %s summed over workers\n", profile.Loose, profile.LooseDuration.Round(time.Microsecond))
+	fmt.Fprintln(w, "slowest objects:")
+	for _, t := range profile.Objects {
+		where := "loose"
+		if t.Pack != "" {
+			where = fmt.Sprintf("%s, delta depth %d", t.Pack, t.DeltaDepth)
+		}
+		fmt.Fprintf(w, "  %10s  %-6s %s  %s (%s)\n", t.Duration.Round(time.Microsecond), t.Type, t.Name[:7], formatSize(t.Size), where)
+	}
+	if len(profile.Packs) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "slowest packs:")
+	for _, p := range profile.Packs {
+		fmt.Fprintf(w, "  %10s  %s  %d objects, max delta depth %d\n", p.Duration.Round(time.Microsecond), p.Pack, p.Objects, p.MaxDeltaDepth)
+		if p.Error != "" {
+			fmt.Fprintf(w, "    error: %s\n", p.Error)
+		}
+	}
+}
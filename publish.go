@@ -0,0 +1,146 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	defaultNatsSubject = "dagit.events"
+	defaultKafkaTopic  = "dagit-events"
+)
+
+// Publishes repo events to a message bus.
+type Publisher interface {
+	Publish(ctx context.Context, e RepoEvent) error
+	Close() error
+}
+
+// Connects to the message bus of a publish URL:
+//
+//	nats://host:4222/subject
+//	kafka://broker1:9092,broker2:9092/topic
+//
+// The subject defaults to dagit.events and the topic to dagit-events.
+func newPublisher(rawURL string) (Publisher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("publish url %q: %w", rawURL, err)
+	}
+	name := strings.Trim(u.Path, "/")
+	switch u.Scheme {
+	case "nats", "tls":
+		target := *u
+		target.Path = ""
+		conn, err := nats.Connect(target.String())
+		if err != nil {
+			return nil, fmt.Errorf("connecting to %s: %w", u.Host, err)
+		}
+		return &natsPublisher{conn: conn, subject: cmp.Or(name, defaultNatsSubject)}, nil
+	case "kafka":
+		return &kafkaPublisher{writer: &kafka.Writer{
+			Addr:                   kafka.TCP(strings.Split(u.Host, ",")...),
+			Topic:                  cmp.Or(name, defaultKafkaTopic),
+			Balancer:               &kafka.Hash{},
+			AllowAutoTopicCreation: true,
+		}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported publish url %q, expected nats:// or kafka://", rawURL)
+	}
+}
+
+type natsPublisher struct {
+	conn    *nats.Conn
+	subject string
+}
+
+// Publishes the event under the subject suffixed with its type, e.g. dagit.events.ref-rewritten.
+func (p *natsPublisher) Publish(ctx context.Context, e RepoEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return p.conn.Publish(p.subject+"."+e.Type, data)
+}
+
+func (p *natsPublisher) Close() error {
+	return p.conn.Drain()
+}
+
+type kafkaPublisher struct {
+	writer *kafka.Writer
+}
+
+// Publishes the event keyed by its ref so the moves of a ref stay ordered within a partition.
+func (p *kafkaPublisher) Publish(ctx context.Context, e RepoEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return p.writer.WriteMessages(ctx, kafka.Message{
+		Key:     []byte(e.Ref),
+		Value:   data,
+		Headers: []kafka.Header{{Key: "type", Value: []byte(e.Type)}},
+	})
+}
+
+func (p *kafkaPublisher) Close() error {
+	return p.writer.Close()
+}
+
+// Connects a publisher for each URL. Publishers already connected are closed on error.
+func newPublishers(urls []string) ([]Publisher, error) {
+	var publishers []Publisher
+	for _, u := range urls {
+		p, err := newPublisher(u)
+		if err != nil {
+			closePublishers(publishers)
+			return nil, err
+		}
+		publishers = append(publishers, p)
+	}
+	return publishers, nil
+}
+
+// Connects the --publish URLs of a command and opens its --journal for the repo at location.
+func repoPublishers(cCtx *cli.Context, location string) ([]Publisher, error) {
+	publishers, err := newPublishers(cCtx.StringSlice("publish"))
+	if err != nil {
+		return nil, err
+	}
+	if path := cCtx.String("journal"); path != "" {
+		j, err := openJournal(path, location)
+		if err != nil {
+			closePublishers(publishers)
+			return nil, fmt.Errorf("journal %s: %w", path, err)
+		}
+		publishers = append(publishers, j)
+	}
+	return publishers, nil
+}
+
+func closePublishers(publishers []Publisher) {
+	for _, p := range publishers {
+		p.Close()
+	}
+}
+
+// Publishes the events of every refresh of the repo.
+func (r *Repo) publishTo(ctx context.Context, publishers []Publisher) {
+	r.onEvent(func(e RepoEvent) {
+		for _, p := range publishers {
+			if err := p.Publish(ctx, e); err != nil {
+				log.Printf("publishing %s event: %v", e.Type, err)
+			}
+		}
+	})
+}
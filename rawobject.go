@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/dagit/repofs"
+)
+
+// Returns the object as git hashes it, its `type size\0` header followed by its content,
+// exactly as decompressed from the object file, to compare with git cat-file.
+func (obj *Object) rawBytes() ([]byte, error) {
+	if obj.compressed != nil {
+		return decompress(obj.compressed)
+	}
+	return inflate(obj.Location)
+}
+
+// Returns the zlib compressed object file, which git reads as a loose object.
+func (obj *Object) looseBytes() ([]byte, error) {
+	if obj.compressed != nil {
+		return obj.compressed, nil
+	}
+	return repofs.ReadFile(obj.Location)
+}
+
+// Returns the raw or, with compressed, the loose object bytes of an object.
+func (obj *Object) rawObject(compressed bool) ([]byte, error) {
+	var data []byte
+	var err error
+	if compressed {
+		data, err = obj.looseBytes()
+	} else {
+		data, err = obj.rawBytes()
+	}
+	if err != nil {
+		return nil, &CorruptObjectError{Name: obj.Name, Location: obj.Location, Err: err}
+	}
+	return data, nil
+}
+
+// Serves the raw bytes of the object named by a unique prefix, or its loose object file
+// with ?compressed=true, as application/octet-stream with the object's type and name in
+// X-Git-Object-Type and X-Git-Object-Name.
+func serveRawObject(w http.ResponseWriter, r *http.Request) {
+	target, err := requestRepo(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if target == nil {
+		http.Error(w, "raw objects need a live repo, not an imported graph", http.StatusNotImplemented)
+		return
+	}
+	defer readLock(target)()
+	if target.opts.AnonymizeAuthors {
+		http.Error(w, "raw objects can't be anonymized", http.StatusForbidden)
+		return
+	}
+	compressed := false
+	if c := r.URL.Query().Get("compressed"); c != "" {
+		if compressed, err = strconv.ParseBool(c); err != nil {
+			http.Error(w, fmt.Sprintf("invalid compressed %q", c), http.StatusBadRequest)
+			return
+		}
+	}
+	name, err := target.FindByPrefix(r.PathValue("prefix"))
+	var ambiguous *AmbiguousObjectError
+	var usage *UsageError
+	switch {
+	case errors.As(err, &ambiguous):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.As(err, &usage):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	obj := target.getObject(name)
+	data, err := obj.rawObject(compressed)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("X-Git-Object-Type", obj.Type)
+	w.Header().Set("X-Git-Object-Name", obj.Name)
+	if _, err := w.Write(data); err != nil {
+		loggerFrom(r.Context()).Error("writing raw object", "error", err)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// What redacted text is replaced with.
+const REDACTED = "[REDACTED]"
+
+// Rewrites blob content and commit and tag messages before they're exported or served.
+type Redactor func(text string) string
+
+type redactRule struct {
+	regex *regexp.Regexp
+	// the group replaced, 0 for the whole match
+	group      int
+	minEntropy float64
+}
+
+// Returns a Redactor replacing the matches of patterns with REDACTED. With secrets set the
+// default secret rules are applied too, only redacting matches scan-secrets would report.
+func newRedactor(patterns []string, secrets bool) (Redactor, error) {
+	var rules []redactRule
+	for _, pattern := range patterns {
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("redact pattern %q: %w", pattern, err)
+		}
+		rules = append(rules, redactRule{regex: regex})
+	}
+	if secrets {
+		secretRules, err := loadSecretRules("")
+		if err != nil {
+			return nil, err
+		}
+		for _, rule := range secretRules {
+			rules = append(rules, redactRule{regex: rule.regex, group: rule.Group, minEntropy: rule.MinEntropy})
+		}
+	}
+	if rules == nil {
+		return nil, nil
+	}
+	return func(text string) string {
+		for _, rule := range rules {
+			text = rule.apply(text)
+		}
+		return text
+	}, nil
+}
+
+func (rule redactRule) apply(text string) string {
+	matches := rule.regex.FindAllStringSubmatchIndex(text, -1)
+	if matches == nil {
+		return text
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[2*rule.group], m[2*rule.group+1]
+		if start < 0 || shannonEntropy(text[start:end]) < rule.minEntropy {
+			continue
+		}
+		b.WriteString(text[last:start])
+		b.WriteString(REDACTED)
+		last = end
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// Collects every --redact pattern as given. A string slice flag would split patterns on commas.
+type patternList []string
+
+func (p *patternList) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
+func (p *patternList) String() string {
+	return strings.Join(*p, " ")
+}
+
+// Applies redact to the message of a commit or tag node, or to the content of a blob node.
+// Binary blobs are left as they are.
+func redactNode(obj *Object, object map[string]json.RawMessage, redact Redactor) error {
+	field := "message"
+	if obj.Type == "blob" {
+		field = "content"
+	} else if obj.Type != "commit" && obj.Type != "tag" {
+		return nil
+	}
+	raw, ok := object[field]
+	if !ok {
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err != nil {
+		return err
+	}
+	if obj.Type == "blob" && strings.ContainsRune(text[:min(len(text), 8000)], 0) {
+		return nil
+	}
+	redacted, err := json.Marshal(redact(text))
+	if err != nil {
+		return err
+	}
+	object[field] = redacted
+	return nil
+}
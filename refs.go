@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/dagit/repofs"
+)
+
+// The node type of FETCH_HEAD, ORIG_HEAD, MERGE_HEAD and the other pseudo-refs.
+const PSEUDO_REF = "pseudo-ref"
+
+var (
+	hashRegex = regexp.MustCompile("^[a-fA-F0-9]{40}$")
+	// HEAD, FETCH_HEAD, ORIG_HEAD, etc.
+	pseudoRefRegex = regexp.MustCompile("^[A-Z_]+$")
+)
+
+// Reads .git/packed-refs into a map of ref name to object name.
+func (r *Repo) packedRefs() map[string]string {
+	refs, _ := r.readPackedRefs()
+	return refs
+}
+
+// Reads the packed refs of the repo's namespace into maps of ref name to object name and of
+// annotated tag ref name to the object the tag peels to.
+func (r *Repo) readPackedRefs() (map[string]string, map[string]string) {
+	stored, storedPeeled := r.readStoredPackedRefs()
+	refs, peeled := map[string]string{}, map[string]string{}
+	for ref, hash := range stored {
+		if name, ok := r.namespacedRef(ref); ok {
+			refs[name] = hash
+			if p, ok := storedPeeled[ref]; ok {
+				peeled[name] = p
+			}
+		}
+	}
+	return refs, peeled
+}
+
+// Reads .git/packed-refs like readPackedRefs, by the names refs are stored under.
+func (r *Repo) readStoredPackedRefs() (map[string]string, map[string]string) {
+	refs, peeled := map[string]string{}, map[string]string{}
+	f, err := repofs.Open(gitDir(r.location) + "/packed-refs")
+	if err != nil {
+		return refs, peeled
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	last := ""
+	for scanner.Scan() {
+		line := scanner.Text()
+		// skip the header
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		// the peeled value of the ref on the previous line
+		if hash, ok := strings.CutPrefix(line, "^"); ok {
+			if last != "" {
+				peeled[last] = hash
+			}
+			continue
+		}
+		hash, name, found := strings.Cut(line, " ")
+		if found {
+			refs[name] = hash
+			last = name
+		}
+	}
+	return refs, peeled
+}
+
+// The most symbolic refs followed in a chain, like git's SYMREF_MAXDEPTH.
+const maxSymrefDepth = 5
+
+// Reads a full ref name's value: an object name, or "ref: " and the ref it points at for a
+// symbolic ref. Loose refs take precedence over packed ones.
+func (r *Repo) readRef(name string) (string, bool) {
+	bytes, err := repofs.ReadFile(gitDir(r.location) + "/" + r.storedRef(name))
+	if err == nil {
+		value := strings.TrimSpace(string(bytes))
+		// FETCH_HEAD and MERGE_HEAD can list several objects, the first one is used
+		value, _, _ = strings.Cut(value, "\n")
+		hash, _, _ := strings.Cut(value, "\t")
+		return r.namespacedValue(strings.TrimSpace(hash)), true
+	}
+	hash, ok := r.packedRefs()[name]
+	return hash, ok
+}
+
+// Follows the chain of symbolic refs starting at a full ref name, e.g. HEAD to
+// refs/heads/main to refs/heads/trunk. Returns the refs after name in order and the object
+// the last one holds, which is empty when that ref doesn't exist, like an unborn branch.
+// Chains that loop or are longer than maxSymrefDepth are errors.
+func (r *Repo) ResolveSymref(name string) ([]string, string, error) {
+	var chain []string
+	seen := map[string]bool{name: true}
+	for ref := name; ; {
+		value, ok := r.readRef(ref)
+		if !ok {
+			return chain, "", nil
+		}
+		target, symbolic := strings.CutPrefix(value, "ref:")
+		if !symbolic {
+			return chain, value, nil
+		}
+		ref = strings.TrimSpace(target)
+		path := strings.Join(append([]string{name}, append(chain, ref)...), " -> ")
+		if seen[ref] {
+			return chain, "", fmt.Errorf("symbolic refs loop: %s", path)
+		}
+		if len(chain) == maxSymrefDepth {
+			return chain, "", fmt.Errorf("symbolic ref chain %s is longer than %d refs", path, maxSymrefDepth)
+		}
+		seen[ref] = true
+		chain = append(chain, ref)
+	}
+}
+
+// Resolves a full ref name (e.g. refs/heads/main) to an object name, following symbolic
+// refs.
+func (r *Repo) resolveRef(name string) (string, bool) {
+	_, hash, err := r.ResolveSymref(name)
+	return hash, err == nil && hash != ""
+}
+
+// Resolves a revision (full object name, HEAD, branch, tag or ref name) to an object name.
+func (r *Repo) resolveRev(rev string) (string, error) {
+	if rev == "" {
+		return "", errors.New("empty revision")
+	}
+	if hashRegex.MatchString(rev) {
+		if r.getObject(strings.ToLower(rev)) == nil {
+			return "", fmt.Errorf("object %s not found", rev)
+		}
+		return strings.ToLower(rev), nil
+	}
+	// same lookup order as git rev-parse
+	candidates := []string{"refs/" + rev, "refs/tags/" + rev, "refs/heads/" + rev, "refs/remotes/" + rev}
+	if pseudoRefRegex.MatchString(rev) || strings.HasPrefix(rev, "refs/") {
+		candidates = append([]string{rev}, candidates...)
+	}
+	for _, candidate := range candidates {
+		_, hash, err := r.ResolveSymref(candidate)
+		if err != nil {
+			return "", err
+		}
+		if hash != "" {
+			return hash, nil
+		}
+	}
+	// like git, refs win over abbreviated object names
+	if prefixRegex.MatchString(rev) {
+		return r.FindByPrefix(rev)
+	}
+	return "", fmt.Errorf("unknown revision %q", rev)
+}
+
+// Returns every ref (loose and packed) of the repo's namespace by full name, resolved to an
+// object name.
+func (r *Repo) allRefs() map[string]string {
+	refs := r.packedRefs()
+	r.walkLooseRefs(func(name string, _ string) {
+		if hash, ok := r.resolveRef(name); ok {
+			refs[name] = hash
+		}
+	})
+	return refs
+}
+
+// Returns the names of every object reachable from HEAD or any ref, including the refs of
+// other namespaces.
+func (r *Repo) reachableFromRefs() map[string]bool {
+	return r.reachable(r.refRoots())
+}
+
+// Returns the lightweight and annotated tags sorted by name.
+func (r *Repo) tags() []TagRef {
+	var tags []TagRef
+	for name, hash := range r.allRefs() {
+		if tag, ok := strings.CutPrefix(name, "refs/tags/"); ok {
+			tags = append(tags, TagRef{Name: tag, Target: hash})
+		}
+	}
+	slices.SortFunc(tags, func(a, b TagRef) int { return strings.Compare(a.Name, b.Name) })
+	return tags
+}
+
+// Resolves a revision to a commit, peeling annotated tags.
+func (r *Repo) commitOf(rev string) (Commit, error) {
+	hash, err := r.resolveRev(rev)
+	if err != nil {
+		return Commit{}, err
+	}
+	obj := r.getObject(hash)
+	for obj != nil && obj.Type == "tag" {
+		obj = r.getObject(parseTag(obj).Object)
+	}
+	if obj == nil || obj.Type != "commit" {
+		return Commit{}, fmt.Errorf("%s is not a commit", rev)
+	}
+	return parseCommit(obj), nil
+}
+
+// A ref as listed by show-ref.
+type RefInfo struct {
+	Name string `json:"name"`
+	// the object the ref resolves to, empty when it doesn't resolve
+	Target string `json:"target,omitempty"`
+	// the ref a symbolic ref points at
+	Symref string `json:"symref,omitempty"`
+	// every ref a symbolic ref points through when it points at another symbolic ref
+	Chain []string `json:"chain,omitempty"`
+	// why a symbolic ref doesn't resolve, e.g. a loop
+	Error string `json:"error,omitempty"`
+	// the object an annotated tag peels to
+	Peeled string `json:"peeled,omitempty"`
+	Packed bool   `json:"packed"`
+	// set when the ref doesn't resolve to an object in the repo
+	Broken bool `json:"broken"`
+}
+
+// Reports whether the repo has an object, loose or in a pack.
+func (r *Repo) hasObject(name string) bool {
+	if r.getObject(name) != nil {
+		return true
+	}
+	if r.packed == nil {
+		r.packed = map[string]bool{}
+		packs, _ := r.packs()
+		for _, pack := range packs {
+			_, idxPath := packPaths(pack)
+			if idx, err := readPackIndex(idxPath); err == nil {
+				for _, n := range idx.names {
+					r.packed[n] = true
+				}
+			}
+		}
+	}
+	return r.packed[name]
+}
+
+// Lists HEAD and every loose, packed and symbolic ref sorted by name, resolved to their
+// targets, with annotated tags peeled.
+func (r *Repo) showRefs() []RefInfo {
+	root := gitDir(r.location)
+	packed, peeled := r.readPackedRefs()
+	var refs []RefInfo
+	add := func(name string, value string, isPacked bool) {
+		ref := RefInfo{Name: name, Packed: isPacked}
+		if symref, ok := strings.CutPrefix(value, "ref:"); ok {
+			chain, target, err := r.ResolveSymref(name)
+			ref.Symref, ref.Target = strings.TrimSpace(symref), target
+			if len(chain) > 1 {
+				ref.Chain = chain
+			}
+			if err != nil {
+				ref.Error = err.Error()
+			}
+		} else {
+			ref.Target = value
+		}
+		ref.Broken = ref.Target == "" || !r.hasObject(ref.Target)
+		if obj := r.getObject(ref.Target); obj != nil && obj.Type == "tag" {
+			ref.Peeled = r.peel(ref.Target)
+		} else if obj == nil && isPacked {
+			// the tag object is packed, but packed-refs may have its peeled value
+			ref.Peeled = peeled[name]
+		}
+		refs = append(refs, ref)
+	}
+	if head, err := repofs.ReadFile(root + "/" + r.storedRef("HEAD")); err == nil {
+		add("HEAD", r.namespacedValue(strings.TrimSpace(string(head))), false)
+	}
+	loose := map[string]bool{}
+	r.walkLooseRefs(func(name string, path string) {
+		if value, err := repofs.ReadFile(path); err == nil {
+			loose[name] = true
+			add(name, r.namespacedValue(strings.TrimSpace(string(value))), false)
+		}
+	})
+	for name, hash := range packed {
+		if !loose[name] {
+			add(name, hash, true)
+		}
+	}
+	slices.SortFunc(refs[min(1, len(refs)):], func(a, b RefInfo) int { return strings.Compare(a.Name, b.Name) })
+	return refs
+}
+
+// Writes refs like git show-ref, with HEAD, symbolic refs and broken refs marked.
+func writeRefs(w io.Writer, refs []RefInfo) {
+	for _, ref := range refs {
+		target := cmp.Or(ref.Target, strings.Repeat("-", 40))
+		switch {
+		case ref.Chain != nil:
+			fmt.Fprintf(w, "%s %s -> %s", target, ref.Name, strings.Join(ref.Chain, " -> "))
+		case ref.Symref != "":
+			fmt.Fprintf(w, "%s %s -> %s", target, ref.Name, ref.Symref)
+		default:
+			fmt.Fprintf(w, "%s %s", target, ref.Name)
+		}
+		if ref.Error != "" {
+			fmt.Fprintf(w, " (broken: %s)", ref.Error)
+		} else if ref.Broken {
+			fmt.Fprint(w, " (broken)")
+		}
+		fmt.Fprintln(w)
+		if ref.Peeled != "" {
+			fmt.Fprintf(w, "%s %s^{}\n", ref.Peeled, ref.Name)
+		}
+	}
+}
+
+// The pseudo-refs git writes during fetches, merges, rebases, cherry-picks and reverts.
+var pseudoRefNames = []string{"FETCH_HEAD", "ORIG_HEAD", "MERGE_HEAD", "CHERRY_PICK_HEAD", "REVERT_HEAD", "REBASE_HEAD"}
+
+// Reads the pseudo-refs present in .git. FETCH_HEAD has a line per fetched ref and MERGE_HEAD
+// a line per merged commit.
+func (r *Repo) pseudoRefs() []PseudoRef {
+	var refs []PseudoRef
+	for _, name := range pseudoRefNames {
+		data, err := repofs.ReadFile(gitDir(r.location) + "/" + name)
+		if err != nil {
+			continue
+		}
+		ref := PseudoRef{Name: name, Targets: []PseudoRefTarget{}}
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.SplitN(line, "\t", 3)
+			hash := strings.TrimSpace(fields[0])
+			if !hashRegex.MatchString(hash) {
+				continue
+			}
+			target := PseudoRefTarget{Hash: hash}
+			if len(fields) == 3 {
+				target.NotForMerge = fields[1] == "not-for-merge"
+				target.Description = fields[2]
+			}
+			ref.Targets = append(ref.Targets, target)
+		}
+		if len(ref.Targets) > 0 {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
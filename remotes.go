@@ -0,0 +1,68 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+)
+
+// Returns the remotes configured in .git/config.
+func (r *Repo) Remotes() ([]Remote, error) {
+	config, err := r.Config(false)
+	if err != nil {
+		return nil, err
+	}
+	return config.Remotes, nil
+}
+
+// Maps a remote-tracking ref back to the branch it tracks using a fetch refspec, e.g.
+// refs/remotes/origin/main to refs/heads/main for +refs/heads/*:refs/remotes/origin/*.
+func matchRefspec(refspec string, ref string) (string, bool) {
+	src, dst, found := strings.Cut(strings.TrimPrefix(refspec, "+"), ":")
+	if !found {
+		return "", false
+	}
+	dstPrefix, dstSuffix, dstGlob := strings.Cut(dst, "*")
+	if !dstGlob {
+		return src, ref == dst
+	}
+	if !strings.HasPrefix(ref, dstPrefix) || !strings.HasSuffix(ref, dstSuffix) || len(ref) < len(dstPrefix)+len(dstSuffix) {
+		return "", false
+	}
+	match := ref[len(dstPrefix) : len(ref)-len(dstSuffix)]
+	return strings.Replace(src, "*", match, 1), true
+}
+
+// Describes a remote-tracking ref, finding the remote whose fetch refspecs write to it.
+func remoteRef(name string, target string, remotes []Remote) RemoteRef {
+	ref := RemoteRef{Name: strings.TrimPrefix(name, "refs/remotes/"), Target: target}
+	for _, remote := range remotes {
+		for _, refspec := range remote.Fetch {
+			if branch, ok := matchRefspec(refspec, name); ok {
+				ref.Remote, ref.URLs, ref.Branch = remote.Name, remote.URLs, branch
+				return ref
+			}
+		}
+	}
+	return ref
+}
+
+// Writes the configured remotes to a remotes table.
+func (r *Repo) writeRemotesTable(db *sql.DB) error {
+	remotes, err := r.Remotes()
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec(`create table remotes (name text primary key, urls jsonb, push_urls jsonb, fetch jsonb);`); err != nil {
+		return err
+	}
+	for _, remote := range remotes {
+		urls, _ := json.Marshal(remote.URLs)
+		pushURLs, _ := json.Marshal(remote.PushURLs)
+		fetch, _ := json.Marshal(remote.Fetch)
+		if _, err := db.Exec("insert into remotes values(?, ?, ?, ?)", remote.Name, urls, pushURLs, fetch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
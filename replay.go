@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dagit/repofs"
+)
+
+const (
+	REPLAY_OBJECT = "object"
+	REPLAY_REF    = "ref"
+)
+
+// A step in the growth of a repo: an object being created or a ref moving.
+type ReplayEvent struct {
+	Seq  int       `json:"seq"`
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+	// the created object's node and its edges to objects created before it
+	Node  map[string]any `json:"node,omitempty"`
+	Edges []Edge         `json:"edges,omitempty"`
+	// the moved ref, from its reflog. Old is empty when the ref was created.
+	Ref     string `json:"ref,omitempty"`
+	Old     string `json:"old,omitempty"`
+	New     string `json:"new,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// An entry of a ref's reflog.
+type ReflogEntry struct {
+	Ref       string    `json:"ref"`
+	Old       string    `json:"old"`
+	New       string    `json:"new"`
+	Committer User      `json:"committer"`
+	Time      time.Time `json:"time"`
+	Message   string    `json:"message"`
+}
+
+const zeroHash = "0000000000000000000000000000000000000000"
+
+// Reads the reflogs of HEAD and every ref under .git/logs, sorted by time.
+func (r *Repo) reflogs() []ReflogEntry {
+	var entries []ReflogEntry
+	root := gitDir(r.location) + "/logs"
+	repofs.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil
+		}
+		f, err := repofs.Open(path)
+		if err != nil {
+			return nil
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line, message, _ := strings.Cut(scanner.Text(), "\t")
+			// <old> <new> <signature>
+			fields := strings.SplitN(line, " ", 3)
+			if len(fields) < 3 || !hashRegex.MatchString(fields[0]) || !hashRegex.MatchString(fields[1]) {
+				continue
+			}
+			committer, when := parseSignature(fields[2])
+			entry := ReflogEntry{Ref: filepath.ToSlash(rel), Old: fields[0], New: fields[1], Committer: committer, Time: when, Message: message}
+			if entry.Old == zeroHash {
+				entry.Old = ""
+			}
+			if entry.New == zeroHash {
+				entry.New = ""
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	slices.SortStableFunc(entries, func(a, b ReflogEntry) int { return a.Time.Compare(b.Time) })
+	return entries
+}
+
+// Writes the selected objects as creation events followed by the ref moves of the reflogs
+// as newline-delimited JSON, in the order they happened. Commits are replayed oldest first by
+// opts.Order (topo by default), each preceded by the trees and blobs it introduced. Objects
+// no commit introduced, e.g. staged blobs, are timed by their loose object file.
+func (r *Repo) writeReplay(ctx context.Context, w io.Writer, opts GraphOptions) (err error) {
+	ctx, span := tracer.Start(ctx, "graph.writeReplay")
+	defer func() { endSpan(span, err) }()
+	sel, err := r.traceSelect(ctx, opts)
+	if err != nil {
+		return err
+	}
+	order := cmp.Or(opts.Order, ORDER_TOPO)
+	var commits []Commit
+	var tags []Tag
+	tagNames := map[string]string{}
+	for _, obj := range sel.objects {
+		switch obj.Type {
+		case "commit":
+			commits = append(commits, parseCommit(obj))
+		case "tag":
+			tag := parseTag(obj)
+			tags = append(tags, tag)
+			tagNames[tag.Object+tag.Tag] = obj.Name
+		}
+	}
+	if err := r.sortCommits(commits, order); err != nil {
+		return err
+	}
+	slices.Reverse(commits)
+	slices.SortStableFunc(tags, func(a, b Tag) int { return a.TagTime.Compare(b.TagTime) })
+
+	enc := json.NewEncoder(w)
+	seq := 0
+	emitted := map[string]bool{}
+	emit := func(e ReplayEvent) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		seq++
+		e.Seq = seq
+		return enc.Encode(e)
+	}
+	// emits an object after the objects it points at
+	var emitObject func(name string, when time.Time) error
+	emitObject = func(name string, when time.Time) error {
+		obj := r.getObject(name)
+		if emitted[name] || obj == nil || !sel.has(name) {
+			return nil
+		}
+		emitted[name] = true
+		var edges []Edge
+		for _, e := range obj.edges() {
+			if !sel.hasEdge(e) {
+				continue
+			}
+			// parents are replayed on their own
+			if obj.Type != "commit" || r.getObject(e.Dest) == nil || r.getObject(e.Dest).Type != "commit" {
+				if err := emitObject(e.Dest, when); err != nil {
+					return err
+				}
+			}
+			edges = append(edges, e)
+		}
+		node, err := sel.node(obj)
+		if err != nil {
+			return err
+		}
+		return emit(ReplayEvent{Time: when, Type: REPLAY_OBJECT, Node: node, Edges: edges})
+	}
+
+	refs := r.reflogs()
+	// emits the ref moves before until, and those at until whose target was already emitted.
+	// A zero until emits every remaining move.
+	emitRefs := func(until time.Time) error {
+		for len(refs) > 0 && (until.IsZero() || refs[0].Time.Before(until) || (refs[0].Time.Equal(until) && emitted[refs[0].New])) {
+			e := refs[0]
+			refs = refs[1:]
+			if err := emit(ReplayEvent{Time: e.Time, Type: REPLAY_REF, Ref: e.Ref, Old: e.Old, New: e.New, Message: e.Message}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, commit := range commits {
+		// annotated tags made before this commit
+		for len(tags) > 0 && tags[0].TagTime.Before(commit.CommitTime) {
+			if err := emitRefs(tags[0].TagTime); err != nil {
+				return err
+			}
+			if err := emitObject(tagNames[tags[0].Object+tags[0].Tag], tags[0].TagTime); err != nil {
+				return err
+			}
+			tags = tags[1:]
+		}
+		if err := emitRefs(commit.CommitTime); err != nil {
+			return err
+		}
+		if err := emitObject(commit.Hash, commit.CommitTime); err != nil {
+			return err
+		}
+	}
+	for _, tag := range tags {
+		if err := emitRefs(tag.TagTime); err != nil {
+			return err
+		}
+		if err := emitObject(tagNames[tag.Object+tag.Tag], tag.TagTime); err != nil {
+			return err
+		}
+	}
+
+	// objects no commit or tag introduced
+	var rest []*Object
+	for _, obj := range sel.objects {
+		if !emitted[obj.Name] {
+			rest = append(rest, obj)
+		}
+	}
+	modTimes := map[string]time.Time{}
+	for _, obj := range rest {
+		if info, err := repofs.Stat(obj.Location); err == nil {
+			modTimes[obj.Name] = info.ModTime()
+		}
+	}
+	slices.SortStableFunc(rest, func(a, b *Object) int { return modTimes[a.Name].Compare(modTimes[b.Name]) })
+	for _, obj := range rest {
+		if err := emitRefs(modTimes[obj.Name]); err != nil {
+			return err
+		}
+		if err := emitObject(obj.Name, modTimes[obj.Name]); err != nil {
+			return err
+		}
+	}
+	return emitRefs(time.Time{})
+}
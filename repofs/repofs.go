@@ -0,0 +1,174 @@
+// Package repofs is the only way dagit touches the files of the repos it reads. Repo files
+// are always opened read-only, and in paranoid mode any write under a protected repo is
+// refused before a file is created:
+//
+//	repofs.SetParanoid(true)
+//	repofs.Protect("/path/to/repo")
+//	data, err := repofs.ReadFile("/path/to/repo/.git/HEAD")
+//	f, err := repofs.Create("/path/to/repo/out.json") // ErrWriteInRepo
+package repofs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Returned, wrapped with the path and repo, for writes under a protected repo in paranoid mode.
+var ErrWriteInRepo = errors.New("paranoid mode refuses writes inside repos")
+
+var (
+	mu        sync.Mutex
+	paranoid  bool
+	protected []string
+)
+
+// Turns paranoid mode on or off.
+func SetParanoid(on bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	paranoid = on
+}
+
+func Paranoid() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return paranoid
+}
+
+// Adds a repo, including its working tree, to the paths writes are refused under in
+// paranoid mode.
+func Protect(root string) error {
+	resolved, err := resolve(root)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, p := range protected {
+		if p == resolved {
+			return nil
+		}
+	}
+	protected = append(protected, resolved)
+	return nil
+}
+
+// Makes path absolute and resolves symlinks in its longest existing prefix, so a path
+// reaching a repo through a symlink or one that doesn't exist yet is still recognized.
+func resolve(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	rest := ""
+	for dir := abs; ; dir = filepath.Dir(dir) {
+		if real, err := filepath.EvalSymlinks(dir); err == nil {
+			return filepath.Join(real, rest), nil
+		}
+		if filepath.Dir(dir) == dir {
+			return abs, nil
+		}
+		rest = filepath.Join(filepath.Base(dir), rest)
+	}
+}
+
+// Returns an error wrapping ErrWriteInRepo when paranoid mode is on and path is under a
+// protected repo.
+func CheckWrite(path string) error {
+	if !Paranoid() {
+		return nil
+	}
+	resolved, err := resolve(path)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, root := range protected {
+		if resolved == root || strings.HasPrefix(resolved, root+string(filepath.Separator)) {
+			return fmt.Errorf("%s is inside the repo at %s: %w", path, root, ErrWriteInRepo)
+		}
+	}
+	return nil
+}
+
+// Opens a file with O_RDONLY.
+func Open(name string) (*os.File, error) {
+	return os.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// Reads a whole file, opened with O_RDONLY.
+func ReadFile(name string) ([]byte, error) {
+	f, err := Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func WalkDir(root string, fn fs.WalkDirFunc) error {
+	return filepath.WalkDir(root, fn)
+}
+
+// Creates or truncates a file after checking the write is allowed.
+func Create(name string) (*os.File, error) {
+	if err := CheckWrite(name); err != nil {
+		return nil, err
+	}
+	return os.Create(name)
+}
+
+func WriteFile(name string, data []byte, perm fs.FileMode) error {
+	if err := CheckWrite(name); err != nil {
+		return err
+	}
+	return os.WriteFile(name, data, perm)
+}
+
+func MkdirAll(path string, perm fs.FileMode) error {
+	if err := CheckWrite(path); err != nil {
+		return err
+	}
+	return os.MkdirAll(path, perm)
+}
+
+func Remove(name string) error {
+	if err := CheckWrite(name); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+// Creates a temporary file in dir after checking the write is allowed.
+func CreateTemp(dir, pattern string) (*os.File, error) {
+	if err := CheckWrite(filepath.Join(dir, pattern)); err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(dir, pattern)
+}
+
+// Renames a file after checking writing both paths is allowed.
+func Rename(oldpath, newpath string) error {
+	if err := CheckWrite(oldpath); err != nil {
+		return err
+	}
+	if err := CheckWrite(newpath); err != nil {
+		return err
+	}
+	return os.Rename(oldpath, newpath)
+}
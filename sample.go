@@ -0,0 +1,132 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// Keeps every selected commit with its root tree but only the first n other trees and blobs
+// each commit's snapshot adds to the graph, oldest commit first. Snapshots are walked breadth
+// first, directories ahead of files, so the sample shows the layout of the repo rather than
+// the content of one directory. Trees and blobs no selected commit holds are kept.
+//
+// What's left out is counted by type in omitted attributes: the entries of a tree node that
+// aren't shown and the paths of a commit's snapshot the graph doesn't reach from its tree.
+func (r *Repo) sample(sel *selection, n int) *selection {
+	var commits []Commit
+	var trees []string
+	for _, obj := range sel.objects {
+		if obj.Type == "commit" {
+			commit := parseCommit(obj)
+			commits = append(commits, commit)
+			trees = append(trees, commit.Tree)
+		}
+	}
+	slices.SortFunc(commits, func(a, b Commit) int {
+		if c := a.CommitTime.Compare(b.CommitTime); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Hash, b.Hash)
+	})
+	// the entries of a tree that are in the selection, directories first
+	entries := func(tree string) []TreeEntry {
+		var selected []TreeEntry
+		for _, entry := range sortedEntries(r.treeEntries(tree)) {
+			if entry.Kind != ENTRY_GITLINK && sel.has(entry.Hash) && r.getObject(entry.Hash) != nil {
+				selected = append(selected, entry)
+			}
+		}
+		slices.SortStableFunc(selected, func(a, b TreeEntry) int {
+			switch {
+			case a.Kind == b.Kind || a.Kind != ENTRY_DIRECTORY && b.Kind != ENTRY_DIRECTORY:
+				return 0
+			case a.Kind == ENTRY_DIRECTORY:
+				return -1
+			}
+			return 1
+		})
+		return selected
+	}
+	kept := map[string]bool{}
+	for _, commit := range commits {
+		if !sel.has(commit.Tree) || r.getObject(commit.Tree) == nil {
+			continue
+		}
+		kept[commit.Tree] = true
+		budget := n
+		// trees kept by an earlier commit aren't walked again, their sample is already taken
+		for queue := []string{commit.Tree}; len(queue) > 0 && budget > 0; queue = queue[1:] {
+			for _, entry := range entries(queue[0]) {
+				if budget == 0 {
+					break
+				}
+				if kept[entry.Hash] {
+					continue
+				}
+				kept[entry.Hash] = true
+				budget--
+				if entry.Kind == ENTRY_DIRECTORY {
+					queue = append(queue, entry.Hash)
+				}
+			}
+		}
+	}
+	inCommits := r.reachable(trees)
+	sampled := *sel
+	sampled.names, sampled.objects = map[string]bool{}, nil
+	for _, obj := range sel.objects {
+		if kept[obj.Name] || obj.Type != "tree" && obj.Type != "blob" || !inCommits[obj.Name] {
+			sampled.names[obj.Name] = true
+			sampled.objects = append(sampled.objects, obj)
+		}
+	}
+
+	// the paths under a tree by type, all of them or only those the kept trees don't reach
+	all, hidden := map[string]map[string]int{}, map[string]map[string]int{}
+	var count func(tree string, onlyHidden bool) map[string]int
+	count = func(tree string, onlyHidden bool) map[string]int {
+		memo := all
+		if onlyHidden {
+			memo = hidden
+		}
+		if counts, ok := memo[tree]; ok {
+			return counts
+		}
+		counts := map[string]int{}
+		memo[tree] = counts
+		for _, entry := range entries(tree) {
+			shown := onlyHidden && kept[entry.Hash]
+			if !shown {
+				counts[r.getObject(entry.Hash).Type]++
+			}
+			if entry.Kind == ENTRY_DIRECTORY {
+				for type_, c := range count(entry.Hash, shown) {
+					counts[type_] += c
+				}
+			}
+		}
+		return counts
+	}
+	for _, obj := range sampled.objects {
+		if obj.Type != "tree" || !kept[obj.Name] {
+			continue
+		}
+		omitted := map[string]int{}
+		for _, entry := range entries(obj.Name) {
+			if !kept[entry.Hash] {
+				omitted[r.getObject(entry.Hash).Type]++
+			}
+		}
+		if len(omitted) > 0 {
+			sampled.annotate(obj.Name, "omitted", omitted)
+		}
+	}
+	for _, commit := range commits {
+		if kept[commit.Tree] {
+			if omitted := count(commit.Tree, true); len(omitted) > 0 {
+				sampled.annotate(commit.Hash, "omitted", omitted)
+			}
+		}
+	}
+	return &sampled
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// The version of the graph JSON format, major.minor. Bump the minor version for new optional
+// properties and the major version (and the schema's pattern) for breaking changes.
+const SCHEMA_VERSION = "1.7"
+
+//go:embed schema/graph.schema.json
+var graphSchema []byte
+
+// Compiles the embedded graph schema.
+func compileGraphSchema() (*jsonschema.Schema, error) {
+	compiler := jsonschema.NewCompiler()
+	compiler.AssertFormat = true
+	if err := compiler.AddResource("graph.schema.json", bytes.NewReader(graphSchema)); err != nil {
+		return nil, err
+	}
+	return compiler.Compile("graph.schema.json")
+}
+
+// Validates a graph read from r against the embedded schema.
+func validateGraph(r io.Reader) error {
+	schema, err := compileGraphSchema()
+	if err != nil {
+		return err
+	}
+	var graph any
+	decoder := json.NewDecoder(r)
+	// the validator expects numbers as json.Number
+	decoder.UseNumber()
+	if err := decoder.Decode(&graph); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	return schema.Validate(graph)
+}
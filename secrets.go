@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/dagit/repofs"
+)
+
+// A rule flagging blob content that looks like a secret. A match of Pattern is reported when
+// its Group (the whole match by default) has at least MinEntropy bits of entropy per byte.
+type SecretRule struct {
+	Name       string  `json:"name"`
+	Pattern    string  `json:"pattern"`
+	Group      int     `json:"group,omitempty"`
+	MinEntropy float64 `json:"minEntropy,omitempty"`
+	regex      *regexp.Regexp
+}
+
+var defaultSecretRules = []SecretRule{
+	{Name: "aws-access-key-id", Pattern: `\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`},
+	{Name: "github-token", Pattern: `\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{60,})\b`},
+	{Name: "slack-token", Pattern: `\bxox[abposr]-[A-Za-z0-9-]{10,}\b`},
+	{Name: "private-key", Pattern: `-----BEGIN (?:[A-Z]+ )?PRIVATE KEY-----`},
+	{Name: "generic-secret", Pattern: `(?i)(?:api[_-]?key|secret|token|passw(?:or)?d)\s*[:=]\s*["']?([A-Za-z0-9+/_\-]{16,})`, Group: 1, MinEntropy: 3.5},
+	{Name: "high-entropy-string", Pattern: `["'=]\s*([A-Za-z0-9+/]{32,}={0,2})`, Group: 1, MinEntropy: 4.5},
+}
+
+// Reads rules from a JSON array of SecretRule. An empty path returns the default rules.
+func loadSecretRules(path string) ([]SecretRule, error) {
+	rules := slices.Clone(defaultSecretRules)
+	if path != "" {
+		data, err := repofs.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		rules = nil
+		if err := json.Unmarshal(data, &rules); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	for i := range rules {
+		regex, err := regexp.Compile(rules[i].Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", rules[i].Name, err)
+		}
+		if rules[i].Group > regex.NumSubexp() {
+			return nil, fmt.Errorf("rule %s: pattern has no group %d", rules[i].Name, rules[i].Group)
+		}
+		rules[i].regex = regex
+	}
+	return rules, nil
+}
+
+// Returns the Shannon entropy of s in bits per byte.
+func shannonEntropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	var counts [256]int
+	for i := 0; i < len(s); i++ {
+		counts[s[i]]++
+	}
+	entropy := 0.0
+	for _, n := range counts {
+		if n > 0 {
+			p := float64(n) / float64(len(s))
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return entropy
+}
+
+// A possible secret found in a blob.
+type SecretFinding struct {
+	Blob string `json:"blob"`
+	Rule string `json:"rule"`
+	Line int    `json:"line"`
+	// the start of the match, the rest masked
+	Match   string  `json:"match"`
+	Entropy float64 `json:"entropy"`
+	// the paths the blob was committed at, empty for blobs no commit references
+	Paths []string `json:"paths"`
+	// the oldest commit containing the blob
+	FirstCommit string `json:"firstCommit,omitempty"`
+	Reachable   bool   `json:"reachable"`
+}
+
+func maskSecret(s string) string {
+	if len(s) <= 4 {
+		return strings.Repeat("*", len(s))
+	}
+	return s[:4] + strings.Repeat("*", min(len(s)-4, 16))
+}
+
+// Scans the content of every blob, reachable or not, with rules.
+func (r *Repo) scanSecrets(rules []SecretRule) []SecretFinding {
+	// the paths and oldest commit of every blob committed, from all commits including unreachable ones
+	paths := map[string]map[string]bool{}
+	first := map[string]Commit{}
+	cache := map[string][]pathBlob{}
+	for _, obj := range r.objectList() {
+		if obj.Type != "commit" {
+			continue
+		}
+		commit := parseCommit(obj)
+		for _, f := range r.flattenTree(commit.Tree, cache) {
+			if paths[f.hash] == nil {
+				paths[f.hash] = map[string]bool{}
+			}
+			paths[f.hash][f.path] = true
+			if c, ok := first[f.hash]; !ok || commit.CommitTime.Before(c.CommitTime) {
+				first[f.hash] = commit
+			}
+		}
+	}
+	reachable := r.reachableFromRefs()
+
+	var findings []SecretFinding
+	for _, obj := range r.objectList() {
+		if obj.Type != "blob" {
+			continue
+		}
+		data := obj.Bytes()
+		// skip binary content
+		if bytes.IndexByte(data[:min(len(data), 8000)], 0) >= 0 {
+			continue
+		}
+		var blobPaths []string
+		for p := range paths[obj.Name] {
+			blobPaths = append(blobPaths, p)
+		}
+		slices.Sort(blobPaths)
+		for i, line := range strings.Split(string(data), "\n") {
+			for _, rule := range rules {
+				for _, match := range rule.regex.FindAllStringSubmatch(line, -1) {
+					secret := match[rule.Group]
+					entropy := shannonEntropy(secret)
+					if entropy < rule.MinEntropy {
+						continue
+					}
+					findings = append(findings, SecretFinding{
+						Blob:        obj.Name,
+						Rule:        rule.Name,
+						Line:        i + 1,
+						Match:       maskSecret(secret),
+						Entropy:     math.Round(entropy*100) / 100,
+						Paths:       blobPaths,
+						FirstCommit: first[obj.Name].Hash,
+						Reachable:   reachable[obj.Name],
+					})
+				}
+			}
+		}
+	}
+	slices.SortFunc(findings, func(a, b SecretFinding) int {
+		if c := strings.Compare(a.Blob, b.Blob); c != 0 {
+			return c
+		}
+		return a.Line - b.Line
+	})
+	return findings
+}
+
+// Prints a finding per line: blob, line, rule, masked match, paths and first commit.
+func writeSecretFindings(w io.Writer, findings []SecretFinding) {
+	for _, f := range findings {
+		where := strings.Join(f.Paths, ",")
+		if where == "" {
+			where = "(no commit)"
+		}
+		if !f.Reachable {
+			where += " [unreachable]"
+		}
+		commit := "-"
+		if f.FirstCommit != "" {
+			commit = shortHash(f.FirstCommit)
+		}
+		fmt.Fprintf(w, "%s:%d\t%s\t%s\t%s\t%s\n", shortHash(f.Blob), f.Line, f.Rule, f.Match, where, commit)
+	}
+}
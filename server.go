@@ -1,11 +1,22 @@
 package main
 
 import (
-	"log"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
 	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const (
@@ -19,6 +30,8 @@ const (
 	repoPeriod = 3 * time.Second
 	// message client sends to get objects even if no changes occurred
 	needObjects = "need-objects"
+	// message client sends with an object name or a unique prefix of one to get its node
+	objectRequest = "object "
 )
 
 var upgrader = websocket.Upgrader{
@@ -27,16 +40,177 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin:     func(r *http.Request) bool { return true },
 }
 
-func getObjectsIfChange(repo *Repo) []byte {
-	if repo.changed() {
-		log.Printf("Repo changed. Refreshing data...")
-		repo.refresh()
-		return repo.toJson()
+// Polls a repo for changes. It's the only goroutine refreshing the repo on the server, so
+// every websocket client following the repo gets the history events and graph of each refresh.
+type repoPoller struct {
+	repo *Repo
+	mu   sync.Mutex
+	// the updates queued for each client, closed once the poller stops
+	clients map[*wsClient]chan [][]byte
+	stopped bool
+	cancel  context.CancelFunc
+}
+
+// Refreshes with this many updates queued for a client drop it rather than wait for it.
+const clientUpdates = 4
+
+// Starts polling r every repoPeriod until ctx is done or the poller is stopped.
+func startPoller(ctx context.Context, r *Repo) *repoPoller {
+	ctx, cancel := context.WithCancel(ctx)
+	p := &repoPoller{repo: r, clients: map[*wsClient]chan [][]byte{}, cancel: cancel}
+	go p.run(ctx)
+	return p
+}
+
+func (p *repoPoller) run(ctx context.Context) {
+	ticker := time.NewTicker(repoPeriod)
+	defer ticker.Stop()
+	defer p.stop()
+	logger := loggerFrom(ctx).With("repo", p.repo.location)
+	// the last error, logged once rather than on every tick while the repo stays unreadable
+	failing := ""
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		updates, err := p.poll(ctx, logger)
+		if err != nil {
+			if err.Error() != failing {
+				logger.Error("refreshing graph", "error", err)
+				failing = err.Error()
+			}
+			continue
+		}
+		if failing != "" {
+			logger.Info("repo readable again")
+			failing = ""
+		}
+		if updates != nil {
+			p.broadcast(updates)
+		}
+	}
+}
+
+// Refreshes the repo if it changed and returns the messages for its clients: the history
+// events since the last refresh, each its own message told apart from graphs by its type,
+// then the graph. Returns nil when the repo didn't change. The graph is only built for
+// clients, while events are reported to the repo's listeners either way.
+func (p *repoPoller) poll(ctx context.Context, logger *slog.Logger) ([][]byte, error) {
+	changed, err := p.repo.changed()
+	if err != nil || !changed {
+		return nil, err
+	}
+	logger.Info("repo changed, refreshing")
+	ctx, span := tracer.Start(ctx, "websocket.update")
+	defer span.End()
+	start := time.Now()
+	events, err := p.repo.refresh(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var updates [][]byte
+	for _, e := range events {
+		logger.Info("event", "type", e.Type, "message", e.Message)
+		msg, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		updates = append(updates, msg)
+	}
+	p.mu.Lock()
+	followed := len(p.clients) > 0
+	p.mu.Unlock()
+	if !followed {
+		return nil, nil
+	}
+	unlock := readLock(p.repo)
+	objects, err := p.repo.toJson(ctx, graphOpts)
+	unlock()
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("graph built", "bytes", len(objects), "events", len(events), "build_ms", time.Since(start).Milliseconds())
+	return append(updates, objects), nil
+}
+
+// Queues updates for every client. Clients too slow to take them are dropped, so one stuck
+// connection doesn't hold up the others.
+func (p *repoPoller) broadcast(updates [][]byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for client, queue := range p.clients {
+		select {
+		case queue <- updates:
+		default:
+			client.logger.Warn("websocket client too slow for updates, disconnecting", "remote", client.remote)
+			close(queue)
+			delete(p.clients, client)
+		}
+	}
+}
+
+// Returns the queue of updates for client, or false when the poller stopped.
+func (p *repoPoller) subscribe(client *wsClient) (<-chan [][]byte, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return nil, false
+	}
+	queue := make(chan [][]byte, clientUpdates)
+	p.clients[client] = queue
+	return queue, true
+}
+
+func (p *repoPoller) unsubscribe(client *wsClient) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if queue, ok := p.clients[client]; ok {
+		close(queue)
+		delete(p.clients, client)
+	}
+}
+
+// Stops polling and closes the queues of the clients, which disconnects them.
+func (p *repoPoller) stop() {
+	p.cancel()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stopped = true
+	for client, queue := range p.clients {
+		close(queue)
+		delete(p.clients, client)
+	}
+}
+
+// A websocket connection. Writes from the reader and writer goroutines are serialized since
+// a connection supports one writer at a time.
+type wsClient struct {
+	conn     *websocket.Conn
+	logger   *slog.Logger
+	remote   string
+	mu       sync.Mutex
+	messages int
+	bytes    int
+}
+
+func (c *wsClient) send(messageType int, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := c.conn.WriteMessage(messageType, data); err != nil {
+		return err
+	}
+	if messageType != websocket.PingMessage {
+		c.messages++
+		c.bytes += len(data)
 	}
 	return nil
 }
 
-func reader(ws *websocket.Conn) {
+func reader(ctx context.Context, client *wsClient, target *Repo) {
+	ws := client.conn
 	defer ws.Close()
 	ws.SetReadLimit(512)
 	ws.SetReadDeadline(time.Now().Add(pongWait))
@@ -46,58 +220,321 @@ func reader(ws *websocket.Conn) {
 		if err != nil {
 			break
 		}
+		if prefix, ok := strings.CutPrefix(string(msg), objectRequest); ok && target != nil {
+			reply, err := json.Marshal(lookupObject(target, prefix))
+			if err != nil {
+				client.logger.Error("encoding object", "error", err)
+				return
+			}
+			if err := client.send(websocket.TextMessage, reply); err != nil {
+				return
+			}
+			continue
+		}
 		if string(msg) == needObjects {
-			log.Printf("objects from %s requested from client ...\n", repo.location)
-			objects := repo.toJson()
-			ws.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := ws.WriteMessage(websocket.TextMessage, objects); err != nil {
+			// the rate limit of demo mode covers graphs asked for over the websocket too
+			if !demo.allow(client.remote) {
+				client.logger.Warn("graph request over the rate limit", "remote", client.remote)
+				continue
+			}
+			if err := sendObjects(ctx, client, target); err != nil {
+				client.logger.Error("sending graph", "error", err)
+				return
+			}
+		}
+	}
+}
+
+// Sends the graph to a client that asked for it.
+func sendObjects(ctx context.Context, client *wsClient, target *Repo) (err error) {
+	ctx, span := tracer.Start(ctx, "websocket.needObjects")
+	defer func() { endSpan(span, err) }()
+	start := time.Now()
+	unlock := readLock(target)
+	objects, err := currentGraph(ctx, target, graphOpts)
+	unlock()
+	if err != nil {
+		return err
+	}
+	span.SetAttributes(attribute.Int("websocket.message.bytes", len(objects)))
+	client.logger.Info("graph requested", "bytes", len(objects), "build_ms", time.Since(start).Milliseconds())
+	return client.send(websocket.TextMessage, objects)
+}
+
+// A job's progress as sent to websocket clients, told apart from events by its type.
+type progressMessage struct {
+	Type string `json:"type"`
+	Job  Job    `json:"job"`
+}
+
+// Sends the progress of jobs on target until done is closed.
+func sendProgress(client *wsClient, target *Repo, done <-chan struct{}) {
+	if target == nil {
+		return
+	}
+	progress := jobs.subscribe()
+	defer jobs.unsubscribe(progress)
+	for {
+		select {
+		case <-done:
+			return
+		case <-progress.notify:
+		}
+		for _, job := range progress.take() {
+			if job.Repo != target.location {
+				continue
+			}
+			msg, err := json.Marshal(progressMessage{Type: "progress", Job: job})
+			if err != nil {
+				client.logger.Error("encoding progress", "error", err)
+				return
+			}
+			if err := client.send(websocket.TextMessage, msg); err != nil {
 				return
 			}
-			log.Println("objects sent to client.")
 		}
 	}
 }
 
-func writer(ws *websocket.Conn) {
+// Sends target's graph and history events whenever its poller refreshes it, and the progress
+// of jobs on target. The connection is closed once the poller stops, e.g. when the repo is
+// removed through the admin API.
+func writer(ctx context.Context, client *wsClient, target *Repo) {
 	pingTicker := time.NewTicker(pingPeriod)
-	repoTicker := time.NewTicker(repoPeriod)
+	done := make(chan struct{})
+	// progress is sent from its own goroutine so jobs the writer runs itself are reported
+	// as they go
+	go sendProgress(client, target, done)
 
 	defer func() {
 		pingTicker.Stop()
-		repoTicker.Stop()
-		ws.Close()
+		close(done)
+		client.conn.Close()
 	}()
 
+	// imported graphs never change, so they have no poller and updates stays nil
+	var updates <-chan [][]byte
+	if target != nil {
+		poller, ok := watched.poller(target)
+		if ok {
+			updates, ok = poller.subscribe(client)
+		}
+		if !ok {
+			client.logger.Info("repo no longer watched, closing websocket")
+			return
+		}
+		defer poller.unsubscribe(client)
+	}
+
 	for {
 		select {
-		case <-repoTicker.C:
-
-			var objects []byte = nil
-			objects = getObjectsIfChange(repo)
-
-			if objects != nil {
-				ws.SetWriteDeadline(time.Now().Add(writeWait))
-				if err := ws.WriteMessage(websocket.TextMessage, objects); err != nil {
+		case messages, ok := <-updates:
+			if !ok {
+				client.logger.Info("stopped following the repo, closing websocket")
+				return
+			}
+			for _, msg := range messages {
+				if err := client.send(websocket.TextMessage, msg); err != nil {
 					return
 				}
 			}
 		case <-pingTicker.C:
-			ws.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := ws.WriteMessage(websocket.PingMessage, nil); err != nil {
+			if err := client.send(websocket.PingMessage, nil); err != nil {
 				return
 			}
 		}
 	}
 }
 
+// Returns the served graph: target's with opts, or the imported one when target is nil.
+func currentGraph(ctx context.Context, target *Repo, opts GraphOptions) ([]byte, error) {
+	if target == nil {
+		return imported.data, nil
+	}
+	return target.toJson(ctx, opts)
+}
+
 func serveWs(w http.ResponseWriter, r *http.Request) {
+	logger := loggerFrom(r.Context())
+	target, err := requestRepo(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		if _, ok := err.(websocket.HandshakeError); !ok {
-			log.Println(err)
+			logger.Error("websocket upgrade", "error", err)
 		}
 		return
 	}
-	go writer(ws)
-	reader(ws)
+	start := time.Now()
+	client := &wsClient{conn: ws, logger: logger, remote: r.RemoteAddr}
+	logger.Info("websocket connected", "remote", r.RemoteAddr)
+	defer func() {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		logger.Info("websocket disconnected", "messages", client.messages, "bytes", client.bytes, "duration_ms", time.Since(start).Milliseconds())
+	}()
+	go writer(r.Context(), client, target)
+	reader(r.Context(), client, target)
+}
+
+// Serves the repo's graph. Query parameters override the server's graph options.
+func serveGraph(w http.ResponseWriter, r *http.Request) {
+	target, err := requestRepo(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if target == nil && len(r.URL.Query()) > 0 {
+		http.Error(w, "graph options aren't supported for imported graphs", http.StatusBadRequest)
+		return
+	}
+	opts, err := graphOptionsFromQuery(r.URL.Query(), graphOpts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	unlock := readLock(target)
+	if notModified(w, r, target) {
+		unlock()
+		return
+	}
+	objects, err := currentGraph(r.Context(), target, opts)
+	unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Vary", "Accept-Encoding")
+	if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		gz.Write(objects)
+		return
+	}
+	w.Write(objects)
+}
+
+// Serves the metrics of the repo's graph. Query parameters override the server's graph options.
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	target, err := requestRepo(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if target == nil {
+		http.Error(w, "metrics need a live repo, not an imported graph", http.StatusNotImplemented)
+		return
+	}
+	opts, err := graphOptionsFromQuery(r.URL.Query(), graphOpts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer readLock(target)()
+	if notModified(w, r, target) {
+		return
+	}
+	metrics, err := target.metrics(opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(metrics); err != nil {
+		loggerFrom(r.Context()).Error("writing metrics", "error", err)
+	}
+}
+
+// Returns a weak ETag for a request's response: a hash of the served graph's snapshot, the
+// path and the query. Responses only change when the repo is refreshed, so they can be
+// validated without rendering them. Weak because the gzipped and plain encodings share it.
+func etag(r *http.Request, target *Repo) string {
+	h := sha256.New()
+	if target == nil {
+		fmt.Fprintf(h, "%s\x00%d", imported.source, imported.loadedAt.UnixNano())
+	} else {
+		fmt.Fprintf(h, "%s\x00%d", target.checksum, target.loadedAt.UnixNano())
+	}
+	// Encode sorts the query by key
+	fmt.Fprintf(h, "\x00%s\x00%s", r.URL.Path, r.URL.Query().Encode())
+	return fmt.Sprintf(`W/"%x"`, h.Sum(nil)[:16])
+}
+
+// Sets the response's ETag, writing 304 Not Modified and returning true when the request's
+// If-None-Match already has it.
+func notModified(w http.ResponseWriter, r *http.Request, target *Repo) bool {
+	tag := etag(r, target)
+	w.Header().Set("ETag", tag)
+	for _, match := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		match = strings.TrimSpace(match)
+		// If-None-Match uses weak comparison
+		if match == "*" || strings.TrimPrefix(match, "W/") == strings.TrimPrefix(tag, "W/") {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+func registerPprof(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
+
+type Stats struct {
+	HeapAlloc     uint64         `json:"heapAlloc"`
+	HeapInuse     uint64         `json:"heapInuse"`
+	HeapObjects   uint64         `json:"heapObjects"`
+	Sys           uint64         `json:"sys"`
+	NumGC         uint32         `json:"numGC"`
+	Goroutines    int            `json:"goroutines"`
+	Objects       int            `json:"objects"`
+	ObjectsByType map[string]int `json:"objectsByType"`
+	LastRefresh   time.Time      `json:"lastRefresh"`
+	LastRefreshMs int64          `json:"lastRefreshMs"`
+	// set with --profile-objects
+	Profile *ObjectProfile `json:"profile,omitempty"`
+}
+
+// Serves runtime and repo diagnostics as JSON.
+func serveStats(w http.ResponseWriter, r *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	stats := Stats{
+		HeapAlloc:     mem.HeapAlloc,
+		HeapInuse:     mem.HeapInuse,
+		HeapObjects:   mem.HeapObjects,
+		Sys:           mem.Sys,
+		NumGC:         mem.NumGC,
+		Goroutines:    runtime.NumGoroutine(),
+		ObjectsByType: map[string]int{},
+	}
+	if imported != nil {
+		stats.Objects = imported.nodes
+		maps.Copy(stats.ObjectsByType, imported.countsByType)
+		stats.LastRefresh = imported.loadedAt
+		stats.LastRefreshMs = imported.loadDuration.Milliseconds()
+	} else {
+		unlock := readLock(repo)
+		stats.Objects = len(repo.objects)
+		stats.LastRefresh = repo.loadedAt
+		stats.LastRefreshMs = repo.loadDuration.Milliseconds()
+		stats.Profile = repo.profile
+		for _, obj := range repo.objects {
+			stats.ObjectsByType[obj.Type]++
+		}
+		unlock()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		loggerFrom(r.Context()).Error("writing stats", "error", err)
+	}
 }
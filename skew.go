@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A commit committed before one of its parents, because the committer's clock was wrong or
+// history was rewritten keeping the old dates. Date-ordered displays show it below its parent.
+type ClockSkew struct {
+	Commit     string    `json:"commit"`
+	CommitTime time.Time `json:"commitTime"`
+	// the parent committed last
+	Parent     string    `json:"parent"`
+	ParentTime time.Time `json:"parentTime"`
+	// how long before the parent the commit was committed
+	Seconds int64 `json:"seconds"`
+}
+
+// Returns how commit predates its latest committed parent, or false when it doesn't.
+// commitTime looks up the commit time of parents, which are skipped when it can't.
+func clockSkew(commit Commit, commitTime func(hash string) (time.Time, bool)) (ClockSkew, bool) {
+	skew := ClockSkew{Commit: commit.Hash, CommitTime: commit.CommitTime}
+	for _, parent := range commit.Parents {
+		if t, ok := commitTime(parent); ok && t.After(commit.CommitTime) && t.After(skew.ParentTime) {
+			skew.Parent, skew.ParentTime = parent, t
+		}
+	}
+	if skew.Parent == "" {
+		return ClockSkew{}, false
+	}
+	skew.Seconds = int64(skew.ParentTime.Sub(skew.CommitTime) / time.Second)
+	return skew, true
+}
+
+// Returns the commit time of a loaded commit.
+func (r *Repo) loadedCommitTime(hash string) (time.Time, bool) {
+	obj := r.getObject(hash)
+	if obj == nil || obj.Type != "commit" {
+		return time.Time{}, false
+	}
+	return parseCommit(obj).CommitTime, true
+}
+
+// Marks the selected commits committed before one of their parents with `skewed: true`.
+// Parents don't have to be selected.
+func (r *Repo) markSkewedCommits(sel *selection) {
+	for _, obj := range sel.objects {
+		if obj.Type != "commit" {
+			continue
+		}
+		if _, skewed := clockSkew(parseCommit(obj), r.loadedCommitTime); skewed {
+			sel.annotate(obj.Name, "skewed", true)
+		}
+	}
+}
+
+// Returns the selected commits committed before one of their parents, sorted by commit.
+func (r *Repo) skewedCommits(sel *selection) []ClockSkew {
+	var result []ClockSkew
+	for _, obj := range sel.objects {
+		if obj.Type != "commit" {
+			continue
+		}
+		if skew, skewed := clockSkew(parseCommit(obj), r.loadedCommitTime); skewed {
+			result = append(result, skew)
+		}
+	}
+	slices.SortFunc(result, func(a, b ClockSkew) int { return strings.Compare(a.Commit, b.Commit) })
+	return result
+}
+
+// Checks every loose and packed commit of the repo for clock skew, sorted by commit.
+func (r *Repo) checkClockSkew() ([]ClockSkew, error) {
+	commits := map[string]Commit{}
+	for _, obj := range r.objectList() {
+		if obj.Type == "commit" {
+			commits[obj.Name] = parseCommit(obj)
+		}
+	}
+	paths, err := r.packs()
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		p, err := openPack(path)
+		if err != nil {
+			return nil, err
+		}
+		for i := range p.entries {
+			type_, data, err := p.object(i)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			if type_ == "commit" {
+				name := p.idx.names[i]
+				commits[name] = parseCommitData(name, data)
+			}
+		}
+	}
+	commitTime := func(hash string) (time.Time, bool) {
+		commit, ok := commits[hash]
+		return commit.CommitTime, ok
+	}
+	var result []ClockSkew
+	for _, commit := range commits {
+		if skew, skewed := clockSkew(commit, commitTime); skewed {
+			result = append(result, skew)
+		}
+	}
+	slices.SortFunc(result, func(a, b ClockSkew) int { return strings.Compare(a.Commit, b.Commit) })
+	return result, nil
+}
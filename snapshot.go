@@ -0,0 +1,226 @@
+package main
+
+import (
+	"cmp"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dagit/repofs"
+)
+
+// The objects and refs of a repo at a point in time.
+type Snapshot struct {
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+	// object types by name
+	Objects map[string]string `json:"objects"`
+	// full ref names (and HEAD) to the object they point at
+	Refs map[string]string `json:"refs"`
+	// what HEAD holds: the branch checked out or, when detached, a commit
+	Head *Head `json:"head,omitempty"`
+}
+
+// Snapshots the repo's current objects and refs.
+func (r *Repo) snapshot(name string) *Snapshot {
+	s := &Snapshot{Name: name, Created: time.Now().UTC(), Objects: map[string]string{}, Refs: r.allRefs()}
+	for _, obj := range r.objectList() {
+		s.Objects[obj.Name] = obj.Type
+	}
+	if hash, ok := r.resolveRef("HEAD"); ok {
+		s.Refs["HEAD"] = hash
+	}
+	head := r.head()
+	s.Head = &head
+	return s
+}
+
+// Returns the objects added and removed and the refs that changed going from old to new.
+func diffSnapshots(old *Snapshot, new *Snapshot) SnapshotDiff {
+	diff := SnapshotDiff{From: old.Name, To: new.Name, Added: []SnapshotObject{}, Removed: []SnapshotObject{}, Refs: []RefChange{}}
+	for name, type_ := range new.Objects {
+		if _, ok := old.Objects[name]; !ok {
+			diff.Added = append(diff.Added, SnapshotObject{Name: name, Type: type_})
+		}
+	}
+	for name, type_ := range old.Objects {
+		if _, ok := new.Objects[name]; !ok {
+			diff.Removed = append(diff.Removed, SnapshotObject{Name: name, Type: type_})
+		}
+	}
+	for ref, hash := range new.Refs {
+		if old.Refs[ref] != hash {
+			diff.Refs = append(diff.Refs, RefChange{Ref: ref, Old: old.Refs[ref], New: hash})
+		}
+	}
+	for ref, hash := range old.Refs {
+		if _, ok := new.Refs[ref]; !ok {
+			diff.Refs = append(diff.Refs, RefChange{Ref: ref, Old: hash})
+		}
+	}
+	byTypeAndName := func(a, b SnapshotObject) int {
+		return cmp.Or(strings.Compare(a.Type, b.Type), strings.Compare(a.Name, b.Name))
+	}
+	slices.SortFunc(diff.Added, byTypeAndName)
+	slices.SortFunc(diff.Removed, byTypeAndName)
+	slices.SortFunc(diff.Refs, func(a, b RefChange) int { return strings.Compare(a.Ref, b.Ref) })
+	return diff
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 7 {
+		return hash[:7]
+	}
+	return hash
+}
+
+// Prints a diff with one line per object and ref: + for added, - for removed and ~ for moved refs.
+func writeSnapshotDiff(w io.Writer, diff SnapshotDiff) {
+	for _, obj := range diff.Added {
+		fmt.Fprintf(w, "+ %s %s\n", obj.Type, obj.Name)
+	}
+	for _, obj := range diff.Removed {
+		fmt.Fprintf(w, "- %s %s\n", obj.Type, obj.Name)
+	}
+	for _, c := range diff.Refs {
+		switch {
+		case c.Old == "":
+			fmt.Fprintf(w, "+ ref %s %s\n", c.Ref, shortHash(c.New))
+		case c.New == "":
+			fmt.Fprintf(w, "- ref %s %s\n", c.Ref, shortHash(c.Old))
+		default:
+			fmt.Fprintf(w, "~ ref %s %s -> %s\n", c.Ref, shortHash(c.Old), shortHash(c.New))
+		}
+	}
+	fmt.Fprintf(w, "%d added, %d removed, %d refs changed\n", len(diff.Added), len(diff.Removed), len(diff.Refs))
+}
+
+// Stores snapshots either as JSON files in a directory or in a SQLite database.
+type snapshotStore struct {
+	dir string
+	db  string
+}
+
+// The default snapshot directory of a repo, next to its layouts.
+func snapshotDir(location string) (string, error) {
+	return repoCacheDir(location, "snapshots")
+}
+
+func (s snapshotStore) openDB() (*sql.DB, error) {
+	if err := repofs.CheckWrite(s.db); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", s.db)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(`create table if not exists snapshots (name text primary key, created text, snapshot jsonb);`); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func (s snapshotStore) save(snapshot *Snapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	if s.db != "" {
+		db, err := s.openDB()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		_, err = db.Exec("insert or replace into snapshots(name, created, snapshot) values(?, ?, ?)", snapshot.Name, snapshot.Created.Format(time.RFC3339), data)
+		return err
+	}
+	if err := repofs.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	return repofs.WriteFile(filepath.Join(s.dir, snapshot.Name+".json"), data, 0644)
+}
+
+func (s snapshotStore) load(name string) (*Snapshot, error) {
+	var data []byte
+	if s.db != "" {
+		db, err := s.openDB()
+		if err != nil {
+			return nil, err
+		}
+		defer db.Close()
+		err = db.QueryRow("select snapshot from snapshots where name = ?", name).Scan(&data)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("no snapshot named %q", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		data, err = repofs.ReadFile(filepath.Join(s.dir, name+".json"))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("no snapshot named %q", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("snapshot %s: %w", name, err)
+	}
+	return &snapshot, nil
+}
+
+// Returns the saved snapshots, oldest first.
+func (s snapshotStore) list() ([]*Snapshot, error) {
+	var names []string
+	if s.db != "" {
+		db, err := s.openDB()
+		if err != nil {
+			return nil, err
+		}
+		defer db.Close()
+		rows, err := db.Query("select name from snapshots")
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				return nil, err
+			}
+			names = append(names, name)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	} else {
+		files, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			names = append(names, strings.TrimSuffix(filepath.Base(f), ".json"))
+		}
+	}
+	var snapshots []*Snapshot
+	for _, name := range names {
+		snapshot, err := s.load(name)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	slices.SortFunc(snapshots, func(a, b *Snapshot) int { return a.Created.Compare(b.Created) })
+	return snapshots, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/dagit/repofs"
+)
+
+// The name of the manifest listing the files of a split export.
+const SPLIT_MANIFEST = "manifest.json"
+
+// A file of a split export.
+type SplitFile struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+	// uncompressed bytes
+	Bytes int64 `json:"bytes"`
+}
+
+// Lists the files of a split export in order.
+type SplitManifest struct {
+	SchemaVersion string       `json:"schemaVersion"`
+	Nodes         []SplitFile  `json:"nodes"`
+	Edges         []SplitFile  `json:"edges"`
+	ParseErrors   []ParseError `json:"parseErrors,omitempty"`
+}
+
+// Writes values as newline-delimited JSON across numbered files of at most limit bytes, e.g.
+// nodes-0001.ndjson. A single value larger than limit gets a file of its own.
+type splitWriter struct {
+	dir      string
+	prefix   string
+	limit    int64
+	compress bool
+	files    []SplitFile
+	w        io.WriteCloser
+}
+
+func (s *splitWriter) write(v any) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if s.w == nil || s.files[len(s.files)-1].Bytes+int64(len(line)) > s.limit {
+		if err := s.next(); err != nil {
+			return err
+		}
+	}
+	if _, err := s.w.Write(line); err != nil {
+		return err
+	}
+	file := &s.files[len(s.files)-1]
+	file.Count++
+	file.Bytes += int64(len(line))
+	return nil
+}
+
+// Closes the current file and starts the next one.
+func (s *splitWriter) next() error {
+	if err := s.close(); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%04d.ndjson", s.prefix, len(s.files)+1)
+	if s.compress {
+		name += ".gz"
+	}
+	w, err := createOutput(filepath.Join(s.dir, name), s.compress)
+	if err != nil {
+		return err
+	}
+	s.w = w
+	s.files = append(s.files, SplitFile{Name: name})
+	return nil
+}
+
+func (s *splitWriter) close() error {
+	if s.w == nil {
+		return nil
+	}
+	err := s.w.Close()
+	s.w = nil
+	return err
+}
+
+// Writes the edges and nodes produced by stream into numbered files of at most limit bytes in
+// dir, followed by a manifest listing them.
+func writeSplitGraph(dir string, limit int64, compress bool, parseErrors []ParseError, stream func(edge func(any) error, node func(map[string]any) error) error) (err error) {
+	if limit <= 0 {
+		return errors.New("the split size must be positive")
+	}
+	if err := repofs.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	edges := &splitWriter{dir: dir, prefix: "edges", limit: limit, compress: compress}
+	nodes := &splitWriter{dir: dir, prefix: "nodes", limit: limit, compress: compress}
+	defer func() { err = errors.Join(err, edges.close(), nodes.close()) }()
+	node := func(n map[string]any) error {
+		return nodes.write(n)
+	}
+	if err := stream(edges.write, node); err != nil {
+		return err
+	}
+	manifest, err := json.Marshal(SplitManifest{
+		SchemaVersion: SCHEMA_VERSION,
+		Nodes:         append([]SplitFile{}, nodes.files...),
+		Edges:         append([]SplitFile{}, edges.files...),
+		ParseErrors:   parseErrors,
+	})
+	if err != nil {
+		return err
+	}
+	return repofs.WriteFile(filepath.Join(dir, SPLIT_MANIFEST), manifest, 0o644)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"cmp"
+	"path"
+	"slices"
+	"strconv"
+)
+
+// Summary statistics of the objects in a repo.
+type RepoStats struct {
+	Objects      int                `json:"objects"`
+	CountsByType map[string]int     `json:"countsByType"`
+	BytesByType  map[string]int     `json:"bytesByType"`
+	Dedup        *DedupReport       `json:"dedup,omitempty"`
+	Conventional *ConventionalStats `json:"conventional,omitempty"`
+	// languages at HEAD
+	Languages []LanguageStats `json:"languages,omitempty"`
+	// languages of every selected commit by commit name
+	LanguagesByCommit map[string][]LanguageStats `json:"languagesByCommit,omitempty"`
+	// the disconnected histories of the commit DAG, HEAD's first
+	Components []CommitComponent `json:"components,omitempty"`
+	// the first commits of histories started apart from HEAD's, e.g. gh-pages
+	OrphanRoots []string `json:"orphanRoots,omitempty"`
+	// commits committed before one of their parents
+	SkewedCommits []ClockSkew `json:"skewedCommits,omitempty"`
+	// objects skipped because they couldn't be read
+	ParseErrors []ParseError `json:"parseErrors,omitempty"`
+}
+
+// Optional reports of the stats command.
+type StatsOptions struct {
+	Dedup bool
+	// period to aggregate conventional commits by. Empty skips the report.
+	ConventionalPeriod string
+	Languages          bool
+	// also report languages for every selected commit
+	LanguagesPerCommit bool
+	Components         bool
+}
+
+// How a blob is shared across paths and commits.
+type BlobUsage struct {
+	Hash    string   `json:"hash"`
+	Size    int      `json:"size"`
+	Paths   []string `json:"paths"`
+	Commits int      `json:"commits"`
+}
+
+// Storage saved by content addressing: every commit snapshot references blobs by name, so a
+// file that doesn't change between commits, or identical files at different paths, is only
+// stored once.
+type DedupReport struct {
+	Blobs       int `json:"blobs"`
+	SharedBlobs int `json:"sharedBlobs"`
+	// number of (commit, path) pairs referencing a blob
+	References int `json:"references"`
+	// bytes of every distinct blob
+	StoredBytes int `json:"storedBytes"`
+	// bytes if every commit stored a full copy of every file
+	NaiveBytes int     `json:"naiveBytes"`
+	SavedBytes int     `json:"savedBytes"`
+	SavedRatio float64 `json:"savedRatio"`
+	// the most shared blobs
+	TopShared []BlobUsage `json:"topShared"`
+}
+
+type pathBlob struct {
+	path string
+	hash string
+}
+
+// Lists every blob under a tree with its path, memoizing subtrees in cache.
+func (r *Repo) flattenTree(hash string, cache map[string][]pathBlob) []pathBlob {
+	if files, ok := cache[hash]; ok {
+		return files
+	}
+	var files []pathBlob
+	for _, entry := range sortedEntries(r.treeEntries(hash)) {
+		if isTreeMode(entry.Mode) {
+			for _, f := range r.flattenTree(entry.Hash, cache) {
+				files = append(files, pathBlob{path.Join(entry.Name, f.path), f.hash})
+			}
+		} else if obj := r.getObject(entry.Hash); obj != nil && obj.Type == "blob" {
+			files = append(files, pathBlob{entry.Name, entry.Hash})
+		}
+	}
+	cache[hash] = files
+	return files
+}
+
+func blobSize(obj *Object) int {
+	size, _ := strconv.Atoi(obj.Size)
+	return size
+}
+
+// Computes how blobs are shared across the paths and commits of the selected commits.
+func (r *Repo) blobUsage(sel *selection) map[string]*BlobUsage {
+	usage := map[string]*BlobUsage{}
+	paths := map[string]map[string]bool{}
+	cache := map[string][]pathBlob{}
+	for _, obj := range sel.objects {
+		if obj.Type != "commit" {
+			continue
+		}
+		for _, f := range r.flattenTree(parseCommit(obj).Tree, cache) {
+			u, ok := usage[f.hash]
+			if !ok {
+				u = &BlobUsage{Hash: f.hash, Size: blobSize(r.getObject(f.hash))}
+				usage[f.hash] = u
+				paths[f.hash] = map[string]bool{}
+			}
+			u.Commits++
+			paths[f.hash][f.path] = true
+		}
+	}
+	for hash, u := range usage {
+		for p := range paths[hash] {
+			u.Paths = append(u.Paths, p)
+		}
+		slices.Sort(u.Paths)
+	}
+	return usage
+}
+
+func dedupReport(usage map[string]*BlobUsage) *DedupReport {
+	report := &DedupReport{TopShared: []BlobUsage{}}
+	var all []BlobUsage
+	for _, u := range usage {
+		report.Blobs++
+		report.References += u.Commits
+		report.StoredBytes += u.Size
+		report.NaiveBytes += u.Size * u.Commits
+		if u.Commits > 1 || len(u.Paths) > 1 {
+			report.SharedBlobs++
+		}
+		all = append(all, *u)
+	}
+	report.SavedBytes = report.NaiveBytes - report.StoredBytes
+	if report.NaiveBytes > 0 {
+		report.SavedRatio = float64(report.SavedBytes) / float64(report.NaiveBytes)
+	}
+	// most saved bytes first
+	slices.SortFunc(all, func(a, b BlobUsage) int {
+		return cmp.Or(cmp.Compare(b.Size*(b.Commits-1), a.Size*(a.Commits-1)), cmp.Compare(a.Hash, b.Hash))
+	})
+	report.TopShared = append(report.TopShared, all[:min(10, len(all))]...)
+	return report
+}
+
+// Computes statistics over the objects selected by opts.
+func (r *Repo) stats(opts GraphOptions, statsOpts StatsOptions) (*RepoStats, error) {
+	sel, err := r.selectObjects(opts)
+	if err != nil {
+		return nil, err
+	}
+	stats := &RepoStats{
+		Objects:      len(sel.objects),
+		CountsByType: map[string]int{},
+		BytesByType:  map[string]int{},
+		ParseErrors:  r.objectErrors(),
+	}
+	stats.SkewedCommits = r.skewedCommits(sel)
+	for _, obj := range sel.objects {
+		stats.CountsByType[obj.Type]++
+		stats.BytesByType[obj.Type] += blobSize(obj)
+	}
+	if statsOpts.Dedup {
+		stats.Dedup = dedupReport(r.blobUsage(sel))
+	}
+	if statsOpts.Languages || statsOpts.LanguagesPerCommit {
+		files, cache := map[string][]pathBlob{}, map[string]LanguageStats{}
+		if head, err := r.commitOf("HEAD"); err == nil {
+			stats.Languages = r.treeLanguages(head.Tree, files, cache)
+		}
+		if statsOpts.LanguagesPerCommit {
+			stats.LanguagesByCommit = map[string][]LanguageStats{}
+			for _, obj := range sel.objects {
+				if obj.Type == "commit" {
+					stats.LanguagesByCommit[obj.Name] = r.treeLanguages(parseCommit(obj).Tree, files, cache)
+				}
+			}
+		}
+	}
+	if statsOpts.Components {
+		var labels map[string]ComponentLabel
+		stats.Components, labels = r.commitComponents(sel)
+		stats.OrphanRoots = []string{}
+		for _, component := range stats.Components {
+			for _, root := range component.Roots {
+				if labels[root].OrphanRoot {
+					stats.OrphanRoots = append(stats.OrphanRoots, root)
+				}
+			}
+		}
+	}
+	if statsOpts.ConventionalPeriod != "" {
+		if stats.Conventional, err = conventionalStats(sel, statsOpts.ConventionalPeriod); err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// The basics of a repo, so dashboards don't have to derive them from the whole graph.
+type RepoSummary struct {
+	Head Head `json:"head"`
+	// the branch HEAD is on, empty when it's detached
+	Branch string `json:"branch,omitempty"`
+	// the loaded loose objects by type
+	Objects  map[string]int `json:"objects"`
+	Branches int            `json:"branches"`
+	Tags     int            `json:"tags"`
+	// the most recently committed commit a ref or HEAD points at, nil without commits
+	LatestCommit *LatestCommit `json:"latestCommit,omitempty"`
+	// bytes on disk of the loose and packed objects
+	Size int64 `json:"size"`
+}
+
+type LatestCommit struct {
+	Hash string `json:"hash"`
+	// the refs pointing at it, e.g. refs/heads/main
+	Refs []string `json:"refs"`
+	Commit
+}
+
+// Returns the repo's summary. Counts are of the refs in the repo's namespace.
+func (r *Repo) Summary() (*RepoSummary, error) {
+	counts, err := r.countObjects()
+	if err != nil {
+		return nil, err
+	}
+	summary := &RepoSummary{
+		Head:    r.head(),
+		Objects: map[string]int{},
+		Size:    counts.Size + counts.SizePack,
+	}
+	if summary.Head.Type == "ref" {
+		summary.Branch = r.currBranch().Name
+	}
+	for _, obj := range r.objects {
+		summary.Objects[obj.Type]++
+	}
+	refs := r.allRefs()
+	if hash, ok := r.resolveRef("HEAD"); ok {
+		refs["HEAD"] = hash
+	}
+	var latest *LatestCommit
+	for name, hash := range refs {
+		switch {
+		case strings.HasPrefix(name, "refs/heads/"):
+			summary.Branches++
+		case strings.HasPrefix(name, "refs/tags/"):
+			summary.Tags++
+		}
+		obj := r.getObject(r.peel(hash))
+		if obj == nil || obj.Type != "commit" {
+			continue
+		}
+		if latest != nil && latest.Hash == obj.Name {
+			latest.Refs = append(latest.Refs, name)
+			continue
+		}
+		commit := parseCommit(obj)
+		// ties go to the lowest hash, so the summary doesn't depend on map order
+		if latest == nil || commit.CommitTime.After(latest.CommitTime) || commit.CommitTime.Equal(latest.CommitTime) && obj.Name < latest.Hash {
+			latest = &LatestCommit{Hash: obj.Name, Refs: []string{name}, Commit: r.mailmap.commit(commit)}
+		}
+	}
+	if latest != nil {
+		sort.Strings(latest.Refs)
+	}
+	summary.LatestCommit = latest
+	return summary, nil
+}
+
+func writeSummary(w io.Writer, summary *RepoSummary) {
+	switch {
+	case summary.Head.Type == "":
+		fmt.Fprintln(w, "HEAD: none")
+	case summary.Branch != "":
+		fmt.Fprintf(w, "HEAD: %s\n", summary.Branch)
+	default:
+		fmt.Fprintf(w, "HEAD: %s (detached)\n", summary.Head.Value)
+	}
+	types := make([]string, 0, len(summary.Objects))
+	for type_ := range summary.Objects {
+		types = append(types, type_)
+	}
+	sort.Strings(types)
+	for _, type_ := range types {
+		fmt.Fprintf(w, "%ss: %d\n", type_, summary.Objects[type_])
+	}
+	fmt.Fprintf(w, "branches: %d\n", summary.Branches)
+	fmt.Fprintf(w, "tags: %d\n", summary.Tags)
+	if c := summary.LatestCommit; c != nil {
+		subject, _, _ := strings.Cut(c.Message, "\n")
+		fmt.Fprintf(w, "latest commit: %s %s (%s, %s)\n", c.Hash[:7], subject, c.Author.Name, c.CommitTime.Format("2006-01-02 15:04:05 -0700"))
+	}
+	fmt.Fprintf(w, "size: %s\n", formatSize(summary.Size))
+}
+
+// Serves the repo's summary.
+func serveSummary(w http.ResponseWriter, r *http.Request) {
+	target, err := requestRepo(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if target == nil {
+		http.Error(w, "summaries need a live repo, not an imported graph", http.StatusNotImplemented)
+		return
+	}
+	defer readLock(target)()
+	if notModified(w, r, target) {
+		return
+	}
+	summary, err := target.Summary()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(summary); err != nil {
+		loggerFrom(r.Context()).Error("writing summary", "error", err)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// The node type of blobs holding a symlink's target path.
+const SYMLINK = "symlink"
+
+// The kind of the edges from a symlink to the object at its target path.
+const EDGE_SYMLINK_TARGET = "target"
+
+// Returns the targets of the selected blobs that selected trees only hold as symlinks.
+// Blobs also checked out as regular files keep showing their content.
+func (r *Repo) symlinkTargets(sel *selection) map[string]string {
+	// whether every entry seen for a blob is a symlink
+	links := map[string]bool{}
+	for _, obj := range sel.objects {
+		if obj.Type != "tree" {
+			continue
+		}
+		for _, entry := range *parseTree(obj) {
+			if seen, ok := links[entry.Hash]; entry.Kind == ENTRY_SYMLINK {
+				links[entry.Hash] = seen || !ok
+			} else if entry.Kind == ENTRY_FILE || entry.Kind == ENTRY_EXECUTABLE {
+				links[entry.Hash] = false
+			}
+		}
+	}
+	targets := map[string]string{}
+	for hash, link := range links {
+		if !link || !sel.has(hash) {
+			continue
+		}
+		if obj := r.getObject(hash); obj != nil && obj.Type == "blob" {
+			targets[hash] = string(obj.Bytes())
+		}
+	}
+	return targets
+}
+
+// Marks the blobs in sel.symlinks as symlink nodes, keeping the unreachable- prefix.
+func (sel *selection) markSymlinks() {
+	for hash := range sel.symlinks {
+		type_ := SYMLINK
+		if reachable, ok := sel.attrs[hash]["reachable"]; ok && reachable == false {
+			type_ = "unreachable-" + SYMLINK
+		}
+		sel.annotate(hash, "type", type_)
+	}
+}
+
+// Returns the object a path names in a tree, or "" when a directory along it is missing,
+// isn't a directory or is a symlink, which isn't followed.
+func (r *Repo) lookupPath(tree string, p string) string {
+	if p == "." {
+		return tree
+	}
+	hash := tree
+	segments := strings.Split(p, "/")
+	for i, name := range segments {
+		entry, ok := r.treeEntries(hash)[name]
+		if !ok || i < len(segments)-1 && entry.Kind != ENTRY_DIRECTORY {
+			return ""
+		}
+		hash = entry.Hash
+	}
+	return hash
+}
+
+// Returns the edges from the selected symlinks to the selected blob or tree at their target
+// path in each selected commit. Absolute targets and ones leaving the repo aren't resolved.
+func (r *Repo) symlinkTargetEdges(sel *selection) []Edge {
+	var edges []Edge
+	seen := map[Edge]bool{}
+	// trees with symlinks somewhere under them, so others aren't walked
+	hasLinks := map[string]bool{}
+	var containsLinks func(tree string) bool
+	containsLinks = func(tree string) bool {
+		if found, ok := hasLinks[tree]; ok {
+			return found
+		}
+		hasLinks[tree] = false
+		for _, entry := range r.treeEntries(tree) {
+			if entry.Kind == ENTRY_SYMLINK || entry.Kind == ENTRY_DIRECTORY && containsLinks(entry.Hash) {
+				hasLinks[tree] = true
+				break
+			}
+		}
+		return hasLinks[tree]
+	}
+	var walk func(root string, dir string, tree string)
+	walk = func(root string, dir string, tree string) {
+		for _, entry := range sortedEntries(r.treeEntries(tree)) {
+			switch entry.Kind {
+			case ENTRY_DIRECTORY:
+				if containsLinks(entry.Hash) {
+					walk(root, path.Join(dir, entry.Name), entry.Hash)
+				}
+			case ENTRY_SYMLINK:
+				target, ok := sel.symlinks[entry.Hash]
+				if !ok || !sel.has(entry.Hash) || path.IsAbs(target) {
+					continue
+				}
+				p := path.Join(dir, target)
+				if p == ".." || strings.HasPrefix(p, "../") {
+					continue
+				}
+				e := Edge{Src: entry.Hash, Dest: r.lookupPath(root, p), Kind: EDGE_SYMLINK_TARGET}
+				if e.Dest == "" || seen[e] || !sel.has(e.Dest) || r.getObject(e.Dest) == nil {
+					continue
+				}
+				seen[e] = true
+				edges = append(edges, e)
+			}
+		}
+	}
+	walked := map[string]bool{}
+	for _, obj := range sel.objects {
+		if obj.Type != "commit" {
+			continue
+		}
+		root := parseCommit(obj).Tree
+		if !walked[root] && containsLinks(root) {
+			walk(root, ".", root)
+		}
+		walked[root] = true
+	}
+	return edges
+}
@@ -0,0 +1,403 @@
+// Package testrepo builds deterministic Git repos from code, writing loose objects and refs
+// without shelling out to git:
+//
+//	repo := testrepo.NewTestRepo().
+//		Commit("initial", testrepo.Files{"README.md": "# demo\n"}).
+//		Branch("dev").
+//		Commit("add main", testrepo.Files{"src/main.go": "package main\n"}).
+//		Checkout("main").
+//		Merge("dev", "merge dev")
+//	err := repo.Write(dir)
+//
+// Commits are authored one minute apart starting at Epoch, so the same calls always produce
+// the same object names.
+package testrepo
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing/fstest"
+	"time"
+)
+
+// The time of the first commit.
+var Epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// File contents by slash-separated path.
+type Files map[string]string
+
+// Deletes a file when used as its content in Files.
+const Deleted = "\x00deleted"
+
+// A repo under construction. Methods record the first error and turn into no-ops after it,
+// which Err, Write and FS return.
+type Repo struct {
+	// compressed loose objects by name
+	objects map[string][]byte
+	// object names by full ref name
+	refs map[string]string
+	// the files of each commit
+	trees map[string]Files
+	// the commits annotated tags point at by tag object name
+	peeled map[string]string
+	branch string
+	// the object HEAD holds when detached, otherwise empty
+	detached string
+	name     string
+	email    string
+	commits  int
+	err      error
+}
+
+// Returns an empty repo with main checked out and no commits.
+func NewTestRepo() *Repo {
+	return &Repo{
+		objects: map[string][]byte{},
+		refs:    map[string]string{},
+		trees:   map[string]Files{},
+		peeled:  map[string]string{},
+		branch:  "main",
+		name:    "Test Author",
+		email:   "author@example.com",
+	}
+}
+
+// Sets the author and committer of the following commits.
+func (r *Repo) Author(name string, email string) *Repo {
+	r.name, r.email = name, email
+	return r
+}
+
+// Commits files on top of the checked out branch's files.
+func (r *Repo) Commit(msg string, files Files) *Repo {
+	var parents []string
+	if head := r.Head(); head != "" {
+		if commit, ok := r.peeled[head]; ok {
+			head = commit
+		}
+		parents = append(parents, head)
+	}
+	return r.commit(msg, files, parents)
+}
+
+// Creates a branch at the current commit and checks it out.
+func (r *Repo) Branch(name string) *Repo {
+	if r.err != nil {
+		return r
+	}
+	if _, ok := r.refs["refs/heads/"+name]; ok {
+		r.err = fmt.Errorf("branch %s already exists", name)
+		return r
+	}
+	if head := r.Head(); head != "" {
+		r.refs["refs/heads/"+name] = head
+	}
+	r.branch, r.detached = name, ""
+	return r
+}
+
+// Checks out an existing branch.
+func (r *Repo) Checkout(name string) *Repo {
+	if r.err != nil {
+		return r
+	}
+	if _, ok := r.refs["refs/heads/"+name]; !ok {
+		r.err = fmt.Errorf("unknown branch %s", name)
+		return r
+	}
+	r.branch, r.detached = name, ""
+	return r
+}
+
+// Detaches HEAD at a branch, a tag or a commit name, peeling annotated tags like git
+// checkout --detach. Commits made while detached move only HEAD.
+func (r *Repo) Detach(rev string) *Repo {
+	if r.err != nil {
+		return r
+	}
+	name := rev
+	for _, ref := range []string{"refs/heads/" + rev, "refs/tags/" + rev} {
+		if hash, ok := r.refs[ref]; ok {
+			name = hash
+			break
+		}
+	}
+	if commit, ok := r.peeled[name]; ok {
+		name = commit
+	}
+	if _, ok := r.trees[name]; !ok {
+		r.err = fmt.Errorf("unknown commit %s", rev)
+		return r
+	}
+	r.detached = name
+	return r
+}
+
+// Detaches HEAD at any object, e.g. an annotated tag, writing its name to HEAD as is. Git
+// itself never does this, but hand-edited and tool-written repos may.
+func (r *Repo) DetachAt(object string) *Repo {
+	if r.err != nil {
+		return r
+	}
+	if _, ok := r.objects[object]; !ok {
+		r.err = fmt.Errorf("unknown object %s", object)
+		return r
+	}
+	r.detached = object
+	return r
+}
+
+// Merges branch into the checked out branch with a merge commit. Files of branch win over
+// those of the checked out branch.
+func (r *Repo) Merge(branch string, msg string) *Repo {
+	if r.err != nil {
+		return r
+	}
+	other, ok := r.refs["refs/heads/"+branch]
+	if !ok {
+		r.err = fmt.Errorf("unknown branch %s", branch)
+		return r
+	}
+	head := r.Head()
+	if head == "" {
+		r.err = fmt.Errorf("can't merge into %s without commits", r.branch)
+		return r
+	}
+	return r.commit(msg, r.trees[other], []string{head, other})
+}
+
+// Creates a lightweight tag at the current commit.
+func (r *Repo) Tag(name string) *Repo {
+	if head := r.head(); head != "" {
+		r.refs["refs/tags/"+name] = head
+	}
+	return r
+}
+
+// Creates an annotated tag of the current commit.
+func (r *Repo) AnnotatedTag(name string, msg string) *Repo {
+	head := r.head()
+	if head == "" {
+		return r
+	}
+	content := fmt.Sprintf("object %s\ntype commit\ntag %s\ntagger %s\n\n%s\n", head, name, r.signature(), msg)
+	tag := r.store("tag", []byte(content))
+	r.refs["refs/tags/"+name] = tag
+	r.peeled[tag] = head
+	return r
+}
+
+// Returns the checked out branch's commit, or the object HEAD is detached at, or an empty
+// string before the first commit.
+func (r *Repo) Head() string {
+	if r.detached != "" {
+		return r.detached
+	}
+	return r.refs["refs/heads/"+r.branch]
+}
+
+// Returns the object name of a full ref name (e.g. refs/tags/v1), or an empty string.
+func (r *Repo) Ref(name string) string {
+	return r.refs[name]
+}
+
+// Returns the first error recorded while building.
+func (r *Repo) Err() error {
+	return r.err
+}
+
+// Writes the repo's .git directory into dir, which is created if needed.
+func (r *Repo) Write(dir string) error {
+	files, err := r.gitFiles()
+	if err != nil {
+		return err
+	}
+	for name, data := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(p, data, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writes the repo into a new temporary directory, returning its path.
+func (r *Repo) TempDir() (string, error) {
+	dir, err := os.MkdirTemp("", "testrepo-")
+	if err != nil {
+		return "", err
+	}
+	if err := r.Write(dir); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+// Returns the repo as an in-memory file system rooted above .git.
+func (r *Repo) FS() (fs.FS, error) {
+	files, err := r.gitFiles()
+	if err != nil {
+		return nil, err
+	}
+	fsys := fstest.MapFS{}
+	for name, data := range files {
+		fsys[name] = &fstest.MapFile{Data: data, Mode: 0o644, ModTime: Epoch}
+	}
+	return fsys, nil
+}
+
+// head returns the current commit, recording an error when there is none.
+func (r *Repo) head() string {
+	if r.err != nil {
+		return ""
+	}
+	head := r.Head()
+	if head == "" {
+		r.err = fmt.Errorf("%s has no commits", r.branch)
+	}
+	return head
+}
+
+func (r *Repo) signature() string {
+	when := Epoch.Add(time.Duration(r.commits) * time.Minute)
+	return fmt.Sprintf("%s <%s> %d +0000", r.name, r.email, when.Unix())
+}
+
+func (r *Repo) commit(msg string, files Files, parents []string) *Repo {
+	if r.err != nil {
+		return r
+	}
+	tree := Files{}
+	if len(parents) > 0 {
+		for p, content := range r.trees[parents[0]] {
+			tree[p] = content
+		}
+	}
+	for p, content := range files {
+		p = path.Clean(strings.TrimPrefix(p, "/"))
+		if content == Deleted {
+			delete(tree, p)
+		} else {
+			tree[p] = content
+		}
+	}
+	treeName, err := r.writeTree(tree)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	var content strings.Builder
+	fmt.Fprintf(&content, "tree %s\n", treeName)
+	for _, parent := range parents {
+		fmt.Fprintf(&content, "parent %s\n", parent)
+	}
+	signature := r.signature()
+	fmt.Fprintf(&content, "author %s\ncommitter %s\n\n%s\n", signature, signature, msg)
+	name := r.store("commit", []byte(content.String()))
+	r.trees[name] = tree
+	if r.detached != "" {
+		r.detached = name
+	} else {
+		r.refs["refs/heads/"+r.branch] = name
+	}
+	r.commits++
+	return r
+}
+
+// Stores the trees of files, returning the root tree's name.
+func (r *Repo) writeTree(files Files) (string, error) {
+	type entry struct {
+		mode string
+		name string
+		hash string
+	}
+	var entries []entry
+	dirs := map[string]Files{}
+	for p, content := range files {
+		dir, rest, nested := strings.Cut(p, "/")
+		if !nested {
+			entries = append(entries, entry{"100644", p, r.store("blob", []byte(content))})
+			continue
+		}
+		if dirs[dir] == nil {
+			dirs[dir] = Files{}
+		}
+		dirs[dir][rest] = content
+	}
+	for dir, sub := range dirs {
+		if _, ok := files[dir]; ok {
+			return "", fmt.Errorf("%s is both a file and a directory", dir)
+		}
+		hash, err := r.writeTree(sub)
+		if err != nil {
+			return "", err
+		}
+		entries = append(entries, entry{"40000", dir, hash})
+	}
+	// git sorts directories as if their names ended with a slash
+	sortKey := func(e entry) string {
+		if e.mode == "40000" {
+			return e.name + "/"
+		}
+		return e.name
+	}
+	slices.SortFunc(entries, func(a, b entry) int { return strings.Compare(sortKey(a), sortKey(b)) })
+	var content bytes.Buffer
+	for _, e := range entries {
+		raw, _ := hex.DecodeString(e.hash)
+		fmt.Fprintf(&content, "%s %s\x00", e.mode, e.name)
+		content.Write(raw)
+	}
+	return r.store("tree", content.Bytes()), nil
+}
+
+// Stores an object, returning its name.
+func (r *Repo) store(type_ string, content []byte) string {
+	data := append([]byte(fmt.Sprintf("%s %d\x00", type_, len(content))), content...)
+	sum := sha1.Sum(data)
+	name := hex.EncodeToString(sum[:])
+	if _, ok := r.objects[name]; !ok {
+		var compressed bytes.Buffer
+		w := zlib.NewWriter(&compressed)
+		w.Write(data)
+		w.Close()
+		r.objects[name] = compressed.Bytes()
+	}
+	return name
+}
+
+// Returns the files of the .git directory by slash-separated path.
+func (r *Repo) gitFiles() (map[string][]byte, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	head := "ref: refs/heads/" + r.branch
+	if r.detached != "" {
+		head = r.detached
+	}
+	files := map[string][]byte{
+		".git/HEAD":        []byte(head + "\n"),
+		".git/config":      []byte("[core]\n\trepositoryformatversion = 0\n\tfilemode = true\n\tbare = false\n"),
+		".git/description": []byte("Unnamed repository; edit this file 'description' to name the repository.\n"),
+	}
+	for name, data := range r.objects {
+		files[".git/objects/"+name[:2]+"/"+name[2:]] = data
+	}
+	for name, hash := range r.refs {
+		files[".git/"+name] = []byte(hash + "\n")
+	}
+	return files, nil
+}
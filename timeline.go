@@ -0,0 +1,115 @@
+package main
+
+import (
+	"cmp"
+	"database/sql"
+	"encoding/csv"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A tag in the release timeline.
+type TimelineEntry struct {
+	// the tag's name without refs/tags/
+	Tag string `json:"tag"`
+	// the tag object of annotated tags, empty for lightweight tags
+	TagObject string `json:"tagObject,omitempty"`
+	// the commit the tag peels to
+	Commit string `json:"commit"`
+	// the tagger date of annotated tags and the commit time of lightweight ones
+	Date   time.Time `json:"date"`
+	Tagger *User     `json:"tagger,omitempty"`
+	// the annotated tag's message
+	Message string `json:"message,omitempty"`
+	// the subject of the tagged commit
+	Subject string `json:"subject"`
+	// days since the previous tag of the timeline, 0 for the first
+	DaysSincePrevious float64 `json:"daysSincePrevious"`
+}
+
+// Returns the tags of the repo oldest first, dated by tagger date or, for lightweight tags,
+// commit time. Messages are passed through redact when it isn't nil. Tags of trees and blobs
+// have no date and are left out.
+func (r *Repo) timeline(redact Redactor) []TimelineEntry {
+	if redact == nil {
+		redact = func(s string) string { return s }
+	}
+	var entries []TimelineEntry
+	for name, hash := range r.allRefs() {
+		tag, ok := strings.CutPrefix(name, "refs/tags/")
+		if !ok {
+			continue
+		}
+		entry := TimelineEntry{Tag: tag}
+		if obj := r.getObject(hash); obj != nil && obj.Type == "tag" {
+			annotated := parseTag(obj)
+			tagger := r.mailmap.resolve(annotated.Tagger)
+			entry.TagObject, entry.Date, entry.Tagger = hash, annotated.TagTime, &tagger
+			entry.Message = redact(annotated.Message)
+		}
+		obj := r.getObject(r.peel(hash))
+		if obj == nil || obj.Type != "commit" {
+			continue
+		}
+		commit := parseCommit(obj)
+		entry.Commit = obj.Name
+		if entry.TagObject == "" {
+			entry.Date = commit.CommitTime
+		}
+		entry.Subject, _, _ = strings.Cut(redact(commit.Message), "\n")
+		entries = append(entries, entry)
+	}
+	slices.SortFunc(entries, func(a, b TimelineEntry) int {
+		return cmp.Or(a.Date.Compare(b.Date), strings.Compare(a.Tag, b.Tag))
+	})
+	for i := 1; i < len(entries); i++ {
+		entries[i].DaysSincePrevious = entries[i].Date.Sub(entries[i-1].Date).Hours() / 24
+	}
+	return entries
+}
+
+func writeTimelineCSV(w io.Writer, entries []TimelineEntry) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"tag", "tagObject", "commit", "date", "taggerName", "taggerEmail", "message", "subject", "daysSincePrevious"})
+	for _, e := range entries {
+		var tagger User
+		if e.Tagger != nil {
+			tagger = *e.Tagger
+		}
+		out.Write([]string{
+			e.Tag,
+			e.TagObject,
+			e.Commit,
+			e.Date.Format(time.RFC3339),
+			tagger.Name,
+			tagger.Email,
+			e.Message,
+			e.Subject,
+			strconv.FormatFloat(e.DaysSincePrevious, 'f', 2, 64),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// Adds a timeline table of the repo's tags to a SQLite export, with messages redacted like
+// the selection's.
+func (r *Repo) writeTimelineTable(db *sql.DB, sel *selection) error {
+	if _, err := db.Exec(`create table timeline (tag text primary key, tag_object text, commit_hash text, date text, tagger_name text, tagger_email text, message text, subject text, days_since_previous real);`); err != nil {
+		return err
+	}
+	for _, e := range r.timeline(sel.redact) {
+		var tagger User
+		if e.Tagger != nil {
+			tagger = *e.Tagger
+		}
+		if _, err := db.Exec("insert into timeline values(?, nullif(?, ''), ?, ?, nullif(?, ''), nullif(?, ''), nullif(?, ''), ?, ?)",
+			e.Tag, e.TagObject, e.Commit, e.Date.Format(time.RFC3339), tagger.Name, tagger.Email, e.Message, e.Subject, e.DaysSincePrevious); err != nil {
+			return err
+		}
+	}
+	return nil
+}
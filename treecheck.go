@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A tree git wouldn't write, e.g. hand-crafted or corrupted. Git and dagit still read these,
+// but lookups by name and diffs against them can give confusing results.
+type TreeProblem struct {
+	Tree string `json:"tree"`
+	// the pack holding the tree, empty for loose trees
+	Pack     string   `json:"pack,omitempty"`
+	Problems []string `json:"problems"`
+}
+
+// Returns the name an entry sorts by in a tree: directories sort as if their name ended in a
+// slash, so a directory foo comes after a file foo.txt.
+func treeSortName(name []byte, mode []byte) []byte {
+	if isTreeMode(string(mode)) {
+		return append(slices.Clip(name), '/')
+	}
+	return name
+}
+
+// Checks a tree's entries against what git writes: names in canonical order without
+// duplicates, names a checkout can create and no bytes after the last entry.
+func treeProblems(data []byte) []string {
+	var problems []string
+	var prev, prevSort []byte
+	for len(data) > 0 {
+		mode, rest, found := bytes.Cut(data, []byte{SPACE})
+		if !found {
+			problems = append(problems, fmt.Sprintf("truncated after %q", prev))
+			break
+		}
+		name, rest, found := bytes.Cut(rest, []byte{NUL})
+		if !found || len(rest) < HASH_SIZE {
+			problems = append(problems, fmt.Sprintf("truncated entry %q", name))
+			break
+		}
+		switch {
+		case len(name) == 0, string(name) == ".", string(name) == "..", bytes.IndexByte(name, '/') >= 0:
+			problems = append(problems, fmt.Sprintf("invalid entry name %q", name))
+		}
+		sortName := treeSortName(name, mode)
+		if prevSort != nil {
+			switch c := bytes.Compare(prevSort, sortName); {
+			case bytes.Equal(prev, name):
+				problems = append(problems, fmt.Sprintf("duplicate entry %q", name))
+			case c > 0:
+				problems = append(problems, fmt.Sprintf("entries out of order: %q before %q", prev, name))
+			}
+		}
+		prev, prevSort = name, sortName
+		data = rest[HASH_SIZE:]
+	}
+	return problems
+}
+
+// Adds the problems of malformed trees to their nodes as a "malformed" attribute.
+func markMalformedTrees(sel *selection) {
+	for _, obj := range sel.objects {
+		if obj.Type != "tree" {
+			continue
+		}
+		if problems := treeProblems(obj.Bytes()); len(problems) > 0 {
+			sel.annotate(obj.Name, "malformed", problems)
+		}
+	}
+}
+
+// Checks every loose and packed tree of the repo, sorted by tree. Packs that can't be read
+// are returned as errors, since verify-pack tells what's wrong with them.
+func (r *Repo) checkTrees() ([]TreeProblem, error) {
+	var result []TreeProblem
+	for _, obj := range r.objectList() {
+		if obj.Type != "tree" {
+			continue
+		}
+		if problems := treeProblems(obj.Bytes()); len(problems) > 0 {
+			result = append(result, TreeProblem{Tree: obj.Name, Problems: problems})
+		}
+	}
+	paths, err := r.packs()
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		p, err := openPack(path)
+		if err != nil {
+			return nil, err
+		}
+		for i := range p.entries {
+			type_, data, err := p.object(i)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			if type_ != "tree" {
+				continue
+			}
+			if problems := treeProblems(data); len(problems) > 0 {
+				result = append(result, TreeProblem{Tree: p.idx.names[i], Pack: filepath.Base(path), Problems: problems})
+			}
+		}
+	}
+	slices.SortFunc(result, func(a, b TreeProblem) int {
+		return strings.Compare(a.Tree+a.Pack, b.Tree+b.Pack)
+	})
+	return result, nil
+}
+
+// The result of fsck: loose objects that couldn't be read are errors, malformed trees and
+// commits with clock skew warnings.
+type FsckReport struct {
+	Objects   int           `json:"objects"`
+	Errors    []ParseError  `json:"errors"`
+	Warnings  []TreeProblem `json:"warnings"`
+	ClockSkew []ClockSkew   `json:"clockSkew"`
+}
+
+func writeFsckReport(w io.Writer, report *FsckReport) {
+	for _, e := range report.Errors {
+		fmt.Fprintf(w, "error: unreadable object %s at %s: %s\n", e.Name, e.Location, e.Error)
+	}
+	for _, t := range report.Warnings {
+		where := ""
+		if t.Pack != "" {
+			where = " in " + t.Pack
+		}
+		for _, problem := range t.Problems {
+			fmt.Fprintf(w, "warning: tree %s%s: %s\n", t.Tree, where, problem)
+		}
+	}
+	for _, s := range report.ClockSkew {
+		fmt.Fprintf(w, "warning: commit %s committed %s before its parent %s\n", s.Commit, time.Duration(s.Seconds)*time.Second, s.Parent)
+	}
+	fmt.Fprintf(w, "checked %d loose %s and every pack: %d %s, %d malformed %s, %d skewed %s\n",
+		report.Objects, plural(report.Objects, "object"), len(report.Errors), plural(len(report.Errors), "error"),
+		len(report.Warnings), plural(len(report.Warnings), "tree"), len(report.ClockSkew), plural(len(report.ClockSkew), "commit"))
+}
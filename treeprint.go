@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// The branches of a tree printout.
+type treeCharset struct {
+	entry, last, pipe, space string
+}
+
+var (
+	unicodeTree = treeCharset{"├── ", "└── ", "│   ", "    "}
+	asciiTree   = treeCharset{"|-- ", "`-- ", "|   ", "    "}
+)
+
+// Formats a size in bytes with binary units, e.g. 1.5 KiB.
+func formatSize(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	size, unit := float64(n), 0
+	units := []string{"KiB", "MiB", "GiB", "TiB"}
+	for size /= 1024; size >= 1024 && unit < len(units)-1; size /= 1024 {
+		unit++
+	}
+	return fmt.Sprintf("%.1f %s", size, units[unit])
+}
+
+// Prints the directory structure of a commit like the tree utility, with the short hash and
+// size of every file. Only depth levels are printed, all of them when depth is 0.
+func (r *Repo) printTree(w io.Writer, rev string, depth int, charset treeCharset) error {
+	commit, err := r.commitOf(rev)
+	if err != nil {
+		return err
+	}
+	dirs, files := 0, 0
+	var walk func(tree string, prefix string, level int)
+	walk = func(tree string, prefix string, level int) {
+		entries := sortedEntries(r.treeEntries(tree))
+		for i, entry := range entries {
+			branch, indent := charset.entry, charset.pipe
+			if i == len(entries)-1 {
+				branch, indent = charset.last, charset.space
+			}
+			switch entry.Kind {
+			case ENTRY_DIRECTORY:
+				dirs++
+				fmt.Fprintf(w, "%s%s%s/\n", prefix, branch, entry.Name)
+				if depth == 0 || level < depth {
+					walk(entry.Hash, prefix+indent, level+1)
+				}
+			case ENTRY_GITLINK:
+				dirs++
+				fmt.Fprintf(w, "%s%s%s  [submodule %s]\n", prefix, branch, entry.Name, shortHash(entry.Hash))
+			default:
+				files++
+				obj := r.getObject(entry.Hash)
+				if obj == nil {
+					fmt.Fprintf(w, "%s%s%s  [%s missing]\n", prefix, branch, entry.Name, shortHash(entry.Hash))
+					continue
+				}
+				name := entry.Name
+				if entry.Kind == ENTRY_SYMLINK {
+					name += " -> " + string(obj.Bytes())
+				}
+				fmt.Fprintf(w, "%s%s%s  [%s %s]\n", prefix, branch, name, shortHash(entry.Hash), formatSize(int64(blobSize(obj))))
+			}
+		}
+	}
+	fmt.Fprintf(w, ".  [%s]\n", shortHash(commit.Tree))
+	walk(commit.Tree, "", 1)
+	dirWord := "directories"
+	if dirs == 1 {
+		dirWord = "directory"
+	}
+	fmt.Fprintf(w, "\n%d %s, %d %s\n", dirs, dirWord, files, plural(files, "file"))
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+)
+
+// How a node type is drawn.
+type NodeStyle struct {
+	Color string `json:"color"`
+	Label string `json:"label"`
+}
+
+// The node styles used unless a git config sets dagit.<type>.color or dagit.<type>.label.
+// dot exports use the default colors.
+var defaultNodeStyles = map[string]NodeStyle{
+	"commit":      {Color: "#f9d77e", Label: "Commit"},
+	OCTOPUS_MERGE: {Color: "#f5b041", Label: "Octopus merge"},
+	"tree":        {Color: "#a8d5a2", Label: "Tree"},
+	"blob":        {Color: "#d0d0d0", Label: "Blob"},
+	LFS_POINTER:   {Color: "#c2a5cf", Label: "LFS pointer"},
+	SYMLINK:       {Color: "#b8e0d2", Label: "Symlink"},
+	"tag":         {Color: "#f4a582", Label: "Tag"},
+	"ref":         {Color: "#92c5de", Label: "Ref"},
+	PSEUDO_REF:    {Color: "#d1e5f0", Label: "Pseudo-ref"},
+	OPERATION:     {Color: "#f7a8a8", Label: "Operation"},
+}
+
+// What UIs should render, so the embedded frontend and others look the same. Unreachable
+// objects, typed unreachable-<type>, are drawn in their type's style.
+type UIConfig struct {
+	Types    map[string]NodeStyle `json:"types"`
+	Features UIFeatures           `json:"features"`
+	// the git config files read, lowest precedence first
+	Files []string `json:"files"`
+}
+
+type UIFeatures struct {
+	// show blobs, off with dagit.blobs = false or when the server leaves blobs out of graphs
+	Blobs bool `json:"blobs"`
+	// show reflog history, on with dagit.reflog = true
+	Reflog bool `json:"reflog"`
+}
+
+// Builds the UI config from the [dagit] section of the repo's and the user's git config,
+// or the user's alone when serving an imported graph. For example:
+//
+//	[dagit "commit"]
+//		color = "#ffcc00"
+//		label = Change
+//	[dagit]
+//		blobs = false
+func uiConfig(target *Repo, opts GraphOptions) (*UIConfig, error) {
+	var config *Config
+	var err error
+	if target != nil {
+		config, err = target.Config(true)
+	} else {
+		config, err = readConfig(globalConfigPaths())
+	}
+	if err != nil {
+		return nil, err
+	}
+	ui := &UIConfig{
+		Types: map[string]NodeStyle{},
+		Features: UIFeatures{
+			Blobs:  opts.Types == nil || slices.Contains(opts.Types, "blob"),
+			Reflog: config.Bool("dagit.reflog"),
+		},
+		Files: config.Files,
+	}
+	if _, ok := config.Get("dagit.blobs"); ok && !config.Bool("dagit.blobs") {
+		ui.Features.Blobs = false
+	}
+	for type_, style := range defaultNodeStyles {
+		if color, ok := config.Get("dagit." + type_ + ".color"); ok {
+			style.Color = color
+		}
+		if label, ok := config.Get("dagit." + type_ + ".label"); ok {
+			style.Label = label
+		}
+		ui.Types[type_] = style
+	}
+	return ui, nil
+}
+
+// Serves the UI config.
+func serveUIConfig(w http.ResponseWriter, r *http.Request) {
+	ui, err := uiConfig(repo, graphOpts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ui); err != nil {
+		loggerFrom(r.Context()).Error("writing config", "error", err)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/dagit/repofs"
+)
+
+// Writes an object as a zlib-compressed loose object under objectsDir, named by the hash of
+// its header and content. Existing objects are left as they are.
+func writeLooseObject(objectsDir string, type_ string, content []byte) (string, error) {
+	data := append([]byte(fmt.Sprintf("%s %d\x00", type_, len(content))), content...)
+	sum := sha1.Sum(data)
+	name := hex.EncodeToString(sum[:])
+	path := filepath.Join(objectsDir, name[:2], name[2:])
+	if _, err := repofs.Stat(path); err == nil {
+		return name, nil
+	}
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	if _, err := w.Write(data); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	if err := repofs.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	// loose objects are read-only, as git writes them
+	return name, repofs.WriteFile(path, compressed.Bytes(), 0o444)
+}
+
+// Writes every object of a pack (given its .pack or .idx path) as a loose object under
+// outDir, laid out like .git/objects. Returns the number of objects written.
+func unpack(path string, outDir string) (int, error) {
+	p, err := openPack(path)
+	if err != nil {
+		return 0, err
+	}
+	if outDir == "" {
+		return 0, errors.New("no output directory")
+	}
+	for i, expected := range p.idx.names {
+		type_, content, err := p.object(i)
+		if err != nil {
+			return i, err
+		}
+		name, err := writeLooseObject(outDir, type_, content)
+		if err != nil {
+			return i, err
+		}
+		if name != expected {
+			return i, fmt.Errorf("object %s unpacked to %s", expected, name)
+		}
+	}
+	return len(p.idx.names), nil
+}
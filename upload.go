@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/dagit/repofs"
+	"gocloud.dev/blob"
+	_ "gocloud.dev/blob/azureblob"
+	_ "gocloud.dev/blob/fileblob"
+	_ "gocloud.dev/blob/gcsblob"
+	_ "gocloud.dev/blob/s3blob"
+)
+
+// Splits an upload URL such as s3://bucket/path/to/key?region=us-east-1 into the bucket URL
+// go-cloud opens (s3://bucket?region=us-east-1) and the object key (path/to/key).
+func splitUploadURL(dest string) (string, string, error) {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return "", "", fmt.Errorf("upload url %q: %w", dest, err)
+	}
+	if u.Scheme == "file" {
+		// file:///dir/key, mostly useful to try an upload out locally
+		dir, key := path.Split(u.Path)
+		u.Path = dir
+		return u.String(), key, nil
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Scheme == "" || u.Host == "" || key == "" {
+		return "", "", fmt.Errorf("upload url %q must look like s3://bucket/key, gs://bucket/key or azblob://container/key", dest)
+	}
+	u.Path = ""
+	return u.String(), key, nil
+}
+
+// Uploads file to object storage. Credentials come from the environment the
+// way each provider's SDK finds them.
+func upload(ctx context.Context, dest string, file string) (err error) {
+	ctx, span := tracer.Start(ctx, "upload")
+	defer func() { endSpan(span, err) }()
+	bucketURL, key, err := splitUploadURL(dest)
+	if err != nil {
+		return err
+	}
+	bucket, err := blob.OpenBucket(ctx, bucketURL)
+	if err != nil {
+		return err
+	}
+	defer bucket.Close()
+	f, err := repofs.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w, err := bucket.NewWriter(ctx, key, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, f); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
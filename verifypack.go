@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+	"slices"
+)
+
+// The result of checking a pack against its index.
+type PackVerification struct {
+	Pack    string `json:"pack"`
+	Objects int    `json:"objects"`
+	// objects by type, deltas counted as the type of their chain's base
+	CountsByType map[string]int `json:"countsByType"`
+	// delta objects by kind, ofs-delta or ref-delta
+	Deltas map[string]int `json:"deltas"`
+	// objects by the length of their delta chain, 0 being whole objects
+	ChainLengths map[int]int `json:"chainLengths"`
+	Problems     []string    `json:"problems"`
+}
+
+func (v *PackVerification) problem(format string, a ...any) {
+	v.Problems = append(v.Problems, fmt.Sprintf(format, a...))
+}
+
+// Checks a pack (given its .pack or .idx path): the pack and index checksums, that the index
+// lists the objects the pack declares, and the CRC32 of every object's packed data. Problems
+// are reported rather than returned as errors; an error means the pack couldn't be read.
+func verifyPack(path string) (*PackVerification, error) {
+	packPath, idxPath := packPaths(path)
+	idx, err := readPackIndex(idxPath)
+	if err != nil {
+		return nil, err
+	}
+	pack, count, err := readPack(packPath)
+	if err != nil {
+		return nil, err
+	}
+	v := &PackVerification{
+		Pack:         packPath,
+		Objects:      len(idx.names),
+		CountsByType: map[string]int{},
+		Deltas:       map[string]int{},
+		ChainLengths: map[int]int{},
+		Problems:     []string{},
+	}
+	if !packChecksumOK(pack) {
+		v.problem("pack checksum mismatch")
+	}
+	if !slices.Equal(idx.packChecksum, pack[len(pack)-HASH_SIZE:]) {
+		v.problem("index is for a different pack")
+	}
+	if count != len(idx.names) {
+		v.problem("pack declares %d objects but the index lists %d", count, len(idx.names))
+	}
+	entries, packed, err := packEntries(pack, idx)
+	if err != nil {
+		v.problem("%v", err)
+		return v, nil
+	}
+
+	byOffset := map[int64]int{}
+	for i, entry := range entries {
+		byOffset[entry.offset] = i
+		if crc := crc32.ChecksumIEEE(pack[entry.offset : entry.offset+packed[i]]); crc != idx.crcs[i] {
+			v.problem("object %s: CRC mismatch", idx.names[i])
+		}
+	}
+	base := func(i int) (int, bool) {
+		switch entries[i].type_ {
+		case PACK_OFS_DELTA:
+			b, ok := byOffset[entries[i].baseOffset]
+			return b, ok
+		case PACK_REF_DELTA:
+			b, ok := slices.BinarySearch(idx.names, entries[i].baseName)
+			return b, ok
+		}
+		return -1, false
+	}
+	// chain lengths and base types, -1 while being resolved to catch cycles
+	depths := make([]int, len(entries))
+	types := make([]int, len(entries))
+	var resolve func(i int) (int, int, error)
+	resolve = func(i int) (int, int, error) {
+		if depths[i] > 0 || types[i] != 0 {
+			return depths[i], types[i], nil
+		}
+		if depths[i] < 0 {
+			return 0, 0, fmt.Errorf("object %s: delta chain cycle", idx.names[i])
+		}
+		if entries[i].type_ != PACK_OFS_DELTA && entries[i].type_ != PACK_REF_DELTA {
+			types[i] = entries[i].type_
+			return 0, types[i], nil
+		}
+		b, ok := base(i)
+		if !ok {
+			return 0, 0, fmt.Errorf("object %s: delta base not in the pack", idx.names[i])
+		}
+		depths[i] = -1
+		depth, type_, err := resolve(b)
+		if err != nil {
+			depths[i] = 0
+			return 0, 0, err
+		}
+		depths[i], types[i] = depth+1, type_
+		return depths[i], types[i], nil
+	}
+	for i, entry := range entries {
+		if entry.type_ == PACK_OFS_DELTA || entry.type_ == PACK_REF_DELTA {
+			v.Deltas[packTypeNames[entry.type_]]++
+		}
+		depth, type_, err := resolve(i)
+		if err != nil {
+			v.problem("%v", err)
+			continue
+		}
+		v.CountsByType[packTypeNames[type_]]++
+		v.ChainLengths[depth]++
+	}
+	return v, nil
+}
+
+// Writes a verification like git verify-pack -s.
+func writePackVerification(w io.Writer, v *PackVerification) {
+	fmt.Fprintf(w, "%s: %d objects\n", v.Pack, v.Objects)
+	for _, counts := range []map[string]int{v.CountsByType, v.Deltas} {
+		names := make([]string, 0, len(counts))
+		for name := range counts {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			fmt.Fprintf(w, "  %s: %d\n", name, counts[name])
+		}
+	}
+	lengths := make([]int, 0, len(v.ChainLengths))
+	for length := range v.ChainLengths {
+		lengths = append(lengths, length)
+	}
+	slices.Sort(lengths)
+	for _, length := range lengths {
+		if length == 0 {
+			fmt.Fprintf(w, "  non delta: %d %s\n", v.ChainLengths[0], plural(v.ChainLengths[0], "object"))
+		} else {
+			fmt.Fprintf(w, "  chain length = %d: %d %s\n", length, v.ChainLengths[length], plural(v.ChainLengths[length], "object"))
+		}
+	}
+	for _, p := range v.Problems {
+		fmt.Fprintf(w, "  error: %s\n", p)
+	}
+	if len(v.Problems) == 0 {
+		fmt.Fprintf(w, "%s: ok\n", v.Pack)
+	} else {
+		fmt.Fprintf(w, "%s: %d %s\n", v.Pack, len(v.Problems), plural(len(v.Problems), "problem"))
+	}
+}